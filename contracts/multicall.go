@@ -0,0 +1,223 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package contracts
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = errors.New
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+)
+
+// Multicall2Call is an auto generated low-level Go binding around an user-defined struct.
+type Multicall2Call struct {
+	Target   common.Address
+	CallData []byte
+}
+
+// Multicall2Result is an auto generated low-level Go binding around an user-defined struct.
+type Multicall2Result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// Multicall2MetaData contains all meta data concerning the Multicall2 contract.
+var Multicall2MetaData = &bind.MetaData{
+	ABI: "[{\"inputs\":[{\"internalType\":\"bool\",\"name\":\"requireSuccess\",\"type\":\"bool\"},{\"components\":[{\"internalType\":\"address\",\"name\":\"target\",\"type\":\"address\"},{\"internalType\":\"bytes\",\"name\":\"callData\",\"type\":\"bytes\"}],\"internalType\":\"structMulticall2.Call[]\",\"name\":\"calls\",\"type\":\"tuple[]\"}],\"name\":\"tryAggregate\",\"outputs\":[{\"components\":[{\"internalType\":\"bool\",\"name\":\"success\",\"type\":\"bool\"},{\"internalType\":\"bytes\",\"name\":\"returnData\",\"type\":\"bytes\"}],\"internalType\":\"structMulticall2.Result[]\",\"name\":\"returnData\",\"type\":\"tuple[]\"}],\"stateMutability\":\"view\",\"type\":\"function\"}]",
+}
+
+// Multicall2ABI is the input ABI used to generate the binding from.
+// Deprecated: Use Multicall2MetaData.ABI instead.
+var Multicall2ABI = Multicall2MetaData.ABI
+
+// Multicall2 is an auto generated Go binding around an Ethereum contract.
+type Multicall2 struct {
+	Multicall2Caller     // Read-only binding to the contract
+	Multicall2Transactor // Write-only binding to the contract
+	Multicall2Filterer   // Log filterer for contract events
+}
+
+// Multicall2Caller is an auto generated read-only Go binding around an Ethereum contract.
+type Multicall2Caller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// Multicall2Transactor is an auto generated write-only Go binding around an Ethereum contract.
+type Multicall2Transactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// Multicall2Filterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type Multicall2Filterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// Multicall2Session is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type Multicall2Session struct {
+	Contract     *Multicall2       // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts     // Call options to use throughout this session
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// Multicall2CallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type Multicall2CallerSession struct {
+	Contract *Multicall2Caller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts     // Call options to use throughout this session
+}
+
+// Multicall2TransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type Multicall2TransactorSession struct {
+	Contract     *Multicall2Transactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts     // Transaction auth options to use throughout this session
+}
+
+// Multicall2Raw is an auto generated low-level Go binding around an Ethereum contract.
+type Multicall2Raw struct {
+	Contract *Multicall2 // Generic contract binding to access the raw methods on
+}
+
+// Multicall2CallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type Multicall2CallerRaw struct {
+	Contract *Multicall2Caller // Generic read-only contract binding to access the raw methods on
+}
+
+// Multicall2TransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type Multicall2TransactorRaw struct {
+	Contract *Multicall2Transactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewMulticall2 creates a new instance of Multicall2, bound to a specific deployed contract.
+func NewMulticall2(address common.Address, backend bind.ContractBackend) (*Multicall2, error) {
+	contract, err := bindMulticall2(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Multicall2{Multicall2Caller: Multicall2Caller{contract: contract}, Multicall2Transactor: Multicall2Transactor{contract: contract}, Multicall2Filterer: Multicall2Filterer{contract: contract}}, nil
+}
+
+// NewMulticall2Caller creates a new read-only instance of Multicall2, bound to a specific deployed contract.
+func NewMulticall2Caller(address common.Address, caller bind.ContractCaller) (*Multicall2Caller, error) {
+	contract, err := bindMulticall2(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Multicall2Caller{contract: contract}, nil
+}
+
+// NewMulticall2Transactor creates a new write-only instance of Multicall2, bound to a specific deployed contract.
+func NewMulticall2Transactor(address common.Address, transactor bind.ContractTransactor) (*Multicall2Transactor, error) {
+	contract, err := bindMulticall2(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Multicall2Transactor{contract: contract}, nil
+}
+
+// NewMulticall2Filterer creates a new log filterer instance of Multicall2, bound to a specific deployed contract.
+func NewMulticall2Filterer(address common.Address, filterer bind.ContractFilterer) (*Multicall2Filterer, error) {
+	contract, err := bindMulticall2(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &Multicall2Filterer{contract: contract}, nil
+}
+
+// bindMulticall2 binds a generic wrapper to an already deployed contract.
+func bindMulticall2(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(Multicall2ABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Multicall2 *Multicall2Raw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _Multicall2.Contract.Multicall2Caller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Multicall2 *Multicall2Raw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Multicall2.Contract.Multicall2Transactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Multicall2 *Multicall2Raw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Multicall2.Contract.Multicall2Transactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Multicall2 *Multicall2CallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _Multicall2.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Multicall2 *Multicall2TransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Multicall2.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Multicall2 *Multicall2TransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Multicall2.Contract.contract.Transact(opts, method, params...)
+}
+
+// TryAggregate is a free data retrieval call binding the contract method 0xbce38bd7.
+//
+// Solidity: function tryAggregate(bool requireSuccess, (address,bytes)[] calls) view returns((bool,bytes)[] returnData)
+func (_Multicall2 *Multicall2Caller) TryAggregate(opts *bind.CallOpts, requireSuccess bool, calls []Multicall2Call) ([]Multicall2Result, error) {
+	var out []interface{}
+	err := _Multicall2.contract.Call(opts, &out, "tryAggregate", requireSuccess, calls)
+
+	if err != nil {
+		return *new([]Multicall2Result), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new([]Multicall2Result)).(*[]Multicall2Result)
+
+	return out0, err
+
+}
+
+// TryAggregate is a free data retrieval call binding the contract method 0xbce38bd7.
+//
+// Solidity: function tryAggregate(bool requireSuccess, (address,bytes)[] calls) view returns((bool,bytes)[] returnData)
+func (_Multicall2 *Multicall2Session) TryAggregate(requireSuccess bool, calls []Multicall2Call) ([]Multicall2Result, error) {
+	return _Multicall2.Contract.TryAggregate(&_Multicall2.CallOpts, requireSuccess, calls)
+}
+
+// TryAggregate is a free data retrieval call binding the contract method 0xbce38bd7.
+//
+// Solidity: function tryAggregate(bool requireSuccess, (address,bytes)[] calls) view returns((bool,bytes)[] returnData)
+func (_Multicall2 *Multicall2CallerSession) TryAggregate(requireSuccess bool, calls []Multicall2Call) ([]Multicall2Result, error) {
+	return _Multicall2.Contract.TryAggregate(&_Multicall2.CallOpts, requireSuccess, calls)
+}
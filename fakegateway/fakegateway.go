@@ -0,0 +1,320 @@
+// Package fakegateway serves the gateway Relay service with configurable fees, gas prices and errors,
+// to run relay nodes locally and in tests without the cBridge gateway. It records the received requests
+// for assertions.
+package fakegateway
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/gatewayrpc"
+	"github.com/celer-network/goutils/log"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	DefaultListenAddr     = "127.0.0.1:8090"
+	DefaultPingAuthWindow = 5 * time.Minute
+
+	MethodPing   = "Ping"
+	MethodGetFee = "GetFee"
+)
+
+type Gateway struct {
+	gatewayrpc.UnimplementedRelayServer
+
+	lock            sync.Mutex
+	listenAddr      string
+	defaultFee      string
+	feeRules        []*cbn.FakeFeeRule
+	defaultGasPrice uint64
+	gasPrices       map[uint64]uint64
+	errorRules      []*errorRule
+	allowNoAuth     bool
+	pingAuthWindow  time.Duration
+	seenPingNonces  map[string]time.Time // eth addr and ping auth nonce -> timestamp, within the auth window
+	pings           []*gatewayrpc.PingRequest
+	feeRequests     []string
+	grpcServer      *grpc.Server
+}
+
+type errorRule struct {
+	method string
+	key    string
+	code   gatewayrpc.ErrCode
+	left   uint64
+	always bool
+}
+
+// ParseConfigFile reads the fake gateway config json file.
+func ParseConfigFile(f string) (*cbn.FakeGatewayConfig, error) {
+	raw, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	ret := new(cbn.FakeGatewayConfig)
+	err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(raw, ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func New(cfg *cbn.FakeGatewayConfig) (*Gateway, error) {
+	g := &Gateway{
+		listenAddr:      cfg.GetListenAddr(),
+		defaultFee:      "0",
+		defaultGasPrice: cfg.GetDefaultGasPrice(),
+		gasPrices:       make(map[uint64]uint64),
+		allowNoAuth:     cfg.GetAllowUnauthenticatedPing(),
+		pingAuthWindow:  time.Duration(cfg.GetPingAuthWindow()) * time.Second,
+		seenPingNonces:  make(map[string]time.Time),
+	}
+	if g.pingAuthWindow == 0 {
+		g.pingAuthWindow = DefaultPingAuthWindow
+	}
+	if g.listenAddr == "" {
+		g.listenAddr = DefaultListenAddr
+	}
+	if cfg.GetDefaultFee() != "" {
+		if err := checkFee(cfg.GetDefaultFee()); err != nil {
+			return nil, err
+		}
+		g.defaultFee = cfg.GetDefaultFee()
+	}
+	for _, rule := range cfg.GetFeeRules() {
+		if err := checkFee(rule.GetFee()); err != nil {
+			return nil, err
+		}
+		g.feeRules = append(g.feeRules, &cbn.FakeFeeRule{TransferOutId: normalizeHex(rule.GetTransferOutId()), Fee: rule.GetFee()})
+	}
+	for chainId, gasPrice := range cfg.GetGasPrices() {
+		g.gasPrices[chainId] = gasPrice
+	}
+	for _, rule := range cfg.GetErrorRules() {
+		code, found := gatewayrpc.ErrCode_value[rule.GetCode()]
+		if !found {
+			return nil, fmt.Errorf("unknown error code %q", rule.GetCode())
+		}
+		if err := g.InjectError(rule.GetMethod(), rule.GetKey(), gatewayrpc.ErrCode(code), rule.GetCount()); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// SetFee sets the fee of the transfer, or of all transfers if transferOutId is empty. It takes precedence
+// over the existing fee rules.
+func (g *Gateway) SetFee(transferOutId, fee string) error {
+	if err := checkFee(fee); err != nil {
+		return err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	rule := &cbn.FakeFeeRule{TransferOutId: normalizeHex(transferOutId), Fee: fee}
+	g.feeRules = append([]*cbn.FakeFeeRule{rule}, g.feeRules...)
+	return nil
+}
+
+// SetGasPrice sets the gas price in gwei returned for the chain.
+func (g *Gateway) SetGasPrice(chainId, gasPrice uint64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.gasPrices[chainId] = gasPrice
+}
+
+// InjectError makes the method return the error code count times, or always if count is 0. Empty method
+// matches all methods and empty key matches all eth addrs of Ping or transfer out ids of GetFee.
+func (g *Gateway) InjectError(method, key string, code gatewayrpc.ErrCode, count uint64) error {
+	if method != "" && method != MethodPing && method != MethodGetFee {
+		return fmt.Errorf("unknown method %q", method)
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.errorRules = append(g.errorRules, &errorRule{
+		method: method,
+		key:    normalizeHex(key),
+		code:   code,
+		left:   count,
+		always: count == 0,
+	})
+	return nil
+}
+
+// ClearErrors removes all injected errors.
+func (g *Gateway) ClearErrors() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.errorRules = nil
+}
+
+// Pings returns the received ping requests in order.
+func (g *Gateway) Pings() []*gatewayrpc.PingRequest {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return append([]*gatewayrpc.PingRequest{}, g.pings...)
+}
+
+// FeeRequests returns the transfer out ids of the received GetFee requests in order.
+func (g *Gateway) FeeRequests() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return append([]string{}, g.feeRequests...)
+}
+
+func (g *Gateway) Ping(ctx context.Context, req *gatewayrpc.PingRequest) (*gatewayrpc.PingResponse, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.pings = append(g.pings, proto.Clone(req).(*gatewayrpc.PingRequest))
+	log.Infof("fake gateway: ping from %s, name:%s, version:%s, chains:%d", req.GetEthAddr(), req.GetNickName(),
+		req.GetNodeVersion(), len(req.GetChainInfo()))
+	if err := g.checkPingAuth(req); err != nil {
+		log.Warnf("fake gateway: reject ping from %s, err:%v", req.GetEthAddr(), err)
+		return &gatewayrpc.PingResponse{Err: &gatewayrpc.ErrMsg{
+			Code: gatewayrpc.ErrCode_NODE_NOT_PERMITTED,
+			Msg:  fmt.Sprintf("invalid ping auth: %v", err),
+		}}, nil
+	}
+	if errMsg := g.injectedError(MethodPing, req.GetEthAddr()); errMsg != nil {
+		return &gatewayrpc.PingResponse{Err: errMsg}, nil
+	}
+	resp := &gatewayrpc.PingResponse{ChainInfo: make(map[uint64]*gatewayrpc.GatewayChainInfo)}
+	for _, chainInfo := range req.GetChainInfo() {
+		gasPrice, found := g.gasPrices[chainInfo.GetChainId()]
+		if !found {
+			gasPrice = g.defaultGasPrice
+		}
+		resp.ChainInfo[chainInfo.GetChainId()] = &gatewayrpc.GatewayChainInfo{GasPrice: gasPrice}
+	}
+	return resp, nil
+}
+
+func (g *Gateway) GetFee(ctx context.Context, req *gatewayrpc.GetFeeRequest) (*gatewayrpc.GetFeeResponse, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.feeRequests = append(g.feeRequests, req.GetTransferOutId())
+	if errMsg := g.injectedError(MethodGetFee, req.GetTransferOutId()); errMsg != nil {
+		return &gatewayrpc.GetFeeResponse{Err: errMsg}, nil
+	}
+	fee := g.defaultFee
+	for _, rule := range g.feeRules {
+		if rule.GetTransferOutId() == "" || rule.GetTransferOutId() == normalizeHex(req.GetTransferOutId()) {
+			fee = rule.GetFee()
+			break
+		}
+	}
+	return &gatewayrpc.GetFeeResponse{Fee: fee}, nil
+}
+
+// checkPingAuth verifies the ping is signed by the node recently and not replayed, g.lock must be held.
+func (g *Gateway) checkPingAuth(req *gatewayrpc.PingRequest) error {
+	if req.GetAuth() == nil && g.allowNoAuth {
+		return nil
+	}
+	now := time.Now()
+	if err := gatewayrpc.VerifyPingAuth(req, now, g.pingAuthWindow); err != nil {
+		return err
+	}
+	for key, ts := range g.seenPingNonces {
+		if ts.Before(now.Add(-g.pingAuthWindow)) {
+			delete(g.seenPingNonces, key)
+		}
+	}
+	// not keyed on the sig, another encoding of the same signature would pass
+	key := normalizeHex(req.GetEthAddr()) + "/" + hex.EncodeToString(req.GetAuth().GetNonce())
+	if _, seen := g.seenPingNonces[key]; seen {
+		return fmt.Errorf("replayed ping auth")
+	}
+	g.seenPingNonces[key] = time.Unix(int64(req.GetAuth().GetTimestamp()), 0)
+	return nil
+}
+
+// injectedError returns the error of the first matching rule, g.lock must be held.
+func (g *Gateway) injectedError(method, key string) *gatewayrpc.ErrMsg {
+	for i, rule := range g.errorRules {
+		if rule.method != "" && rule.method != method || rule.key != "" && rule.key != normalizeHex(key) {
+			continue
+		}
+		if !rule.always {
+			rule.left--
+			if rule.left == 0 {
+				g.errorRules = append(g.errorRules[:i], g.errorRules[i+1:]...)
+			}
+		}
+		return &gatewayrpc.ErrMsg{
+			Code: rule.code,
+			Msg:  fmt.Sprintf("fake gateway injected %s error", rule.code),
+		}
+	}
+	return nil
+}
+
+// ListenAndServe serves the Relay service at the listen address without TLS, until Stop is called.
+func (g *Gateway) ListenAndServe() error {
+	lis, err := net.Listen("tcp", g.listenAddr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(lis)
+}
+
+// Serve serves the Relay service on lis without TLS, until Stop is called.
+func (g *Gateway) Serve(lis net.Listener) error {
+	g.lock.Lock()
+	if g.grpcServer == nil {
+		g.grpcServer = grpc.NewServer()
+		gatewayrpc.RegisterRelayServer(g.grpcServer, g)
+	}
+	grpcServer := g.grpcServer
+	g.lock.Unlock()
+	log.Infof("fake gateway serving on %s", lis.Addr())
+	return grpcServer.Serve(lis)
+}
+
+func (g *Gateway) Stop() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.grpcServer != nil {
+		g.grpcServer.Stop()
+	}
+}
+
+// Client returns an in-process Relay client of the gateway, which needs no network.
+func (g *Gateway) Client() gatewayrpc.RelayClient {
+	return &localClient{g: g}
+}
+
+type localClient struct {
+	g *Gateway
+}
+
+func (c *localClient) Ping(ctx context.Context, in *gatewayrpc.PingRequest, opts ...grpc.CallOption) (*gatewayrpc.PingResponse, error) {
+	return c.g.Ping(ctx, in)
+}
+
+func (c *localClient) GetFee(ctx context.Context, in *gatewayrpc.GetFeeRequest, opts ...grpc.CallOption) (*gatewayrpc.GetFeeResponse, error) {
+	return c.g.GetFee(ctx, in)
+}
+
+func checkFee(fee string) error {
+	v, ok := new(big.Int).SetString(fee, 10)
+	if !ok || v.Sign() < 0 {
+		return fmt.Errorf("invalid fee %q", fee)
+	}
+	return nil
+}
+
+// normalizeHex makes hex addrs and ids comparable regardless of case and 0x prefix.
+func normalizeHex(s string) string {
+	return strings.TrimPrefix(strings.ToLower(s), "0x")
+}
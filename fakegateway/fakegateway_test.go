@@ -0,0 +1,266 @@
+package fakegateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/gatewayrpc"
+	"github.com/celer-network/cBridge-go/server"
+	"github.com/celer-network/goutils/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	testTid1 = "0x1111111111111111111111111111111111111111111111111111111111111111"
+	testTid2 = "0x2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func getFee(t *testing.T, client gatewayrpc.RelayClient, tid string) *gatewayrpc.GetFeeResponse {
+	t.Helper()
+	resp, err := client.GetFee(context.Background(), &gatewayrpc.GetFeeRequest{TransferOutId: tid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]*cbn.FakeGatewayConfig{
+		"default fee": {DefaultFee: "-1"},
+		"rule fee":    {FeeRules: []*cbn.FakeFeeRule{{Fee: "1.5"}}},
+		"error code":  {ErrorRules: []*cbn.FakeErrorRule{{Code: "NOT_A_CODE"}}},
+		"method":      {ErrorRules: []*cbn.FakeErrorRule{{Method: "Relay", Code: "TRANSFER_NOT_FOUND"}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+}
+
+func TestFeeRules(t *testing.T) {
+	g, err := New(&cbn.FakeGatewayConfig{
+		DefaultFee: "10",
+		FeeRules:   []*cbn.FakeFeeRule{{TransferOutId: testTid1[2:], Fee: "20"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := g.Client()
+	if fee := getFee(t, client, testTid1).GetFee(); fee != "20" {
+		t.Errorf("expect rule fee 20, got %s", fee)
+	}
+	if fee := getFee(t, client, testTid2).GetFee(); fee != "10" {
+		t.Errorf("expect default fee 10, got %s", fee)
+	}
+	if err = g.SetFee("", "30"); err != nil {
+		t.Fatal(err)
+	}
+	if fee := getFee(t, client, testTid1).GetFee(); fee != "30" {
+		t.Errorf("expect latest fee 30, got %s", fee)
+	}
+	if got := g.FeeRequests(); len(got) != 3 || got[2] != testTid1 {
+		t.Errorf("unexpected fee requests %v", got)
+	}
+}
+
+func TestInjectError(t *testing.T) {
+	g, err := New(&cbn.FakeGatewayConfig{
+		ErrorRules:               []*cbn.FakeErrorRule{{Method: MethodGetFee, Key: testTid1, Code: "TRANSFER_NOT_FOUND", Count: 2}},
+		AllowUnauthenticatedPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := g.Client()
+	for i := 0; i < 2; i++ {
+		if code := getFee(t, client, testTid1).GetErr().GetCode(); code != gatewayrpc.ErrCode_TRANSFER_NOT_FOUND {
+			t.Errorf("attempt %d: expect TRANSFER_NOT_FOUND, got %s", i, code)
+		}
+	}
+	if resp := getFee(t, client, testTid1); resp.GetErr() != nil || resp.GetFee() != "0" {
+		t.Errorf("expect error used up, got %v", resp)
+	}
+	if resp := getFee(t, client, testTid2); resp.GetErr() != nil {
+		t.Errorf("expect no error for other transfer, got %v", resp)
+	}
+
+	if err = g.InjectError(MethodPing, "", gatewayrpc.ErrCode_NODE_NOT_PERMITTED, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Ping(context.Background(), &gatewayrpc.PingRequest{EthAddr: "0xabc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := resp.GetErr().GetCode(); code != gatewayrpc.ErrCode_NODE_NOT_PERMITTED {
+			t.Errorf("attempt %d: expect NODE_NOT_PERMITTED, got %s", i, code)
+		}
+	}
+	g.ClearErrors()
+	if resp, _ := client.Ping(context.Background(), &gatewayrpc.PingRequest{}); resp.GetErr() != nil {
+		t.Errorf("expect errors cleared, got %v", resp)
+	}
+}
+
+func signPing(t *testing.T, req *gatewayrpc.PingRequest, key *ecdsa.PrivateKey, now time.Time) {
+	t.Helper()
+	err := gatewayrpc.SignPing(req, now, func(data []byte) ([]byte, error) {
+		return crypto.Sign(eth.GeneratePrefixedHash(data), key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pingErr(t *testing.T, client gatewayrpc.RelayClient, req *gatewayrpc.PingRequest) *gatewayrpc.ErrMsg {
+	t.Helper()
+	resp, err := client.Ping(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.GetErr()
+}
+
+func TestPingAuth(t *testing.T) {
+	g, err := New(&cbn.FakeGatewayConfig{PingAuthWindow: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := g.Client()
+	key, _ := crypto.GenerateKey()
+	newPing := func() *gatewayrpc.PingRequest {
+		return &gatewayrpc.PingRequest{
+			EthAddr:     crypto.PubkeyToAddress(key.PublicKey).Hex(),
+			NickName:    "local",
+			NodeVersion: "v1",
+			ChainInfo: []*gatewayrpc.ChainInfo{{
+				ChainId:         56,
+				FeePer_10000:    5,
+				TokenAndBalance: map[string]string{"0x55d398326f99059fF775485246999027B3197955": "1000", "0x0000000000000000000000000000000000000001": "7"},
+			}, {ChainId: 1}},
+		}
+	}
+
+	req := newPing()
+	signPing(t, req, key, time.Now())
+	if errMsg := pingErr(t, client, req); errMsg != nil {
+		t.Fatalf("expect signed ping accepted, got %v", errMsg)
+	}
+	// chain infos and tokens in another order sign the same payload
+	reordered := proto.Clone(req).(*gatewayrpc.PingRequest)
+	reordered.ChainInfo[0], reordered.ChainInfo[1] = reordered.ChainInfo[1], reordered.ChainInfo[0]
+	payload1, _ := gatewayrpc.PingAuthPayload(req)
+	payload2, _ := gatewayrpc.PingAuthPayload(reordered)
+	if string(payload1) != string(payload2) {
+		t.Error("expect payload independent of order")
+	}
+	if errMsg := pingErr(t, client, req); errMsg.GetCode() != gatewayrpc.ErrCode_NODE_NOT_PERMITTED {
+		t.Errorf("expect replayed ping rejected, got %v", errMsg)
+	}
+	// other encodings of the same signature are replays too: v as 27/28, and the high-s form
+	vShifted := proto.Clone(req).(*gatewayrpc.PingRequest)
+	vShifted.Auth.Sig[64] += 27
+	highS := proto.Clone(req).(*gatewayrpc.PingRequest)
+	sig := highS.Auth.Sig
+	copy(sig[32:64], common.LeftPadBytes(new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(sig[32:64])).Bytes(), 32))
+	sig[64] ^= 1
+	for name, malleated := range map[string]*gatewayrpc.PingRequest{"v": vShifted, "high s": highS} {
+		if err := gatewayrpc.VerifyPingAuth(malleated, time.Now(), time.Minute); err != nil {
+			t.Fatalf("expect %s malleated sig valid, got err:%v", name, err)
+		}
+		if errMsg := pingErr(t, client, malleated); errMsg.GetCode() != gatewayrpc.ErrCode_NODE_NOT_PERMITTED {
+			t.Errorf("expect ping with %s malleated sig rejected, got %v", name, errMsg)
+		}
+	}
+
+	otherKey, _ := crypto.GenerateKey()
+	for name, tamper := range map[string]func(req *gatewayrpc.PingRequest){
+		"balance": func(req *gatewayrpc.PingRequest) {
+			req.ChainInfo[0].TokenAndBalance["0x0000000000000000000000000000000000000001"] = "8"
+		},
+		"fee rate": func(req *gatewayrpc.PingRequest) { req.ChainInfo[1].FeePer_10000 = 1 },
+		"version":  func(req *gatewayrpc.PingRequest) { req.NodeVersion = "v2" },
+		"chain":    func(req *gatewayrpc.PingRequest) { req.ChainInfo = req.ChainInfo[:1] },
+		"signer":   func(req *gatewayrpc.PingRequest) { signPing(t, req, otherKey, time.Now()) },
+		"expired":  func(req *gatewayrpc.PingRequest) { signPing(t, req, key, time.Now().Add(-2*time.Minute)) },
+		"missing":  func(req *gatewayrpc.PingRequest) { req.Auth = nil },
+	} {
+		req := newPing()
+		signPing(t, req, key, time.Now())
+		tamper(req)
+		if errMsg := pingErr(t, client, req); errMsg.GetCode() != gatewayrpc.ErrCode_NODE_NOT_PERMITTED {
+			t.Errorf("%s: expect ping rejected, got %v", name, errMsg)
+		}
+	}
+
+	g, err = New(&cbn.FakeGatewayConfig{AllowUnauthenticatedPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errMsg := pingErr(t, g.Client(), newPing()); errMsg != nil {
+		t.Errorf("expect ping without auth accepted, got %v", errMsg)
+	}
+}
+
+func TestOverGrpc(t *testing.T) {
+	g, err := New(&cbn.FakeGatewayConfig{
+		DefaultFee:      "1000",
+		DefaultGasPrice: 5,
+		GasPrices:       map[uint64]uint64{56: 7},
+		ErrorRules:      []*cbn.FakeErrorRule{{Method: MethodGetFee, Code: "TRANSFER_NOT_FOUND", Count: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(lis)
+	defer g.Stop()
+
+	gateway, err := server.NewGatewayAPI(lis.Addr().String(), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	key, _ := crypto.GenerateKey()
+	req := &gatewayrpc.PingRequest{
+		EthAddr:   crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		NickName:  "local",
+		ChainInfo: []*gatewayrpc.ChainInfo{{ChainId: 1}, {ChainId: 56}},
+	}
+	signPing(t, req, key, time.Now())
+	resp, err := gateway.PingGateway(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetChainInfo()[1].GetGasPrice() != 5 || resp.GetChainInfo()[56].GetGasPrice() != 7 {
+		t.Errorf("unexpected gas prices %v", resp.GetChainInfo())
+	}
+	if pings := g.Pings(); len(pings) != 1 || pings[0].GetNickName() != "local" {
+		t.Errorf("unexpected pings %v", pings)
+	}
+
+	var gatewayErr *server.GatewayError
+	if _, err = gateway.GetFee(context.Background(), common.HexToHash(testTid1)); !errors.As(err, &gatewayErr) ||
+		gatewayErr.Err.GetCode() != gatewayrpc.ErrCode_TRANSFER_NOT_FOUND {
+		t.Fatalf("expect TRANSFER_NOT_FOUND, got %v", err)
+	}
+	fee, err := gateway.GetFee(context.Background(), common.HexToHash(testTid1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.Int64() != 1000 {
+		t.Errorf("expect fee 1000, got %s", fee)
+	}
+}
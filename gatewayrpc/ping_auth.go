@@ -0,0 +1,133 @@
+package gatewayrpc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/celer-network/goutils/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	PingAuthVersion   = 1
+	PingAuthNonceSize = 16
+
+	pingAuthDomain = "cbridge-ping-v1"
+)
+
+// PingAuthPayload returns the 32 bytes signed in the ping auth, keccak256 of
+//
+//	"cbridge-ping-v1" ++ eth_addr (20 bytes) ++ timestamp (uint64) ++ nonce ++ keccak256(nick_name) ++
+//	keccak256(node_version) ++ keccak256(chain infos)
+//
+// where the chain infos are sorted by chain id, each encoded as chain_id (uint64) ++ fee_per_10000 (uint64) ++
+// number of tokens (uint64) followed by its tokens sorted by addr, each token addr (20 bytes) ++ balance (uint256).
+// Integers are big endian.
+func PingAuthPayload(req *PingRequest) ([]byte, error) {
+	auth := req.GetAuth()
+	if auth.GetVersion() != PingAuthVersion {
+		return nil, fmt.Errorf("unsupported ping auth version %d", auth.GetVersion())
+	}
+	if !common.IsHexAddress(req.GetEthAddr()) {
+		return nil, fmt.Errorf("invalid eth addr %q", req.GetEthAddr())
+	}
+	chainInfos := append([]*ChainInfo{}, req.GetChainInfo()...)
+	sort.Slice(chainInfos, func(i, j int) bool { return chainInfos[i].GetChainId() < chainInfos[j].GetChainId() })
+	var chains []byte
+	for _, chainInfo := range chainInfos {
+		chains = appendUint64(chains, chainInfo.GetChainId())
+		chains = appendUint64(chains, chainInfo.GetFeePer_10000())
+		chains = appendUint64(chains, uint64(len(chainInfo.GetTokenAndBalance())))
+		tokens := make([]common.Address, 0, len(chainInfo.GetTokenAndBalance()))
+		balances := make(map[common.Address]*big.Int)
+		for token, balanceStr := range chainInfo.GetTokenAndBalance() {
+			if !common.IsHexAddress(token) {
+				return nil, fmt.Errorf("invalid token addr %q on chain %d", token, chainInfo.GetChainId())
+			}
+			balance, ok := new(big.Int).SetString(balanceStr, 10)
+			if !ok || balance.Sign() < 0 || balance.BitLen() > 256 {
+				return nil, fmt.Errorf("invalid balance %q of token %s on chain %d", balanceStr, token, chainInfo.GetChainId())
+			}
+			addr := common.HexToAddress(token)
+			if _, dup := balances[addr]; dup {
+				return nil, fmt.Errorf("duplicate token %s on chain %d", token, chainInfo.GetChainId())
+			}
+			tokens = append(tokens, addr)
+			balances[addr] = balance
+		}
+		sort.Slice(tokens, func(i, j int) bool { return bytes.Compare(tokens[i].Bytes(), tokens[j].Bytes()) < 0 })
+		for _, token := range tokens {
+			chains = append(chains, token.Bytes()...)
+			chains = append(chains, math.U256Bytes(new(big.Int).Set(balances[token]))...)
+		}
+	}
+	payload := []byte(pingAuthDomain)
+	payload = append(payload, common.HexToAddress(req.GetEthAddr()).Bytes()...)
+	payload = appendUint64(payload, auth.GetTimestamp())
+	payload = append(payload, auth.GetNonce()...)
+	payload = append(payload, crypto.Keccak256([]byte(req.GetNickName()))...)
+	payload = append(payload, crypto.Keccak256([]byte(req.GetNodeVersion()))...)
+	payload = append(payload, crypto.Keccak256(chains)...)
+	return crypto.Keccak256(payload), nil
+}
+
+// SignPing sets the auth of the ping, signed at now by sign, e.g. eth.Signer.SignEthMessage.
+func SignPing(req *PingRequest, now time.Time, sign func(data []byte) ([]byte, error)) error {
+	nonce := make([]byte, PingAuthNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	req.Auth = &PingAuth{
+		Version:   PingAuthVersion,
+		Timestamp: uint64(now.Unix()),
+		Nonce:     nonce,
+	}
+	payload, err := PingAuthPayload(req)
+	if err != nil {
+		return err
+	}
+	req.Auth.Sig, err = sign(payload)
+	return err
+}
+
+// VerifyPingAuth checks the ping auth is signed by eth_addr within window of now. Callers should also
+// reject nonces of eth_addr they have seen within the window. Signatures are malleable, they must not be
+// used to detect replays.
+func VerifyPingAuth(req *PingRequest, now time.Time, window time.Duration) error {
+	auth := req.GetAuth()
+	if auth == nil {
+		return fmt.Errorf("missing ping auth")
+	}
+	if len(auth.GetNonce()) != PingAuthNonceSize {
+		return fmt.Errorf("invalid ping auth nonce size %d", len(auth.GetNonce()))
+	}
+	signedAt := time.Unix(int64(auth.GetTimestamp()), 0)
+	if signedAt.Before(now.Add(-window)) || signedAt.After(now.Add(window)) {
+		return fmt.Errorf("ping auth timestamp %d out of window", auth.GetTimestamp())
+	}
+	payload, err := PingAuthPayload(req)
+	if err != nil {
+		return err
+	}
+	signer, err := eth.RecoverSigner(payload, auth.GetSig())
+	if err != nil {
+		return fmt.Errorf("invalid ping auth sig, err:%w", err)
+	}
+	if signer != common.HexToAddress(req.GetEthAddr()) {
+		return fmt.Errorf("ping auth signed by %s, not %s", signer.Hex(), req.GetEthAddr())
+	}
+	return nil
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultListTransfersLimit = 100
+	maxListTransfersLimit     = 5000
+)
+
+// adminService serves the cbridgenode.CBridgeNode gRPC service on top of the relay node.
+type adminService struct {
+	cbn.UnimplementedCBridgeNodeServer
+	s *server
+}
+
+// StartAdminService starts the gRPC admin and query service if admin_config.listen_addr is set.
+// It returns right after the listener is created, the grpc server is served in background.
+func (s *server) StartAdminService() error {
+	adminCfg := s.cfg.GetAdminConfig()
+	if adminCfg.GetListenAddr() == "" {
+		log.Infoln("admin service is disabled, admin_config.listen_addr not set")
+		return nil
+	}
+	opts, err := adminServerOptions(adminCfg)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", adminCfg.GetListenAddr())
+	if err != nil {
+		return err
+	}
+	grpcServer := grpc.NewServer(opts...)
+	cbn.RegisterCBridgeNodeServer(grpcServer, &adminService{s: s})
+	// allow grpcurl and other tools to discover the service
+	reflection.Register(grpcServer)
+	go func() {
+		if serveErr := grpcServer.Serve(lis); serveErr != nil {
+			log.Errorf("admin service stopped, err:%v", serveErr)
+		}
+	}()
+	log.Infof("admin service listening on %s", adminCfg.GetListenAddr())
+	return nil
+}
+
+func adminServerOptions(adminCfg *cbn.AdminConfig) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	if adminCfg.GetTlsCert() != "" {
+		cert, err := tls.LoadX509KeyPair(adminCfg.GetTlsCert(), adminCfg.GetTlsKey())
+		if err != nil {
+			return nil, fmt.Errorf("fail to load admin tls cert, err:%w", err)
+		}
+		tlsCfg := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		if adminCfg.GetClientCa() != "" {
+			caPem, err := ioutil.ReadFile(adminCfg.GetClientCa())
+			if err != nil {
+				return nil, fmt.Errorf("fail to read admin client ca, err:%w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPem) {
+				return nil, fmt.Errorf("no valid certificate found in %s", adminCfg.GetClientCa())
+			}
+			tlsCfg.ClientCAs = pool
+			tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	} else if adminCfg.GetClientCa() != "" {
+		return nil, fmt.Errorf("admin_config.client_ca requires tls_cert and tls_key")
+	}
+
+	if len(adminCfg.GetAuthTokens()) > 0 {
+		tokens := adminCfg.GetAuthTokens()
+		opts = append(opts, grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if !strings.HasPrefix(info.FullMethod, "/cbridgenode.") {
+				// reflection service
+				return handler(ctx, req)
+			}
+			if err := checkAuthToken(ctx, tokens); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}))
+	} else if adminCfg.GetClientCa() == "" {
+		if !isLoopbackListenAddr(adminCfg.GetListenAddr()) {
+			return nil, fmt.Errorf("admin service on %s has neither client_ca nor auth_tokens configured, set one of them or listen on a loopback address",
+				adminCfg.GetListenAddr())
+		}
+		log.Warnln("admin service has neither client_ca nor auth_tokens configured, any local process may query and modify the node")
+	}
+	return opts, nil
+}
+
+// isLoopbackListenAddr returns if the host:port only accepts connections from the same machine.
+func isLoopbackListenAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func checkAuthToken(ctx context.Context, tokens []string) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing metadata")
+	}
+	for _, v := range md.Get("authorization") {
+		token := strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return nil
+			}
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid auth token")
+}
+
+func newNodeError(code cbn.ErrorCode, format string, a ...interface{}) *cbn.CbridgeNodeError {
+	return &cbn.CbridgeNodeError{
+		Code: code,
+		Msg:  fmt.Sprintf(format, a...),
+	}
+}
+
+func (a *adminService) ListTransfers(ctx context.Context, req *cbn.ListTransfersRequest) (*cbn.ListTransfersResponse, error) {
+	limit := req.GetLimit()
+	if limit == 0 {
+		limit = defaultListTransfersLimit
+	} else if limit > maxListTransfersLimit {
+		limit = maxListTransfersLimit
+	}
+	transfers, dbErr := a.s.db.GetTransfersWithFilter(limit, req.GetStatus(), req.GetChainId())
+	if dbErr != nil {
+		return &cbn.ListTransfersResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query transfers, err:%v", dbErr)}, nil
+	}
+	resp := &cbn.ListTransfersResponse{}
+	for _, tx := range transfers {
+		resp.Transfers = append(resp.Transfers, tx.toProto())
+	}
+	return resp, nil
+}
+
+func (a *adminService) GetTransfer(ctx context.Context, req *cbn.GetTransferRequest) (*cbn.GetTransferResponse, error) {
+	if req.GetTransferId() == "" {
+		return &cbn.GetTransferResponse{Err: newNodeError(cbn.ErrorCode_INVALID_ARGUMENT, "transfer_id is required")}, nil
+	}
+	tx, found, dbErr := a.s.db.GetTransferByTid(Hex2Hash(req.GetTransferId()))
+	if dbErr != nil {
+		return &cbn.GetTransferResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query transfer, err:%v", dbErr)}, nil
+	}
+	if !found {
+		return &cbn.GetTransferResponse{Err: newNodeError(cbn.ErrorCode_NOT_FOUND, "transfer %s not found", req.GetTransferId())}, nil
+	}
+	resp := &cbn.GetTransferResponse{Transfer: tx.toProto()}
+	related, found, dbErr := a.s.db.GetTransferByTid(tx.RelatedTid)
+	if dbErr != nil {
+		return &cbn.GetTransferResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query related transfer, err:%v", dbErr)}, nil
+	}
+	if found {
+		resp.RelatedTransfer = related.toProto()
+	}
+	for _, tid := range []Hash{tx.TransferId, tx.RelatedTid} {
+		chainTxs, dbErr := a.s.db.GetChainTxsByTransfer(tid)
+		if dbErr != nil {
+			return &cbn.GetTransferResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query txs, err:%v", dbErr)}, nil
+		}
+		for _, chainTx := range chainTxs {
+			resp.ChainTxs = append(resp.ChainTxs, chainTx.toProto())
+		}
+	}
+	return resp, nil
+}
+
+func (a *adminService) GetSummary(ctx context.Context, req *cbn.GetSummaryRequest) (*cbn.GetSummaryResponse, error) {
+	perChain2ChainSummary, dbErr := a.s.getTotalSummary()
+	if dbErr != nil {
+		return &cbn.GetSummaryResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query transfers, err:%v", dbErr)}, nil
+	}
+	resp := &cbn.GetSummaryResponse{}
+	for _, v := range perChain2ChainSummary {
+		pair := &cbn.ChainPairSummary{
+			SrcChainId:             v.SrcChainId,
+			DstChainId:             v.DstChainId,
+			TotalTransferOut:       v.TotalTransferOutNumber,
+			TotalSuccessTransferIn: v.TotalSuccessTransferInNumber,
+			GasCost:                v.GasCost.String(),
+			GasTokenName:           v.GasTokenName,
+		}
+		for _, tokenFee := range v.FeeReceived {
+			pair.Tokens = append(pair.Tokens, &cbn.TokenSummary{
+				TokenName:    tokenFee.TokenName,
+				TokenAddr:    tokenFee.TokenAddr.String(),
+				Volume:       tokenFee.TotalVolume.String(),
+				Fee:          tokenFee.FeeAmount.String(),
+				TokenDecimal: tokenFee.TokenDecimal,
+			})
+		}
+		resp.ChainPairs = append(resp.ChainPairs, pair)
+	}
+	return resp, nil
+}
+
+func (a *adminService) GetBalances(ctx context.Context, req *cbn.GetBalancesRequest) (*cbn.GetBalancesResponse, error) {
+	resp := &cbn.GetBalancesResponse{}
+	for chainId, bc := range a.s.chainMap {
+		if req.GetChainId() != 0 && req.GetChainId() != chainId {
+			continue
+		}
+		chainBalance := &cbn.ChainBalance{
+			ChainId:      chainId,
+			GasTokenName: a.s.getGasTokenInfo(chainId).GasTokenName,
+		}
+		gasBalance, err := bc.ec.BalanceAt(ctx, a.s.accountAddr, nil)
+		if err != nil {
+			return &cbn.GetBalancesResponse{Err: newNodeError(cbn.ErrorCode_CHAIN_ERROR, "fail to get gas balance on chain %d, err:%v", chainId, err)}, nil
+		}
+		chainBalance.GasBalance = gasBalance.String()
+		for addr, erc20 := range bc.erc20Map {
+			balance, err := erc20.BalanceOf(&bind.CallOpts{Context: ctx}, a.s.accountAddr)
+			if err != nil {
+				return &cbn.GetBalancesResponse{Err: newNodeError(cbn.ErrorCode_CHAIN_ERROR, "fail to get balance of token %s on chain %d, err:%v", addr.String(), chainId, err)}, nil
+			}
+			chainBalance.Tokens = append(chainBalance.Tokens, &cbn.TokenBalance{
+				TokenName:    a.s.getTokenName(chainId, addr),
+				TokenAddr:    addr.String(),
+				Balance:      balance.String(),
+				TokenDecimal: a.s.getTokenDecimal(chainId, addr),
+			})
+		}
+		resp.Chains = append(resp.Chains, chainBalance)
+	}
+	if req.GetChainId() != 0 && len(resp.Chains) == 0 {
+		return &cbn.GetBalancesResponse{Err: newNodeError(cbn.ErrorCode_NOT_FOUND, "chain %d not supported", req.GetChainId())}, nil
+	}
+	return resp, nil
+}
+
+func (a *adminService) GetChainStatus(ctx context.Context, req *cbn.GetChainStatusRequest) (*cbn.GetChainStatusResponse, error) {
+	resp := &cbn.GetChainStatusResponse{Gateway: a.s.gateway.Status(), Ha: a.s.haStatus()}
+	for chainId, bc := range a.s.chainMap {
+		chainStatus := &cbn.ChainStatus{
+			ChainId:         chainId,
+			ContractAddress: bc.contractChain.GetAddr().String(),
+			FeeRate:         a.s.getFeeRate(chainId),
+			CurrentBlock:    bc.currentBlockNumber(),
+			EventSource:     bc.eventSource(),
+		}
+		deferred, gasSaved := bc.confirmSched.stats()
+		chainStatus.DeferredConfirms = deferred
+		chainStatus.ConfirmGasSaved = gasSaved.String()
+		if gatewayChainInfo := a.s.getGatewayChainInfo(chainId); gatewayChainInfo != nil {
+			chainStatus.GatewayGasPrice = gatewayChainInfo.GetGasPrice()
+		}
+		resp.Chains = append(resp.Chains, chainStatus)
+	}
+	return resp, nil
+}
+
+func (a *adminService) SetFeeRate(ctx context.Context, req *cbn.SetFeeRateRequest) (*cbn.SetFeeRateResponse, error) {
+	if nodeErr := a.s.checkLeader(); nodeErr != nil {
+		return &cbn.SetFeeRateResponse{Err: nodeErr}, nil
+	}
+	if req.GetFeeRate() >= 10000 {
+		return &cbn.SetFeeRateResponse{Err: newNodeError(cbn.ErrorCode_INVALID_ARGUMENT, "fee rate %d should be less than 10000", req.GetFeeRate())}, nil
+	}
+	if !a.s.setFeeRate(req.GetChainId(), req.GetFeeRate()) {
+		return &cbn.SetFeeRateResponse{Err: newNodeError(cbn.ErrorCode_NOT_FOUND, "chain %d not supported", req.GetChainId())}, nil
+	}
+	log.Infof("admin set fee rate, chainId:%d, feeRate:%d", req.GetChainId(), req.GetFeeRate())
+	if pingErr := a.s.PingAndRefreshFee(); pingErr != nil {
+		return &cbn.SetFeeRateResponse{Err: newNodeError(cbn.ErrorCode_GATEWAY_ERROR, "fee rate updated but fail to ping gateway, err:%v", pingErr)}, nil
+	}
+	return &cbn.SetFeeRateResponse{}, nil
+}
+
+func (a *adminService) RetryTransfer(ctx context.Context, req *cbn.RetryTransferRequest) (*cbn.RetryTransferResponse, error) {
+	if nodeErr := a.s.checkLeader(); nodeErr != nil {
+		return &cbn.RetryTransferResponse{Err: nodeErr}, nil
+	}
+	tid := Hex2Hash(req.GetTransferId())
+	tx, found, dbErr := a.s.db.GetTransferByTid(tid)
+	if dbErr != nil {
+		return &cbn.RetryTransferResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query transfer, err:%v", dbErr)}, nil
+	}
+	if !found {
+		return &cbn.RetryTransferResponse{Err: newNodeError(cbn.ErrorCode_NOT_FOUND, "transfer %s not found", req.GetTransferId())}, nil
+	}
+	// same transitions as processRecoverTimeoutPending*, without waiting for the timeout, so only once no tx sent
+	// for the transfer may still be mined
+	var to cbn.TransferStatus
+	var queue *workQueue
+	var lg *logger
+	switch tx.Status {
+	case cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING:
+		to, queue, lg = cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START, a.s.sendQueue, senderLog
+	case cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING:
+		to, queue, lg = cbn.TransferStatus_TRANSFER_STATUS_LOCKED, a.s.confirmQueue, confirmerLog
+	case cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING:
+		to, queue, lg = cbn.TransferStatus_TRANSFER_STATUS_LOCKED, a.s.refundQueue, refunderLog
+	default:
+		return &cbn.RetryTransferResponse{
+			Err:    newNodeError(cbn.ErrorCode_FAILED_PRECONDITION, "transfer in status %s can not be retried", tx.Status.String()),
+			Status: tx.Status,
+		}, nil
+	}
+	chainTxs, dbErr := a.s.db.GetChainTxsByTransfer(tid)
+	if dbErr != nil {
+		return &cbn.RetryTransferResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query transfer txs, err:%v", dbErr)}, nil
+	}
+	for _, chainTx := range chainTxs {
+		if chainTx.Status == cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING {
+			return &cbn.RetryTransferResponse{
+				Err: newNodeError(cbn.ErrorCode_FAILED_PRECONDITION, "%s tx %x of the transfer is pending, retry once it is mined or dropped",
+					chainTx.Purpose, chainTx.TxHash),
+				Status: tx.Status,
+			}, nil
+		}
+	}
+	dbErr = a.s.db.SetTransferStatusByFrom(tid, to, tx.Status)
+	if dbErr != nil {
+		return &cbn.RetryTransferResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to reset transfer status, err:%v", dbErr)}, nil
+	}
+	queue.add(tid)
+	lg.transfer(tx).Infof("admin retry transfer, from:%s, to:%s", tx.Status.String(), to.String())
+	return &cbn.RetryTransferResponse{Status: to}, nil
+}
+
+func (a *adminService) PingGateway(ctx context.Context, req *cbn.PingGatewayRequest) (*cbn.PingGatewayResponse, error) {
+	if nodeErr := a.s.checkLeader(); nodeErr != nil {
+		return &cbn.PingGatewayResponse{Err: nodeErr}, nil
+	}
+	if pingErr := a.s.PingAndRefreshFee(); pingErr != nil {
+		return &cbn.PingGatewayResponse{Err: newNodeError(cbn.ErrorCode_GATEWAY_ERROR, "fail to ping gateway, err:%v", pingErr)}, nil
+	}
+	return &cbn.PingGatewayResponse{}, nil
+}
+
+func (a *adminService) Rebalance(ctx context.Context, req *cbn.RebalanceRequest) (*cbn.RebalanceResponse, error) {
+	if nodeErr := a.s.checkLeader(); nodeErr != nil {
+		return &cbn.RebalanceResponse{Err: nodeErr}, nil
+	}
+	amount, ok := new(big.Int).SetString(req.GetAmount(), 10)
+	if !ok {
+		return &cbn.RebalanceResponse{Err: newNodeError(cbn.ErrorCode_INVALID_ARGUMENT, "invalid amount %s", req.GetAmount())}, nil
+	}
+	if !common.IsHexAddress(req.GetBridgeAddr()) {
+		return &cbn.RebalanceResponse{Err: newNodeError(cbn.ErrorCode_INVALID_ARGUMENT, "invalid bridge addr %s", req.GetBridgeAddr())}, nil
+	}
+	r, err := a.s.Rebalance(req.GetSrcChainId(), req.GetDstChainId(), req.GetTokenName(), amount, Hex2Addr(req.GetBridgeAddr()),
+		time.Duration(req.GetTimeLock())*time.Second)
+	if err != nil {
+		code := cbn.ErrorCode_CHAIN_ERROR
+		if errors.Is(err, errInvalidRebalance) {
+			code = cbn.ErrorCode_INVALID_ARGUMENT
+		}
+		return &cbn.RebalanceResponse{Err: newNodeError(code, "fail to rebalance, err:%v", err)}, nil
+	}
+	rebalanceLog.rebalance(r).Infof("admin rebalance, dst:%d, token:%s, amount:%s, bridge:%s",
+		r.DstChainId, req.GetTokenName(), r.Amount.String(), r.BridgeAddr.String())
+	return &cbn.RebalanceResponse{Rebalance: r.toProto()}, nil
+}
+
+func (a *adminService) ListRebalances(ctx context.Context, req *cbn.ListRebalancesRequest) (*cbn.ListRebalancesResponse, error) {
+	limit := req.GetLimit()
+	if limit == 0 {
+		limit = defaultListTransfersLimit
+	} else if limit > maxListTransfersLimit {
+		limit = maxListTransfersLimit
+	}
+	rebalances, dbErr := a.s.db.GetRebalancesWithLimit(limit)
+	if dbErr != nil {
+		return &cbn.ListRebalancesResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query rebalances, err:%v", dbErr)}, nil
+	}
+	resp := &cbn.ListRebalancesResponse{}
+	for _, r := range rebalances {
+		resp.Rebalances = append(resp.Rebalances, r.toProto())
+	}
+	return resp, nil
+}
+
+func (a *adminService) ArchiveTransfers(ctx context.Context, req *cbn.ArchiveTransfersRequest) (*cbn.ArchiveTransfersResponse, error) {
+	archived, err := a.s.archiveTransfers()
+	if err != nil {
+		code := cbn.ErrorCode_DB_ERROR
+		if errors.Is(err, errArchiveDisabled) {
+			code = cbn.ErrorCode_FAILED_PRECONDITION
+		}
+		return &cbn.ArchiveTransfersResponse{Err: newNodeError(code, "fail to archive transfers, err:%v", err), Archived: archived}, nil
+	}
+	retentionLog.Infof("admin archive transfers, archived:%d", archived)
+	return &cbn.ArchiveTransfersResponse{Archived: archived}, nil
+}
+
+func (a *adminService) RestoreTransfers(ctx context.Context, req *cbn.RestoreTransfersRequest) (*cbn.RestoreTransfersResponse, error) {
+	from := time.Unix(int64(req.GetFromTs()), 0)
+	to := time.Now()
+	if req.GetToTs() > 0 {
+		to = time.Unix(int64(req.GetToTs()), 0)
+	}
+	if !from.Before(to) {
+		return &cbn.RestoreTransfersResponse{Err: newNodeError(cbn.ErrorCode_INVALID_ARGUMENT, "from %d not before to %d", req.GetFromTs(), req.GetToTs())}, nil
+	}
+	restored, err := a.s.restoreTransfers(from, to)
+	if err != nil {
+		return &cbn.RestoreTransfersResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to restore transfers, err:%v", err)}, nil
+	}
+	retentionLog.Infof("admin restore transfers, from:%d, to:%d, restored:%d", req.GetFromTs(), req.GetToTs(), restored)
+	return &cbn.RestoreTransfersResponse{Restored: restored}, nil
+}
+
+func (a *adminService) ListInboxEvents(ctx context.Context, req *cbn.ListInboxEventsRequest) (*cbn.ListInboxEventsResponse, error) {
+	limit := req.GetLimit()
+	if limit == 0 {
+		limit = defaultListTransfersLimit
+	} else if limit > maxListTransfersLimit {
+		limit = maxListTransfersLimit
+	}
+	events, dbErr := a.s.db.GetInboxEvents(limit, req.GetStatus(), req.GetChainId())
+	if dbErr != nil {
+		return &cbn.ListInboxEventsResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query inbox events, err:%v", dbErr)}, nil
+	}
+	resp := &cbn.ListInboxEventsResponse{}
+	for _, e := range events {
+		resp.Events = append(resp.Events, e.toProto())
+	}
+	return resp, nil
+}
+
+func (a *adminService) ListChainTxs(ctx context.Context, req *cbn.ListChainTxsRequest) (*cbn.ListChainTxsResponse, error) {
+	var txs []*ChainTx
+	var dbErr error
+	if req.GetTransferId() != "" {
+		txs, dbErr = a.s.db.GetChainTxsByTransfer(Hex2Hash(req.GetTransferId()))
+	} else {
+		limit := req.GetLimit()
+		if limit == 0 {
+			limit = defaultListTransfersLimit
+		} else if limit > maxListTransfersLimit {
+			limit = maxListTransfersLimit
+		}
+		txs, dbErr = a.s.db.GetChainTxs(limit, req.GetStatus(), req.GetChainId())
+	}
+	if dbErr != nil {
+		return &cbn.ListChainTxsResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to query txs, err:%v", dbErr)}, nil
+	}
+	resp := &cbn.ListChainTxsResponse{}
+	for _, t := range txs {
+		resp.Txs = append(resp.Txs, t.toProto())
+	}
+	return resp, nil
+}
+
+func (a *adminService) ReprocessInboxEvents(ctx context.Context, req *cbn.ReprocessInboxEventsRequest) (*cbn.ReprocessInboxEventsResponse, error) {
+	txHash := ""
+	if req.GetTxHash() != "" {
+		txHash = Hex2Hash(req.GetTxHash()).String()
+	}
+	requeued, err := a.s.reprocessInboxEvents(req.GetChainId(), txHash)
+	if err != nil {
+		return &cbn.ReprocessInboxEventsResponse{Err: newNodeError(cbn.ErrorCode_DB_ERROR, "fail to requeue inbox events, err:%v", err)}, nil
+	}
+	monitorLog.with("chain_id", req.GetChainId(), "tx_hash", req.GetTxHash()).Infof("admin reprocess inbox events, requeued:%d", requeued)
+	return &cbn.ReprocessInboxEventsResponse{Requeued: requeued}, nil
+}
+
+func (tx *Transfer) toProto() *cbn.Transfer {
+	return &cbn.Transfer{
+		TransferId:        tx.TransferId.Bytes(),
+		CreateTs:          uint64(tx.CreateTs.Unix()),
+		Status:            tx.Status,
+		Type:              tx.TransferType,
+		ContractAddr:      tx.Token.Bytes(),
+		ChainId:           tx.ChainId,
+		FromAddr:          tx.Sender.Bytes(),
+		ToAddr:            tx.Receiver.Bytes(),
+		TimeLock:          uint64(tx.TimeLock.Unix()),
+		Amount:            tx.Amount.String(),
+		Fee:               tx.Fee.String(),
+		TxHash:            tx.TxHash.Bytes(),
+		HashLock:          tx.HashLock.Bytes(),
+		RelatedTransferId: tx.RelatedTid.Bytes(),
+		RelatedChainId:    tx.RelatedChainId,
+		RelatedToken:      tx.RelatedToken.Bytes(),
+		UpdateTs:          uint64(tx.UpdateTs.Unix()),
+		TxConfirmHash:     tx.TxConfirmHash.Bytes(),
+		TxRefundHash:      tx.TxRefundHash.Bytes(),
+		PreimageStatus:    tx.PreimageStatus,
+	}
+}
+
+func (e *InboxEvent) toProto() *cbn.InboxEvent {
+	return &cbn.InboxEvent{
+		ChainId:     e.ChainId,
+		TxHash:      e.Log.TxHash.Bytes(),
+		LogIndex:    uint64(e.Log.Index),
+		BlockNumber: e.Log.BlockNumber,
+		EventName:   e.EventName,
+		Status:      e.Status,
+		Attempts:    e.Attempts,
+		LastError:   e.LastError,
+		CreateTs:    uint64(e.CreateTs.Unix()),
+		UpdateTs:    uint64(e.UpdateTs.Unix()),
+	}
+}
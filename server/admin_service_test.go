@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminServerOptions(t *testing.T) {
+	tests := []struct {
+		cfg  *cbn.AdminConfig
+		fail bool
+	}{
+		{&cbn.AdminConfig{ListenAddr: "127.0.0.1:8089"}, false},
+		{&cbn.AdminConfig{ListenAddr: "[::1]:8089"}, false},
+		{&cbn.AdminConfig{ListenAddr: "localhost:8089"}, false},
+		{&cbn.AdminConfig{ListenAddr: "0.0.0.0:8089"}, true},
+		{&cbn.AdminConfig{ListenAddr: ":8089"}, true},
+		{&cbn.AdminConfig{ListenAddr: "10.0.0.1:8089"}, true},
+		{&cbn.AdminConfig{ListenAddr: "0.0.0.0:8089", AuthTokens: []string{"token"}}, false},
+		{&cbn.AdminConfig{ListenAddr: "0.0.0.0:8089", ClientCa: "ca.crt"}, true}, // client_ca requires tls
+	}
+	for _, test := range tests {
+		_, err := adminServerOptions(test.cfg)
+		if (err != nil) != test.fail {
+			t.Errorf("config %v: expect fail %t, got err:%v", test.cfg, test.fail, err)
+		}
+	}
+}
+
+func TestAdminAuthToken(t *testing.T) {
+	tokens := []string{"token1", "token2"}
+	for authz, ok := range map[string]bool{
+		"Bearer token2": true,
+		"token1":        true,
+		"Bearer token3": false,
+		"":              false,
+	} {
+		ctx := context.Background()
+		if authz != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", authz))
+		}
+		err := checkAuthToken(ctx, tokens)
+		if ok && err != nil {
+			t.Errorf("authorization %q: expect ok, got err:%v", authz, err)
+		}
+		if !ok && status.Code(err) != codes.Unauthenticated {
+			t.Errorf("authorization %q: expect unauthenticated, got err:%v", authz, err)
+		}
+	}
+}
+
+func TestAdminRetryTransfer(t *testing.T) {
+	store, err := NewSqliteDAL(filepath.Join(t.TempDir(), "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := NewServer("test")
+	s.db = store
+	a := &adminService{s: s}
+	retry := func(tid Hash) *cbn.RetryTransferResponse {
+		resp, err := a.RetryTransfer(context.Background(), &cbn.RetryTransferRequest{TransferId: tid.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	in := newTestTransfer(0x80, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING, time.Now().Add(time.Hour))
+	locked := newTestTransfer(0x81, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now().Add(time.Hour))
+	for _, tx := range []*Transfer{in, locked} {
+		if err = store.InsertTransfer(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp := retry(Bytes2Hash([]byte{0x8f})); resp.GetErr().GetCode() != cbn.ErrorCode_NOT_FOUND {
+		t.Fatalf("expect not found, got %v", resp)
+	}
+	if resp := retry(locked.TransferId); resp.GetErr().GetCode() != cbn.ErrorCode_FAILED_PRECONDITION {
+		t.Fatalf("expect locked transfer not retried, got %v", resp)
+	}
+
+	// the transfer in tx may still be mined
+	chainTx := &ChainTx{
+		ChainId:    5,
+		TxHash:     Bytes2Hash([]byte{0x80, 9}),
+		TransferId: in.TransferId,
+		Purpose:    cbn.TxPurpose_TX_PURPOSE_TRANSFER_IN,
+		Nonce:      3,
+		Status:     cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING,
+	}
+	if err = store.InsertChainTx(chainTx); err != nil {
+		t.Fatal(err)
+	}
+	if resp := retry(in.TransferId); resp.GetErr().GetCode() != cbn.ErrorCode_FAILED_PRECONDITION {
+		t.Fatalf("expect retry refused with a pending tx, got %v", resp)
+	}
+	if got := mustGetTransfer(t, store, in.TransferId).Status; got != cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING {
+		t.Fatalf("expect transfer in still pending, got %s", got)
+	}
+	if ids := s.sendQueue.take(); len(ids) != 0 {
+		t.Fatalf("expect nothing queued, got %x", ids)
+	}
+
+	if err = store.SetChainTxFailed(chainTx.ChainId, chainTx.TxHash, "dropped"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := retry(in.TransferId); resp.GetErr() != nil || resp.GetStatus() != cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START {
+		t.Fatalf("expect transfer in retried, got %v", resp)
+	}
+	if got := mustGetTransfer(t, store, in.TransferId).Status; got != cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START {
+		t.Fatalf("expect transfer in start, got %s", got)
+	}
+	if ids := s.sendQueue.take(); len(ids) != 1 || ids[0] != in.TransferId {
+		t.Fatalf("expect transfer in queued, got %x", ids)
+	}
+}
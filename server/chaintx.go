@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/eth"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// pending txs sent longer ago are checked by reconcileChainTxs, e.g. those whose receipt the node stopped
+	// waiting for on a restart
+	chainTxReconcileAge   = 10 * time.Minute
+	chainTxReconcileLimit = 100
+	chainTxReceiptTimeout = 10 * time.Second
+)
+
+var (
+	revertedChainTxs = newCounter("cbridge/tx/reverted")
+	failedChainTxs   = newCounter("cbridge/tx/failed")
+)
+
+// recordChainTx records the sent tx as pending, linked to the pending tx of the same nonce it replaces if any.
+// The tx is sent already, so db errors are only logged.
+func (bc *bridgeConfig) recordChainTx(ctx context.Context, lg *logger, purpose cbn.TxPurpose, transferId Hash, tx *ethtypes.Transaction) *ChainTx {
+	chainTx := &ChainTx{
+		ChainId:    bc.chainId.Uint64(),
+		TxHash:     tx.Hash(),
+		TransferId: transferId,
+		Purpose:    purpose,
+		Nonce:      tx.Nonce(),
+		GasLimit:   tx.Gas(),
+		Status:     cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING,
+	}
+	if tx.Type() == ethtypes.DynamicFeeTxType {
+		chainTx.TxType = cbn.TxType_TX_TYPE_DYNAMIC_FEE
+		chainTx.GasFeeCap.Set(tx.GasFeeCap())
+		chainTx.GasTipCap.Set(tx.GasTipCap())
+	} else {
+		chainTx.GasPrice.Set(tx.GasPrice())
+	}
+	lg = lg.with("tx_hash", chainTx.TxHash)
+	db := bc.db.WithContext(ctx)
+	// e.g. the nonce of a pending tx reused after the transactor reset its nonce, or by the other node of an ha pair
+	replaced, found, dbErr := db.GetPendingChainTxByNonce(chainTx.ChainId, chainTx.Nonce)
+	if dbErr != nil {
+		lg.Errorf("fail to get pending tx of nonce %d, err:%v", chainTx.Nonce, dbErr)
+	} else if found {
+		chainTx.Replaces = replaced.TxHash
+		lg.Warnf("%s tx replaces pending %s tx %x of nonce %d", purpose, replaced.Purpose, replaced.TxHash, chainTx.Nonce)
+	}
+	if dbErr = db.InsertChainTx(chainTx); dbErr != nil {
+		lg.Errorf("fail to record tx, err:%v", dbErr)
+	}
+	return chainTx
+}
+
+// chainTxHandler saves the receipt of the tx recorded by recordChainTx in *chainTx, then calls handler.
+func (bc *bridgeConfig) chainTxHandler(lg *logger, chainTx **ChainTx, handler *eth.TransactionStateHandler) *eth.TransactionStateHandler {
+	return &eth.TransactionStateHandler{
+		OnMined: func(receipt *ethtypes.Receipt) {
+			ctx, cancel := context.WithTimeout(context.Background(), chainTxReceiptTimeout)
+			defer cancel()
+			bc.setChainTxMined(ctx, lg, *chainTx, receipt)
+			if handler != nil && handler.OnMined != nil {
+				handler.OnMined(receipt)
+			}
+		},
+		OnError: func(tx *ethtypes.Transaction, err error) {
+			failedChainTxs.Inc(1)
+			if dbErr := bc.db.SetChainTxFailed(bc.chainId.Uint64(), tx.Hash(), err.Error()); dbErr != nil {
+				lg.with("tx_hash", tx.Hash()).Errorf("fail to record tx failed, err:%v", dbErr)
+			}
+			if handler != nil && handler.OnError != nil {
+				handler.OnError(tx, err)
+			}
+		},
+	}
+}
+
+// setChainTxMined saves the status, gas used and cost of the mined tx, and the revert reason if it reverted.
+func (bc *bridgeConfig) setChainTxMined(ctx context.Context, lg *logger, chainTx *ChainTx, receipt *ethtypes.Receipt) {
+	lg = lg.with("tx_hash", chainTx.TxHash)
+	mined := *chainTx
+	mined.BlockNum = receipt.BlockNumber.Uint64()
+	mined.GasUsed = receipt.GasUsed
+	mined.Status = cbn.ChainTxStatus_CHAIN_TX_STATUS_SUCCEEDED
+	gasPrice, err := bc.effectiveGasPrice(ctx, chainTx, receipt.BlockNumber)
+	if err != nil {
+		lg.Warnf("fail to get effective gas price, err:%v", err)
+	} else {
+		mined.GasCost.Mul(gasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+	}
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		revertedChainTxs.Inc(1)
+		mined.Status = cbn.ChainTxStatus_CHAIN_TX_STATUS_REVERTED
+		mined.Error = bc.revertReason(ctx, chainTx.TxHash, receipt.BlockNumber)
+	}
+	if dbErr := bc.db.SetChainTxMined(&mined); dbErr != nil {
+		lg.Errorf("fail to record tx mined, err:%v", dbErr)
+	}
+}
+
+// effectiveGasPrice returns the gas price paid by the tx mined in the block. Receipts of this go-ethereum
+// version have no effectiveGasPrice, so for a dynamic fee tx it is derived from the base fee of the block.
+func (bc *bridgeConfig) effectiveGasPrice(ctx context.Context, chainTx *ChainTx, blockNum *big.Int) (*big.Int, error) {
+	if chainTx.TxType != cbn.TxType_TX_TYPE_DYNAMIC_FEE {
+		return new(big.Int).Set(&chainTx.GasPrice), nil
+	}
+	header, err := bc.ec.HeaderByNumber(ctx, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if header.BaseFee == nil {
+		return new(big.Int).Set(&chainTx.GasFeeCap), nil
+	}
+	price := new(big.Int).Add(header.BaseFee, &chainTx.GasTipCap)
+	if price.Cmp(&chainTx.GasFeeCap) > 0 {
+		price.Set(&chainTx.GasFeeCap)
+	}
+	return price, nil
+}
+
+// revertReason replays the reverted tx as a call on the state of its block to get the reason.
+func (bc *bridgeConfig) revertReason(ctx context.Context, txHash Hash, blockNum *big.Int) string {
+	tx, _, err := bc.ec.TransactionByHash(ctx, txHash)
+	if err != nil {
+		return fmt.Sprintf("reverted, fail to get tx, err:%v", err)
+	}
+	msg := ethereum.CallMsg{From: bc.trans.Address(), To: tx.To(), Gas: tx.Gas(), Value: tx.Value(), Data: tx.Data()}
+	_, err = bc.ec.CallContract(ctx, msg, blockNum)
+	if err == nil {
+		return "reverted, no reason in replay"
+	}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			if reason, unpackErr := abi.UnpackRevert(common.FromHex(data)); unpackErr == nil {
+				return reason
+			}
+		}
+	}
+	return err.Error()
+}
+
+// reconcileChainTxs checks the txs pending for long, as the node only waits for the receipts of the txs it
+// sent since it started. A tx without receipt is failed once its nonce is used by another tx.
+func (s *server) reconcileChainTxs() {
+	txs, dbErr := s.db.GetPendingChainTxs(time.Now().Add(-chainTxReconcileAge), chainTxReconcileLimit)
+	if dbErr != nil {
+		senderLog.Errorf("fail to get pending txs, err:%v", dbErr)
+		return
+	}
+	for _, chainTx := range txs {
+		bc := s.chainMap[chainTx.ChainId]
+		if bc == nil {
+			continue
+		}
+		lg := senderLog.with("chain_id", chainTx.ChainId).with("transfer_id", chainTx.TransferId)
+		ctx, cancel := context.WithTimeout(context.Background(), chainTxReceiptTimeout)
+		receipt, err := bc.ec.TransactionReceipt(ctx, chainTx.TxHash)
+		if err == nil {
+			lg.with("tx_hash", chainTx.TxHash).Infof("reconcile %s tx mined in block %s", chainTx.Purpose, receipt.BlockNumber)
+			bc.setChainTxMined(ctx, lg, chainTx, receipt)
+			cancel()
+			continue
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			lg.with("tx_hash", chainTx.TxHash).Warnf("fail to get tx receipt, err:%v", err)
+			cancel()
+			continue
+		}
+		nonce, err := bc.ec.NonceAt(ctx, bc.trans.Address(), nil)
+		cancel()
+		if err != nil {
+			lg.Warnf("fail to get nonce, err:%v", err)
+			continue
+		}
+		if nonce > chainTx.Nonce {
+			failedChainTxs.Inc(1)
+			lg.with("tx_hash", chainTx.TxHash).Warnf("%s tx dropped, nonce %d used by another tx", chainTx.Purpose, chainTx.Nonce)
+			if dbErr = s.db.SetChainTxFailed(chainTx.ChainId, chainTx.TxHash, "dropped, nonce used by another tx"); dbErr != nil {
+				lg.Errorf("fail to record tx failed, err:%v", dbErr)
+			}
+		}
+	}
+}
+
+func (t *ChainTx) toProto() *cbn.ChainTx {
+	pb := &cbn.ChainTx{
+		ChainId:     t.ChainId,
+		TxHash:      t.TxHash.Bytes(),
+		TransferId:  t.TransferId.Bytes(),
+		Purpose:     t.Purpose,
+		Nonce:       t.Nonce,
+		TxType:      t.TxType,
+		GasPrice:    t.GasPrice.String(),
+		GasFeeCap:   t.GasFeeCap.String(),
+		GasTipCap:   t.GasTipCap.String(),
+		GasLimit:    t.GasLimit,
+		CreateTs:    uint64(t.CreateTs.Unix()),
+		Status:      t.Status,
+		BlockNumber: t.BlockNum,
+		GasUsed:     t.GasUsed,
+		GasCost:     t.GasCost.String(),
+		Error:       t.Error,
+		UpdateTs:    uint64(t.UpdateTs.Unix()),
+	}
+	if t.Replaces != (Hash{}) {
+		pb.Replaces = t.Replaces.Bytes()
+	}
+	return pb
+}
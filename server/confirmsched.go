@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	defaultConfirmSafetyMargin = 2 * time.Hour
+	gasPriceQueryTimeout       = 10 * time.Second
+)
+
+var (
+	deferredConfirms    = newCounter("cbridge/confirm/deferred")
+	confirmGasSavedGwei = newCounter("cbridge/confirm/gas_saved_gwei")
+	confirmGasLostGwei  = newCounter("cbridge/confirm/gas_lost_gwei")
+)
+
+// confirmScheduler defers the confirms of transfers out on a chain while its gas price is higher than
+// max_gas_gwei, until the gas price drops or the transfer out timelock is within the safety margin.
+// Deferrals are kept in memory, after a restart a transfer is deferred again from the gas price then.
+type confirmScheduler struct {
+	maxGasPrice  *big.Int // nil means never defer
+	safetyMargin time.Duration
+
+	lock     sync.Mutex
+	deferred map[Hash]*deferredConfirm
+	gasSaved *big.Int // in wei, the deferrals that cost more are not counted
+}
+
+type deferredConfirm struct {
+	since time.Time
+	// max price per gas of the confirm when first deferred, i.e. of confirming right away, see txFees.gasPrice
+	gasPrice *big.Int
+}
+
+func newConfirmScheduler(cfg *cbn.ConfirmScheduleConfig) *confirmScheduler {
+	cs := &confirmScheduler{
+		safetyMargin: defaultConfirmSafetyMargin,
+		deferred:     make(map[Hash]*deferredConfirm),
+		gasSaved:     new(big.Int),
+	}
+	if cfg.GetMaxGasGwei() > 0 {
+		cs.maxGasPrice = gweiToWei(cfg.GetMaxGasGwei())
+	}
+	if cfg.GetSafetyMargin() > 0 {
+		cs.safetyMargin = time.Duration(cfg.GetSafetyMargin()) * time.Second
+	}
+	return cs
+}
+
+// schedule returns true if the confirm of the transfer should be deferred. Otherwise it returns the deferral
+// of the transfer ended now, nil if the transfer was not deferred.
+func (cs *confirmScheduler) schedule(bc *bridgeConfig, tx *Transfer) (bool, *deferredConfirm) {
+	if cs.maxGasPrice == nil || tx.TransferType != cbn.TransferType_TRANSFER_TYPE_OUT {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gasPriceQueryTimeout)
+	defer cancel()
+	gasPrice, err := bc.ec.SuggestGasPrice(ctx)
+	if err != nil {
+		confirmerLog.transfer(tx).Warnf("fail to get gas price, confirm without deferring, err:%v", err)
+		return false, cs.end(tx.TransferId)
+	}
+	deadline := tx.TimeLock.Add(-cs.safetyMargin)
+	if gasPrice.Cmp(cs.maxGasPrice) <= 0 {
+		return false, cs.end(tx.TransferId)
+	}
+	if !time.Now().Before(deadline) {
+		d := cs.end(tx.TransferId)
+		if d != nil {
+			confirmerLog.transfer(tx).Warnf("confirm deferred transfer at the safety deadline, gasPrice:%s, deferred since:%s",
+				gasPrice, d.since)
+		}
+		return false, d
+	}
+
+	cs.lock.Lock()
+	_, found := cs.deferred[tx.TransferId]
+	cs.lock.Unlock()
+	if found {
+		return true, nil
+	}
+	// the confirms of a chain are scheduled by one worker, no other deferral of the transfer is added meanwhile
+	d := &deferredConfirm{since: time.Now(), gasPrice: bc.suggestFees(ctx).gasPrice(gasPrice)}
+	cs.lock.Lock()
+	cs.deferred[tx.TransferId] = d
+	cs.lock.Unlock()
+	deferredConfirms.Inc(1)
+	confirmerLog.transfer(tx).Infof("defer confirm, gasPrice:%s, maxGasPrice:%s, deadline:%s", gasPrice, cs.maxGasPrice, deadline)
+	return true, nil
+}
+
+// end removes the deferral of the transfer and returns it, nil if the transfer was not deferred.
+func (cs *confirmScheduler) end(tid Hash) *deferredConfirm {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	d, found := cs.deferred[tid]
+	if !found {
+		return nil
+	}
+	delete(cs.deferred, tid)
+	return d
+}
+
+// onConfirmMined adds the gas saved by the deferral of the mined confirm, from the max price per gas of the
+// sent confirm. If the confirm was sent at a higher price than when first deferred, the loss is counted apart.
+func (cs *confirmScheduler) onConfirmMined(tid Hash, d *deferredConfirm, sent *ethtypes.Transaction, receipt *ethtypes.Receipt) {
+	lg := confirmerLog.with("transfer_id", tid, "tx_hash", receipt.TxHash)
+	// the fee cap of a dynamic fee tx, the gas price of a legacy tx
+	saved := new(big.Int).Sub(d.gasPrice, sent.GasFeeCap())
+	saved.Mul(saved, new(big.Int).SetUint64(receipt.GasUsed))
+	if saved.Sign() < 0 {
+		lost := new(big.Int).Neg(saved)
+		confirmGasLostGwei.Inc(new(big.Int).Div(lost, big.NewInt(1e9)).Int64())
+		lg.Warnf("deferred confirm mined at a higher gas price, deferred for:%s, gas lost:%s wei", time.Since(d.since), lost)
+		return
+	}
+	cs.lock.Lock()
+	cs.gasSaved.Add(cs.gasSaved, saved)
+	cs.lock.Unlock()
+	confirmGasSavedGwei.Inc(new(big.Int).Div(saved, big.NewInt(1e9)).Int64())
+	lg.Infof("deferred confirm mined, deferred for:%s, gas saved:%s wei", time.Since(d.since), saved)
+}
+
+// stats returns the number of deferred confirms and the gas saved in wei.
+func (cs *confirmScheduler) stats() (uint64, *big.Int) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	return uint64(len(cs.deferred)), new(big.Int).Set(cs.gasSaved)
+}
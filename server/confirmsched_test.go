@@ -0,0 +1,64 @@
+package server
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestConfirmScheduler(t *testing.T) {
+	c := newSimChain(t, 11)
+	bc := &bridgeConfig{chainId: c.chainId, ec: c.ec}
+	// the simulated gas price is base fee + 1 gwei
+	cs := newConfirmScheduler(&cbn.ConfirmScheduleConfig{MaxGasGwei: 1, SafetyMargin: 3600})
+
+	tx := newTestTransfer(1, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now().Add(10*time.Hour))
+	for i := 0; i < 2; i++ {
+		if deferConfirm, _ := cs.schedule(bc, tx); !deferConfirm {
+			t.Fatalf("scan %d: expect confirm deferred while gas price is high", i)
+		}
+	}
+	if deferred, _ := cs.stats(); deferred != 1 {
+		t.Fatalf("expect 1 deferred confirm, got %d", deferred)
+	}
+
+	in := newTestTransfer(2, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now().Add(10*time.Hour))
+	if deferConfirm, d := cs.schedule(bc, in); deferConfirm || d != nil {
+		t.Fatal("expect transfer in never deferred")
+	}
+
+	// within the safety margin
+	tx.TimeLock = time.Now().Add(30 * time.Minute)
+	deferConfirm, d := cs.schedule(bc, tx)
+	if deferConfirm || d == nil || d.gasPrice == nil {
+		t.Fatalf("expect confirm at the safety deadline ending the deferral, got %t %+v", deferConfirm, d)
+	}
+	// a dynamic fee confirm counts its fee cap
+	d.gasPrice = big.NewInt(15e9)
+	sent := ethtypes.NewTx(&ethtypes.DynamicFeeTx{GasFeeCap: big.NewInt(10e9), GasTipCap: big.NewInt(1e9)})
+	savedGwei, lostGwei := confirmGasSavedGwei.Count(), confirmGasLostGwei.Count()
+	cs.onConfirmMined(tx.TransferId, d, sent, &ethtypes.Receipt{GasUsed: 50000})
+	deferred, saved := cs.stats()
+	if deferred != 0 || saved.Cmp(big.NewInt(5e9*50000)) != 0 || confirmGasSavedGwei.Count() != savedGwei+250000 {
+		t.Fatalf("expect no deferred confirm and 2.5e14 wei saved, got %d %s", deferred, saved)
+	}
+	// a legacy confirm sent at a higher price is a loss, not counted as saved
+	sent = ethtypes.NewTx(&ethtypes.LegacyTx{GasPrice: big.NewInt(17e9)})
+	cs.onConfirmMined(tx.TransferId, d, sent, &ethtypes.Receipt{GasUsed: 50000})
+	if _, saved = cs.stats(); saved.Cmp(big.NewInt(5e9*50000)) != 0 || confirmGasSavedGwei.Count() != savedGwei+250000 {
+		t.Fatalf("expect the loss not counted as saved, got %s", saved)
+	}
+	if got := confirmGasLostGwei.Count(); got != lostGwei+100000 {
+		t.Fatalf("expect 1e5 gwei lost, got %d", got-lostGwei)
+	}
+
+	// never deferred when cheaper than the max
+	cs = newConfirmScheduler(&cbn.ConfirmScheduleConfig{MaxGasGwei: 1000})
+	tx.TimeLock = time.Now().Add(10 * time.Hour)
+	if deferConfirm, d = cs.schedule(bc, tx); deferConfirm || d != nil {
+		t.Fatal("expect confirm not deferred under the max gas price")
+	}
+}
@@ -1,19 +1,24 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math/big"
+	"strings"
 	"time"
 
 	cbn "github.com/celer-network/cBridge-go/cbridgenode"
 	"github.com/celer-network/goutils/log"
 	"github.com/celer-network/goutils/sqldb"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	transferAllColumns             = "tid,txhash,chainid,token,transfertype,timelock,hashlock,status,relatedtid,relatedchainid,relatedtoken,amount,fee,transfergascost,confirmgascost,refundgascost,preimage,senderaddr,receiveraddr,txconfirmhash,txrefundhash,updatets,createts"
-	transferAllColumnParams        = "$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23"
+	transferAllColumns             = "tid,txhash,chainid,token,transfertype,timelock,hashlock,status,relatedtid,relatedchainid,relatedtoken,amount,fee,transfergascost,confirmgascost,refundgascost,preimage,senderaddr,receiveraddr,txconfirmhash,txrefundhash,updatets,createts,preimagestatus"
+	transferAllColumnParams        = "$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24"
 	timeLockSafeMargin             = 6 * time.Minute
 	refundSafeMargin               = 3 * time.Minute
 	maxPendingTimeOutRetryDuration = 15 * time.Minute
@@ -21,17 +26,21 @@ const (
 
 type DAL struct {
 	*sqldb.Db
+	driver string
+	ctx    context.Context // queries are traced in its span if not nil
 }
 
 func NewDAL(driver, info string, poolSize int) (*DAL, error) {
 	db, err := sqldb.NewDb(driver, info, poolSize)
 	if err != nil {
-		log.Errorf("fail with db init:%s, %s, %d, err:%+v", driver, info, poolSize, err)
+		// info is not logged as it may contain the password
+		log.Errorf("fail with db init:%s, %d, err:%+v", driver, poolSize, err)
 		return nil, err
 	}
 
 	dal := &DAL{
-		db,
+		Db:     db,
+		driver: driver,
 	}
 	return dal, nil
 }
@@ -43,6 +52,18 @@ func (d *DAL) Close() {
 	}
 }
 
+// Ping checks the db is reachable.
+func (d *DAL) Ping() error {
+	var one int
+	return d.QueryRow(`SELECT 1`).Scan(&one)
+}
+
+// dbNow returns the current time in UTC. All times are stored in UTC so they compare correctly
+// on backends keeping timestamps as text, e.g. SQLite.
+func dbNow() time.Time {
+	return time.Now().UTC()
+}
+
 func closeRows(rows *sql.Rows) {
 	if err := rows.Close(); err != nil {
 		log.Warnln("closeRows: error:", err)
@@ -53,6 +74,45 @@ func (d *DAL) DB() *sqldb.Db {
 	return d.Db
 }
 
+// WithContext returns the DAL tracing its queries as children of the span of ctx.
+func (d *DAL) WithContext(ctx context.Context) TransferStore {
+	return d.withContext(ctx)
+}
+
+func (d *DAL) withContext(ctx context.Context) *DAL {
+	return &DAL{Db: d.Db, driver: d.driver, ctx: ctx}
+}
+
+func (d *DAL) Exec(query string, args ...interface{}) (sql.Result, error) {
+	span := d.startQuerySpan(query)
+	res, err := d.Db.Exec(query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (d *DAL) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	span := d.startQuerySpan(query)
+	rows, err := d.Db.Query(query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (d *DAL) QueryRow(query string, args ...interface{}) *sql.Row {
+	span := d.startQuerySpan(query)
+	row := d.Db.QueryRow(query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+func (d *DAL) startQuerySpan(query string) trace.Span {
+	if d.ctx == nil {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := tracer.Start(d.ctx, dbSpanName(query), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemKey.String(d.driver), semconv.DBStatementKey.String(query)))
+	return span
+}
+
 // The "monitor" table.
 func (d *DAL) InsertMonitor(event string, blockNum uint64, blockIdx int64, restart bool) error {
 	q := `INSERT INTO monitor (event, blocknum, blockidx, restart)
@@ -108,27 +168,41 @@ type Transfer struct {
 	TxRefundHash    Hash
 	UpdateTs        time.Time
 	CreateTs        time.Time
+	PreimageStatus  cbn.PreimageStatus
 }
 
 func (d *DAL) InsertTransfer(tx *Transfer) error {
-	tsNow := time.Now()
+	tsNow := dbNow()
 	q := fmt.Sprintf("INSERT INTO transfer (%s) VALUES (%s) ON CONFLICT DO NOTHING", transferAllColumns, transferAllColumnParams)
 	_, err := d.Exec(q, tx.TransferId.String(), tx.TxHash.String(), tx.ChainId, tx.Token.String(), tx.TransferType,
-		tx.TimeLock, tx.HashLock.String(), tx.Status, tx.RelatedTid.String(), tx.RelatedChainId, tx.RelatedToken.String(), tx.Amount.String(),
+		tx.TimeLock.UTC(), tx.HashLock.String(), tx.Status, tx.RelatedTid.String(), tx.RelatedChainId, tx.RelatedToken.String(), tx.Amount.String(),
 		tx.Fee.String(), tx.TransferGasCost.String(), tx.ConfirmGasCost.String(), tx.RefundGasCost.String(), "", tx.Sender.String(), tx.Receiver.String(), tx.TxConfirmHash.String(),
-		tx.TxRefundHash.String(), tsNow, tsNow)
+		tx.TxRefundHash.String(), tsNow, tsNow, cbn.PreimageStatus_PREIMAGE_STATUS_NONE)
 	return err
 }
 
+// SetRelatedTxPreimage stores the preimage to the transfer related to relatedTid, only if it matches the hashlock.
 func (d *DAL) SetRelatedTxPreimage(preimage, relatedTid Hash) error {
-	q := `UPDATE transfer SET preimage = $1 WHERE relatedtid = $2`
-	_, err := d.Exec(q, preimage.String(), relatedTid.String())
+	q := `UPDATE transfer SET preimage = $1, preimagestatus = $2 WHERE relatedtid = $3 and hashlock = $4`
+	_, err := d.Exec(q, preimage.String(), cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED, relatedTid.String(), getHashLockWithPreImage(preimage).String())
 	return err
 }
 
+// ConfirmTransfer sets the transfer confirmed. The preimage is only stored if it matches the hashlock.
 func (d *DAL) ConfirmTransfer(tid, preimage, txConfirmHash Hash) error {
-	q := `UPDATE transfer SET status = $1, preimage = $2, txconfirmhash = $3 WHERE tid = $4`
-	_, err := d.Exec(q, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, preimage.String(), txConfirmHash.String(), tid.String())
+	q := `UPDATE transfer SET status = $1, txconfirmhash = $2,
+		preimage = (case when hashlock = $3 then $4 else preimage end),
+		preimagestatus = (case when hashlock = $3 then $5 else preimagestatus end) WHERE tid = $6`
+	_, err := d.Exec(q, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, txConfirmHash.String(), getHashLockWithPreImage(preimage).String(),
+		preimage.String(), cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED, tid.String())
+	return err
+}
+
+// MarkPreimageMismatch records that a preimage not matching the hashlock was seen for the transfer.
+// A verified preimage is kept.
+func (d *DAL) MarkPreimageMismatch(tid Hash) error {
+	q := `UPDATE transfer SET preimagestatus = $1 WHERE tid = $2 and preimagestatus != $3`
+	_, err := d.Exec(q, cbn.PreimageStatus_PREIMAGE_STATUS_MISMATCH, tid.String(), cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED)
 	return err
 }
 
@@ -151,12 +225,6 @@ func (d *DAL) UpdateTransferStatus(tid Hash, to cbn.TransferStatus) error {
 	return err
 }
 
-func (d *DAL) SetTransferPreimage(tid Hash, preimage Hash) error {
-	q := `UPDATE transfer SET status = $1 WHERE tid = $2`
-	_, err := d.Exec(q, preimage.String(), tid.String())
-	return err
-}
-
 func (d *DAL) SetTransferInAmountAndFee(tid Hash, amount, fee *big.Int) error {
 	q := `UPDATE transfer SET amount = $1, fee = $2 WHERE tid = $3`
 	_, err := d.Exec(q, amount.String(), fee.String(), tid.String())
@@ -165,13 +233,13 @@ func (d *DAL) SetTransferInAmountAndFee(tid Hash, amount, fee *big.Int) error {
 
 func (d *DAL) SetPendingTransferIn(tid Hash, to, from cbn.TransferStatus) error {
 	q := `UPDATE transfer SET status = $1, updatets = $2 WHERE tid = $3 and status = $4`
-	_, err := d.Exec(q, to, time.Now(), tid.String(), from)
+	_, err := d.Exec(q, to, dbNow(), tid.String(), from)
 	return err
 }
 
 func (d *DAL) SetTransferStatusByFrom(tid Hash, to, from cbn.TransferStatus) error {
 	q := `UPDATE transfer SET status = $1, updatets = $2 WHERE tid = $3 and status = $4`
-	_, err := d.Exec(q, to, time.Now(), tid.String(), from)
+	_, err := d.Exec(q, to, dbNow(), tid.String(), from)
 	return err
 }
 
@@ -227,9 +295,29 @@ func (d *DAL) GetAllTransfersWithLimit(limit uint64) ([]*Transfer, error) {
 	return txs, err
 }
 
+// GetTransfersWithFilter returns the latest transfers, optionally filtered by status and chain.
+// Zero status or chainId means no filter on that column.
+func (d *DAL) GetTransfersWithFilter(limit uint64, status cbn.TransferStatus, chainId uint64) ([]*Transfer, error) {
+	q := fmt.Sprintf("SELECT %s from transfer where ($1 = 0 or status = $1) and ($2 = 0 or chainid = $2) order by createts desc limit %d", transferAllColumns, limit)
+	rows, err := d.Query(q, status, chainId)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+	var txs []*Transfer
+	for rows.Next() {
+		tx := &Transfer{}
+		if err = scanTransfers(rows, tx); err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, err
+}
+
 func (d *DAL) GetAllStartTransferIn() ([]*Transfer, error) {
 	q := fmt.Sprintf("SELECT %s from transfer where status = $1 and timelock > $2 and transfertype = $3", transferAllColumns)
-	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START, time.Now().Add(1*time.Hour), cbn.TransferType_TRANSFER_TYPE_IN)
+	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START, dbNow().Add(1*time.Hour), cbn.TransferType_TRANSFER_TYPE_IN)
 	if err != nil {
 		return nil, err
 	}
@@ -246,8 +334,10 @@ func (d *DAL) GetAllStartTransferIn() ([]*Transfer, error) {
 }
 
 func (d *DAL) GetAllConfirmableLockedTransfer() ([]*Transfer, error) {
-	q := fmt.Sprintf("SELECT %s from transfer where status = $1 and preimage is not null and preimage != ''", transferAllColumns)
-	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+	// transfers whose source timelock is closest go first, the source timelock of a transferIn is the one of its transferOut
+	q := fmt.Sprintf(`SELECT %s from transfer t where status = $1 and preimage is not null and preimage != '' and preimagestatus != $2
+		order by (case when transfertype = $3 then timelock else (select r.timelock from transfer r where r.tid = t.relatedtid) end) asc`, transferAllColumns)
+	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, cbn.PreimageStatus_PREIMAGE_STATUS_MISMATCH, cbn.TransferType_TRANSFER_TYPE_OUT)
 	if err != nil {
 		return nil, err
 	}
@@ -267,7 +357,7 @@ func (d *DAL) GetRecoverTimeoutPendingTransferIn() ([]*Transfer, error) {
 	// We find all pending transfers in which may have do transfer before 1 hour ago, but have not received the monitor.
 	// We will try to send transfer in again for this transfer in. By set the status back to start from pending, the job of transfer in will try send again.
 	// On another hand, if the transfer in time lock is expired, we will ignore this transfer in.
-	tsNow := time.Now()
+	tsNow := dbNow()
 	q := fmt.Sprintf("SELECT %s from transfer where status = $1 and updatets < $2 and transfertype = $3 and timelock > $4", transferAllColumns)
 	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING, tsNow.Add(-1*maxPendingTimeOutRetryDuration), cbn.TransferType_TRANSFER_TYPE_IN, tsNow.Add(timeLockSafeMargin))
 	if err != nil {
@@ -286,7 +376,7 @@ func (d *DAL) GetRecoverTimeoutPendingTransferIn() ([]*Transfer, error) {
 }
 
 func (d *DAL) GetRecoverTimeoutPendingConfirm() ([]*Transfer, error) {
-	tsNow := time.Now()
+	tsNow := dbNow()
 	q := fmt.Sprintf("SELECT %s from transfer where status = $1 and updatets < $2 and timelock > $3", transferAllColumns)
 	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING, tsNow.Add(-1*maxPendingTimeOutRetryDuration), tsNow.Add(timeLockSafeMargin))
 	if err != nil {
@@ -305,7 +395,7 @@ func (d *DAL) GetRecoverTimeoutPendingConfirm() ([]*Transfer, error) {
 }
 
 func (d *DAL) GetRecoverTimeoutPendingRefund() ([]*Transfer, error) {
-	tsNow := time.Now()
+	tsNow := dbNow()
 	q := fmt.Sprintf("SELECT %s from transfer where status = $1 and updatets < $2", transferAllColumns)
 	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING, tsNow.Add(-1*maxPendingTimeOutRetryDuration))
 	if err != nil {
@@ -325,7 +415,7 @@ func (d *DAL) GetRecoverTimeoutPendingRefund() ([]*Transfer, error) {
 
 func (d *DAL) GetAllRefundAbleTransferIn() ([]*Transfer, error) {
 	q := fmt.Sprintf(`SELECT %s from transfer where status = $1 and timelock < $2 and transfertype = $3`, transferAllColumns)
-	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now(), cbn.TransferType_TRANSFER_TYPE_IN)
+	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, dbNow(), cbn.TransferType_TRANSFER_TYPE_IN)
 	if err != nil {
 		return nil, err
 	}
@@ -341,11 +431,101 @@ func (d *DAL) GetAllRefundAbleTransferIn() ([]*Transfer, error) {
 	return txs, err
 }
 
-func scanTransfers(rows *sql.Rows, tx *Transfer) error {
+// TransferPair is a transferOut with its transferIn, nil if not found. Only the id, status and timelock of the
+// transferIn are set.
+type TransferPair struct {
+	Out *Transfer
+	In  *Transfer
+}
+
+// GetOpenTransferOut returns transferOuts not confirmed or refunded yet with their transferIns, closest timelock first.
+func (d *DAL) GetOpenTransferOut() ([]*TransferPair, error) {
+	q := fmt.Sprintf(`SELECT o.%s, i.tid, i.status, i.timelock from transfer o left join transfer i on i.tid = o.relatedtid
+		where o.transfertype = $1 and o.status in ($2, $3) order by o.timelock asc`, strings.ReplaceAll(transferAllColumns, ",", ",o."))
+	rows, err := d.Query(q, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+	var pairs []*TransferPair
+	for rows.Next() {
+		pair := &TransferPair{Out: &Transfer{}}
+		var inTid sql.NullString
+		var inStatus sql.NullInt64
+		var inTimeLock sql.NullTime
+		if err = scanTransfers(rows, pair.Out, &inTid, &inStatus, &inTimeLock); err != nil {
+			return nil, err
+		}
+		if inTid.Valid {
+			pair.In = &Transfer{
+				TransferId: Hex2Hash(inTid.String),
+				Status:     cbn.TransferStatus(inStatus.Int64),
+				TimeLock:   inTimeLock.Time,
+			}
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, err
+}
+
+// TransferSummary aggregates the transfers with the same type, chain pair, token and status.
+type TransferSummary struct {
+	TransferType    cbn.TransferType
+	ChainId         uint64
+	RelatedChainId  uint64
+	Token           Addr
+	Status          cbn.TransferStatus
+	Count           uint64
+	Amount          big.Int
+	Fee             big.Int
+	TransferGasCost big.Int
+	ConfirmGasCost  big.Int
+	RefundGasCost   big.Int
+}
+
+func (d *DAL) GetTransferSummary() ([]*TransferSummary, error) {
+	// archived transfers are counted by their rollup rows
+	q := `SELECT transfertype, chainid, relatedchainid, token, status, sum(txcount), sum(amount), sum(fee),
+		sum(transfergascost), sum(confirmgascost), sum(refundgascost) from (
+			SELECT transfertype, chainid, relatedchainid, token, status, count(*) as txcount, sum(amount) as amount, sum(fee) as fee,
+				sum(transfergascost) as transfergascost, sum(confirmgascost) as confirmgascost, sum(refundgascost) as refundgascost
+				from transfer group by transfertype, chainid, relatedchainid, token, status
+			UNION ALL
+			SELECT transfertype, chainid, relatedchainid, token, status, txcount, amount, fee, transfergascost, confirmgascost, refundgascost
+				from transfer_rollup
+		) s group by transfertype, chainid, relatedchainid, token, status`
+	rows, err := d.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+	var summaries []*TransferSummary
+	for rows.Next() {
+		ts := &TransferSummary{}
+		var token, amount, fee, transferFee, confirmFee, refundFee string
+		err = rows.Scan(&ts.TransferType, &ts.ChainId, &ts.RelatedChainId, &token, &ts.Status, &ts.Count, &amount, &fee,
+			&transferFee, &confirmFee, &refundFee)
+		if err != nil {
+			return nil, err
+		}
+		ts.Token = Hex2Addr(token)
+		ts.Amount.SetString(amount, 10)
+		ts.Fee.SetString(fee, 10)
+		ts.TransferGasCost.SetString(transferFee, 10)
+		ts.ConfirmGasCost.SetString(confirmFee, 10)
+		ts.RefundGasCost.SetString(refundFee, 10)
+		summaries = append(summaries, ts)
+	}
+	return summaries, err
+}
+
+// scanTransfers scans the transferAllColumns of the row into tx, and the columns selected after them into extra.
+func scanTransfers(rows *sql.Rows, tx *Transfer, extra ...interface{}) error {
 	var transferId, txHash, token, relatedToken, hashLock, relatedTid, amount, fee, transferFee, confirmFee, refundFee, preimage, sender, receiver, txConfirmHash, txRefundHash string
-	err := rows.Scan(&transferId, &txHash, &tx.ChainId, &token, &tx.TransferType, &tx.TimeLock, &hashLock, &tx.Status,
+	dest := []interface{}{&transferId, &txHash, &tx.ChainId, &token, &tx.TransferType, &tx.TimeLock, &hashLock, &tx.Status,
 		&relatedTid, &tx.RelatedChainId, &relatedToken, &amount, &fee, &transferFee, &confirmFee, &refundFee, &preimage, &sender,
-		&receiver, &txConfirmHash, &txRefundHash, &tx.UpdateTs, &tx.CreateTs)
+		&receiver, &txConfirmHash, &txRefundHash, &tx.UpdateTs, &tx.CreateTs, &tx.PreimageStatus}
+	err := rows.Scan(append(dest, extra...)...)
 	if err != nil {
 		return err
 	}
@@ -372,7 +552,7 @@ func scanTransfer(row *sql.Row, tx *Transfer) error {
 	var transferId, txHash, token, relatedToken, hashLock, relatedTid, amount, fee, transferFee, confirmFee, refundFee, preimage, sender, receiver, txConfirmHash, txRefundHash string
 	err := row.Scan(&transferId, &txHash, &tx.ChainId, &token, &tx.TransferType, &tx.TimeLock, &hashLock, &tx.Status,
 		&relatedTid, &tx.RelatedChainId, &relatedToken, &amount, &fee, &transferFee, &confirmFee, &refundFee, &preimage, &sender,
-		&receiver, &txConfirmHash, &txRefundHash, &tx.UpdateTs, &tx.CreateTs)
+		&receiver, &txConfirmHash, &txRefundHash, &tx.UpdateTs, &tx.CreateTs, &tx.PreimageStatus)
 	if err != nil {
 		return err
 	}
@@ -394,3 +574,658 @@ func scanTransfer(row *sql.Row, tx *Transfer) error {
 	tx.TxRefundHash = Hex2Hash(txRefundHash)
 	return nil
 }
+
+// The "transfer_archive", "transfer_rollup" and "transfer_hold" tables.
+// Settled transfers are moved to transfer_archive, and added to transfer_rollup so the summary stays the same.
+
+// ArchiveTransfers moves at most limit settled transfers created before the given time to transfer_archive.
+// A transfer is only archived together with its related transfer, both confirmed or refunded and created
+// before the time, and neither held after a restore. It returns the number of archived transfers.
+func (d *DAL) ArchiveTransfers(before time.Time, limit uint64) (uint64, error) {
+	tsNow := dbNow()
+	if _, err := d.Exec(`DELETE FROM transfer_hold WHERE untilts < $1`, tsNow); err != nil {
+		return 0, err
+	}
+	q := fmt.Sprintf(`SELECT %s from transfer t where status in ($1, $2) and createts < $3
+		and not exists (select 1 from transfer_hold h where h.tid = t.tid and h.untilts > $4)
+		and not exists (select 1 from transfer r where (r.tid = t.relatedtid or r.relatedtid = t.tid)
+			and (r.status not in ($1, $2) or r.createts >= $3 or r.tid in (select h.tid from transfer_hold h where h.untilts > $4)))
+		order by createts asc limit %d`, transferAllColumns, limit)
+	rows, err := d.Query(q, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED, before.UTC(), tsNow)
+	if err != nil {
+		return 0, err
+	}
+	var txs []*Transfer
+	for rows.Next() {
+		tx := &Transfer{}
+		if err = scanTransfers(rows, tx); err != nil {
+			closeRows(rows)
+			return 0, err
+		}
+		txs = append(txs, tx)
+	}
+	closeRows(rows)
+	if len(txs) == 0 {
+		return 0, nil
+	}
+
+	err = d.Transactional(func(dbTx *sqldb.DbTx, args ...interface{}) error {
+		for _, tx := range txs {
+			q := fmt.Sprintf(`INSERT INTO transfer_archive (%s, archivets) SELECT %s, $1 from transfer where tid = $2`, transferAllColumns, transferAllColumns)
+			res, err := dbTx.Exec(q, tsNow, tx.TransferId.String())
+			if err = sqldb.ChkExec(res, err, 1, "InsertTransferArchive"); err != nil {
+				return err
+			}
+			// the status is checked again in case it is changed since the query
+			q = `DELETE FROM transfer WHERE tid = $1 and status in ($2, $3)`
+			res, err = dbTx.Exec(q, tx.TransferId.String(), cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED)
+			if err = sqldb.ChkExec(res, err, 1, "DeleteArchivedTransfer"); err != nil {
+				return err
+			}
+		}
+		return updateTransferRollup(dbTx, txs, 1)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(txs)), nil
+}
+
+// RestoreTransfers moves the archived transfers created in [from, to) and their related transfers back
+// to the transfer table, where they are held from archiving until holdUntil. It returns the number of
+// restored transfers.
+func (d *DAL) RestoreTransfers(from, to, holdUntil time.Time) (uint64, error) {
+	q := fmt.Sprintf(`SELECT %s from transfer_archive where (createts >= $1 and createts < $2)
+		or tid in (select relatedtid from transfer_archive where createts >= $1 and createts < $2)
+		or relatedtid in (select tid from transfer_archive where createts >= $1 and createts < $2)`, transferAllColumns)
+	rows, err := d.Query(q, from.UTC(), to.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var txs []*Transfer
+	for rows.Next() {
+		tx := &Transfer{}
+		if err = scanTransfers(rows, tx); err != nil {
+			closeRows(rows)
+			return 0, err
+		}
+		txs = append(txs, tx)
+	}
+	closeRows(rows)
+	if len(txs) == 0 {
+		return 0, nil
+	}
+
+	err = d.Transactional(func(dbTx *sqldb.DbTx, args ...interface{}) error {
+		for _, tx := range txs {
+			q := fmt.Sprintf(`INSERT INTO transfer (%s) SELECT %s from transfer_archive where tid = $1`, transferAllColumns, transferAllColumns)
+			res, err := dbTx.Exec(q, tx.TransferId.String())
+			if err = sqldb.ChkExec(res, err, 1, "RestoreTransfer"); err != nil {
+				return err
+			}
+			q = `DELETE FROM transfer_archive WHERE tid = $1`
+			res, err = dbTx.Exec(q, tx.TransferId.String())
+			if err = sqldb.ChkExec(res, err, 1, "DeleteTransferArchive"); err != nil {
+				return err
+			}
+			q = `INSERT INTO transfer_hold (tid, untilts) VALUES ($1, $2) ON CONFLICT (tid) DO UPDATE SET untilts = excluded.untilts`
+			res, err = dbTx.Exec(q, tx.TransferId.String(), holdUntil.UTC())
+			if err = sqldb.ChkExec(res, err, 1, "UpsertTransferHold"); err != nil {
+				return err
+			}
+		}
+		return updateTransferRollup(dbTx, txs, -1)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(txs)), nil
+}
+
+// updateTransferRollup adds (sign 1) or subtracts (sign -1) the transfers to their rollup rows. The sums are
+// computed in Go as the amounts are TEXT in SQLite.
+func updateTransferRollup(dbTx *sqldb.DbTx, txs []*Transfer, sign int64) error {
+	deltas := make(map[string]*TransferSummary)
+	var keys []string
+	for _, tx := range txs {
+		key := fmt.Sprintf("%d-%d-%d-%s-%d", tx.TransferType, tx.ChainId, tx.RelatedChainId, tx.Token.String(), tx.Status)
+		delta, found := deltas[key]
+		if !found {
+			delta = &TransferSummary{
+				TransferType:   tx.TransferType,
+				ChainId:        tx.ChainId,
+				RelatedChainId: tx.RelatedChainId,
+				Token:          tx.Token,
+				Status:         tx.Status,
+			}
+			deltas[key] = delta
+			keys = append(keys, key)
+		}
+		delta.Count++
+		delta.Amount.Add(&delta.Amount, &tx.Amount)
+		delta.Fee.Add(&delta.Fee, &tx.Fee)
+		delta.TransferGasCost.Add(&delta.TransferGasCost, &tx.TransferGasCost)
+		delta.ConfirmGasCost.Add(&delta.ConfirmGasCost, &tx.ConfirmGasCost)
+		delta.RefundGasCost.Add(&delta.RefundGasCost, &tx.RefundGasCost)
+	}
+
+	for _, key := range keys {
+		delta := deltas[key]
+		where := []interface{}{delta.TransferType, delta.ChainId, delta.RelatedChainId, delta.Token.String(), delta.Status}
+		var count int64
+		var amount, fee, transferFee, confirmFee, refundFee string
+		q := `SELECT txcount, amount, fee, transfergascost, confirmgascost, refundgascost from transfer_rollup
+			where transfertype = $1 and chainid = $2 and relatedchainid = $3 and token = $4 and status = $5`
+		err := dbTx.QueryRow(q, where...).Scan(&count, &amount, &fee, &transferFee, &confirmFee, &refundFee)
+		if _, err = sqldb.ChkQueryRow(err); err != nil {
+			return err
+		}
+		count += sign * int64(delta.Count)
+		if count < 0 {
+			return fmt.Errorf("negative transfer rollup count of %s", key)
+		}
+		if count == 0 {
+			q = `DELETE FROM transfer_rollup where transfertype = $1 and chainid = $2 and relatedchainid = $3 and token = $4 and status = $5`
+			if _, err = dbTx.Exec(q, where...); err != nil {
+				return err
+			}
+			continue
+		}
+		sum := func(s string, v *big.Int) string {
+			total, _ := new(big.Int).SetString(s, 10)
+			if total == nil {
+				total = new(big.Int)
+			}
+			return total.Add(total, new(big.Int).Mul(v, big.NewInt(sign))).String()
+		}
+		q = `INSERT INTO transfer_rollup (transfertype, chainid, relatedchainid, token, status, txcount, amount, fee,
+			transfergascost, confirmgascost, refundgascost) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (transfertype, chainid, relatedchainid, token, status) DO UPDATE SET txcount = excluded.txcount,
+			amount = excluded.amount, fee = excluded.fee, transfergascost = excluded.transfergascost,
+			confirmgascost = excluded.confirmgascost, refundgascost = excluded.refundgascost`
+		res, err := dbTx.Exec(q, append(where, count, sum(amount, &delta.Amount), sum(fee, &delta.Fee), sum(transferFee, &delta.TransferGasCost),
+			sum(confirmFee, &delta.ConfirmGasCost), sum(refundFee, &delta.RefundGasCost))...)
+		if err = sqldb.ChkExec(res, err, 1, "UpsertTransferRollup"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The "rebalance" table.
+const (
+	rebalanceAllColumns      = "tid,srcchainid,dstchainid,srctoken,dsttoken,amount,dstamount,bridgeaddr,hashlock,preimage,timelock,dsttid,status,updatets,createts"
+	rebalanceAllColumnParams = "$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15"
+)
+
+type Rebalance struct {
+	TransferId    Hash
+	SrcChainId    uint64
+	DstChainId    uint64
+	SrcToken      Addr
+	DstToken      Addr
+	Amount        big.Int
+	DstAmount     big.Int
+	BridgeAddr    Addr
+	HashLock      Hash
+	Preimage      Hash
+	TimeLock      time.Time
+	DstTransferId Hash
+	Status        cbn.RebalanceStatus
+	UpdateTs      time.Time
+	CreateTs      time.Time
+}
+
+func (d *DAL) InsertRebalance(r *Rebalance) error {
+	tsNow := dbNow()
+	q := fmt.Sprintf("INSERT INTO rebalance (%s) VALUES (%s)", rebalanceAllColumns, rebalanceAllColumnParams)
+	res, err := d.Exec(q, r.TransferId.String(), r.SrcChainId, r.DstChainId, r.SrcToken.String(), r.DstToken.String(), r.Amount.String(),
+		"", r.BridgeAddr.String(), r.HashLock.String(), r.Preimage.String(), r.TimeLock.UTC(), r.DstTransferId.String(), r.Status, tsNow, tsNow)
+	return sqldb.ChkExec(res, err, 1, "InsertRebalance")
+}
+
+func (d *DAL) SetRebalanceStatusByFrom(tid Hash, to, from cbn.RebalanceStatus) error {
+	q := `UPDATE rebalance SET status = $1, updatets = $2 WHERE tid = $3 and status = $4`
+	_, err := d.Exec(q, to, dbNow(), tid.String(), from)
+	return err
+}
+
+// RecordRebalanceTransferIn records the transferIn locked by the relay node for the rebalance, checked by
+// checkRebalanceTransferIn. It returns false if the rebalance is not waiting for the transferIn.
+func (d *DAL) RecordRebalanceTransferIn(tid, dstTid Hash, dstAmount *big.Int) (bool, error) {
+	q := `UPDATE rebalance SET status = $1, dsttid = $2, dstamount = $3, updatets = $4 WHERE tid = $5 and status in ($6, $7)`
+	res, err := d.Exec(q, cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED, dstTid.String(), dstAmount.String(), dbNow(), tid.String(),
+		cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+func (d *DAL) GetRebalance(tid Hash) (*Rebalance, bool, error) {
+	r := &Rebalance{}
+	q := fmt.Sprintf("SELECT %s from rebalance where tid = $1", rebalanceAllColumns)
+	err := scanRebalance(d.QueryRow(q, tid.String()), r)
+	found, err := sqldb.ChkQueryRow(err)
+	return r, found, err
+}
+
+func (d *DAL) GetRebalanceByDstTid(dstTid Hash) (*Rebalance, bool, error) {
+	r := &Rebalance{}
+	q := fmt.Sprintf("SELECT %s from rebalance where dsttid = $1", rebalanceAllColumns)
+	err := scanRebalance(d.QueryRow(q, dstTid.String()), r)
+	found, err := sqldb.ChkQueryRow(err)
+	return r, found, err
+}
+
+func (d *DAL) GetRebalancesWithLimit(limit uint64) ([]*Rebalance, error) {
+	q := fmt.Sprintf("SELECT %s from rebalance order by createts desc limit %d", rebalanceAllColumns, limit)
+	rows, err := d.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+	var rs []*Rebalance
+	for rows.Next() {
+		r := &Rebalance{}
+		if err = scanRebalance(rows, r); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, err
+}
+
+// GetActiveRebalances returns rebalances not finished yet.
+func (d *DAL) GetActiveRebalances() ([]*Rebalance, error) {
+	q := fmt.Sprintf("SELECT %s from rebalance where status in ($1, $2, $3, $4, $5)", rebalanceAllColumns)
+	rows, err := d.Query(q, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED,
+		cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED, cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRM_PENDING, cbn.RebalanceStatus_REBALANCE_STATUS_REFUND_PENDING)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+	var rs []*Rebalance
+	for rows.Next() {
+		r := &Rebalance{}
+		if err = scanRebalance(rows, r); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, err
+}
+
+// scanRebalance scans a *sql.Row or *sql.Rows.
+func scanRebalance(row interface{ Scan(...interface{}) error }, r *Rebalance) error {
+	var tid, srcToken, dstToken, amount, dstAmount, bridgeAddr, hashLock, preimage, dstTid string
+	err := row.Scan(&tid, &r.SrcChainId, &r.DstChainId, &srcToken, &dstToken, &amount, &dstAmount, &bridgeAddr, &hashLock,
+		&preimage, &r.TimeLock, &dstTid, &r.Status, &r.UpdateTs, &r.CreateTs)
+	if err != nil {
+		return err
+	}
+	r.TransferId = Hex2Hash(tid)
+	r.SrcToken = Hex2Addr(srcToken)
+	r.DstToken = Hex2Addr(dstToken)
+	r.Amount.SetString(amount, 10)
+	r.DstAmount.SetString(dstAmount, 10)
+	r.BridgeAddr = Hex2Addr(bridgeAddr)
+	r.HashLock = Hex2Hash(hashLock)
+	r.Preimage = Hex2Hash(preimage)
+	r.DstTransferId = Hex2Hash(dstTid)
+	return nil
+}
+
+// The "event_inbox" table.
+// Matched contract logs are stored here before they are processed, see eventInbox.
+const (
+	inboxEventAllColumns      = "chainid,txhash,logindex,blocknum,eventname,rawlog,status,attempts,lasterror,nextts,createts,updatets"
+	inboxEventAllColumnParams = "$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12"
+)
+
+type InboxEvent struct {
+	ChainId   uint64
+	EventName string
+	Log       ethtypes.Log
+	Status    cbn.InboxEventStatus
+	Attempts  uint64
+	LastError string
+	NextTs    time.Time // next attempt of a pending event
+	CreateTs  time.Time
+	UpdateTs  time.Time
+}
+
+// InsertInboxEvent stores the event as pending. It returns false if the event is stored already.
+func (d *DAL) InsertInboxEvent(e *InboxEvent) (bool, error) {
+	rawLog, err := e.Log.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	tsNow := dbNow()
+	q := fmt.Sprintf("INSERT INTO event_inbox (%s) VALUES (%s) ON CONFLICT DO NOTHING", inboxEventAllColumns, inboxEventAllColumnParams)
+	res, err := d.Exec(q, e.ChainId, e.Log.TxHash.String(), e.Log.Index, e.Log.BlockNumber, e.EventName, string(rawLog),
+		cbn.InboxEventStatus_INBOX_EVENT_STATUS_PENDING, 0, "", tsNow, tsNow, tsNow)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// GetDueInboxEvents returns at most limit pending events whose next attempt is due, in the order they were seen.
+func (d *DAL) GetDueInboxEvents(limit uint64) ([]*InboxEvent, error) {
+	q := fmt.Sprintf("SELECT %s from event_inbox where status = $1 and nextts <= $2 order by createts, chainid, blocknum, logindex limit %d",
+		inboxEventAllColumns, limit)
+	rows, err := d.Query(q, cbn.InboxEventStatus_INBOX_EVENT_STATUS_PENDING, dbNow())
+	if err != nil {
+		return nil, err
+	}
+	return scanInboxEvents(rows)
+}
+
+// GetInboxEvents returns the latest events, optionally filtered by status and chain.
+// Zero status or chainId means no filter on that column.
+func (d *DAL) GetInboxEvents(limit uint64, status cbn.InboxEventStatus, chainId uint64) ([]*InboxEvent, error) {
+	q := fmt.Sprintf("SELECT %s from event_inbox where ($1 = 0 or status = $1) and ($2 = 0 or chainid = $2) order by createts desc limit %d",
+		inboxEventAllColumns, limit)
+	rows, err := d.Query(q, status, chainId)
+	if err != nil {
+		return nil, err
+	}
+	return scanInboxEvents(rows)
+}
+
+// UpdateInboxEvent saves the status, attempts, last error and next attempt of the event.
+func (d *DAL) UpdateInboxEvent(e *InboxEvent) error {
+	q := `UPDATE event_inbox SET status = $1, attempts = $2, lasterror = $3, nextts = $4, updatets = $5
+		WHERE chainid = $6 and txhash = $7 and logindex = $8`
+	res, err := d.Exec(q, e.Status, e.Attempts, e.LastError, e.NextTs.UTC(), dbNow(), e.ChainId, e.Log.TxHash.String(), e.Log.Index)
+	return sqldb.ChkExec(res, err, 1, "UpdateInboxEvent")
+}
+
+// RequeueInboxEvents sets the quarantined events pending again with no failed attempt, optionally filtered
+// by chain and tx hash. Zero chainId or empty txHash means no filter on that column. It returns the number
+// of requeued events.
+func (d *DAL) RequeueInboxEvents(chainId uint64, txHash string) (uint64, error) {
+	tsNow := dbNow()
+	q := `UPDATE event_inbox SET status = $1, attempts = 0, nextts = $2, updatets = $2
+		WHERE status = $3 and ($4 = 0 or chainid = $4) and ($5 = '' or txhash = $5)`
+	res, err := d.Exec(q, cbn.InboxEventStatus_INBOX_EVENT_STATUS_PENDING, tsNow, cbn.InboxEventStatus_INBOX_EVENT_STATUS_QUARANTINED,
+		chainId, txHash)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	return uint64(rows), err
+}
+
+// DeleteProcessedInboxEvents deletes the events processed before the given time, and returns the number deleted.
+func (d *DAL) DeleteProcessedInboxEvents(before time.Time) (uint64, error) {
+	q := `DELETE FROM event_inbox WHERE status = $1 and updatets < $2`
+	res, err := d.Exec(q, cbn.InboxEventStatus_INBOX_EVENT_STATUS_PROCESSED, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	return uint64(rows), err
+}
+
+func scanInboxEvents(rows *sql.Rows) ([]*InboxEvent, error) {
+	defer closeRows(rows)
+	var events []*InboxEvent
+	for rows.Next() {
+		e := &InboxEvent{}
+		var txHash, rawLog string
+		var logIndex uint
+		var blockNum uint64
+		err := rows.Scan(&e.ChainId, &txHash, &logIndex, &blockNum, &e.EventName, &rawLog, &e.Status, &e.Attempts, &e.LastError,
+			&e.NextTs, &e.CreateTs, &e.UpdateTs)
+		if err != nil {
+			return nil, err
+		}
+		if err = e.Log.UnmarshalJSON([]byte(rawLog)); err != nil {
+			return nil, fmt.Errorf("invalid raw log of event %s-%d, err:%w", txHash, logIndex, err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// The "chain_tx" table.
+// Every tx sent by the node is recorded with the fee params it was signed with, see bridgeConfig.transact,
+// and updated with its receipt once mined, see bridgeConfig.chainTxHandler.
+const (
+	chainTxAllColumns = "chainid,txhash,transferid,purpose,nonce,txtype,gasprice,gasfeecap,gastipcap,gaslimit,createts," +
+		"status,blocknum,gasused,gascost,txerror,replaces,updatets"
+	chainTxAllColumnParams = "$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18"
+)
+
+type ChainTx struct {
+	ChainId    uint64
+	TxHash     Hash
+	TransferId Hash // transfer or rebalance the tx is sent for
+	Purpose    cbn.TxPurpose
+	Nonce      uint64
+	TxType     cbn.TxType
+	GasPrice   big.Int // legacy tx only
+	GasFeeCap  big.Int // dynamic fee tx only
+	GasTipCap  big.Int // dynamic fee tx only
+	GasLimit   uint64
+	CreateTs   time.Time
+	Status     cbn.ChainTxStatus
+	BlockNum   uint64 // block the tx was mined in
+	GasUsed    uint64
+	GasCost    big.Int // gas used x effective gas price
+	Error      string  // revert reason, or why waiting for the tx failed
+	Replaces   Hash    // the pending tx of the same nonce this tx was sent to replace, zero if none
+	UpdateTs   time.Time
+}
+
+func (d *DAL) InsertChainTx(t *ChainTx) error {
+	tsNow := dbNow()
+	replaces := ""
+	if t.Replaces != (Hash{}) {
+		replaces = t.Replaces.String()
+	}
+	q := fmt.Sprintf("INSERT INTO chain_tx (%s) VALUES (%s)", chainTxAllColumns, chainTxAllColumnParams)
+	res, err := d.Exec(q, t.ChainId, t.TxHash.String(), t.TransferId.String(), t.Purpose, t.Nonce, t.TxType, t.GasPrice.String(),
+		t.GasFeeCap.String(), t.GasTipCap.String(), t.GasLimit, tsNow, t.Status, t.BlockNum, t.GasUsed, t.GasCost.String(), t.Error,
+		replaces, tsNow)
+	return sqldb.ChkExec(res, err, 1, "InsertChainTx")
+}
+
+// GetPendingChainTxByNonce returns the latest pending tx of the nonce on the chain, i.e. the tx a new tx of
+// the nonce replaces.
+func (d *DAL) GetPendingChainTxByNonce(chainId, nonce uint64) (*ChainTx, bool, error) {
+	q := fmt.Sprintf("SELECT %s from chain_tx where chainid = $1 and nonce = $2 and status = $3 order by createts desc limit 1",
+		chainTxAllColumns)
+	rows, err := d.Query(q, chainId, nonce, cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING)
+	if err != nil {
+		return nil, false, err
+	}
+	txs, err := scanChainTxs(rows)
+	if err != nil || len(txs) == 0 {
+		return nil, false, err
+	}
+	return txs[0], true, nil
+}
+
+// SetChainTxMined saves the receipt of the tx, unless another tx of the nonce is known mined already. The other
+// pending txs of the nonce are marked replaced.
+func (d *DAL) SetChainTxMined(t *ChainTx) error {
+	tsNow := dbNow()
+	q := `UPDATE chain_tx SET status = $1, blocknum = $2, gasused = $3, gascost = $4, txerror = $5, updatets = $6
+		WHERE chainid = $7 and txhash = $8 and status in ($9, $10)`
+	res, err := d.Exec(q, t.Status, t.BlockNum, t.GasUsed, t.GasCost.String(), t.Error, tsNow, t.ChainId, t.TxHash.String(),
+		cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING, cbn.ChainTxStatus_CHAIN_TX_STATUS_FAILED)
+	if err = sqldb.ChkExec(res, err, 1, "SetChainTxMined"); err != nil {
+		return err
+	}
+	q = `UPDATE chain_tx SET status = $1, updatets = $2 WHERE chainid = $3 and nonce = $4 and txhash <> $5 and status = $6`
+	_, err = d.Exec(q, cbn.ChainTxStatus_CHAIN_TX_STATUS_REPLACED, tsNow, t.ChainId, t.Nonce, t.TxHash.String(),
+		cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING)
+	return err
+}
+
+// SetChainTxFailed marks the pending tx failed, e.g. dropped or not mined in time. A receipt found later
+// still overrides it.
+func (d *DAL) SetChainTxFailed(chainId uint64, txHash Hash, txErr string) error {
+	q := `UPDATE chain_tx SET status = $1, txerror = $2, updatets = $3 WHERE chainid = $4 and txhash = $5 and status = $6`
+	res, err := d.Exec(q, cbn.ChainTxStatus_CHAIN_TX_STATUS_FAILED, txErr, dbNow(), chainId, txHash.String(),
+		cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING)
+	return sqldb.ChkExec(res, err, 1, "SetChainTxFailed")
+}
+
+// GetChainTxsByTransfer returns the txs sent for the transfer, in the order they were sent.
+func (d *DAL) GetChainTxsByTransfer(transferId Hash) ([]*ChainTx, error) {
+	q := fmt.Sprintf("SELECT %s from chain_tx where transferid = $1 order by createts, nonce", chainTxAllColumns)
+	rows, err := d.Query(q, transferId.String())
+	if err != nil {
+		return nil, err
+	}
+	return scanChainTxs(rows)
+}
+
+// GetChainTxs returns the latest txs. Zero status or chainId means no filter on that column.
+func (d *DAL) GetChainTxs(limit uint64, status cbn.ChainTxStatus, chainId uint64) ([]*ChainTx, error) {
+	q := fmt.Sprintf("SELECT %s from chain_tx where ($1 = 0 or status = $1) and ($2 = 0 or chainid = $2) order by createts desc limit %d",
+		chainTxAllColumns, limit)
+	rows, err := d.Query(q, status, chainId)
+	if err != nil {
+		return nil, err
+	}
+	return scanChainTxs(rows)
+}
+
+// GetPendingChainTxs returns the txs sent before the time and still pending, oldest first.
+func (d *DAL) GetPendingChainTxs(before time.Time, limit uint64) ([]*ChainTx, error) {
+	q := fmt.Sprintf("SELECT %s from chain_tx where status = $1 and createts < $2 order by createts limit %d",
+		chainTxAllColumns, limit)
+	rows, err := d.Query(q, cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return scanChainTxs(rows)
+}
+
+func scanChainTxs(rows *sql.Rows) ([]*ChainTx, error) {
+	defer closeRows(rows)
+	var txs []*ChainTx
+	for rows.Next() {
+		t := &ChainTx{}
+		var txHash, transferId, gasPrice, gasFeeCap, gasTipCap, gasCost, replaces string
+		err := rows.Scan(&t.ChainId, &txHash, &transferId, &t.Purpose, &t.Nonce, &t.TxType, &gasPrice, &gasFeeCap, &gasTipCap,
+			&t.GasLimit, &t.CreateTs, &t.Status, &t.BlockNum, &t.GasUsed, &gasCost, &t.Error, &replaces, &t.UpdateTs)
+		if err != nil {
+			return nil, err
+		}
+		t.TxHash = Hex2Hash(txHash)
+		t.TransferId = Hex2Hash(transferId)
+		t.GasPrice.SetString(gasPrice, 10)
+		t.GasFeeCap.SetString(gasFeeCap, 10)
+		t.GasTipCap.SetString(gasTipCap, 10)
+		t.GasCost.SetString(gasCost, 10)
+		if replaces != "" {
+			t.Replaces = Hex2Hash(replaces)
+		}
+		txs = append(txs, t)
+	}
+	return txs, rows.Err()
+}
+
+// The "leader_lease" table.
+// Nodes sharing the db hold a lease to be the leader, see leaderLease. The token is increased each time the
+// lease changes holder, so a deposed leader is fenced off by its stale token.
+type LeaderLease struct {
+	Name     string
+	Holder   string
+	Token    uint64
+	ExpireTs time.Time
+	UpdateTs time.Time
+}
+
+// leaseNow returns the now of the lease times. On CockroachDB/PostgreSQL it is the db clock, so the nodes sharing
+// the db agree on the lease expiry whatever the skew of their clocks. A SQLite db is only shared on one machine.
+func (d *DAL) leaseNow(queryRow func(query string, args ...interface{}) *sql.Row) (time.Time, error) {
+	if d.driver == dbDriverSqlite {
+		return dbNow(), nil
+	}
+	var tsNow time.Time
+	if err := queryRow(`SELECT now()`).Scan(&tsNow); err != nil {
+		return time.Time{}, err
+	}
+	return tsNow.UTC(), nil
+}
+
+// AcquireLeaderLease acquires the lease for the holder until ttl from now if it is free or expired, or renews
+// it if the holder has it. It returns the fencing token and true if the holder has the lease.
+func (d *DAL) AcquireLeaderLease(name, holder string, ttl time.Duration) (uint64, bool, error) {
+	var token uint64
+	var acquired bool
+	err := d.Transactional(func(dbTx *sqldb.DbTx, args ...interface{}) error {
+		tsNow, err := d.leaseNow(dbTx.QueryRow)
+		if err != nil {
+			return err
+		}
+		q := `INSERT INTO leader_lease (name, holder, token, expirets, updatets) VALUES ($1, '', 0, $2, $2) ON CONFLICT DO NOTHING`
+		if _, err = dbTx.Exec(q, name, tsNow); err != nil {
+			return err
+		}
+		// the CASE sees the holder before the update
+		q = `UPDATE leader_lease SET token = CASE WHEN holder = $1 THEN token ELSE token + 1 END, holder = $1, expirets = $2,
+			updatets = $3 WHERE name = $4 and (holder = $1 or expirets <= $3)`
+		res, err := dbTx.Exec(q, holder, tsNow.Add(ttl), tsNow, name)
+		if err != nil {
+			return err
+		}
+		if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+			return err
+		}
+		q = `SELECT token from leader_lease where name = $1 and holder = $2`
+		if err = dbTx.QueryRow(q, name, holder).Scan(&token); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return token, acquired, nil
+}
+
+// CheckLeaderLease returns true if the holder still has the unexpired lease with the token.
+func (d *DAL) CheckLeaderLease(name, holder string, token uint64) (bool, error) {
+	var expireTs time.Time
+	q := `SELECT expirets from leader_lease where name = $1 and holder = $2 and token = $3`
+	err := d.QueryRow(q, name, holder, token).Scan(&expireTs)
+	found, err := sqldb.ChkQueryRow(err)
+	if err != nil || !found {
+		return false, err
+	}
+	tsNow, err := d.leaseNow(d.QueryRow)
+	if err != nil {
+		return false, err
+	}
+	return expireTs.After(tsNow), nil
+}
+
+// ReleaseLeaderLease expires the lease now if the holder has it, so another node can acquire it right away.
+func (d *DAL) ReleaseLeaderLease(name, holder string) error {
+	tsNow, err := d.leaseNow(d.QueryRow)
+	if err != nil {
+		return err
+	}
+	q := `UPDATE leader_lease SET expirets = $1, updatets = $1 WHERE name = $2 and holder = $3 and expirets > $1`
+	_, err = d.Exec(q, tsNow, name, holder)
+	return err
+}
+
+func (d *DAL) GetLeaderLease(name string) (*LeaderLease, bool, error) {
+	l := &LeaderLease{}
+	q := `SELECT name, holder, token, expirets, updatets from leader_lease where name = $1`
+	err := d.QueryRow(q, name).Scan(&l.Name, &l.Holder, &l.Token, &l.ExpireTs, &l.UpdateTs)
+	found, err := sqldb.ChkQueryRow(err)
+	return l, found, err
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/celer-network/goutils/log"
+)
+
+// SqliteDAL is the embedded TransferStore for small deployments and tests. The queries of DAL
+// are shared, so they must stay portable between CockroachDB/PostgreSQL and SQLite.
+type SqliteDAL struct {
+	*DAL
+}
+
+// NewSqliteDAL opens the SQLite db file at path, creating it if not exist and migrating it to the latest schema.
+func NewSqliteDAL(path string) (*SqliteDAL, error) {
+	dal, err := NewDAL(dbDriverSqlite, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = dal.prepareSchema(true); err != nil {
+		log.Errorf("fail to prepare sqlite schema, path:%s, err:%v", path, err)
+		dal.Close()
+		return nil, err
+	}
+	return &SqliteDAL{dal}, nil
+}
+
+// WithContext returns the SqliteDAL tracing its queries as children of the span of ctx.
+func (d *SqliteDAL) WithContext(ctx context.Context) TransferStore {
+	return &SqliteDAL{d.DAL.withContext(ctx)}
+}
+
+// GetTransferSummary sums the amounts in Go, as they are TEXT in SQLite. Archived transfers are counted
+// by their rollup rows.
+func (d *SqliteDAL) GetTransferSummary() ([]*TransferSummary, error) {
+	q := `SELECT transfertype, chainid, relatedchainid, token, status, 1, amount, fee, coalesce(transfergascost, '0'),
+		coalesce(confirmgascost, '0'), coalesce(refundgascost, '0') from transfer
+		UNION ALL
+		SELECT transfertype, chainid, relatedchainid, token, status, txcount, amount, fee, transfergascost,
+		confirmgascost, refundgascost from transfer_rollup`
+	rows, err := d.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+	summaryMap := make(map[string]*TransferSummary)
+	var summaries []*TransferSummary
+	for rows.Next() {
+		tx := &TransferSummary{}
+		var count uint64
+		var token, amount, fee, transferFee, confirmFee, refundFee string
+		err = rows.Scan(&tx.TransferType, &tx.ChainId, &tx.RelatedChainId, &token, &tx.Status, &count, &amount, &fee,
+			&transferFee, &confirmFee, &refundFee)
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%d-%d-%d-%s-%d", tx.TransferType, tx.ChainId, tx.RelatedChainId, token, tx.Status)
+		ts, found := summaryMap[key]
+		if !found {
+			ts = tx
+			ts.Token = Hex2Addr(token)
+			summaryMap[key] = ts
+			summaries = append(summaries, ts)
+		}
+		ts.Count += count
+		addDecimalString(&ts.Amount, amount)
+		addDecimalString(&ts.Fee, fee)
+		addDecimalString(&ts.TransferGasCost, transferFee)
+		addDecimalString(&ts.ConfirmGasCost, confirmFee)
+		addDecimalString(&ts.RefundGasCost, refundFee)
+	}
+	return summaries, err
+}
+
+func addDecimalString(sum *big.Int, s string) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if ok {
+		sum.Add(sum, v)
+	}
+}
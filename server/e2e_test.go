@@ -0,0 +1,849 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/contracts"
+	"github.com/celer-network/cBridge-go/fakegateway"
+	"github.com/celer-network/cBridge-go/gatewayrpc"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	e2eChainA = 1001
+	e2eChainB = 1002
+
+	e2eTokenName   = "USDT"
+	e2eKsPassword  = "e2e"
+	e2eWaitTimeout = 30 * time.Second
+)
+
+var cbridgeAbi, _ = abi.JSON(strings.NewReader(contracts.CBridgeABI))
+
+// e2eFee is the fee the gateway charges for every transfer.
+var e2eFee = big.NewInt(1000)
+
+type e2eChain struct {
+	*simChain
+	endpoint   string
+	wsEndpoint string
+	bridge     *contracts.CBridge
+	bridgeAddr Addr
+	token      *contracts.Erc20
+	tokenAddr  Addr
+	decimals   uint8
+}
+
+// no code is deployed there, the simulated chains run the multicalls, see simChain.tryAggregate
+var e2eMulticallAddr = Hex2Addr("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+type e2eOptions struct {
+	decimalsA, decimalsB uint8
+	// node token balance on chain B, in whole tokens
+	nodeLiquidityB int64
+	// fixed gas limit of the node transactions on chain B, 0 to estimate
+	gasLimitB uint64
+	// get events in the subscription mode instead of polling
+	subscribe bool
+	// batch the reads of the sweeps with a Multicall contract
+	multicall bool
+}
+
+// e2eEnv runs a relay node between two simulated chains with deployed CBridge and ERC-20 contracts,
+// an in-process fake gateway and a SQLite db. The node loops are driven by the tests instead of tickers.
+type e2eEnv struct {
+	t       *testing.T
+	dir     string
+	owner   *ecdsa.PrivateKey
+	user    *ecdsa.PrivateKey
+	node    *ecdsa.PrivateKey
+	chains  map[uint64]*e2eChain
+	gateway *fakegateway.Gateway
+	config  *cbn.CBridgeConfig
+	ks      string
+	pwd     string
+	s       *server
+}
+
+// e2eTransfer is a transfer started by the user, as seen on chain.
+type e2eTransfer struct {
+	src, dst uint64
+	amount   *big.Int
+	preimage Hash
+	hashLock Hash
+	outId    Hash
+	inId     Hash
+}
+
+func newE2EEnv(t *testing.T, opts e2eOptions) *e2eEnv {
+	if testing.Short() {
+		t.Skip("skip end-to-end test in short mode")
+	}
+	env := &e2eEnv{
+		t:      t,
+		dir:    t.TempDir(),
+		chains: make(map[uint64]*e2eChain),
+	}
+	var err error
+	env.gateway, err = fakegateway.New(&cbn.FakeGatewayConfig{DefaultFee: e2eFee.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []**ecdsa.PrivateKey{&env.owner, &env.user, &env.node} {
+		if *key, err = crypto.GenerateKey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ks := keystore.NewKeyStore(filepath.Join(env.dir, "keystore"), keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.ImportECDSA(env.node, e2eKsPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.ks = account.URL.Path
+	env.pwd = filepath.Join(env.dir, "password")
+	if err = ioutil.WriteFile(env.pwd, []byte(e2eKsPassword), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env.config = &cbn.CBridgeConfig{
+		RelayNodeName: "e2e",
+		Gateway:       "in-process",
+		DbConfig: &cbn.DbConfig{
+			Driver: dbDriverSqlite,
+			Dsn:    filepath.Join(env.dir, "cbridge.db"),
+		},
+	}
+	env.addChain(e2eChainA, opts.decimalsA, 1000000, 0)
+	env.addChain(e2eChainB, opts.decimalsB, opts.nodeLiquidityB, opts.gasLimitB)
+	if opts.subscribe {
+		for _, chainConfig := range env.config.ChainConfig {
+			chainConfig.WatchConfig.WsEndpoint = env.chains[chainConfig.ChainId].wsEndpoint
+			chainConfig.WatchConfig.WsReconnectInterval = 1
+		}
+	}
+	if opts.multicall {
+		for _, chainConfig := range env.config.ChainConfig {
+			env.chains[chainConfig.ChainId].setMulticall(e2eMulticallAddr, false)
+			chainConfig.MulticallAddress = e2eMulticallAddr.String()
+		}
+	}
+
+	oldDial := dialChain
+	dialChain = func(endpoint string) (*rpc.Client, error) {
+		for _, c := range env.chains {
+			if c.endpoint == endpoint {
+				return c.rc, nil
+			}
+			if c.wsEndpoint == endpoint {
+				return c.dialWs()
+			}
+		}
+		return nil, fmt.Errorf("unknown endpoint %s", endpoint)
+	}
+	t.Cleanup(func() {
+		if env.s != nil {
+			env.stop()
+		}
+		dialChain = oldDial
+	})
+	return env
+}
+
+// addChain starts a chain, deploys the contracts and funds the accounts with nodeLiquidity and 1000000 tokens for the user.
+func (env *e2eEnv) addChain(chainId uint64, decimals uint8, nodeLiquidity int64, gasLimit uint64) {
+	t := env.t
+	c := &e2eChain{
+		simChain:   newSimChain(t, chainId, env.addr(env.owner), env.addr(env.user), env.addr(env.node)),
+		endpoint:   fmt.Sprintf("sim://%d", chainId),
+		wsEndpoint: fmt.Sprintf("simws://%d", chainId),
+		decimals:   decimals,
+	}
+	env.chains[chainId] = c
+	owner := env.auth(c, env.owner)
+	var tx *ethtypes.Transaction
+	var err error
+	c.bridgeAddr, tx, c.bridge, err = contracts.DeployCBridge(owner, c.ec)
+	env.mustSucceed(c, tx, err)
+	c.tokenAddr, tx, c.token, err = contracts.DeployErc20(owner, c.ec, "Tether USD", e2eTokenName, c.tokens(1000000000), decimals)
+	env.mustSucceed(c, tx, err)
+	tx, err = c.token.Transfer(owner, env.addr(env.user), c.tokens(1000000))
+	env.mustSucceed(c, tx, err)
+	env.fundNode(c, nodeLiquidity)
+	tx, err = c.token.Approve(env.auth(c, env.user), c.bridgeAddr, MaxUint256)
+	env.mustSucceed(c, tx, err)
+	// approved beforehand, the node waits for more blocks than the simulated chain mines on start
+	tx, err = c.token.Approve(env.auth(c, env.node), c.bridgeAddr, MaxUint256)
+	env.mustSucceed(c, tx, err)
+
+	env.config.ChainConfig = append(env.config.ChainConfig, &cbn.ChainConfig{
+		Endpoint:        c.endpoint,
+		ChainId:         chainId,
+		ContractAddress: c.bridgeAddr.String(),
+		TokenConfig: []*cbn.TokenConfig{{
+			TokenName:    e2eTokenName,
+			TokenAddress: c.tokenAddr.String(),
+			TokenDecimal: uint64(decimals),
+		}},
+		WatchConfig: &cbn.WatchConfig{
+			PollingInterval: 1,
+		},
+		GasTokenName:     "ETH",
+		GasTokenDecimal:  18,
+		TransactorConfig: &cbn.TransactorConfig{GasLimit: gasLimit},
+	})
+}
+
+func (env *e2eEnv) fundNode(c *e2eChain, tokens int64) {
+	if tokens == 0 {
+		return
+	}
+	tx, err := c.token.Transfer(env.auth(c, env.owner), env.addr(env.node), c.tokens(tokens))
+	env.mustSucceed(c, tx, err)
+}
+
+func (c *e2eChain) tokens(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.decimals)), nil))
+}
+
+func (env *e2eEnv) addr(key *ecdsa.PrivateKey) Addr {
+	return crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func (env *e2eEnv) auth(c *e2eChain, key *ecdsa.PrivateKey) *bind.TransactOpts {
+	auth, err := bind.NewKeyedTransactorWithChainID(key, c.chainId)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	return auth
+}
+
+// mustSucceed checks the tx is mined successfully, the simulated chains mine it on send.
+func (env *e2eEnv) mustSucceed(c *e2eChain, tx *ethtypes.Transaction, err error) {
+	env.t.Helper()
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	receipt, err := c.ec.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		env.t.Fatalf("tx %x failed", tx.Hash())
+	}
+}
+
+func (env *e2eEnv) start() {
+	env.t.Helper()
+	s := NewServer("e2e")
+	s.gateway = NewGatewayClient(env.gateway.Client(), nil)
+	if err := s.Init(env.config, env.ks, env.pwd); err != nil {
+		env.t.Fatal(err)
+	}
+	// events reach the db through the inbox, like the monitors they are always on
+	go s.ProcessEventInbox()
+	env.s = s
+}
+
+func (env *e2eEnv) stop() {
+	env.s.Close()
+	env.s.db.Close()
+	env.s = nil
+}
+
+func (env *e2eEnv) dal() *DAL {
+	return env.s.db.(*SqliteDAL).DAL
+}
+
+// transferOut sends a transfer out from the user to the node on src chain, to the user on dst chain.
+func (env *e2eEnv) transferOut(src, dst uint64, amount *big.Int, timeLock time.Duration) *e2eTransfer {
+	env.t.Helper()
+	xfer := env.submitTransferOut(src, dst, amount, timeLock)
+	env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START)
+	return xfer
+}
+
+// submitTransferOut sends the transfer out to the node as the user, without waiting for the node.
+func (env *e2eEnv) submitTransferOut(src, dst uint64, amount *big.Int, timeLock time.Duration) *e2eTransfer {
+	env.t.Helper()
+	xfer := &e2eTransfer{src: src, dst: dst, amount: amount}
+	if _, err := rand.Read(xfer.preimage[:]); err != nil {
+		env.t.Fatal(err)
+	}
+	xfer.hashLock = getHashLockWithPreImage(xfer.preimage)
+	user, node := env.addr(env.user), env.addr(env.node)
+	xfer.outId = getTransferId(user, node, xfer.hashLock, src)
+	xfer.inId = getTransferId(node, user, xfer.hashLock, dst)
+	c := env.chains[src]
+	tx, err := c.bridge.TransferOut(env.auth(c, env.user), node, c.tokenAddr, amount, xfer.hashLock,
+		uint64(c.now().Add(timeLock).Unix()), dst, user)
+	env.mustSucceed(c, tx, err)
+	return xfer
+}
+
+// userConfirm confirms the transfer in on dst chain as the user, revealing the preimage.
+func (env *e2eEnv) userConfirm(xfer *e2eTransfer) {
+	env.t.Helper()
+	c := env.chains[xfer.dst]
+	tx, err := c.bridge.Confirm(env.auth(c, env.user), xfer.inId, xfer.preimage)
+	env.mustSucceed(c, tx, err)
+}
+
+// sendTransferIn runs the node job sending transfer in, and waits it is locked on dst chain.
+func (env *e2eEnv) sendTransferIn(xfer *e2eTransfer) {
+	env.t.Helper()
+	env.s.processTrySendTransferIn()
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+}
+
+// confirmTransferOut waits for the preimage revealed on dst chain, then runs the node job confirming the transfer out.
+func (env *e2eEnv) confirmTransferOut(xfer *e2eTransfer) {
+	env.t.Helper()
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+	env.waitFor("preimage of transfer out", func() bool {
+		return env.getTransfer(xfer.outId).Preimage == xfer.preimage
+	})
+	env.s.processTryConfirmTransfer()
+	env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+}
+
+func (env *e2eEnv) getTransfer(tid Hash) *Transfer {
+	env.t.Helper()
+	tx, found, err := env.s.db.GetTransferByTid(tid)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	if !found {
+		return &Transfer{}
+	}
+	return tx
+}
+
+func (env *e2eEnv) waitStatus(tid Hash, status cbn.TransferStatus) {
+	env.t.Helper()
+	env.waitFor(fmt.Sprintf("transfer %x %s", tid, status), func() bool {
+		return env.getTransfer(tid).Status == status
+	})
+}
+
+func (env *e2eEnv) waitFor(desc string, cond func() bool) {
+	env.t.Helper()
+	deadline := time.Now().Add(e2eWaitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			env.t.Fatalf("timeout waiting for %s", desc)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// elapse moves the time forward by d: the chains mine a block d later, and the times in the db are moved back by d.
+func (env *e2eEnv) elapse(d time.Duration) {
+	env.t.Helper()
+	for _, c := range env.chains {
+		if err := c.advance(d); err != nil {
+			env.t.Fatal(err)
+		}
+	}
+	dal := env.dal()
+	txs, err := dal.GetAllTransfersWithLimit(1000)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	for _, tx := range txs {
+		_, err = dal.Exec("UPDATE transfer SET timelock = $1, createts = $2, updatets = $3 WHERE tid = $4",
+			tx.TimeLock.Add(-d).UTC(), tx.CreateTs.Add(-d).UTC(), tx.UpdateTs.Add(-d).UTC(), tx.TransferId.String())
+		if err != nil {
+			env.t.Fatal(err)
+		}
+	}
+	// let the watchers see the new blocks
+	time.Sleep(2 * time.Second)
+}
+
+func (env *e2eEnv) balance(chainId uint64, key *ecdsa.PrivateKey) *big.Int {
+	env.t.Helper()
+	c := env.chains[chainId]
+	balance, err := c.token.BalanceOf(nil, env.addr(key))
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	return balance
+}
+
+func (env *e2eEnv) expectBalance(chainId uint64, key *ecdsa.PrivateKey, before *big.Int, delta *big.Int) {
+	env.t.Helper()
+	expected := new(big.Int).Add(before, delta)
+	if got := env.balance(chainId, key); got.Cmp(expected) != 0 {
+		env.t.Fatalf("expect balance %s on chain %d, got %s", expected, chainId, got)
+	}
+}
+
+func (env *e2eEnv) remoteStatus(chainId uint64, tid Hash) uint8 {
+	env.t.Helper()
+	transfer, err := env.chains[chainId].bridge.Transfers(nil, tid)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	return transfer.Status
+}
+
+func neg(x *big.Int) *big.Int {
+	return new(big.Int).Neg(x)
+}
+
+func sub(x, y *big.Int) *big.Int {
+	return new(big.Int).Sub(x, y)
+}
+
+func TestE2EHappyPath(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	env.start()
+	userA, userB := env.balance(e2eChainA, env.user), env.balance(e2eChainB, env.user)
+	nodeA, nodeB := env.balance(e2eChainA, env.node), env.balance(e2eChainB, env.node)
+
+	amount := env.chains[e2eChainA].tokens(100)
+	xfer := env.transferOut(e2eChainA, e2eChainB, amount, 20*time.Hour)
+	env.sendTransferIn(xfer)
+	received := sub(amount, e2eFee)
+	if in := env.getTransfer(xfer.inId); in.Amount.Cmp(received) != 0 || in.Fee.Cmp(e2eFee) != 0 {
+		t.Fatalf("unexpected transfer in amount %s fee %s", in.Amount.String(), in.Fee.String())
+	}
+	env.userConfirm(xfer)
+	env.confirmTransferOut(xfer)
+
+	env.expectBalance(e2eChainA, env.user, userA, neg(amount))
+	env.expectBalance(e2eChainA, env.node, nodeA, amount)
+	env.expectBalance(e2eChainB, env.user, userB, received)
+	env.expectBalance(e2eChainB, env.node, nodeB, neg(received))
+
+	summary, err := env.s.getTotalSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	detail := summary[generateChain2ChainKey(e2eChainA, e2eChainB)]
+	if detail == nil || detail.TotalTransferOutNumber != 1 || detail.TotalSuccessTransferInNumber != 1 {
+		t.Fatalf("unexpected summary %+v", detail)
+	}
+	if fee := detail.FeeReceived[env.chains[e2eChainB].tokenAddr]; fee == nil || fee.FeeAmount.Cmp(e2eFee) != 0 {
+		t.Fatalf("unexpected fee summary %+v", fee)
+	}
+}
+
+func TestE2EDynamicFee(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	// the base fee of the simulated chains is at most 1 gwei, the max fee is capped below 2 x base fee + tip
+	maxFee := gweiToWei(2)
+	for _, chainConfig := range env.config.ChainConfig {
+		chainConfig.ForceGasGwei = 2
+		if chainConfig.ChainId == e2eChainB {
+			chainConfig.TransactorConfig.TxType = cbn.TxType_TX_TYPE_DYNAMIC_FEE
+			chainConfig.TransactorConfig.AddGasGwei = 1
+		}
+	}
+	env.start()
+
+	xfer := env.transferOut(e2eChainA, e2eChainB, env.chains[e2eChainA].tokens(100), 20*time.Hour)
+	env.sendTransferIn(xfer)
+	env.userConfirm(xfer)
+	env.confirmTransferOut(xfer)
+
+	txs, err := env.s.db.GetChainTxsByTransfer(xfer.inId)
+	if err != nil || len(txs) != 1 {
+		t.Fatalf("expect 1 tx of transfer in, got %d, err:%v", len(txs), err)
+	}
+	in := txs[0]
+	if in.ChainId != e2eChainB || in.Purpose != cbn.TxPurpose_TX_PURPOSE_TRANSFER_IN || in.TxType != cbn.TxType_TX_TYPE_DYNAMIC_FEE ||
+		in.GasPrice.Sign() != 0 || in.GasFeeCap.Sign() <= 0 || in.GasFeeCap.Cmp(maxFee) > 0 || in.GasTipCap.Cmp(&in.GasFeeCap) > 0 {
+		t.Fatalf("unexpected transfer in tx %+v", in)
+	}
+	tx, _, err := env.chains[e2eChainB].ec.TransactionByHash(context.Background(), in.TxHash)
+	if err != nil || tx.Type() != ethtypes.DynamicFeeTxType || tx.GasFeeCap().Cmp(&in.GasFeeCap) != 0 {
+		t.Fatalf("transfer in tx not sent with the recorded fees, tx %+v, err:%v", tx, err)
+	}
+	receipt, err := env.chains[e2eChainB].ec.TransactionReceipt(context.Background(), in.TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.waitFor("transfer in tx mined", func() bool {
+		txs, err = env.s.db.GetChainTxsByTransfer(xfer.inId)
+		return err == nil && len(txs) == 1 && txs[0].Status == cbn.ChainTxStatus_CHAIN_TX_STATUS_SUCCEEDED
+	})
+	header, err := env.chains[e2eChainB].ec.HeaderByNumber(context.Background(), receipt.BlockNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gasPrice := new(big.Int).Add(header.BaseFee, &in.GasTipCap)
+	if gasPrice.Cmp(&in.GasFeeCap) > 0 {
+		gasPrice.Set(&in.GasFeeCap)
+	}
+	in = txs[0]
+	if in.BlockNum != receipt.BlockNumber.Uint64() || in.GasUsed != receipt.GasUsed ||
+		in.GasCost.Cmp(new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(receipt.GasUsed))) != 0 {
+		t.Fatalf("unexpected mined transfer in tx %+v", in)
+	}
+
+	if txs, err = env.s.db.GetChainTxsByTransfer(xfer.outId); err != nil || len(txs) != 1 {
+		t.Fatalf("expect 1 tx of transfer out, got %d, err:%v", len(txs), err)
+	}
+	confirm := txs[0]
+	if confirm.ChainId != e2eChainA || confirm.Purpose != cbn.TxPurpose_TX_PURPOSE_CONFIRM || confirm.TxType != cbn.TxType_TX_TYPE_LEGACY ||
+		confirm.GasPrice.Sign() <= 0 || confirm.GasPrice.Cmp(maxFee) > 0 {
+		t.Fatalf("unexpected confirm tx %+v", confirm)
+	}
+}
+
+func TestE2EMulticall(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000, multicall: true})
+	env.start()
+	chainA, chainB := env.chains[e2eChainA], env.chains[e2eChainB]
+
+	var xfers []*e2eTransfer
+	for i := 0; i < 3; i++ {
+		xfers = append(xfers, env.submitTransferOut(e2eChainA, e2eChainB, chainA.tokens(10), 20*time.Hour))
+	}
+	for _, xfer := range xfers {
+		env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START)
+	}
+	// one batch for the 3 transfers in
+	calls := chainB.getMulticalls()
+	env.s.processTrySendTransferIn()
+	if got := chainB.getMulticalls() - calls; got != 1 {
+		t.Fatalf("expect 1 multicall for the send sweep, got %d", got)
+	}
+	for _, xfer := range xfers {
+		env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+		env.userConfirm(xfer)
+	}
+	for _, xfer := range xfers {
+		env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+		env.waitFor("preimage of transfer out", func() bool {
+			return env.getTransfer(xfer.outId).Preimage == xfer.preimage
+		})
+	}
+	env.s.processTryConfirmTransfer()
+	for _, xfer := range xfers {
+		env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+	}
+
+	bcB := env.s.chainMap[e2eChainB]
+	balances := bcB.getTokenBalances(gatewayLog, env.addr(env.node))
+	if balance := balances[chainB.tokenAddr]; balance == nil || balance.Cmp(env.balance(e2eChainB, env.node)) != 0 {
+		t.Fatalf("unexpected batched token balance %v", balance)
+	}
+
+	// read one by one while the Multicall contract fails
+	chainB.setMulticall(e2eMulticallAddr, true)
+	remote := bcB.getTransfers(confirmerLog, []Hash{xfers[0].inId, xfers[1].inId})
+	if len(remote) != 2 || remote[xfers[0].inId].Status != remoteTransferStatusConfirmed {
+		t.Fatalf("unexpected transfers read without multicall %+v", remote)
+	}
+	if balances = bcB.getTokenBalances(gatewayLog, env.addr(env.node)); balances[chainB.tokenAddr] == nil {
+		t.Fatal("expect token balance read without multicall")
+	}
+}
+
+func TestE2EEventDispatch(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	env.start()
+	// no job is run by the test, the monitor callbacks wake the processors long before their scans
+	go env.s.ProcessSendTransfer()
+	go env.s.ProcessConfirmTransfer()
+	userB := env.balance(e2eChainB, env.user)
+
+	amount := env.chains[e2eChainA].tokens(100)
+	xfer := env.submitTransferOut(e2eChainA, e2eChainB, amount, 20*time.Hour)
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+	env.userConfirm(xfer)
+	env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+	if status := env.remoteStatus(e2eChainA, xfer.outId); status != remoteTransferStatusConfirmed {
+		t.Fatalf("transfer out not confirmed on chain, status %d", status)
+	}
+	env.expectBalance(e2eChainB, env.user, userB, sub(amount, e2eFee))
+}
+
+func TestE2ESubscription(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000, subscribe: true})
+	env.start()
+	go env.s.ProcessSendTransfer()
+	go env.s.ProcessConfirmTransfer()
+	waitSource := func(source cbn.EventSource) {
+		env.waitFor(source.String(), func() bool {
+			return env.s.chainMap[e2eChainA].eventSource() == source && env.s.chainMap[e2eChainB].eventSource() == source
+		})
+	}
+	waitSource(cbn.EventSource_EVENT_SOURCE_SUBSCRIPTION)
+	run := func() {
+		xfer := env.submitTransferOut(e2eChainA, e2eChainB, env.chains[e2eChainA].tokens(100), 20*time.Hour)
+		env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+		env.userConfirm(xfer)
+		env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+	}
+	run()
+
+	// events are polled while the websocket endpoints are down
+	env.chains[e2eChainA].setWsDown(true)
+	env.chains[e2eChainB].setWsDown(true)
+	waitSource(cbn.EventSource_EVENT_SOURCE_FALLBACK_POLLING)
+	run()
+	env.chains[e2eChainA].setWsDown(false)
+	env.chains[e2eChainB].setWsDown(false)
+	waitSource(cbn.EventSource_EVENT_SOURCE_SUBSCRIPTION)
+
+	// the transfer out while the node is down is fetched from the stored cursor on start
+	env.stop()
+	xfer := env.submitTransferOut(e2eChainA, e2eChainB, env.chains[e2eChainA].tokens(100), 20*time.Hour)
+	env.start()
+	go env.s.ProcessSendTransfer()
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+}
+
+func TestE2EGatewayFeeError(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	env.start()
+	pings := env.gateway.Pings()
+	if len(pings) != 1 || !strings.EqualFold(pings[0].GetEthAddr(), env.addr(env.node).String()) {
+		t.Fatalf("unexpected pings %v", pings)
+	}
+
+	xfer := env.transferOut(e2eChainA, e2eChainB, env.chains[e2eChainA].tokens(100), 20*time.Hour)
+	if err := env.gateway.InjectError(fakegateway.MethodGetFee, xfer.outId.String(), gatewayrpc.ErrCode_TRANSFER_NOT_FOUND, 1); err != nil {
+		t.Fatal(err)
+	}
+	env.s.processTrySendTransferIn()
+	if status := env.getTransfer(xfer.inId).Status; status != cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START {
+		t.Fatalf("transfer in sent without fee, status %s", status)
+	}
+	// retried in the next round once the gateway knows the transfer
+	fee := big.NewInt(2000)
+	if err := env.gateway.SetFee(xfer.outId.String(), fee.String()); err != nil {
+		t.Fatal(err)
+	}
+	env.sendTransferIn(xfer)
+	if in := env.getTransfer(xfer.inId); in.Fee.Cmp(fee) != 0 {
+		t.Fatalf("unexpected transfer in fee %s", in.Fee.String())
+	}
+	if reqs := env.gateway.FeeRequests(); len(reqs) != 2 {
+		t.Fatalf("unexpected fee requests %v", reqs)
+	}
+}
+
+func TestE2EUserNeverReveals(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	env.start()
+	userA, nodeB := env.balance(e2eChainA, env.user), env.balance(e2eChainB, env.node)
+
+	xfer := env.transferOut(e2eChainA, e2eChainB, env.chains[e2eChainA].tokens(100), 20*time.Hour)
+	env.sendTransferIn(xfer)
+
+	// the transfer in timelock is 8 hours before the transfer out one
+	env.elapse(13 * time.Hour)
+	env.s.processTryRefundTransferIn()
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED)
+	env.expectBalance(e2eChainB, env.node, nodeB, big.NewInt(0))
+
+	env.elapse(8 * time.Hour)
+	c := env.chains[e2eChainA]
+	tx, err := c.bridge.Refund(env.auth(c, env.user), xfer.outId)
+	env.mustSucceed(c, tx, err)
+	env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED)
+	env.expectBalance(e2eChainA, env.user, userA, big.NewInt(0))
+}
+
+func TestE2EMissedConfirmEvent(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	env.start()
+
+	xfer := env.transferOut(e2eChainA, e2eChainB, env.chains[e2eChainA].tokens(100), 20*time.Hour)
+	env.sendTransferIn(xfer)
+	env.userConfirm(xfer)
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+	env.waitFor("preimage of transfer out", func() bool {
+		return env.getTransfer(xfer.outId).Preimage == xfer.preimage
+	})
+
+	// the node never sees its own confirm on chain A
+	env.chains[e2eChainA].dropEvent(cbridgeAbi, evLogTransferConfirmed)
+	env.s.processTryConfirmTransfer()
+	if status := env.remoteStatus(e2eChainA, xfer.outId); status != remoteTransferStatusConfirmed {
+		t.Fatalf("expect transfer out confirmed on chain, got %d", status)
+	}
+	time.Sleep(2 * time.Second)
+	if status := env.getTransfer(xfer.outId).Status; status != cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING {
+		t.Fatalf("expect transfer out confirm pending, got %s", status)
+	}
+
+	// recovered after the pending timeout, the on-chain status is used
+	env.elapse(maxPendingTimeOutRetryDuration + time.Minute)
+	env.s.processRecoverTimeoutPendingConfirm()
+	env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+	env.s.processTryConfirmTransfer()
+	env.waitStatus(xfer.outId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED)
+}
+
+func TestE2ETransferInRevert(t *testing.T) {
+	// no liquidity on chain B, with a fixed gas limit the transfer in is mined and reverts
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, gasLimitB: 500000})
+	env.start()
+
+	amount := env.chains[e2eChainA].tokens(100)
+	xfer := env.transferOut(e2eChainA, e2eChainB, amount, 20*time.Hour)
+	cB := env.chains[e2eChainB]
+	nonce, err := cB.ec.NonceAt(context.Background(), env.addr(env.node), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.s.processTrySendTransferIn()
+	if after, _ := cB.ec.NonceAt(context.Background(), env.addr(env.node), nil); after != nonce+1 {
+		t.Fatalf("expect a transfer in tx sent, nonce %d -> %d", nonce, after)
+	}
+	if status := env.remoteStatus(e2eChainB, xfer.inId); status != remoteTransferStatusUndefined {
+		t.Fatalf("expect no transfer in on chain, got %d", status)
+	}
+	time.Sleep(2 * time.Second)
+	if status := env.getTransfer(xfer.inId).Status; status != cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING {
+		t.Fatalf("expect transfer in pending, got %s", status)
+	}
+	var txs []*ChainTx
+	env.waitFor("transfer in tx mined", func() bool {
+		txs, err = env.s.db.GetChainTxsByTransfer(xfer.inId)
+		return err == nil && len(txs) == 1 && txs[0].Status != cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING
+	})
+	if reverted := txs[0]; reverted.Status != cbn.ChainTxStatus_CHAIN_TX_STATUS_REVERTED || reverted.BlockNum == 0 ||
+		reverted.GasUsed == 0 || reverted.GasCost.Sign() <= 0 || !strings.Contains(reverted.Error, "execution reverted") {
+		t.Fatalf("unexpected reverted tx %+v", reverted)
+	}
+
+	// retried after the pending timeout, with the same fee
+	env.fundNode(cB, 1000000)
+	env.elapse(maxPendingTimeOutRetryDuration + time.Minute)
+	env.s.processRecoverTimeoutPendingTransferIn()
+	env.waitStatus(xfer.inId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START)
+	env.sendTransferIn(xfer)
+	transfer, err := cB.bridge.Transfers(nil, xfer.inId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received := sub(amount, e2eFee); transfer.Amount.Cmp(received) != 0 {
+		t.Fatalf("expect transfer in amount %s, got %s", received, transfer.Amount)
+	}
+	env.waitFor("retried transfer in tx mined", func() bool {
+		txs, err = env.s.db.GetChainTxsByTransfer(xfer.inId)
+		return err == nil && len(txs) == 2 && txs[1].Status == cbn.ChainTxStatus_CHAIN_TX_STATUS_SUCCEEDED
+	})
+	if txs[1].Replaces != (Hash{}) {
+		t.Fatalf("expect the retried transfer in tx with a new nonce, replaces %x", txs[1].Replaces)
+	}
+	env.userConfirm(xfer)
+	env.confirmTransferOut(xfer)
+}
+
+func TestE2ENodeRestart(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	env.start()
+
+	xfer := env.transferOut(e2eChainA, e2eChainB, env.chains[e2eChainA].tokens(100), 20*time.Hour)
+	env.sendTransferIn(xfer)
+	env.stop()
+
+	// the user confirms while the node is down, the node catches up from the stored monitor blocks
+	env.userConfirm(xfer)
+	env.start()
+	env.confirmTransferOut(xfer)
+	if status := env.remoteStatus(e2eChainA, xfer.outId); status != remoteTransferStatusConfirmed {
+		t.Fatalf("expect transfer out confirmed on chain, got %d", status)
+	}
+}
+
+func TestE2EDecimalsMismatch(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 6, nodeLiquidityB: 1000000})
+	env.start()
+	cA, cB := env.chains[e2eChainA], env.chains[e2eChainB]
+
+	// 18 -> 6 decimals
+	userB := env.balance(e2eChainB, env.user)
+	xfer := env.transferOut(e2eChainA, e2eChainB, cA.tokens(100), 20*time.Hour)
+	env.sendTransferIn(xfer)
+	env.userConfirm(xfer)
+	env.confirmTransferOut(xfer)
+	env.expectBalance(e2eChainB, env.user, userB, sub(cB.tokens(100), e2eFee))
+
+	// 6 -> 18 decimals
+	userA := env.balance(e2eChainA, env.user)
+	xfer = env.transferOut(e2eChainB, e2eChainA, cB.tokens(50), 20*time.Hour)
+	env.sendTransferIn(xfer)
+	env.userConfirm(xfer)
+	env.confirmTransferOut(xfer)
+	env.expectBalance(e2eChainA, env.user, userA, sub(cA.tokens(50), e2eFee))
+}
+
+func TestE2EStandbyApprove(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	c := env.chains[e2eChainB]
+	tx, err := c.token.Approve(env.auth(c, env.node), c.bridgeAddr, big.NewInt(0))
+	env.mustSucceed(c, tx, err)
+	allowance := func() *big.Int {
+		a, err2 := c.token.Allowance(&bind.CallOpts{}, env.addr(env.node), c.bridgeAddr)
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+		return a
+	}
+
+	// a standby only reads the allowance
+	env.config.HaConfig = &cbn.HaConfig{Enabled: true, LeaseTtl: 60}
+	env.start()
+	bc := env.s.chainMap[e2eChainB]
+	if allowance().Sign() != 0 || len(bc.unapprovedTokens) != 1 {
+		t.Fatalf("expect no approval by a standby, got allowance %s, unapproved %v", allowance(), bc.unapprovedTokens)
+	}
+	if err = env.s.approveTokens(bc); err == nil {
+		t.Fatal("expect approval without the lease refused")
+	}
+
+	// the leader approves through transact
+	if err = env.s.lease.renew(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- env.s.approveTokens(bc) }()
+	env.waitFor("approve tx", func() bool { return allowance().Cmp(MaxUint256) == 0 })
+	// mine the blocks the approval waits for
+	env.waitFor("approve tx confirmed", func() bool {
+		select {
+		case err = <-done:
+			return true
+		default:
+			env.elapse(time.Second)
+			return false
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bc.unapprovedTokens) != 0 {
+		t.Fatalf("expect all tokens approved, got %v", bc.unapprovedTokens)
+	}
+	ctxs, err := env.s.db.GetChainTxsByTransfer(Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctxs) != 1 || ctxs[0].Purpose != cbn.TxPurpose_TX_PURPOSE_APPROVE {
+		t.Fatalf("expect the approval recorded, got %+v", ctxs)
+	}
+}
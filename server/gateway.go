@@ -3,49 +3,169 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"google.golang.org/grpc/credentials"
+	"io/ioutil"
 	"math/big"
+	"math/rand"
+	"sync"
+	"time"
 
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
 	"github.com/celer-network/cBridge-go/gatewayrpc"
 	"github.com/celer-network/goutils/log"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 type GatewayAPI interface {
 	Close()
 	PingGateway(req *gatewayrpc.PingRequest) (*gatewayrpc.PingResponse, error)
-	GetFee(transferOutId Hash) (fee *big.Int, err error)
+	GetFee(ctx context.Context, transferOutId Hash) (fee *big.Int, err error)
+	Status() *cbn.GatewayStatus
+}
+
+// GatewayError is an error returned by the gateway in the response body.
+type GatewayError struct {
+	Err *gatewayrpc.ErrMsg
 }
 
+func (e *GatewayError) Error() string {
+	return fmt.Sprintf("gateway err, code:%s, msg:%s", e.Err.GetCode().String(), e.Err.GetMsg())
+}
+
+const (
+	defaultGatewayCallTimeout      = 10 * time.Second
+	defaultGatewayMaxRetries       = 2
+	defaultGatewayRetryBackoff     = 500 * time.Millisecond
+	defaultGatewayBreakerThreshold = 5
+	defaultGatewayBreakerCooldown  = 30 * time.Second
+)
+
+var (
+	gatewayState    = newGauge("cbridge/gateway/state")
+	gatewayRetries  = newCounter("cbridge/gateway/retries")
+	gatewayFailures = newCounter("cbridge/gateway/failures")
+	gatewayRejected = newCounter("cbridge/gateway/rejected")
+)
+
+// ErrGatewayCircuitOpen is returned without calling the gateway while the circuit breaker is open.
+var ErrGatewayCircuitOpen = errors.New("gateway circuit breaker open")
+
 type GatewayClient struct {
 	gatewayConn *grpc.ClientConn
+	client      gatewayrpc.RelayClient
+
+	callTimeout      time.Duration
+	maxRetries       uint64
+	retryBackoff     time.Duration
+	breakerThreshold uint64
+	breakerCooldown  time.Duration
+
+	lock        sync.Mutex
+	state       cbn.GatewayState
+	failures    uint64
+	lastErr     error
+	lastSuccess time.Time
+	openUntil   time.Time
+	trialing    bool
 }
 
-func NewGatewayAPI(gatewayUrl string) (*GatewayClient, error) {
-	config := &tls.Config{}
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(config))}
+// NewGatewayAPI connects to the gateway with TLS, or without if insecure, which is only for a local fake gateway.
+func NewGatewayAPI(gatewayUrl string, insecure bool, cfg *cbn.GatewayConfig) (*GatewayClient, error) {
+	var opts []grpc.DialOption
+	if insecure {
+		gatewayLog.Warnf("connecting to gateway %s without TLS", gatewayUrl)
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		tlsConfig, err := newGatewayTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	// a span for each attempt, with the trace context sent to the gateway
+	opts = append(opts, grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()))
 	conn, err := grpc.Dial(gatewayUrl, opts...)
 	if err != nil {
 		return nil, err
 	}
-	gateway := &GatewayClient{
-		gatewayConn: conn,
-	}
+	gateway := NewGatewayClient(gatewayrpc.NewRelayClient(conn), cfg)
+	gateway.gatewayConn = conn
 	return gateway, nil
 }
 
-func (c *GatewayClient) GetFee(transferOutId Hash) (*big.Int, error) {
-	client := gatewayrpc.NewRelayClient(c.gatewayConn)
+// NewGatewayClient uses the Relay client as gateway, e.g. the in-process client of a fake gateway.
+func NewGatewayClient(client gatewayrpc.RelayClient, cfg *cbn.GatewayConfig) *GatewayClient {
+	c := &GatewayClient{
+		client:           client,
+		callTimeout:      time.Duration(cfg.GetCallTimeout()) * time.Millisecond,
+		maxRetries:       cfg.GetMaxRetries(),
+		retryBackoff:     time.Duration(cfg.GetRetryBackoff()) * time.Millisecond,
+		breakerThreshold: cfg.GetBreakerThreshold(),
+		breakerCooldown:  time.Duration(cfg.GetBreakerCooldown()) * time.Second,
+	}
+	if c.callTimeout == 0 {
+		c.callTimeout = defaultGatewayCallTimeout
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultGatewayMaxRetries
+	}
+	if c.retryBackoff == 0 {
+		c.retryBackoff = defaultGatewayRetryBackoff
+	}
+	if c.breakerThreshold == 0 {
+		c.breakerThreshold = defaultGatewayBreakerThreshold
+	}
+	if c.breakerCooldown == 0 {
+		c.breakerCooldown = defaultGatewayBreakerCooldown
+	}
+	gatewayState.Update(int64(cbn.GatewayState_GATEWAY_STATE_HEALTHY))
+	return c
+}
+
+func newGatewayTLSConfig(cfg *cbn.GatewayConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: cfg.GetServerName()}
+	if cfg.GetCaFile() != "" {
+		pem, err := ioutil.ReadFile(cfg.GetCaFile())
+		if err != nil {
+			return nil, fmt.Errorf("fail to read gateway ca file, err:%w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no cert found in gateway ca file %s", cfg.GetCaFile())
+		}
+	}
+	if cfg.GetCertFile() != "" || cfg.GetKeyFile() != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.GetCertFile(), cfg.GetKeyFile())
+		if err != nil {
+			return nil, fmt.Errorf("fail to load gateway client cert, err:%w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (c *GatewayClient) GetFee(ctx context.Context, transferOutId Hash) (*big.Int, error) {
 	req := &gatewayrpc.GetFeeRequest{
 		TransferOutId: transferOutId.String(),
 	}
-	resp, pingErr := client.GetFee(context.Background(), req)
-	if pingErr != nil {
-		return nil, pingErr
+	var resp *gatewayrpc.GetFeeResponse
+	err := c.call(ctx, "GetFee", func(ctx context.Context) (err error) {
+		resp, err = c.client.GetFee(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 	if beErr := resp.GetErr(); beErr != nil {
-		return nil, fmt.Errorf("fail to get fee with token, err:%v", beErr)
+		return nil, fmt.Errorf("fail to get fee with token, err:%w", &GatewayError{Err: beErr})
 	}
 	fee, success := new(big.Int).SetString(resp.GetFee(), 10)
 	if !success {
@@ -55,18 +175,125 @@ func (c *GatewayClient) GetFee(transferOutId Hash) (*big.Int, error) {
 }
 
 func (c *GatewayClient) PingGateway(req *gatewayrpc.PingRequest) (*gatewayrpc.PingResponse, error) {
-	client := gatewayrpc.NewRelayClient(c.gatewayConn)
-	resp, pingErr := client.Ping(context.Background(), req)
-	if pingErr != nil {
-		return nil, pingErr
+	var resp *gatewayrpc.PingResponse
+	err := c.call(context.Background(), "Ping", func(ctx context.Context) (err error) {
+		resp, err = c.client.Ping(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 	if beErr := resp.GetErr(); beErr != nil {
-		return nil, fmt.Errorf("fail to ping gateway and get fee map, err:%v", beErr)
+		return nil, fmt.Errorf("fail to ping gateway and get fee map, err:%w", &GatewayError{Err: beErr})
 	}
 	return resp, nil
 }
 
+// Status returns the connectivity to the gateway.
+func (c *GatewayClient) Status() *cbn.GatewayStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ret := &cbn.GatewayStatus{
+		State:               c.state,
+		ConsecutiveFailures: c.failures,
+	}
+	if c.lastErr != nil {
+		ret.LastError = c.lastErr.Error()
+	}
+	if !c.lastSuccess.IsZero() {
+		ret.LastSuccessTs = uint64(c.lastSuccess.Unix())
+	}
+	return ret
+}
+
+// call runs the gateway call with a timeout per attempt, retrying transient grpc errors with jittered
+// backoff. Errors returned in the response body are not failures of the connection. The call is traced as
+// a child span of ctx.
+func (c *GatewayClient) call(ctx context.Context, method string, attempt func(ctx context.Context) error) (err error) {
+	ctx, span := tracer.Start(ctx, "gateway "+method, trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		endSpan(span, err)
+	}()
+	if err = c.acquire(); err != nil {
+		gatewayRejected.Inc(1)
+		return err
+	}
+	backoff := c.retryBackoff
+	for i := uint64(0); ; i++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.callTimeout)
+		err = attempt(attemptCtx)
+		cancel()
+		if err == nil || !isTransientGatewayErr(err) || i == c.maxRetries {
+			break
+		}
+		gatewayRetries.Inc(1)
+		sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		gatewayLog.Debugf("gateway %s failed, retry %d in %s, err:%v", method, i+1, sleep, err)
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int64("attempt", int64(i+1)), attribute.String("error", err.Error())))
+		time.Sleep(sleep)
+		backoff *= 2
+	}
+	c.release(err)
+	if err != nil {
+		return fmt.Errorf("fail to call gateway %s, err:%w", method, err)
+	}
+	return nil
+}
+
+// acquire checks the circuit breaker, letting a single trial call through once the cooldown has passed.
+func (c *GatewayClient) acquire() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != cbn.GatewayState_GATEWAY_STATE_DOWN {
+		return nil
+	}
+	if c.trialing || time.Now().Before(c.openUntil) {
+		return ErrGatewayCircuitOpen
+	}
+	c.trialing = true
+	return nil
+}
+
+func (c *GatewayClient) release(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.trialing = false
+	prev := c.state
+	if err == nil {
+		c.failures = 0
+		c.lastSuccess = time.Now()
+		c.state = cbn.GatewayState_GATEWAY_STATE_HEALTHY
+	} else {
+		gatewayFailures.Inc(1)
+		c.failures++
+		c.lastErr = err
+		if c.failures >= c.breakerThreshold {
+			c.state = cbn.GatewayState_GATEWAY_STATE_DOWN
+			c.openUntil = time.Now().Add(c.breakerCooldown)
+		} else {
+			c.state = cbn.GatewayState_GATEWAY_STATE_DEGRADED
+		}
+	}
+	gatewayState.Update(int64(c.state))
+	if c.state != prev && err == nil {
+		gatewayLog.Infof("gateway state changed from %s to %s", prev, c.state)
+	} else if c.state != prev {
+		gatewayLog.Warnf("gateway state changed from %s to %s, failures:%d, err:%v", prev, c.state, c.failures, err)
+	}
+}
+
+func isTransientGatewayErr(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
 func (c *GatewayClient) Close() {
+	if c.gatewayConn == nil {
+		return
+	}
 	if err := c.gatewayConn.Close(); err != nil {
 		log.Warnln("closeGatewayConn: error:", err)
 	}
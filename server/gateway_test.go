@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/gatewayrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubRelay returns the queued errors in order, then succeeds. A nil queued error blocks until the call
+// deadline.
+type stubRelay struct {
+	lock  sync.Mutex
+	errs  []error
+	calls int
+}
+
+func (r *stubRelay) next(ctx context.Context) error {
+	r.lock.Lock()
+	r.calls++
+	if len(r.errs) == 0 {
+		r.lock.Unlock()
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	r.lock.Unlock()
+	if err == nil {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return err
+}
+
+func (r *stubRelay) Ping(ctx context.Context, in *gatewayrpc.PingRequest, opts ...grpc.CallOption) (*gatewayrpc.PingResponse, error) {
+	if err := r.next(ctx); err != nil {
+		return nil, err
+	}
+	return &gatewayrpc.PingResponse{}, nil
+}
+
+func (r *stubRelay) GetFee(ctx context.Context, in *gatewayrpc.GetFeeRequest, opts ...grpc.CallOption) (*gatewayrpc.GetFeeResponse, error) {
+	if err := r.next(ctx); err != nil {
+		return nil, err
+	}
+	return &gatewayrpc.GetFeeResponse{Fee: "1000"}, nil
+}
+
+func (r *stubRelay) callCount() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.calls
+}
+
+var testGatewayConfig = &cbn.GatewayConfig{
+	CallTimeout:      50,
+	MaxRetries:       2,
+	RetryBackoff:     1,
+	BreakerThreshold: 2,
+	BreakerCooldown:  1,
+}
+
+func TestGatewayRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	relay := &stubRelay{errs: []error{unavailable, nil}}
+	gateway := NewGatewayClient(relay, testGatewayConfig)
+	fee, err := gateway.GetFee(context.Background(), Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.Int64() != 1000 || relay.callCount() != 3 {
+		t.Errorf("expect fee after 2 retries, got fee %s in %d calls", fee, relay.callCount())
+	}
+	if state := gateway.Status().GetState(); state != cbn.GatewayState_GATEWAY_STATE_HEALTHY {
+		t.Errorf("expect healthy, got %s", state)
+	}
+
+	// retries exhausted
+	relay = &stubRelay{errs: []error{unavailable, unavailable, unavailable}}
+	gateway = NewGatewayClient(relay, testGatewayConfig)
+	if _, err = gateway.PingGateway(&gatewayrpc.PingRequest{}); status.Code(errors.Unwrap(err)) != codes.Unavailable {
+		t.Errorf("expect unavailable, got %v", err)
+	}
+	if relay.callCount() != 3 {
+		t.Errorf("expect 3 calls, got %d", relay.callCount())
+	}
+	if st := gateway.Status(); st.GetState() != cbn.GatewayState_GATEWAY_STATE_DEGRADED || st.GetConsecutiveFailures() != 1 {
+		t.Errorf("expect degraded after 1 failure, got %v", st)
+	}
+
+	// not retried
+	relay = &stubRelay{errs: []error{status.Error(codes.InvalidArgument, "bad request")}}
+	gateway = NewGatewayClient(relay, testGatewayConfig)
+	if _, err = gateway.GetFee(context.Background(), Hash{}); err == nil || relay.callCount() != 1 {
+		t.Errorf("expect 1 failed call, got %d calls, err:%v", relay.callCount(), err)
+	}
+}
+
+func TestGatewayCircuitBreaker(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	relay := &stubRelay{errs: []error{unavailable, unavailable, unavailable, unavailable, unavailable, unavailable}}
+	gateway := NewGatewayClient(relay, testGatewayConfig)
+	for i := 0; i < 2; i++ {
+		if _, err := gateway.GetFee(context.Background(), Hash{}); err == nil {
+			t.Fatal("expect error")
+		}
+	}
+	if state := gateway.Status().GetState(); state != cbn.GatewayState_GATEWAY_STATE_DOWN {
+		t.Fatalf("expect down, got %s", state)
+	}
+	if _, err := gateway.GetFee(context.Background(), Hash{}); !errors.Is(err, ErrGatewayCircuitOpen) || relay.callCount() != 6 {
+		t.Fatalf("expect call rejected, got %d calls, err:%v", relay.callCount(), err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := gateway.GetFee(context.Background(), Hash{}); err != nil {
+		t.Fatalf("expect trial call to succeed, err:%v", err)
+	}
+	if st := gateway.Status(); st.GetState() != cbn.GatewayState_GATEWAY_STATE_HEALTHY || st.GetLastSuccessTs() == 0 {
+		t.Errorf("expect healthy, got %v", st)
+	}
+}
+
+func TestGatewayCallTimeout(t *testing.T) {
+	relay := &stubRelay{errs: []error{nil, nil, nil}}
+	gateway := NewGatewayClient(relay, testGatewayConfig)
+	start := time.Now()
+	_, err := gateway.GetFee(context.Background(), Hash{})
+	if status.Code(errors.Unwrap(err)) != codes.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call took %s", elapsed)
+	}
+	if relay.callCount() != 3 {
+		t.Errorf("expect timed out calls retried, got %d calls", relay.callCount())
+	}
+}
+
+func TestSendQueueGatewayDown(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	relay := &stubRelay{errs: []error{unavailable, unavailable, unavailable, unavailable, unavailable, unavailable}}
+	s := NewServer("test")
+	s.gateway = NewGatewayClient(relay, testGatewayConfig)
+	for i := 0; i < 2; i++ {
+		if _, err := s.gateway.GetFee(context.Background(), Hash{}); err == nil {
+			t.Fatal("expect error")
+		}
+	}
+	tid := Bytes2Hash([]byte{0x70})
+	s.sendQueue.add(tid)
+	// left in the queue until the gateway is back
+	s.processSendQueue()
+	if ids := s.sendQueue.take(); len(ids) != 1 || ids[0] != tid {
+		t.Fatalf("expect transfer in kept queued, got %x", ids)
+	}
+}
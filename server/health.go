@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/ethereum/go-ethereum"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultMaxHeadAge         = 300 * time.Second
+	defaultMaxCursorLag       = 100
+	defaultMaxGatewayAge      = 120 * time.Second
+	defaultHealthCheckTimeout = 3 * time.Second
+	// the checks call the chain endpoints, /readyz reuses the last result within this window
+	readinessCacheTTL = 5 * time.Second
+)
+
+var (
+	// the events of the polling watches, one watch each
+	watchedEvents = []string{evLogTransferOut, evLogTransferIn, evLogTransferConfirmed, evLogTransferRefunded}
+
+	healthReady = newGauge("cbridge/health/ready")
+)
+
+// healthThresholds are the thresholds of health_config with the defaults applied.
+type healthThresholds struct {
+	maxHeadAge    time.Duration
+	maxCursorLag  uint64
+	maxGatewayAge time.Duration
+	checkTimeout  time.Duration
+}
+
+func newHealthThresholds(cfg *cbn.HealthConfig) *healthThresholds {
+	h := &healthThresholds{
+		maxHeadAge:    time.Duration(cfg.GetMaxHeadAge()) * time.Second,
+		maxCursorLag:  cfg.GetMaxCursorLag(),
+		maxGatewayAge: time.Duration(cfg.GetMaxGatewayAge()) * time.Second,
+		checkTimeout:  time.Duration(cfg.GetCheckTimeout()) * time.Millisecond,
+	}
+	if h.maxHeadAge == 0 {
+		h.maxHeadAge = defaultMaxHeadAge
+	}
+	if h.maxCursorLag == 0 {
+		h.maxCursorLag = defaultMaxCursorLag
+	}
+	if h.maxGatewayAge == 0 {
+		h.maxGatewayAge = defaultMaxGatewayAge
+	}
+	if h.checkTimeout == 0 {
+		h.checkTimeout = defaultHealthCheckTimeout
+	}
+	return h
+}
+
+// watchClient is the client of the polling watches of a chain. A watch keeps the block it has fetched logs up
+// to in memory and only stores a cursor when an event is handled, so the client records the last block the logs
+// of each event were fetched up to.
+type watchClient struct {
+	*ethclient.Client
+	lock    sync.Mutex
+	scanned map[Hash]uint64 // event id -> block
+}
+
+func newWatchClient(ec *ethclient.Client) *watchClient {
+	return &watchClient{Client: ec, scanned: make(map[Hash]uint64)}
+}
+
+func (c *watchClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]ethtypes.Log, error) {
+	logs, err := c.Client.FilterLogs(ctx, q)
+	if err != nil || q.ToBlock == nil || len(q.Topics) == 0 {
+		return logs, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, id := range q.Topics[0] {
+		if q.ToBlock.Uint64() > c.scanned[id] {
+			c.scanned[id] = q.ToBlock.Uint64()
+		}
+	}
+	return logs, nil
+}
+
+// scannedBlock returns the last block the logs of the event were fetched up to, false if not fetched yet or c
+// is nil, i.e. the watches are not started.
+func (c *watchClient) scannedBlock(event string) (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	block, found := c.scanned[cbridgeABI.Events[event].ID]
+	return block, found
+}
+
+// readinessCache holds the last readiness result. The lock is held while the checks run, so concurrent
+// requests wait for the running checks instead of starting their own.
+type readinessCache struct {
+	lock sync.Mutex
+	resp *cbn.ReadinessResponse
+	ts   time.Time
+}
+
+// cachedReadiness returns the last readiness result if not older than readinessCacheTTL, else runs the checks.
+func (s *server) cachedReadiness() *cbn.ReadinessResponse {
+	c := &s.readinessCache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.resp == nil || time.Since(c.ts) > readinessCacheTTL {
+		c.resp = s.readiness()
+		c.ts = time.Now()
+	}
+	return c.resp
+}
+
+// readiness runs the checks of the dependencies of the node. The chains are checked concurrently. The
+// monitor cursors and the gateway are only checked on the leader, a standby does not run them.
+func (s *server) readiness() *cbn.ReadinessResponse {
+	h := newHealthThresholds(s.cfg.GetHealthConfig())
+	resp := &cbn.ReadinessResponse{Ha: s.haStatus()}
+	leader := resp.Ha.GetRole() == cbn.HaRole_HA_ROLE_LEADER
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	add := func(checks ...*cbn.ReadinessCheck) {
+		lock.Lock()
+		resp.Checks = append(resp.Checks, checks...)
+		lock.Unlock()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		add(s.checkDbReady())
+	}()
+	for _, bc := range s.chainMap {
+		bc := bc
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			add(s.checkChainReady(bc, h, leader)...)
+		}()
+	}
+	wg.Wait()
+	add(s.checkGatewayReady(h, leader))
+
+	resp.Ready = true
+	for _, check := range resp.Checks {
+		if !check.Ok {
+			resp.Ready = false
+		}
+	}
+	if resp.Ready {
+		healthReady.Update(1)
+	} else {
+		healthReady.Update(0)
+	}
+	return resp
+}
+
+func (s *server) checkDbReady() *cbn.ReadinessCheck {
+	check := &cbn.ReadinessCheck{Name: "db"}
+	if err := s.db.Ping(); err != nil {
+		check.Detail = fmt.Sprintf("fail to query db, err:%v", err)
+		return check
+	}
+	check.Ok = true
+	return check
+}
+
+// checkChainReady checks the rpc endpoint, the monitor cursors and the gas balance of the chain.
+func (s *server) checkChainReady(bc *bridgeConfig, h *healthThresholds, leader bool) []*cbn.ReadinessCheck {
+	chainId := bc.config.GetChainId()
+	rpcCheck := &cbn.ReadinessCheck{Name: "rpc", ChainId: chainId}
+	checks := []*cbn.ReadinessCheck{rpcCheck}
+	head, err := checkChainHead(bc, h)
+	if err != nil {
+		rpcCheck.Detail = err.Error()
+	} else {
+		rpcCheck.Ok = true
+		rpcCheck.Detail = fmt.Sprintf("head %d", head)
+	}
+	checks = append(checks, s.checkCursorsReady(bc, h, leader, head, err == nil)...)
+	if gasCheck := s.checkGasReady(bc, h); gasCheck != nil {
+		checks = append(checks, gasCheck)
+	}
+	return checks
+}
+
+// checkChainHead returns the latest block number of the chain endpoint, or an error if the endpoint is down,
+// serves another chain or its latest block is older than max_head_age.
+func checkChainHead(bc *bridgeConfig, h *healthThresholds) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.checkTimeout)
+	defer cancel()
+	chainId, err := bc.ec.ChainID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("fail to get chain id, err:%w", err)
+	}
+	if chainId.Uint64() != bc.config.GetChainId() {
+		return 0, fmt.Errorf("endpoint serves chain %d", chainId.Uint64())
+	}
+	header, err := bc.ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("fail to get head, err:%w", err)
+	}
+	age := time.Since(time.Unix(int64(header.Time), 0))
+	if age > h.maxHeadAge {
+		return 0, fmt.Errorf("head %d is %s old", header.Number.Uint64(), age.Round(time.Second))
+	}
+	return header.Number.Uint64(), nil
+}
+
+// checkCursorsReady checks the blocks the monitors have scanned up to are within max_cursor_lag of the head,
+// not counting block_delay. In polling mode each event has its own watch, so a check per event with the block
+// its watch last fetched logs up to.
+func (s *server) checkCursorsReady(bc *bridgeConfig, h *healthThresholds, leader bool, head uint64, headOk bool) []*cbn.ReadinessCheck {
+	chainId := bc.config.GetChainId()
+	events := watchedEvents
+	if bc.sub != nil {
+		events = []string{subscriptionCursorEvent}
+	}
+	checks := make([]*cbn.ReadinessCheck, 0, len(events))
+	blockDelay := bc.config.GetWatchConfig().GetBlockDelay()
+	for _, event := range events {
+		check := &cbn.ReadinessCheck{Name: "cursor", ChainId: chainId, Event: event}
+		checks = append(checks, check)
+		if !leader {
+			check.Ok, check.Skipped, check.Detail = true, true, "standby"
+			continue
+		}
+		if !headOk {
+			check.Detail = "chain head unknown"
+			continue
+		}
+		var cursor uint64
+		if bc.sub != nil {
+			cursor = bc.sub.getCursor()
+		} else {
+			scanned, found := bc.watchClient.scannedBlock(event)
+			if !found {
+				check.Detail = "no block scanned yet"
+				continue
+			}
+			cursor = scanned
+		}
+		var lag uint64
+		if head > blockDelay && head-blockDelay > cursor {
+			lag = head - blockDelay - cursor
+		}
+		check.Ok = lag <= h.maxCursorLag
+		check.Detail = fmt.Sprintf("cursor %d, lag %d blocks", cursor, lag)
+	}
+	return checks
+}
+
+// checkGasReady checks the gas balance against min_gas_balance, nil if the chain has no floor configured.
+func (s *server) checkGasReady(bc *bridgeConfig, h *healthThresholds) *cbn.ReadinessCheck {
+	if bc.config.GetMinGasBalance() == "" {
+		return nil
+	}
+	check := &cbn.ReadinessCheck{Name: "gas_balance", ChainId: bc.config.GetChainId()}
+	minBalance, ok := new(big.Int).SetString(bc.config.GetMinGasBalance(), 10)
+	if !ok {
+		check.Detail = fmt.Sprintf("invalid min gas balance %s", bc.config.GetMinGasBalance())
+		return check
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.checkTimeout)
+	defer cancel()
+	balance, err := bc.ec.BalanceAt(ctx, s.accountAddr, nil)
+	if err != nil {
+		check.Detail = fmt.Sprintf("fail to get gas balance, err:%v", err)
+		return check
+	}
+	check.Ok = balance.Cmp(minBalance) >= 0
+	check.Detail = fmt.Sprintf("balance %s, floor %s", balance, minBalance)
+	return check
+}
+
+// checkGatewayReady checks the last successful gateway call, the ping every 30s on the leader, is recent.
+func (s *server) checkGatewayReady(h *healthThresholds, leader bool) *cbn.ReadinessCheck {
+	check := &cbn.ReadinessCheck{Name: "gateway"}
+	if !leader {
+		check.Ok, check.Skipped, check.Detail = true, true, "standby"
+		return check
+	}
+	status := s.gateway.Status()
+	if status.GetLastSuccessTs() == 0 {
+		check.Detail = fmt.Sprintf("no successful call, state %s", status.GetState())
+		return check
+	}
+	age := time.Since(time.Unix(int64(status.GetLastSuccessTs()), 0))
+	check.Ok = age <= h.maxGatewayAge
+	check.Detail = fmt.Sprintf("last success %s ago, state %s", age.Round(time.Second), status.GetState())
+	if status.GetLastError() != "" {
+		check.Detail += ", last error: " + status.GetLastError()
+	}
+	return check
+}
+
+// httpHealthz tells the process is alive, e.g. for a liveness probe.
+func (s *server) httpHealthz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"status":"ok"}`))
+}
+
+// httpReadyz returns the checks of the dependencies and the HA status, with status 503 if any check fails,
+// e.g. for a readiness probe or a load balancer.
+func (s *server) httpReadyz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	resp := s.cachedReadiness()
+	if !resp.Ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeProtoJson(w, resp)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestReadiness(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 18, nodeLiquidityB: 1000000})
+	env.start()
+
+	checks := func(resp *cbn.ReadinessResponse) map[string]*cbn.ReadinessCheck {
+		m := make(map[string]*cbn.ReadinessCheck)
+		for _, check := range resp.Checks {
+			m[fmt.Sprintf("%s/%s/%d", check.Name, check.Event, check.ChainId)] = check
+		}
+		return m
+	}
+	// the gateway is pinged by Init, the watches scan their first blocks right after
+	var resp *cbn.ReadinessResponse
+	env.waitFor("ready", func() bool {
+		resp = env.s.readiness()
+		return resp.Ready
+	})
+	got := checks(resp)
+	for _, chainId := range []uint64{e2eChainA, e2eChainB} {
+		if !got[fmt.Sprintf("rpc//%d", chainId)].GetOk() {
+			t.Fatalf("expect rpc check of chain %d ok, got %v", chainId, resp)
+		}
+		for _, event := range watchedEvents {
+			if !got[fmt.Sprintf("cursor/%s/%d", event, chainId)].GetOk() {
+				t.Fatalf("expect cursor check of %s on chain %d ok, got %v", event, chainId, resp)
+			}
+		}
+	}
+	if !got["db//0"].GetOk() || !got["gateway//0"].GetOk() || got[fmt.Sprintf("gas_balance//%d", e2eChainB)] != nil {
+		t.Fatalf("expect db and gateway checks ok and no gas check without floor, got %v", resp)
+	}
+
+	if len(resp.Checks) != 12 {
+		t.Fatalf("expect one rpc check and a cursor check per event per chain, got %v", resp)
+	}
+
+	get := func(h func(http.ResponseWriter, *http.Request, httprouter.Params), expectedCode int) *cbn.ReadinessResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/readyz", nil), nil)
+		if w.Code != expectedCode {
+			t.Fatalf("expect status %d, got %d", expectedCode, w.Code)
+		}
+		resp := &cbn.ReadinessResponse{}
+		if err := protojson.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	env.s.chainMap[e2eChainB].config.MinGasBalance = "1000000000000000000000000000"
+	env.s.readinessCache.ts = time.Now().Add(-readinessCacheTTL)
+	resp = get(env.s.httpReadyz, http.StatusServiceUnavailable)
+	if resp.Ready || checks(resp)[fmt.Sprintf("gas_balance//%d", e2eChainB)].GetOk() || resp.Ha == nil {
+		t.Fatalf("expect gas balance check failed, got %v", resp)
+	}
+
+	// the result is reused within the cache ttl
+	env.s.chainMap[e2eChainB].config.MinGasBalance = ""
+	get(env.s.httpReadyz, http.StatusServiceUnavailable)
+	env.s.readinessCache.ts = time.Now().Add(-readinessCacheTTL)
+	if resp = get(env.s.httpReadyz, http.StatusOK); !resp.Ready {
+		t.Fatalf("expect ready after the cache expired, got %v", resp)
+	}
+}
+
+func TestCursorChecks(t *testing.T) {
+	bc := &bridgeConfig{
+		config:      &cbn.ChainConfig{ChainId: 5, WatchConfig: &cbn.WatchConfig{BlockDelay: 5}},
+		watchClient: newWatchClient(nil),
+	}
+	h := newHealthThresholds(&cbn.HealthConfig{MaxCursorLag: 10})
+	// the watch of transfer in is stuck, the watch of refund has not fetched yet
+	bc.watchClient.scanned[cbridgeABI.Events[evLogTransferOut].ID] = 993
+	bc.watchClient.scanned[cbridgeABI.Events[evLogTransferIn].ID] = 900
+	bc.watchClient.scanned[cbridgeABI.Events[evLogTransferConfirmed].ID] = 995
+	expected := map[string]struct {
+		ok     bool
+		detail string
+	}{
+		evLogTransferOut:       {true, "cursor 993, lag 2 blocks"},
+		evLogTransferIn:        {false, "cursor 900, lag 95 blocks"},
+		evLogTransferConfirmed: {true, "cursor 995, lag 0 blocks"},
+		evLogTransferRefunded:  {false, "no block scanned yet"},
+	}
+	checks := (&server{}).checkCursorsReady(bc, h, true, 1000, true)
+	if len(checks) != len(expected) {
+		t.Fatalf("expect a check per event, got %v", checks)
+	}
+	for _, check := range checks {
+		if e := expected[check.Event]; check.Ok != e.ok || check.Detail != e.detail {
+			t.Errorf("%s: expect ok %t %q, got %t %q", check.Event, e.ok, e.detail, check.Ok, check.Detail)
+		}
+	}
+
+	for _, check := range (&server{}).checkCursorsReady(bc, h, false, 1000, true) {
+		if !check.Ok || !check.Skipped {
+			t.Errorf("expect cursor checks skipped on a standby, got %v", check)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// HMAC signed requests carry these headers. The signature is
+//
+//	hex(hmac_sha256(secret, method + "\n" + request_uri + "\n" + timestamp + "\n" + nonce + "\n" + hex(sha256(body))))
+//
+// where timestamp is the unix seconds also sent in hdrHmacTimestamp, and nonce is a random string sent in
+// hdrHmacNonce, accepted once per key within the skew window.
+const (
+	hdrHmacKeyId     = "X-Cbridge-Key-Id"
+	hdrHmacTimestamp = "X-Cbridge-Timestamp"
+	hdrHmacNonce     = "X-Cbridge-Nonce"
+	hdrHmacSignature = "X-Cbridge-Signature"
+
+	defaultHmacMaxSkew = 300 * time.Second
+	maxHmacNonceLen    = 64
+	maxHttpBodySize    = 1 << 20
+)
+
+type httpAuth struct {
+	cfg     *cbn.HttpConfig
+	tokens  map[string]cbn.HttpRole
+	keys    map[string]*cbn.HttpHmacKey
+	maxSkew time.Duration
+	// loopback clients without credentials get the admin role, only if http_config.trust_loopback is set
+	trustLoopback bool
+
+	nonceLock sync.Mutex
+	nonces    map[string]time.Time // key id + nonce -> when the timestamp of the request is out of the skew window
+	lastPrune time.Time
+}
+
+func newHttpAuth(cfg *cbn.HttpConfig) *httpAuth {
+	a := &httpAuth{
+		cfg:     cfg,
+		tokens:  make(map[string]cbn.HttpRole),
+		keys:    make(map[string]*cbn.HttpHmacKey),
+		maxSkew: defaultHmacMaxSkew,
+		nonces:  make(map[string]time.Time),
+	}
+	for _, t := range cfg.GetTokens() {
+		a.tokens[t.GetToken()] = t.GetRole()
+	}
+	for _, k := range cfg.GetHmacKeys() {
+		a.keys[k.GetKeyId()] = k
+	}
+	if cfg.GetHmacMaxSkew() > 0 {
+		a.maxSkew = time.Duration(cfg.GetHmacMaxSkew()) * time.Second
+	}
+	a.trustLoopback = cfg.GetTrustLoopback()
+	return a
+}
+
+// authenticates returns if any client can get a role above public, otherwise routes requiring one are not served.
+func (a *httpAuth) authenticates() bool {
+	return len(a.tokens) > 0 || len(a.keys) > 0 || a.trustLoopback
+}
+
+// role returns the role of the request, or an error if the request carries invalid credentials.
+func (a *httpAuth) role(r *http.Request) (cbn.HttpRole, error) {
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		if !strings.HasPrefix(authz, "Bearer ") {
+			return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("unsupported authorization scheme")
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(authz, "Bearer "))
+		for t, role := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return role, nil
+			}
+		}
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("invalid token")
+	}
+	if keyId := r.Header.Get(hdrHmacKeyId); keyId != "" {
+		return a.hmacRole(r, keyId)
+	}
+	if a.trustLoopback && isLoopback(r.RemoteAddr) {
+		return cbn.HttpRole_HTTP_ROLE_ADMIN, nil
+	}
+	return cbn.HttpRole_HTTP_ROLE_PUBLIC, nil
+}
+
+func (a *httpAuth) hmacRole(r *http.Request, keyId string) (cbn.HttpRole, error) {
+	key, found := a.keys[keyId]
+	if !found {
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("unknown key id")
+	}
+	tsStr := r.Header.Get(hdrHmacTimestamp)
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("invalid timestamp")
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("timestamp out of range")
+	}
+	nonce := r.Header.Get(hdrHmacNonce)
+	if nonce == "" || len(nonce) > maxHmacNonceLen {
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("invalid nonce")
+	}
+	sig, err := hex.DecodeString(r.Header.Get(hdrHmacSignature))
+	if err != nil {
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("invalid signature")
+	}
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxHttpBodySize))
+		if err != nil {
+			return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("fail to read body")
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if !hmac.Equal(sig, HttpHmacSign([]byte(key.GetSecret()), r.Method, r.URL.RequestURI(), tsStr, nonce, body)) {
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("invalid signature")
+	}
+	if !a.useNonce(keyId, nonce, time.Unix(ts, 0).Add(a.maxSkew)) {
+		return cbn.HttpRole_HTTP_ROLE_PUBLIC, fmt.Errorf("replayed nonce")
+	}
+	return key.GetRole(), nil
+}
+
+// useNonce records the nonce of a signed request until expire, when the request is rejected by its timestamp
+// anyway. It returns false if the nonce is used already.
+func (a *httpAuth) useNonce(keyId, nonce string, expire time.Time) bool {
+	a.nonceLock.Lock()
+	defer a.nonceLock.Unlock()
+	now := time.Now()
+	if now.Sub(a.lastPrune) > a.maxSkew {
+		for k, exp := range a.nonces {
+			if now.After(exp) {
+				delete(a.nonces, k)
+			}
+		}
+		a.lastPrune = now
+	}
+	k := keyId + "\n" + nonce
+	if exp, found := a.nonces[k]; found && !now.After(exp) {
+		return false
+	}
+	a.nonces[k] = expire
+	return true
+}
+
+// HttpHmacSign computes the signature of a request for HMAC authentication.
+func HttpHmacSign(secret []byte, method, requestUri, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + requestUri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// routeRole returns the role required by the route, overridden per route by http_config.route_roles.
+func (a *httpAuth) routeRole(path string, role cbn.HttpRole) cbn.HttpRole {
+	if override, found := a.cfg.GetRouteRoles()[path]; found {
+		return override
+	}
+	return role
+}
+
+// require wraps a route handler with the check of the role required by the route.
+func (a *httpAuth) require(path string, role cbn.HttpRole, h httprouter.Handle) httprouter.Handle {
+	role = a.routeRole(path, role)
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		got, err := a.role(r)
+		if err != nil {
+			httpLog.Warnf("http auth failed, path:%s, remote:%s, err:%v", r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if got < role {
+			if got == cbn.HttpRole_HTTP_ROLE_PUBLIC {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			} else {
+				http.Error(w, "forbidden", http.StatusForbidden)
+			}
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+// cors sets CORS headers for allowed origins and answers preflight requests.
+func (a *httpAuth) cors(h http.Handler) http.Handler {
+	allowAny := false
+	allowed := make(map[string]bool)
+	for _, o := range a.cfg.GetCorsOrigins() {
+		if o == "*" {
+			allowAny = true
+		}
+		allowed[o] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowAny || allowed[origin]) {
+			if allowAny {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join([]string{"Authorization", hdrHmacKeyId, hdrHmacTimestamp, hdrHmacNonce, hdrHmacSignature}, ", "))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ServeHttp serves the http endpoints and blocks. port is used when http_config.listen_addr is not set.
+func (s *server) ServeHttp(port int) error {
+	httpCfg := s.cfg.GetHttpConfig()
+	auth := newHttpAuth(httpCfg)
+	addr := httpCfg.GetListenAddr()
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", port)
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           auth.cors(s.httpRouter(auth)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if httpCfg.GetTlsCert() != "" {
+		httpLog.Infof("serving https on %s", addr)
+		return srv.ListenAndServeTLS(httpCfg.GetTlsCert(), httpCfg.GetTlsKey())
+	}
+	httpLog.Infof("serving http on %s", addr)
+	return srv.ListenAndServe()
+}
+
+// httpRouter returns the router of the http endpoints. Without any credential configured, the routes requiring
+// a role above public are not served.
+func (s *server) httpRouter(auth *httpAuth) *httprouter.Router {
+	if !auth.authenticates() {
+		httpLog.Warnf("no http tokens, hmac keys or trust_loopback configured, operator and admin http routes are not served")
+	}
+	if auth.trustLoopback {
+		httpLog.Warnf("http trust_loopback is set, loopback clients get the admin role without credentials")
+	}
+	router := httprouter.New()
+	handle := func(method, path string, role cbn.HttpRole, h httprouter.Handle) {
+		if !auth.authenticates() && auth.routeRole(path, role) > cbn.HttpRole_HTTP_ROLE_PUBLIC {
+			return
+		}
+		router.Handle(method, path, auth.require(path, role, h))
+	}
+	handle(http.MethodGet, "/v1/summary/total", cbn.HttpRole_HTTP_ROLE_PUBLIC, s.GetTotalSummary)
+	handle(http.MethodGet, "/v1/transfer/:limit", cbn.HttpRole_HTTP_ROLE_OPERATOR, s.GetTransfer)
+	handle(http.MethodPost, "/v1/admin/feerate/:chainid/:feerate", cbn.HttpRole_HTTP_ROLE_ADMIN, s.httpSetFeeRate)
+	handle(http.MethodPost, "/v1/admin/retry/:tid", cbn.HttpRole_HTTP_ROLE_ADMIN, s.httpRetryTransfer)
+	handle(http.MethodPost, "/v1/admin/ping", cbn.HttpRole_HTTP_ROLE_ADMIN, s.httpPingGateway)
+	handle(http.MethodGet, "/v1/ha", cbn.HttpRole_HTTP_ROLE_PUBLIC, s.httpHaStatus)
+	handle(http.MethodGet, "/healthz", cbn.HttpRole_HTTP_ROLE_PUBLIC, s.httpHealthz)
+	handle(http.MethodGet, "/readyz", cbn.HttpRole_HTTP_ROLE_OPERATOR, s.httpReadyz)
+	metricsHandler := prometheus.Handler(metricsRegistry)
+	handle(http.MethodGet, "/metrics", cbn.HttpRole_HTTP_ROLE_OPERATOR, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		metricsHandler.ServeHTTP(w, r)
+	})
+	return router
+}
+
+func (s *server) httpSetFeeRate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	chainId, err := strconv.ParseUint(ps.ByName("chainid"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid chainid param", http.StatusBadRequest)
+		return
+	}
+	feeRate, err := strconv.ParseUint(ps.ByName("feerate"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid feerate param", http.StatusBadRequest)
+		return
+	}
+	resp, _ := (&adminService{s: s}).SetFeeRate(r.Context(), &cbn.SetFeeRateRequest{ChainId: chainId, FeeRate: feeRate})
+	writeProtoJson(w, resp)
+}
+
+func (s *server) httpRetryTransfer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	resp, _ := (&adminService{s: s}).RetryTransfer(r.Context(), &cbn.RetryTransferRequest{TransferId: ps.ByName("tid")})
+	writeProtoJson(w, resp)
+}
+
+func (s *server) httpPingGateway(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	resp, _ := (&adminService{s: s}).PingGateway(r.Context(), &cbn.PingGatewayRequest{})
+	writeProtoJson(w, resp)
+}
+
+// httpHaStatus returns the ha role of the node, e.g. for a load balancer to route admin requests to the leader.
+func (s *server) httpHaStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeProtoJson(w, s.haStatus())
+}
+
+func writeProtoJson(w http.ResponseWriter, m proto.Message) {
+	out, err := protojson.Marshal(m)
+	if err != nil {
+		http.Error(w, "marshal response failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(out)
+	if err != nil {
+		httpLog.Errorf("write response err: %v", err)
+	}
+}
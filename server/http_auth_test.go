@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+func TestHttpAuthRole(t *testing.T) {
+	a := newHttpAuth(&cbn.HttpConfig{
+		Tokens: []*cbn.HttpToken{
+			{Token: "op-token", Role: cbn.HttpRole_HTTP_ROLE_OPERATOR},
+			{Token: "admin-token", Role: cbn.HttpRole_HTTP_ROLE_ADMIN},
+		},
+	})
+	tests := []struct {
+		authz  string
+		remote string
+		role   cbn.HttpRole
+		fail   bool
+	}{
+		{"", "10.0.0.1:1234", cbn.HttpRole_HTTP_ROLE_PUBLIC, false},
+		{"Bearer op-token", "10.0.0.1:1234", cbn.HttpRole_HTTP_ROLE_OPERATOR, false},
+		{"Bearer admin-token", "10.0.0.1:1234", cbn.HttpRole_HTTP_ROLE_ADMIN, false},
+		{"Bearer wrong", "10.0.0.1:1234", cbn.HttpRole_HTTP_ROLE_PUBLIC, true},
+		{"Basic b3A6dG9rZW4=", "10.0.0.1:1234", cbn.HttpRole_HTTP_ROLE_PUBLIC, true},
+		// loopback clients are not trusted without trust_loopback
+		{"", "127.0.0.1:1234", cbn.HttpRole_HTTP_ROLE_PUBLIC, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/transfer/10", nil)
+		r.RemoteAddr = test.remote
+		if test.authz != "" {
+			r.Header.Set("Authorization", test.authz)
+		}
+		role, err := a.role(r)
+		if role != test.role || (err != nil) != test.fail {
+			t.Errorf("authorization %q from %s: expect role %s fail %t, got %s err:%v", test.authz, test.remote, test.role, test.fail, role, err)
+		}
+	}
+}
+
+func TestHttpAuthLoopback(t *testing.T) {
+	a := newHttpAuth(&cbn.HttpConfig{TrustLoopback: true})
+	for remote, expected := range map[string]cbn.HttpRole{
+		"127.0.0.1:1234": cbn.HttpRole_HTTP_ROLE_ADMIN,
+		"[::1]:1234":     cbn.HttpRole_HTTP_ROLE_ADMIN,
+		"10.0.0.1:1234":  cbn.HttpRole_HTTP_ROLE_PUBLIC,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/transfer/10", nil)
+		r.RemoteAddr = remote
+		if role, err := a.role(r); err != nil || role != expected {
+			t.Errorf("client %s: expect role %s, got %s err:%v", remote, expected, role, err)
+		}
+	}
+}
+
+func TestHttpAuthHmac(t *testing.T) {
+	secret := "secret"
+	a := newHttpAuth(&cbn.HttpConfig{
+		HmacKeys:    []*cbn.HttpHmacKey{{KeyId: "ops", Secret: secret, Role: cbn.HttpRole_HTTP_ROLE_ADMIN}},
+		HmacMaxSkew: 60,
+	})
+	body := `{"a":1}`
+	request := func(keyId string, ts time.Time, nonce, sigSecret string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/admin/ping?x=1", strings.NewReader(body))
+		tsStr := strconv.FormatInt(ts.Unix(), 10)
+		r.Header.Set(hdrHmacKeyId, keyId)
+		r.Header.Set(hdrHmacTimestamp, tsStr)
+		r.Header.Set(hdrHmacNonce, nonce)
+		r.Header.Set(hdrHmacSignature, hex.EncodeToString(HttpHmacSign([]byte(sigSecret), http.MethodPost, "/v1/admin/ping?x=1", tsStr, nonce, []byte(body))))
+		return r
+	}
+	now := time.Now()
+	tests := []struct {
+		desc string
+		r    *http.Request
+		ok   bool
+	}{
+		{"valid", request("ops", now, "n1", secret), true},
+		{"replayed nonce", request("ops", now, "n1", secret), false},
+		{"another nonce", request("ops", now.Add(-50*time.Second), "n2", secret), true},
+		{"within skew ahead", request("ops", now.Add(50*time.Second), "n3", secret), true},
+		{"past skew", request("ops", now.Add(-70*time.Second), "n4", secret), false},
+		{"ahead of skew", request("ops", now.Add(70*time.Second), "n5", secret), false},
+		{"wrong secret", request("ops", now, "n6", "wrong"), false},
+		{"unknown key", request("dev", now, "n7", secret), false},
+		{"no nonce", request("ops", now, "", secret), false},
+		{"long nonce", request("ops", now, strings.Repeat("n", maxHmacNonceLen+1), secret), false},
+	}
+	for _, test := range tests {
+		role, err := a.role(test.r)
+		if test.ok && (err != nil || role != cbn.HttpRole_HTTP_ROLE_ADMIN) {
+			t.Errorf("%s: expect admin role, got %s err:%v", test.desc, role, err)
+		}
+		if !test.ok && (err == nil || role != cbn.HttpRole_HTTP_ROLE_PUBLIC) {
+			t.Errorf("%s: expect rejected, got %s", test.desc, role)
+		}
+	}
+
+	// a tampered body fails the signature
+	r := request("ops", now, "n8", secret)
+	r.Body = http.NoBody
+	if _, err := a.role(r); err == nil {
+		t.Error("expect tampered body rejected")
+	}
+
+	// a nonce is accepted again once its request is out of the skew window
+	a.nonces["ops\nold"] = now.Add(-time.Second)
+	a.lastPrune = time.Time{}
+	if _, err := a.role(request("ops", now, "n9", secret)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := a.nonces["ops\nold"]; found {
+		t.Error("expect expired nonce pruned")
+	}
+}
+
+func TestHttpRouterWithoutCredentials(t *testing.T) {
+	s := NewServer("test")
+	get := func(auth *httpAuth, method, path, remote string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = remote
+		s.httpRouter(auth).ServeHTTP(w, r)
+		return w.Code
+	}
+	// operator and admin routes are not served, even to loopback clients
+	auth := newHttpAuth(&cbn.HttpConfig{})
+	if code := get(auth, http.MethodPost, "/v1/admin/ping", "127.0.0.1:1234"); code != http.StatusNotFound {
+		t.Fatalf("expect admin route not served, got %d", code)
+	}
+	if code := get(auth, http.MethodGet, "/v1/transfer/10", "127.0.0.1:1234"); code != http.StatusNotFound {
+		t.Fatalf("expect operator route not served, got %d", code)
+	}
+	if code := get(auth, http.MethodGet, "/metrics", "127.0.0.1:1234"); code != http.StatusNotFound {
+		t.Fatalf("expect metrics not served, got %d", code)
+	}
+	if code := get(auth, http.MethodGet, "/healthz", "10.0.0.1:1234"); code != http.StatusOK {
+		t.Fatalf("expect public route served, got %d", code)
+	}
+
+	// served but denied to other clients with trust_loopback
+	auth = newHttpAuth(&cbn.HttpConfig{TrustLoopback: true})
+	if code := get(auth, http.MethodGet, "/v1/transfer/10", "10.0.0.1:1234"); code != http.StatusUnauthorized {
+		t.Fatalf("expect operator route unauthorized, got %d", code)
+	}
+	if code := get(auth, http.MethodGet, "/metrics", "127.0.0.1:1234"); code != http.StatusOK {
+		t.Fatalf("expect metrics served to loopback, got %d", code)
+	}
+}
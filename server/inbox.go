@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	defaultInboxMaxAttempts   = 10
+	defaultInboxRetryBackoff  = 5 * time.Second
+	maxInboxRetryBackoff      = 10 * time.Minute
+	defaultInboxRetentionDays = 7
+
+	inboxScanInterval    = 10 * time.Second
+	inboxCleanupInterval = time.Hour
+	inboxBatchSize       = 100
+)
+
+var (
+	inboxProcessedEvents   = newCounter("cbridge/inbox/processed_events")
+	inboxFailedAttempts    = newCounter("cbridge/inbox/failed_attempts")
+	inboxQuarantinedEvents = newCounter("cbridge/inbox/quarantined_events")
+)
+
+// poisonEventError means the event can never be processed, e.g. it cannot be parsed or refers to a token
+// not in the config, so it is quarantined without retries.
+type poisonEventError struct {
+	msg string
+}
+
+func newPoisonEventErr(format string, args ...interface{}) error {
+	return &poisonEventError{msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *poisonEventError) Error() string {
+	return e.msg
+}
+
+// eventInbox holds the contract events seen by the monitors until they are processed. The monitor callbacks
+// only store the events, so an event failing to process is retried from the db instead of being lost or
+// blocking the monitor.
+type eventInbox struct {
+	maxAttempts   uint64
+	retryBackoff  time.Duration
+	processedHold time.Duration
+	// has a value when new events are stored
+	wake chan struct{}
+}
+
+func newEventInbox(cfg *cbn.EventInboxConfig) *eventInbox {
+	b := &eventInbox{
+		maxAttempts:   defaultInboxMaxAttempts,
+		retryBackoff:  defaultInboxRetryBackoff,
+		processedHold: defaultInboxRetentionDays * 24 * time.Hour,
+		wake:          make(chan struct{}, 1),
+	}
+	if cfg.GetMaxAttempts() > 0 {
+		b.maxAttempts = cfg.GetMaxAttempts()
+	}
+	if cfg.GetRetryBackoff() > 0 {
+		b.retryBackoff = time.Duration(cfg.GetRetryBackoff()) * time.Second
+	}
+	if cfg.GetProcessedRetentionDays() > 0 {
+		b.processedHold = time.Duration(cfg.GetProcessedRetentionDays()) * 24 * time.Hour
+	}
+	return b
+}
+
+func (b *eventInbox) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// backoff returns the wait before the next attempt after the given number of failed attempts.
+func (b *eventInbox) backoff(attempts uint64) time.Duration {
+	d := b.retryBackoff
+	for i := uint64(1); i < attempts && d < maxInboxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxInboxRetryBackoff {
+		d = maxInboxRetryBackoff
+	}
+	return d
+}
+
+// storeInboxEvent is the monitor callback body, it returns true for the monitor to retry the event if it
+// cannot be stored.
+func (s *server) storeInboxEvent(bc *bridgeConfig, name string, eLog ethtypes.Log) bool {
+	stored, err := s.db.InsertInboxEvent(&InboxEvent{ChainId: bc.chainId.Uint64(), EventName: name, Log: eLog})
+	if err != nil {
+		monitorLog.with("chain_id", bc.chainId.Uint64(), "tx_hash", eLog.TxHash).Errorf("fail to store %s event in inbox, err:%v", name, err)
+		return true
+	}
+	if stored {
+		s.inbox.notify()
+	}
+	return false
+}
+
+func (s *server) ProcessEventInbox() {
+	ticker := time.NewTicker(inboxScanInterval)
+	defer ticker.Stop()
+	lastCleanup := time.Now()
+	s.processEventInbox()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-s.inbox.wake:
+		case <-ticker.C:
+		}
+		s.processEventInbox()
+		if time.Since(lastCleanup) > inboxCleanupInterval {
+			s.cleanupEventInbox()
+			lastCleanup = time.Now()
+		}
+	}
+}
+
+// processEventInbox handles the due pending events until none is left. The pass stops if the result of an
+// event cannot be saved, the event would be due again and fetched over and over.
+func (s *server) processEventInbox() {
+	for {
+		events, err := s.db.GetDueInboxEvents(inboxBatchSize)
+		if err != nil {
+			monitorLog.Errorf("fail to get inbox events, err:%v", err)
+			return
+		}
+		for _, e := range events {
+			if err = s.processInboxEvent(e); err != nil {
+				return
+			}
+		}
+		if len(events) < inboxBatchSize {
+			return
+		}
+	}
+}
+
+// processInboxEvent handles the event and saves the result, the error is of the save.
+func (s *server) processInboxEvent(e *InboxEvent) error {
+	lg := monitorLog.with("chain_id", e.ChainId, "tx_hash", e.Log.TxHash)
+	err := s.handleInboxEvent(e)
+	if err == nil {
+		e.Status = cbn.InboxEventStatus_INBOX_EVENT_STATUS_PROCESSED
+		e.LastError = ""
+		inboxProcessedEvents.Inc(1)
+	} else {
+		e.Attempts++
+		e.LastError = err.Error()
+		inboxFailedAttempts.Inc(1)
+		_, poison := err.(*poisonEventError)
+		if poison || e.Attempts >= s.inbox.maxAttempts {
+			e.Status = cbn.InboxEventStatus_INBOX_EVENT_STATUS_QUARANTINED
+			inboxQuarantinedEvents.Inc(1)
+			lg.Errorf("quarantine inbox event, event:%s, logIndex:%d, attempts:%d, err:%v", e.EventName, e.Log.Index, e.Attempts, err)
+		} else {
+			e.NextTs = time.Now().Add(s.inbox.backoff(e.Attempts))
+			lg.Warnf("fail to process inbox event, will retry, event:%s, logIndex:%d, attempts:%d, err:%v",
+				e.EventName, e.Log.Index, e.Attempts, err)
+		}
+	}
+	if dbErr := s.db.UpdateInboxEvent(e); dbErr != nil {
+		lg.Errorf("fail to update inbox event, logIndex:%d, err:%v", e.Log.Index, dbErr)
+		return dbErr
+	}
+	return nil
+}
+
+func (s *server) handleInboxEvent(e *InboxEvent) error {
+	bc, found := s.chainMap[e.ChainId]
+	if !found {
+		return newPoisonEventErr("chain %d not configured", e.ChainId)
+	}
+	switch e.EventName {
+	case evLogTransferOut:
+		return s.handleLogTransferOut(bc, e.Log)
+	case evLogTransferIn:
+		return s.handleLogTransferIn(bc, e.Log)
+	case evLogTransferConfirmed:
+		return s.handleLogConfirm(bc, e.Log)
+	case evLogTransferRefunded:
+		return s.handleLogRefund(bc, e.Log)
+	}
+	return newPoisonEventErr("unknown event %s", e.EventName)
+}
+
+// reprocessInboxEvents sets the quarantined events pending again, optionally only of the chain or tx.
+func (s *server) reprocessInboxEvents(chainId uint64, txHash string) (uint64, error) {
+	requeued, err := s.db.RequeueInboxEvents(chainId, txHash)
+	if err != nil {
+		return 0, err
+	}
+	if requeued > 0 {
+		monitorLog.with("chain_id", chainId, "tx_hash", txHash).Infof("requeued %d quarantined inbox events", requeued)
+		s.inbox.notify()
+	}
+	return requeued, nil
+}
+
+func (s *server) cleanupEventInbox() {
+	deleted, err := s.db.DeleteProcessedInboxEvents(time.Now().Add(-s.inbox.processedHold))
+	if err != nil {
+		monitorLog.Errorf("fail to delete processed inbox events, err:%v", err)
+		return
+	}
+	if deleted > 0 {
+		monitorLog.Infof("deleted %d processed inbox events", deleted)
+	}
+}
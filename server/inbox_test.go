@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+func TestEventInboxBackoff(t *testing.T) {
+	b := newEventInbox(&cbn.EventInboxConfig{RetryBackoff: 5})
+	for attempts, expected := range map[uint64]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		4:  40 * time.Second,
+		8:  maxInboxRetryBackoff,
+		64: maxInboxRetryBackoff,
+	} {
+		if got := b.backoff(attempts); got != expected {
+			t.Errorf("attempts %d: expect backoff %s, got %s", attempts, expected, got)
+		}
+	}
+}
+
+func TestProcessEventInbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cbridge-inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewSqliteDAL(filepath.Join(dir, "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := NewServer("test")
+	s.db = store
+	s.inbox = newEventInbox(nil)
+
+	// events of a chain not in the config can never be processed
+	e := newTestInboxEvent(7, 0x70, 0)
+	if _, err = store.InsertInboxEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	s.processEventInbox()
+	events, err := store.GetInboxEvents(10, cbn.InboxEventStatus_INBOX_EVENT_STATUS_QUARANTINED, 7)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expect event quarantined, got %+v, err:%v", events, err)
+	}
+	if events[0].Attempts != 1 || !strings.Contains(events[0].LastError, "chain 7 not configured") {
+		t.Fatalf("unexpected quarantined event %+v", events[0])
+	}
+
+	requeued, err := s.reprocessInboxEvents(7, "")
+	if err != nil || requeued != 1 {
+		t.Fatalf("expect 1 requeued, got %d, err:%v", requeued, err)
+	}
+	select {
+	case <-s.inbox.wake:
+	default:
+		t.Fatal("expect inbox woken by reprocess")
+	}
+	if due, err := store.GetDueInboxEvents(10); err != nil || len(due) != 1 {
+		t.Fatalf("expect requeued event due, got %d, err:%v", len(due), err)
+	}
+}
+
+// failingInboxStore fails to save the inbox events.
+type failingInboxStore struct {
+	TransferStore
+	updates int
+}
+
+func (f *failingInboxStore) UpdateInboxEvent(e *InboxEvent) error {
+	f.updates++
+	return errors.New("db down")
+}
+
+func TestProcessEventInboxUpdateFail(t *testing.T) {
+	store, err := NewSqliteDAL(filepath.Join(t.TempDir(), "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	failing := &failingInboxStore{TransferStore: store}
+	s := NewServer("test")
+	s.db = failing
+	s.inbox = newEventInbox(nil)
+	// a full batch, the pass would fetch the same events again
+	for i := 0; i < inboxBatchSize; i++ {
+		if _, err = store.InsertInboxEvent(newTestInboxEvent(7, 0x71, uint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.processEventInbox()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the pass to stop on the update error")
+	}
+	if failing.updates != 1 {
+		t.Fatalf("expect 1 update tried, got %d", failing.updates)
+	}
+}
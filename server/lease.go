@@ -0,0 +1,224 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/log"
+)
+
+const (
+	leaderLeaseName = "relay"
+	defaultLeaseTtl = 15 * time.Second
+)
+
+var (
+	errNotLeader = errors.New("not the leader")
+
+	haLeader = newGauge("cbridge/ha/leader")
+)
+
+// haExit stops the node when it loses the leader lease or fails to start as the leader, tests replace it.
+// The jobs of the leader can't be stopped safely in the middle of sending txs, so the node exits and its
+// supervisor restarts it as a standby.
+var haExit = func(format string, args ...interface{}) {
+	log.Fatalf(format, args...)
+}
+
+// leaderLease is the lease in the db making the node the leader of the nodes sharing the db when
+// ha_config is enabled. Only the leader runs the monitors, the jobs and the gateway ping.
+type leaderLease struct {
+	db            TransferStore
+	holder        string // unique per process, a restarted node gets a new fencing token
+	ttl           time.Duration
+	renewInterval time.Duration
+
+	lock   sync.Mutex
+	token  uint64    // fencing token of the held lease, 0 if not held
+	expire time.Time // local deadline of the held lease, from before the last renewal
+}
+
+func newLeaderLease(cfg *cbn.HaConfig, db TransferStore) *leaderLease {
+	nodeId := cfg.GetNodeId()
+	if nodeId == "" {
+		nodeId, _ = os.Hostname()
+	}
+	l := &leaderLease{
+		db:     db,
+		holder: fmt.Sprintf("%s-%d-%d", nodeId, os.Getpid(), time.Now().Unix()),
+		ttl:    defaultLeaseTtl,
+	}
+	if cfg.GetLeaseTtl() > 0 {
+		l.ttl = time.Duration(cfg.GetLeaseTtl()) * time.Second
+	}
+	l.renewInterval = l.ttl / 3
+	if cfg.GetRenewInterval() > 0 {
+		l.renewInterval = time.Duration(cfg.GetRenewInterval()) * time.Second
+	}
+	if l.renewInterval >= l.ttl {
+		log.Warnf("ha renew interval %s not shorter than the lease ttl %s, use ttl/3", l.renewInterval, l.ttl)
+		l.renewInterval = l.ttl / 3
+	}
+	return l
+}
+
+// renew acquires or renews the lease. A db error keeps the lease held until its local deadline.
+func (l *leaderLease) renew() error {
+	start := time.Now()
+	token, acquired, err := l.db.AcquireLeaderLease(leaderLeaseName, l.holder, l.ttl)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !acquired {
+		l.token = 0
+		return nil
+	}
+	l.token = token
+	l.expire = start.Add(l.ttl)
+	return nil
+}
+
+// fencingToken returns the token of the held lease, 0 if the lease is not held.
+func (l *leaderLease) fencingToken() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.token == 0 || !time.Now().Before(l.expire) {
+		return 0
+	}
+	return l.token
+}
+
+func (l *leaderLease) isLeader() bool {
+	return l.fencingToken() != 0
+}
+
+// check returns errNotLeader unless the lease is held in the db with the token of the node, so a deposed
+// leader whose jobs still run can't send txs. A nil lease, ha disabled, always passes.
+func (l *leaderLease) check() error {
+	if l == nil {
+		return nil
+	}
+	token := l.fencingToken()
+	if token == 0 {
+		return errNotLeader
+	}
+	held, err := l.db.CheckLeaderLease(leaderLeaseName, l.holder, token)
+	if err != nil {
+		return fmt.Errorf("fail to check leader lease: %w", err)
+	}
+	if !held {
+		return errNotLeader
+	}
+	return nil
+}
+
+// release lets a standby acquire the lease right away, instead of after the ttl.
+func (l *leaderLease) release() {
+	l.lock.Lock()
+	l.token = 0
+	l.lock.Unlock()
+	if err := l.db.ReleaseLeaderLease(leaderLeaseName, l.holder); err != nil {
+		log.Errorf("fail to release leader lease, err:%v", err)
+	}
+}
+
+// RunJobs starts the jobs of the node. With ha_config enabled, the node starts as a standby and runs the
+// monitors and the jobs once it acquires the leader lease.
+func (s *server) RunJobs() {
+	if s.lease == nil {
+		haLeader.Update(1)
+		s.startJobs()
+		return
+	}
+	go s.runLeaderElection()
+}
+
+func (s *server) startJobs() {
+	go s.PingCron()
+	go s.ProcessSendTransfer()
+	go s.ProcessConfirmTransfer()
+	go s.ProcessRefundTransferIn()
+	go s.ProcessRecoverTimeoutPendingTransfer()
+	go s.ProcessTimelockWatchdog()
+	go s.ProcessRebalance()
+	go s.ProcessArchiveTransfers()
+	go s.ProcessEventInbox()
+}
+
+// runLeaderElection renews the lease every renew interval. It starts the leader when the lease is acquired,
+// and exits the node when the lease is lost.
+func (s *server) runLeaderElection() {
+	ticker := time.NewTicker(s.lease.renewInterval)
+	defer ticker.Stop()
+	leading := false
+	for {
+		if err := s.lease.renew(); err != nil {
+			log.Errorf("fail to renew leader lease, holder:%s, err:%v", s.lease.holder, err)
+		}
+		if s.lease.isLeader() {
+			if !leading {
+				leading = true
+				haLeader.Update(1)
+				log.Infof("acquired leader lease, holder:%s, token:%d", s.lease.holder, s.lease.fencingToken())
+				s.notifier.Notify(&Notification{
+					Event:    cbn.NotifyEvent_NOTIFY_EVENT_LEADER_CHANGE,
+					Key:      s.lease.holder,
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf("%s is the leader now", s.lease.holder),
+					Fields:   map[string]string{"holder": s.lease.holder},
+				})
+				if err := s.startLeading(); err != nil {
+					s.lease.release()
+					haExit("fail to start as leader, err:%v", err)
+					return
+				}
+				s.startJobs()
+			}
+		} else if leading {
+			haLeader.Update(0)
+			haExit("lost leader lease, holder:%s", s.lease.holder)
+			return
+		}
+		select {
+		case <-s.quit:
+			if leading {
+				s.lease.release()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkLeader returns an error for admin calls changing the node state on a standby.
+func (s *server) checkLeader() *cbn.CbridgeNodeError {
+	if s.lease == nil || s.lease.isLeader() {
+		return nil
+	}
+	return newNodeError(cbn.ErrorCode_FAILED_PRECONDITION, "this node is a standby, send the request to the leader")
+}
+
+func (s *server) haStatus() *cbn.HaStatus {
+	status := &cbn.HaStatus{Role: cbn.HaRole_HA_ROLE_LEADER}
+	if s.lease == nil {
+		return status
+	}
+	status.Holder = s.lease.holder
+	status.FencingToken = s.lease.fencingToken()
+	if status.FencingToken == 0 {
+		status.Role = cbn.HaRole_HA_ROLE_STANDBY
+	}
+	lease, found, err := s.db.GetLeaderLease(leaderLeaseName)
+	if err != nil {
+		log.Errorf("fail to get leader lease, err:%v", err)
+	} else if found && lease.ExpireTs.After(time.Now()) {
+		status.Leader = lease.Holder
+	}
+	return status
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+func TestLeaderLeaseFailover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cbridge-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewSqliteDAL(filepath.Join(dir, "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cfg := &cbn.HaConfig{Enabled: true, LeaseTtl: 1}
+	a, b := newLeaderLease(cfg, store), newLeaderLease(&cbn.HaConfig{Enabled: true, NodeId: "b", LeaseTtl: 1}, store)
+	if a.holder == b.holder || a.renewInterval != time.Second/3 {
+		t.Fatalf("unexpected lease config, holders %s %s, renew interval %s", a.holder, b.holder, a.renewInterval)
+	}
+	var nilLease *leaderLease
+	if err = nilLease.check(); err != nil {
+		t.Fatalf("expect no fencing with ha disabled, err:%v", err)
+	}
+
+	for _, l := range []*leaderLease{a, b} {
+		if err = l.renew(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !a.isLeader() || b.isLeader() {
+		t.Fatalf("expect a leader and b standby, got %t %t", a.isLeader(), b.isLeader())
+	}
+	if err = a.check(); err != nil {
+		t.Fatalf("expect leader a to pass the fencing check, err:%v", err)
+	}
+	if err = b.check(); !errors.Is(err, errNotLeader) {
+		t.Fatalf("expect standby b fenced off, err:%v", err)
+	}
+
+	// a stops renewing, e.g. stuck or partitioned from the db
+	time.Sleep(1100 * time.Millisecond)
+	if a.isLeader() {
+		t.Fatal("expect a to give up leadership after the ttl")
+	}
+	if err = b.renew(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.isLeader() || b.fencingToken() != 2 {
+		t.Fatalf("expect b to take over with token 2, got %t %d", b.isLeader(), b.fencingToken())
+	}
+	// a deposed leader still holding its token locally is fenced off by the db
+	a.lock.Lock()
+	a.expire = time.Now().Add(time.Minute)
+	a.lock.Unlock()
+	if err = a.check(); !errors.Is(err, errNotLeader) {
+		t.Fatalf("expect deposed a fenced off, err:%v", err)
+	}
+
+	b.release()
+	if err = a.renew(); err != nil {
+		t.Fatal(err)
+	}
+	if !a.isLeader() || a.fencingToken() != 3 {
+		t.Fatalf("expect a to acquire the released lease with token 3, got %t %d", a.isLeader(), a.fencingToken())
+	}
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/log"
+)
+
+// subsystem loggers, log_config.levels sets their levels by name
+var (
+	monitorLog   = newLogger("monitor")
+	senderLog    = newLogger("sender")
+	confirmerLog = newLogger("confirmer")
+	refunderLog  = newLogger("refunder")
+	gatewayLog   = newLogger("gateway")
+	rebalanceLog = newLogger("rebalance")
+	notifyLog    = newLogger("notify")
+	retentionLog = newLogger("retention")
+	httpLog      = newLogger("http")
+)
+
+// level of a subsystem following the goutils log level, set by the -loglevel flag
+const inheritLogLevel = -1
+
+var (
+	subsystemLevels = make(map[string]*int32)
+
+	// all log lines are written here, the goutils log lines too in json format
+	logSink = &logWriter{out: os.Stderr}
+
+	// a goutils log line, e.g. "2021-07-01 10:00:00.000 |INFO | server.go:123: message"
+	goutilsLogLine = regexp.MustCompile(`(?s)^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d{3}) \|(\w+) ?\| ([^ ]+:\d+): (.*?)\n?$`)
+)
+
+type logWriter struct {
+	lock sync.Mutex
+	out  io.Writer
+	json bool
+}
+
+func (w *logWriter) write(b []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, _ = w.out.Write(b)
+}
+
+func (w *logWriter) isJson() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.json
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// logger writes the lines of a subsystem with fields, as "key=value" after the message in text format, or
+// as keys of the json object in json format. Loggers with more fields are derived with with and transfer.
+type logger struct {
+	subsystem string
+	level     *int32
+	fields    []logField
+}
+
+func newLogger(subsystem string) *logger {
+	level := int32(inheritLogLevel)
+	subsystemLevels[subsystem] = &level
+	return &logger{subsystem: subsystem, level: &level}
+}
+
+// SetupLogging applies log_config, it should be called once before the node starts.
+func SetupLogging(cfg *cbn.LogConfig) error {
+	for subsystem, name := range cfg.GetLevels() {
+		level, found := subsystemLevels[subsystem]
+		if !found {
+			return fmt.Errorf("unknown log subsystem %s", subsystem)
+		}
+		parsed, err := parseLogLevel(name)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt32(level, int32(parsed))
+	}
+	if cfg.GetFormat() == cbn.LogFormat_LOG_FORMAT_JSON {
+		logSink.lock.Lock()
+		logSink.json = true
+		logSink.lock.Unlock()
+		log.SetOutput(goutilsJsonWriter{})
+	}
+	return nil
+}
+
+func parseLogLevel(name string) (log.Level, error) {
+	switch strings.ToLower(name) {
+	case "trace":
+		return log.TraceLevel, nil
+	case "debug":
+		return log.DebugLevel, nil
+	case "info":
+		return log.InfoLevel, nil
+	case "warn":
+		return log.WarnLevel, nil
+	case "error":
+		return log.ErrorLevel, nil
+	}
+	return 0, fmt.Errorf("invalid log level %s", name)
+}
+
+// with returns a logger with the key value pairs added to the fields, replacing the values of existing keys.
+func (l *logger) with(kvs ...interface{}) *logger {
+	fields := make([]logField, len(l.fields), len(l.fields)+len(kvs)/2)
+	copy(fields, l.fields)
+next:
+	for i := 0; i+1 < len(kvs); i += 2 {
+		f := logField{key: fmt.Sprint(kvs[i]), value: logValue(kvs[i+1])}
+		for j := range fields {
+			if fields[j].key == f.key {
+				fields[j] = f
+				continue next
+			}
+		}
+		fields = append(fields, f)
+	}
+	return &logger{subsystem: l.subsystem, level: l.level, fields: fields}
+}
+
+// transfer returns a logger with the fields every line about the transfer has.
+func (l *logger) transfer(tx *Transfer) *logger {
+	return l.with("transfer_id", tx.TransferId, "related_tid", tx.RelatedTid, "chain_id", tx.ChainId,
+		"direction", transferDirection(tx.TransferType), "status", tx.Status)
+}
+
+// rebalance returns a logger with the transfer fields of the rebalance transfer out.
+func (l *logger) rebalance(r *Rebalance) *logger {
+	return l.with("transfer_id", r.TransferId, "related_tid", r.DstTransferId, "chain_id", r.SrcChainId, "direction", "out",
+		"status", r.Status)
+}
+
+func transferDirection(t cbn.TransferType) string {
+	switch t {
+	case cbn.TransferType_TRANSFER_TYPE_OUT:
+		return "out"
+	case cbn.TransferType_TRANSFER_TYPE_IN:
+		return "in"
+	}
+	return ""
+}
+
+// logValue formats ids, addresses and amounts the same way in every line.
+func logValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case Hash:
+		return value.Hex()
+	case [32]byte:
+		return Hash(value).Hex()
+	case Addr:
+		return value.Hex()
+	case *big.Int:
+		if value == nil {
+			return "0"
+		}
+		return value.String()
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	}
+	return v
+}
+
+func (l *logger) enabled(level log.Level) bool {
+	subsystemLevel := atomic.LoadInt32(l.level)
+	if subsystemLevel == inheritLogLevel {
+		return log.IsLevelEnabled(level)
+	}
+	return level >= log.Level(subsystemLevel)
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.output(log.DebugLevel, format, args...)
+}
+
+func (l *logger) Infof(format string, args ...interface{}) {
+	l.output(log.InfoLevel, format, args...)
+}
+
+func (l *logger) Warnf(format string, args ...interface{}) {
+	l.output(log.WarnLevel, format, args...)
+}
+
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.output(log.ErrorLevel, format, args...)
+}
+
+func (l *logger) output(level log.Level, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	// the caller of Infof etc.
+	_, file, line, ok := runtime.Caller(2)
+	caller := "???:0"
+	if ok {
+		caller = file[strings.LastIndex(file, "/")+1:] + ":" + strconv.Itoa(line)
+	}
+	msg := fmt.Sprintf(format, args...)
+	if logSink.isJson() {
+		name := strings.ToLower(strings.Trim(logLevelTags[level], "| "))
+		logSink.write(jsonLogObject(time.Now(), name, l.subsystem, caller, msg, l.fields))
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(time.Now().UTC().Format("2006-01-02 15:04:05.000 "))
+	buf.WriteString(logLevelTags[level])
+	buf.WriteString(caller)
+	buf.WriteString(": [")
+	buf.WriteString(l.subsystem)
+	buf.WriteString("] ")
+	buf.WriteString(msg)
+	for _, f := range l.fields {
+		value := fmt.Sprint(f.value)
+		if value == "" || strings.ContainsAny(value, " =\"") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(" " + f.key + "=" + value)
+	}
+	buf.WriteByte('\n')
+	logSink.write(buf.Bytes())
+}
+
+// same as goutils log
+var logLevelTags = map[log.Level]string{
+	log.TraceLevel: "|TRACE| ",
+	log.DebugLevel: "|DEBUG| ",
+	log.InfoLevel:  "|INFO | ",
+	log.WarnLevel:  "|WARN | ",
+	log.ErrorLevel: "|ERROR| ",
+	log.FatalLevel: "|FATAL| ",
+	log.PanicLevel: "|PANIC| ",
+}
+
+func jsonLogObject(ts time.Time, level, subsystem, caller, msg string, fields []logField) []byte {
+	var buf bytes.Buffer
+	writeField := func(key string, value interface{}) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('{')
+	writeField("ts", ts.UTC().Format(time.RFC3339Nano))
+	writeField("level", level)
+	if subsystem != "" {
+		writeField("subsystem", subsystem)
+	}
+	writeField("caller", caller)
+	writeField("msg", msg)
+	for _, f := range fields {
+		writeField(f.key, f.value)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// goutilsJsonWriter turns the lines of goutils log, of the node and its libraries, into json lines.
+type goutilsJsonWriter struct{}
+
+func (goutilsJsonWriter) Write(p []byte) (int, error) {
+	m := goutilsLogLine.FindSubmatch(p)
+	if m == nil {
+		logSink.write(jsonLogObject(time.Now(), "info", "", "", strings.TrimSuffix(string(p), "\n"), nil))
+		return len(p), nil
+	}
+	ts, err := time.Parse("2006-01-02 15:04:05.000", string(m[1]))
+	if err != nil {
+		ts = time.Now()
+	}
+	logSink.write(jsonLogObject(ts, strings.ToLower(string(m[2])), "", string(m[3]), string(m[4]), nil))
+	return len(p), nil
+}
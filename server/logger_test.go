@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+// captureLogs writes the lines of the subsystem loggers to the returned buffer until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logSink.lock.Lock()
+	out := logSink.out
+	logSink.out = &buf
+	logSink.lock.Unlock()
+	t.Cleanup(func() {
+		logSink.lock.Lock()
+		logSink.out = out
+		logSink.lock.Unlock()
+	})
+	return &buf
+}
+
+func TestLoggerFields(t *testing.T) {
+	var buf bytes.Buffer
+	logSink.lock.Lock()
+	out := logSink.out
+	logSink.out = &buf
+	logSink.lock.Unlock()
+	defer func() {
+		logSink.lock.Lock()
+		logSink.out, logSink.json = out, false
+		logSink.lock.Unlock()
+	}()
+
+	lg := newLogger("test")
+	if err := SetupLogging(&cbn.LogConfig{Levels: map[string]string{"test": "warn"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetupLogging(&cbn.LogConfig{Levels: map[string]string{"unknown": "warn"}}); err == nil {
+		t.Fatal("expect error for unknown subsystem")
+	}
+	tx := newTestTransfer(1, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now())
+	lg.transfer(tx).Infof("filtered")
+	lg.transfer(tx).with("status", cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED).Warnf("text %d", 1)
+	line := buf.String()
+	if strings.Contains(line, "filtered") || !strings.Contains(line, "logger_test.go:") ||
+		!strings.Contains(line, "[test] text 1 transfer_id="+tx.TransferId.Hex()) ||
+		!strings.Contains(line, "status=TRANSFER_STATUS_CONFIRMED\n") {
+		t.Fatalf("unexpected text line %q", line)
+	}
+
+	buf.Reset()
+	logSink.lock.Lock()
+	logSink.json = true
+	logSink.lock.Unlock()
+	lg.transfer(tx).Errorf("json")
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatalf("invalid json line %q, err:%v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level": "error", "subsystem": "test", "msg": "json", "transfer_id": tx.TransferId.Hex(),
+		"related_tid": tx.RelatedTid.Hex(), "chain_id": float64(tx.ChainId), "direction": "out",
+		"status": "TRANSFER_STATUS_LOCKED",
+	}
+	for k, v := range expected {
+		if obj[k] != v {
+			t.Fatalf("expect %s %v, got %v", k, v, obj[k])
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const adminUsage = `Usage: cbridge-node admin [flags] <command> [args]
+
+Commands:
+  transfers [limit] [status] [chainId]   list latest transfers, status is e.g. TRANSFER_STATUS_LOCKED
+  transfer <transferId>                  show a transfer and its related transfer
+  summary                                show per chain pair summary
+  balances [chainId]                     show token and gas balances
+  chains                                 show chain status
+  setfeerate <chainId> <feeRate>         update fee rate (in 0.01%%) of a chain
+  retry <transferId>                     reset a pending transfer so it is processed again
+  ping                                   ping gateway now
+  rebalance <srcChainId> <dstChainId> <tokenName> <amount> <bridgeAddr> [timelockSeconds]
+                                         move own liquidity to dst chain through the relay node bridgeAddr,
+                                         amount in src token base unit
+  rebalances [limit]                     list latest rebalances
+  archive                                archive settled transfers now according to the retention config
+  restore <from> [to]                    restore archived transfers created in [from, to) for audits,
+                                         dates in RFC3339 or 2006-01-02 format, to defaults to now
+  events [status] [chainId] [limit]      list latest inbox events, status is e.g. INBOX_EVENT_STATUS_QUARANTINED
+  reprocess [chainId] [txHash]           set quarantined inbox events pending again
+  txs [status] [chainId] [limit]         list latest txs sent by the node, status is e.g. CHAIN_TX_STATUS_REVERTED
+  transfertxs <transferId>               list txs sent for a transfer or rebalance, in the order they were sent
+
+Flags:
+`
+
+// runAdmin is the client side of the admin gRPC service, see server.StartAdminService.
+func runAdmin(args []string) {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8089", "admin service address")
+	token := fs.String("token", os.Getenv("CBRIDGE_ADMIN_TOKEN"), "auth token, default from env CBRIDGE_ADMIN_TOKEN")
+	caFile := fs.String("ca", "", "CA to verify the admin service certificate, enables TLS")
+	certFile := fs.String("cert", "", "client certificate for mTLS")
+	keyFile := fs.String("key", "", "client key for mTLS")
+	serverName := fs.String("servername", "", "override server name in TLS verification")
+	timeout := fs.Duration("timeout", 30*time.Second, "request timeout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), adminUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dialOpt := grpc.WithInsecure()
+	if *caFile != "" {
+		tlsCfg, err := adminClientTLSConfig(*caFile, *certFile, *keyFile, *serverName)
+		chkErr(err)
+		dialOpt = grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg))
+	}
+	conn, err := grpc.Dial(*addr, dialOpt)
+	chkErr(err)
+	defer conn.Close()
+	client := cbn.NewCBridgeNodeClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if *token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+	}
+
+	cmdArgs := fs.Args()[1:]
+	var resp proto.Message
+	switch fs.Arg(0) {
+	case "transfers":
+		req := &cbn.ListTransfersRequest{}
+		if len(cmdArgs) > 0 {
+			req.Limit = parseUint(cmdArgs[0])
+		}
+		if len(cmdArgs) > 1 {
+			st, ok := cbn.TransferStatus_value[strings.ToUpper(cmdArgs[1])]
+			if !ok {
+				chkErr(fmt.Errorf("unknown transfer status %s", cmdArgs[1]))
+			}
+			req.Status = cbn.TransferStatus(st)
+		}
+		if len(cmdArgs) > 2 {
+			req.ChainId = parseUint(cmdArgs[2])
+		}
+		resp, err = client.ListTransfers(ctx, req)
+	case "transfer":
+		needArgs(cmdArgs, 1)
+		resp, err = client.GetTransfer(ctx, &cbn.GetTransferRequest{TransferId: cmdArgs[0]})
+	case "summary":
+		resp, err = client.GetSummary(ctx, &cbn.GetSummaryRequest{})
+	case "balances":
+		req := &cbn.GetBalancesRequest{}
+		if len(cmdArgs) > 0 {
+			req.ChainId = parseUint(cmdArgs[0])
+		}
+		resp, err = client.GetBalances(ctx, req)
+	case "chains":
+		resp, err = client.GetChainStatus(ctx, &cbn.GetChainStatusRequest{})
+	case "setfeerate":
+		needArgs(cmdArgs, 2)
+		resp, err = client.SetFeeRate(ctx, &cbn.SetFeeRateRequest{ChainId: parseUint(cmdArgs[0]), FeeRate: parseUint(cmdArgs[1])})
+	case "retry":
+		needArgs(cmdArgs, 1)
+		resp, err = client.RetryTransfer(ctx, &cbn.RetryTransferRequest{TransferId: cmdArgs[0]})
+	case "ping":
+		resp, err = client.PingGateway(ctx, &cbn.PingGatewayRequest{})
+	case "rebalance":
+		needArgs(cmdArgs, 5)
+		req := &cbn.RebalanceRequest{
+			SrcChainId: parseUint(cmdArgs[0]),
+			DstChainId: parseUint(cmdArgs[1]),
+			TokenName:  cmdArgs[2],
+			Amount:     cmdArgs[3],
+			BridgeAddr: cmdArgs[4],
+		}
+		if len(cmdArgs) > 5 {
+			req.TimeLock = parseUint(cmdArgs[5])
+		}
+		resp, err = client.Rebalance(ctx, req)
+	case "rebalances":
+		req := &cbn.ListRebalancesRequest{}
+		if len(cmdArgs) > 0 {
+			req.Limit = parseUint(cmdArgs[0])
+		}
+		resp, err = client.ListRebalances(ctx, req)
+	case "archive":
+		resp, err = client.ArchiveTransfers(ctx, &cbn.ArchiveTransfersRequest{})
+	case "restore":
+		needArgs(cmdArgs, 1)
+		req := &cbn.RestoreTransfersRequest{FromTs: parseDate(cmdArgs[0])}
+		if len(cmdArgs) > 1 {
+			req.ToTs = parseDate(cmdArgs[1])
+		}
+		resp, err = client.RestoreTransfers(ctx, req)
+	case "events":
+		req := &cbn.ListInboxEventsRequest{}
+		if len(cmdArgs) > 0 {
+			st, ok := cbn.InboxEventStatus_value[strings.ToUpper(cmdArgs[0])]
+			if !ok {
+				chkErr(fmt.Errorf("unknown inbox event status %s", cmdArgs[0]))
+			}
+			req.Status = cbn.InboxEventStatus(st)
+		}
+		if len(cmdArgs) > 1 {
+			req.ChainId = parseUint(cmdArgs[1])
+		}
+		if len(cmdArgs) > 2 {
+			req.Limit = parseUint(cmdArgs[2])
+		}
+		resp, err = client.ListInboxEvents(ctx, req)
+	case "reprocess":
+		req := &cbn.ReprocessInboxEventsRequest{}
+		if len(cmdArgs) > 0 {
+			req.ChainId = parseUint(cmdArgs[0])
+		}
+		if len(cmdArgs) > 1 {
+			req.TxHash = cmdArgs[1]
+		}
+		resp, err = client.ReprocessInboxEvents(ctx, req)
+	case "txs":
+		req := &cbn.ListChainTxsRequest{}
+		if len(cmdArgs) > 0 {
+			st, ok := cbn.ChainTxStatus_value[strings.ToUpper(cmdArgs[0])]
+			if !ok {
+				chkErr(fmt.Errorf("unknown tx status %s", cmdArgs[0]))
+			}
+			req.Status = cbn.ChainTxStatus(st)
+		}
+		if len(cmdArgs) > 1 {
+			req.ChainId = parseUint(cmdArgs[1])
+		}
+		if len(cmdArgs) > 2 {
+			req.Limit = parseUint(cmdArgs[2])
+		}
+		resp, err = client.ListChainTxs(ctx, req)
+	case "transfertxs":
+		needArgs(cmdArgs, 1)
+		resp, err = client.ListChainTxs(ctx, &cbn.ListChainTxsRequest{TransferId: cmdArgs[0]})
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	chkErr(err)
+	out, err := protojson.MarshalOptions{Multiline: true, EmitUnpopulated: false}.Marshal(resp)
+	chkErr(err)
+	fmt.Println(string(out))
+}
+
+func adminClientTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	caPem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("no valid certificate found in %s", caFile)
+	}
+	tlsCfg := &tls.Config{
+		RootCAs:    pool,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+func needArgs(args []string, n int) {
+	if len(args) < n {
+		chkErr(fmt.Errorf("expect %d args, got %d", n, len(args)))
+	}
+}
+
+func parseUint(s string) uint64 {
+	v, err := strconv.ParseUint(s, 10, 64)
+	chkErr(err)
+	return v
+}
+
+// parseDate parses a RFC3339 time or a UTC date to unix seconds.
+func parseDate(s string) uint64 {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	chkErr(err)
+	return uint64(t.Unix())
+}
+
+func chkErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Err:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/fakegateway"
+)
+
+const fakeGatewayUsage = `Usage: cbridge-node fakegateway [flags]
+
+Serves the gateway Relay service without TLS for running relay nodes locally. Set "gateway" to the listen
+address and "gatewayInsecure": true in the relay node config.
+
+Flags:
+`
+
+// runFakeGateway serves a fake gateway until killed.
+func runFakeGateway(args []string) {
+	fs := flag.NewFlagSet("fakegateway", flag.ExitOnError)
+	cfgFile := fs.String("c", "", "fake gateway config json file path, optional")
+	addr := fs.String("addr", "", "listen address, overrides listenAddr of the config, default "+fakegateway.DefaultListenAddr)
+	fee := fs.String("fee", "", "fee of all transfers in dst token base unit, overrides defaultFee of the config")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), fakeGatewayUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	cfg := &cbn.FakeGatewayConfig{}
+	if *cfgFile != "" {
+		var err error
+		cfg, err = fakegateway.ParseConfigFile(*cfgFile)
+		chkErr(err)
+	}
+	if *addr != "" {
+		cfg.ListenAddr = *addr
+	}
+	if *fee != "" {
+		cfg.DefaultFee = *fee
+	}
+	gateway, err := fakegateway.New(cfg)
+	chkErr(err)
+	chkErr(gateway.ListenAndServe())
+}
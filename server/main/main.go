@@ -3,16 +3,14 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net/http"
 	"os"
 
 	"github.com/celer-network/cBridge-go/server"
 	"github.com/celer-network/goutils/log"
-	"github.com/julienschmidt/httprouter"
 )
 
 var (
-	port    = flag.Int("p", 8088, "web port used for get relay node stats")
+	port    = flag.Int("p", 8088, "web port used for get relay node stats, ignored if httpConfig.listenAddr is set")
 	config  = flag.String("c", "", "config json file path")
 	showver = flag.Bool("v", false, "Show version and exit")
 	ks      = flag.String("ks", "", "path to keystore json file")
@@ -20,6 +18,18 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		runAdmin(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fakegateway" {
+		runFakeGateway(os.Args[2:])
+		return
+	}
 	flag.Parse()
 	if version == "" {
 		version = "v1.0.2"
@@ -37,10 +47,21 @@ func main() {
 		log.Fatal(err)
 		return
 	}
+	err = server.SetupLogging(cbConfig.GetLogConfig())
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	shutdownTracing, err := server.SetupTracing(cbConfig.GetTracingConfig(), version)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	defer shutdownTracing()
 	log.Infoln("Successfully load config file")
 
 	log.Infoln("Connecting to gateway server...")
-	err = s.InitGatewayClient(cbConfig.GetGateway())
+	err = s.InitGatewayClient(cbConfig)
 	if err != nil {
 		log.Fatal(err)
 		return
@@ -54,22 +75,17 @@ func main() {
 	}
 	log.Infof("cBridge relay node successfully starts")
 
-	go s.PingCron()
-	go s.ProcessSendTransfer()
-	go s.ProcessConfirmTransfer()
-	go s.ProcessRefundTransferIn()
-	go s.ProcessRecoverTimeoutPendingTransfer()
+	err = s.StartAdminService()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
 
-	webRouter := httprouter.New()
-	webRouter.GET("/v1/summary/total", s.GetTotalSummary)
-	webRouter.GET("/v1/transfer/:limit", s.GetTransfer)
-	startListenAndServeByPort(*port, webRouter)
-}
+	s.RunJobs()
 
-func startListenAndServeByPort(port int, hanlder http.Handler) {
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), hanlder)
+	err = s.ServeHttp(*port)
 	if err != nil {
-		log.Errorf("fail to startListenAndServeByPort, err:%v", err)
+		log.Errorf("fail to serve http, err:%v", err)
 	}
 }
 
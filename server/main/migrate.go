@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/celer-network/cBridge-go/server"
+)
+
+const migrateUsage = `Usage: cbridge-node migrate -c <config> [flags] <command> [version]
+
+Commands:
+  status           show the db schema version and the latest version of this binary
+  up [version]     migrate up to version, default latest
+  down [version]   migrate down to version, default one version down
+
+Flags:
+`
+
+// runMigrate applies db schema migrations of the db in the config file.
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	cfgFile := fs.String("c", "", "config json file path")
+	dryRun := fs.Bool("dryrun", false, "print the sql statements without executing them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *cfgFile == "" || fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cbConfig, err := server.ParseCfgFile(*cfgFile)
+	chkErr(err)
+	dal, err := server.OpenDAL(cbConfig)
+	chkErr(err)
+	defer dal.Close()
+	current, err := dal.SchemaVersion()
+	chkErr(err)
+	latest := server.LatestSchemaVersion()
+
+	cmdArgs := fs.Args()[1:]
+	var target int
+	switch fs.Arg(0) {
+	case "status":
+		fmt.Printf("db schema version: %d\nlatest schema version: %d\n", current, latest)
+		return
+	case "up":
+		target = latest
+	case "down":
+		target = current - 1
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(cmdArgs) > 0 {
+		target, err = strconv.Atoi(cmdArgs[0])
+		chkErr(err)
+	}
+	if fs.Arg(0) == "up" && target < current || fs.Arg(0) == "down" && target > current {
+		chkErr(fmt.Errorf("can't migrate %s from version %d to %d", fs.Arg(0), current, target))
+	}
+	chkErr(dal.Migrate(target, *dryRun, os.Stdout))
+	if !*dryRun {
+		fmt.Printf("db schema migrated from version %d to %d\n", current, target)
+	}
+}
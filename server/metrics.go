@@ -0,0 +1,26 @@
+package server
+
+import (
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// metricsRegistry holds the node metrics, served at /metrics in prometheus format.
+// Metrics are created directly instead of through metrics.NewRegisteredXXX, which
+// return no-op metrics unless geth's global metrics flag is set.
+var metricsRegistry = metrics.NewRegistry()
+
+func newGauge(name string) metrics.Gauge {
+	g := new(metrics.StandardGauge)
+	if err := metricsRegistry.Register(name, g); err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func newCounter(name string) metrics.Counter {
+	c := metrics.NewCounterForced()
+	if err := metricsRegistry.Register(name, c); err != nil {
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/celer-network/goutils/log"
+	"github.com/celer-network/goutils/sqldb"
+)
+
+// The "schema_version" table, one row per applied migration.
+func (d *DAL) schemaVersionTableExists() (bool, error) {
+	q := `SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_version'`
+	if d.driver == dbDriverSqlite {
+		q = `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`
+	}
+	var count int
+	err := d.QueryRow(q).Scan(&count)
+	return count > 0, err
+}
+
+func (d *DAL) createSchemaVersionTable() error {
+	q := `CREATE TABLE IF NOT EXISTS schema_version (
+    version INT PRIMARY KEY NOT NULL,
+    name TEXT NOT NULL,
+    appliedts TIMESTAMPTZ NOT NULL
+)`
+	if d.driver == dbDriverSqlite {
+		q = `CREATE TABLE IF NOT EXISTS schema_version (
+    version INT PRIMARY KEY NOT NULL,
+    name TEXT NOT NULL,
+    appliedts TIMESTAMP NOT NULL
+)`
+	}
+	_, err := d.Exec(q)
+	return err
+}
+
+// columnExists returns if the "table.column" exists in the current schema of a CockroachDB/PostgreSQL db.
+func (d *DAL) columnExists(column string) (bool, error) {
+	parts := strings.SplitN(column, ".", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("invalid column %s, should be table.column", column)
+	}
+	q := `SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2`
+	var count int
+	err := d.QueryRow(q, parts[0], parts[1]).Scan(&count)
+	return count > 0, err
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 if none.
+func (d *DAL) SchemaVersion() (int, error) {
+	exists, err := d.schemaVersionTableExists()
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	q := `SELECT coalesce(max(version), 0) FROM schema_version`
+	err = d.QueryRow(q).Scan(&version)
+	return version, err
+}
+
+// Migrate applies migrations up or down until the schema is at the target version. With dryRun,
+// the statements are only written to w.
+func (d *DAL) Migrate(target int, dryRun bool, w io.Writer) error {
+	if target < 0 || target > LatestSchemaVersion() {
+		return fmt.Errorf("invalid target schema version %d, latest is %d", target, LatestSchemaVersion())
+	}
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("fail to get schema version: %w", err)
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("db schema version %d is newer than %d supported by this binary", current, LatestSchemaVersion())
+	}
+	if !dryRun {
+		if err = d.createSchemaVersionTable(); err != nil {
+			return fmt.Errorf("fail to create schema_version table: %w", err)
+		}
+	}
+	if target >= current {
+		for _, m := range migrations {
+			if m.version > current && m.version <= target {
+				if err = d.runMigration(m, true, dryRun, w); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version <= current && m.version > target {
+			if err = d.runMigration(m, false, dryRun, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DAL) runMigration(m *migration, up, dryRun bool, w io.Writer) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	fmt.Fprintf(w, "-- migrate %s %d %s\n", direction, m.version, m.name)
+	if !dryRun {
+		log.Infof("Migrating %s to schema version %d %s", direction, m.version, m.name)
+	}
+	for _, stmt := range m.statements(d.driver, up) {
+		fmt.Fprintf(w, "%s;\n", stmt)
+		if dryRun {
+			continue
+		}
+		if column, found := m.ifColumn[stmt]; found && d.driver != dbDriverSqlite {
+			exists, err := d.columnExists(column)
+			if err != nil {
+				return fmt.Errorf("fail to migrate %s %d %s: %w", direction, m.version, m.name, err)
+			}
+			if !exists {
+				log.Infof("Skip statement of migration %d, column %s not found, already applied", m.version, column)
+				continue
+			}
+		}
+		if _, err := d.Exec(stmt); err != nil {
+			return fmt.Errorf("fail to migrate %s %d %s: %w", direction, m.version, m.name, err)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	if up {
+		q := `INSERT INTO schema_version (version, name, appliedts) VALUES ($1, $2, $3)`
+		res, err := d.Exec(q, m.version, m.name, dbNow())
+		return sqldb.ChkExec(res, err, 1, "InsertSchemaVersion")
+	}
+	q := `DELETE FROM schema_version WHERE version = $1`
+	res, err := d.Exec(q, m.version)
+	return sqldb.ChkExec(res, err, 1, "DeleteSchemaVersion")
+}
+
+// prepareSchema refuses a db schema newer than the binary, and applies pending migrations if autoMigrate.
+func (d *DAL) prepareSchema(autoMigrate bool) error {
+	current, err := d.SchemaVersion()
+	if err != nil {
+		log.Errorf("fail to get schema version, err:%v", err)
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("db schema version %d is newer than %d supported by this binary, please upgrade the relay node", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+	if !autoMigrate {
+		return fmt.Errorf("db schema version %d is older than %d, please run cbridge-node migrate", current, latest)
+	}
+	log.Infof("Migrating db schema from version %d to %d...", current, latest)
+	return d.Migrate(latest, false, ioutil.Discard)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSqliteDAL(t *testing.T) (*DAL, func()) {
+	dir, err := ioutil.TempDir("", "cbridge-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDAL(dbDriverSqlite, filepath.Join(dir, "cbridge.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, func() {
+		d.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func expectSchemaVersion(t *testing.T, d *DAL, expected int) {
+	t.Helper()
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected {
+		t.Fatalf("expect schema version %d, got %d", expected, version)
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	d, cleanup := newTestSqliteDAL(t)
+	defer cleanup()
+	expectSchemaVersion(t, d, 0)
+	latest := LatestSchemaVersion()
+
+	var out bytes.Buffer
+	if err := d.Migrate(latest, true, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "-- migrate up 1 baseline") || !strings.Contains(out.String(), "CREATE TABLE IF NOT EXISTS transfer") {
+		t.Fatalf("unexpected dry run output:\n%s", out.String())
+	}
+	expectSchemaVersion(t, d, 0)
+	if _, err := d.Exec("SELECT count(*) FROM transfer"); err == nil {
+		t.Fatal("expect no transfer table after dry run")
+	}
+
+	// every migration must be reversible
+	for round := 0; round < 2; round++ {
+		if err := d.Migrate(latest, false, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		expectSchemaVersion(t, d, latest)
+		if _, err := d.Exec("SELECT count(*) FROM transfer"); err != nil {
+			t.Fatal(err)
+		}
+		for version := latest - 1; version >= 0; version-- {
+			if err := d.Migrate(version, false, ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+			expectSchemaVersion(t, d, version)
+		}
+		if _, err := d.Exec("SELECT count(*) FROM transfer"); err == nil {
+			t.Fatal("expect no transfer table after migrating down to 0")
+		}
+	}
+}
+
+func TestPrepareSchema(t *testing.T) {
+	d, cleanup := newTestSqliteDAL(t)
+	defer cleanup()
+	if err := d.prepareSchema(false); err == nil {
+		t.Fatal("expect error on pending migrations without auto migrate")
+	}
+	if err := d.prepareSchema(true); err != nil {
+		t.Fatal(err)
+	}
+	expectSchemaVersion(t, d, LatestSchemaVersion())
+	if err := d.prepareSchema(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// schema migrated by a newer binary
+	if _, err := d.Exec(`INSERT INTO schema_version (version, name, appliedts) VALUES ($1, $2, $3)`,
+		LatestSchemaVersion()+1, "future", dbNow()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.prepareSchema(true); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expect newer schema refused, err:%v", err)
+	}
+}
+
+func TestMigrationGuards(t *testing.T) {
+	for _, m := range migrations {
+		for stmt, column := range m.ifColumn {
+			if !containsString(m.up, stmt) && !containsString(m.down, stmt) {
+				t.Errorf("guarded statement of migration %d not found: %s", m.version, stmt)
+			}
+			if parts := strings.Split(column, "."); len(parts) != 2 || !strings.Contains(stmt, parts[1]) {
+				t.Errorf("statement of migration %d does not read column %s: %s", m.version, column, stmt)
+			}
+		}
+	}
+}
+
+// TestPostgresMigrateRetry migrates the db of testPostgresDsnEnv down to 1 and back up, with a retry of
+// migration 2 failed after it dropped the amount column.
+func TestPostgresMigrateRetry(t *testing.T) {
+	dsn := os.Getenv(testPostgresDsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testPostgresDsnEnv)
+	}
+	d, err := NewDAL(dbDriverPostgres, dsn, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err = d.Migrate(1, false, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Migrate(LatestSchemaVersion(), false, ioutil.Discard)
+	if _, err = d.Exec("DELETE FROM transfer"); err != nil {
+		t.Fatal(err)
+	}
+	tid := Bytes2Hash([]byte{0x90})
+	_, err = d.Exec(`INSERT INTO transfer (tid, chainid, token, transfertype, timelock, hashlock, status, relatedtid, relatedchainid,
+		relatedtoken, amount, fee, senderaddr, receiveraddr, updatets, createts) VALUES ($1, 5, '', 1, $2, '', 1, '', 4, '', '123', '7', '', '', $2, $2)`,
+		tid.String(), dbNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the statements of migration 2 up to the drop of amount, as if the rename failed
+	v2 := migrations[1]
+	for _, stmt := range v2.up[:3] {
+		if _, err = d.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.Migrate(2, false, ioutil.Discard); err != nil {
+		t.Fatalf("expect migration 2 retried, err:%v", err)
+	}
+	var amount, fee string
+	if err = d.QueryRow("SELECT amount::TEXT, fee::TEXT FROM transfer WHERE tid = $1", tid.String()).Scan(&amount, &fee); err != nil {
+		t.Fatal(err)
+	}
+	if amount != "123" || fee != "7" {
+		t.Fatalf("expect amount 123 and fee 7 kept, got %s %s", amount, fee)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,532 @@
+package server
+
+// migration is a versioned schema change. Statements are run in order, up when migrating to
+// the version, down when migrating back to the previous version. CockroachDB/PostgreSQL use
+// up/down, SQLite uses sqliteUp/sqliteDown if set, otherwise up/down too.
+//
+// Never change a released migration, add a new one instead. Keep postgres statements
+// idempotent (IF [NOT] EXISTS) as CockroachDB can't run them in one transaction with the
+// schema_version update, a failed migration is retried from its first statement. A statement
+// that can't be, e.g. an UPDATE copying a column dropped by a later statement, is guarded by
+// ifColumn.
+type migration struct {
+	version    int
+	name       string
+	up         []string
+	down       []string
+	sqliteUp   []string
+	sqliteDown []string
+	// postgres statement -> "table.column" it reads, the statement is skipped if the column does not exist
+	ifColumn map[string]string
+}
+
+func (m *migration) statements(driver string, up bool) []string {
+	if driver == dbDriverSqlite {
+		if up && m.sqliteUp != nil {
+			return m.sqliteUp
+		}
+		if !up && m.sqliteDown != nil {
+			return m.sqliteDown
+		}
+	}
+	if up {
+		return m.up
+	}
+	return m.down
+}
+
+// migrations ordered by version, starting from 1.
+var migrations = []*migration{
+	{
+		// tables as created by schema.sql before versioned migrations, existing dbs are upgraded in place
+		version: 1,
+		name:    "baseline",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS monitor (
+    event TEXT PRIMARY KEY NOT NULL,
+    blocknum INT NOT NULL,
+    blockidx INT NOT NULL,
+    restart BOOL NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS transfer (
+    tid TEXT PRIMARY KEY NOT NULL,
+    txhash TEXT NOT NULL DEFAULT '',
+    chainid INT NOT NULL,
+    token TEXT NOT NULL,
+    transfertype INT NOT NULL,
+    timelock TIMESTAMPTZ NOT NULL,
+    hashlock TEXT NOT NULL,
+    status INT NOT NULL,
+    relatedtid TEXT NOT NULL,
+    relatedchainid INT NOT NULL,
+    relatedtoken TEXT NOT NULL,
+    amount TEXT NOT NULL,
+    fee TEXT NOT NULL,
+    transfergascost TEXT,
+    confirmgascost TEXT,
+    refundgascost TEXT,
+    preimage TEXT,
+    senderaddr TEXT NOT NULL,
+    receiveraddr TEXT NOT NULL,
+    txconfirmhash TEXT NOT NULL DEFAULT '',
+    txrefundhash TEXT NOT NULL DEFAULT '',
+    updatets TIMESTAMPTZ NOT NULL,
+    createts TIMESTAMPTZ NOT NULL DEFAULT now()
+)`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS preimagestatus INT NOT NULL DEFAULT 0`,
+			`CREATE INDEX IF NOT EXISTS transfer_create_ts_idx ON transfer (createts)`,
+			`CREATE INDEX IF NOT EXISTS transfer_chain_id_idx ON transfer (chainid)`,
+			`CREATE INDEX IF NOT EXISTS transfer_status_idx ON transfer (status)`,
+			`CREATE INDEX IF NOT EXISTS transfer_related_tid_idx ON transfer (relatedtid)`,
+			`CREATE TABLE IF NOT EXISTS rebalance (
+    tid TEXT PRIMARY KEY NOT NULL,
+    srcchainid INT NOT NULL,
+    dstchainid INT NOT NULL,
+    srctoken TEXT NOT NULL,
+    dsttoken TEXT NOT NULL,
+    amount TEXT NOT NULL,
+    dstamount TEXT NOT NULL DEFAULT '',
+    bridgeaddr TEXT NOT NULL,
+    hashlock TEXT NOT NULL,
+    preimage TEXT NOT NULL,
+    timelock TIMESTAMPTZ NOT NULL,
+    dsttid TEXT NOT NULL,
+    status INT NOT NULL,
+    updatets TIMESTAMPTZ NOT NULL,
+    createts TIMESTAMPTZ NOT NULL DEFAULT now()
+)`,
+			`CREATE INDEX IF NOT EXISTS rebalance_status_idx ON rebalance (status)`,
+			`CREATE INDEX IF NOT EXISTS rebalance_hashlock_idx ON rebalance (hashlock)`,
+			`CREATE INDEX IF NOT EXISTS rebalance_dst_tid_idx ON rebalance (dsttid)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS rebalance`,
+			`DROP TABLE IF EXISTS transfer`,
+			`DROP TABLE IF EXISTS monitor`,
+		},
+		// timestamps are declared as TIMESTAMP so the driver parses them back to time.Time
+		sqliteUp: []string{
+			`CREATE TABLE IF NOT EXISTS monitor (
+    event TEXT PRIMARY KEY NOT NULL,
+    blocknum INT NOT NULL,
+    blockidx INT NOT NULL,
+    restart BOOL NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS transfer (
+    tid TEXT PRIMARY KEY NOT NULL,
+    txhash TEXT NOT NULL DEFAULT '',
+    chainid INT NOT NULL,
+    token TEXT NOT NULL,
+    transfertype INT NOT NULL,
+    timelock TIMESTAMP NOT NULL,
+    hashlock TEXT NOT NULL,
+    status INT NOT NULL,
+    relatedtid TEXT NOT NULL,
+    relatedchainid INT NOT NULL,
+    relatedtoken TEXT NOT NULL,
+    amount TEXT NOT NULL,
+    fee TEXT NOT NULL,
+    transfergascost TEXT,
+    confirmgascost TEXT,
+    refundgascost TEXT,
+    preimage TEXT,
+    senderaddr TEXT NOT NULL,
+    receiveraddr TEXT NOT NULL,
+    txconfirmhash TEXT NOT NULL DEFAULT '',
+    txrefundhash TEXT NOT NULL DEFAULT '',
+    updatets TIMESTAMP NOT NULL,
+    createts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    preimagestatus INT NOT NULL DEFAULT 0
+)`,
+			`CREATE INDEX IF NOT EXISTS transfer_create_ts_idx ON transfer (createts)`,
+			`CREATE INDEX IF NOT EXISTS transfer_chain_id_idx ON transfer (chainid)`,
+			`CREATE INDEX IF NOT EXISTS transfer_status_idx ON transfer (status)`,
+			`CREATE INDEX IF NOT EXISTS transfer_related_tid_idx ON transfer (relatedtid)`,
+			`CREATE TABLE IF NOT EXISTS rebalance (
+    tid TEXT PRIMARY KEY NOT NULL,
+    srcchainid INT NOT NULL,
+    dstchainid INT NOT NULL,
+    srctoken TEXT NOT NULL,
+    dsttoken TEXT NOT NULL,
+    amount TEXT NOT NULL,
+    dstamount TEXT NOT NULL DEFAULT '',
+    bridgeaddr TEXT NOT NULL,
+    hashlock TEXT NOT NULL,
+    preimage TEXT NOT NULL,
+    timelock TIMESTAMP NOT NULL,
+    dsttid TEXT NOT NULL,
+    status INT NOT NULL,
+    updatets TIMESTAMP NOT NULL,
+    createts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+			`CREATE INDEX IF NOT EXISTS rebalance_status_idx ON rebalance (status)`,
+			`CREATE INDEX IF NOT EXISTS rebalance_hashlock_idx ON rebalance (hashlock)`,
+			`CREATE INDEX IF NOT EXISTS rebalance_dst_tid_idx ON rebalance (dsttid)`,
+		},
+	},
+	{
+		// amounts as NUMERIC(78,0) to aggregate in sql, uint256 has 78 digits at most. CockroachDB can't change
+		// the column type in place, so the values are copied to new columns. SQLite keeps TEXT as its NUMERIC
+		// is a float for large values, the sums are done in Go by SqliteDAL.
+		version: 2,
+		name:    "transfer_numeric_amounts",
+		up: []string{
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS amountnum NUMERIC(78,0) NOT NULL DEFAULT 0`,
+			`UPDATE transfer SET amountnum = CAST(COALESCE(amount, '0') AS NUMERIC(78,0))`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS amount`,
+			`ALTER TABLE transfer RENAME COLUMN amountnum TO amount`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS feenum NUMERIC(78,0) NOT NULL DEFAULT 0`,
+			`UPDATE transfer SET feenum = CAST(COALESCE(fee, '0') AS NUMERIC(78,0))`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS fee`,
+			`ALTER TABLE transfer RENAME COLUMN feenum TO fee`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS transfergascostnum NUMERIC(78,0) NOT NULL DEFAULT 0`,
+			`UPDATE transfer SET transfergascostnum = CAST(COALESCE(transfergascost, '0') AS NUMERIC(78,0))`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS transfergascost`,
+			`ALTER TABLE transfer RENAME COLUMN transfergascostnum TO transfergascost`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS confirmgascostnum NUMERIC(78,0) NOT NULL DEFAULT 0`,
+			`UPDATE transfer SET confirmgascostnum = CAST(COALESCE(confirmgascost, '0') AS NUMERIC(78,0))`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS confirmgascost`,
+			`ALTER TABLE transfer RENAME COLUMN confirmgascostnum TO confirmgascost`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS refundgascostnum NUMERIC(78,0) NOT NULL DEFAULT 0`,
+			`UPDATE transfer SET refundgascostnum = CAST(COALESCE(refundgascost, '0') AS NUMERIC(78,0))`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS refundgascost`,
+			`ALTER TABLE transfer RENAME COLUMN refundgascostnum TO refundgascost`,
+			`CREATE INDEX IF NOT EXISTS transfer_status_timelock_idx ON transfer (status, timelock)`,
+			`CREATE INDEX IF NOT EXISTS transfer_status_update_ts_idx ON transfer (status, updatets)`,
+			`DROP INDEX IF EXISTS transfer_status_idx`,
+		},
+		down: []string{
+			`CREATE INDEX IF NOT EXISTS transfer_status_idx ON transfer (status)`,
+			`DROP INDEX IF EXISTS transfer_status_update_ts_idx`,
+			`DROP INDEX IF EXISTS transfer_status_timelock_idx`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS amounttext TEXT NOT NULL DEFAULT ''`,
+			`UPDATE transfer SET amounttext = CAST(amount AS TEXT)`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS amount`,
+			`ALTER TABLE transfer RENAME COLUMN amounttext TO amount`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS feetext TEXT NOT NULL DEFAULT ''`,
+			`UPDATE transfer SET feetext = CAST(fee AS TEXT)`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS fee`,
+			`ALTER TABLE transfer RENAME COLUMN feetext TO fee`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS transfergascosttext TEXT`,
+			`UPDATE transfer SET transfergascosttext = CAST(transfergascost AS TEXT)`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS transfergascost`,
+			`ALTER TABLE transfer RENAME COLUMN transfergascosttext TO transfergascost`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS confirmgascosttext TEXT`,
+			`UPDATE transfer SET confirmgascosttext = CAST(confirmgascost AS TEXT)`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS confirmgascost`,
+			`ALTER TABLE transfer RENAME COLUMN confirmgascosttext TO confirmgascost`,
+			`ALTER TABLE transfer ADD COLUMN IF NOT EXISTS refundgascosttext TEXT`,
+			`UPDATE transfer SET refundgascosttext = CAST(refundgascost AS TEXT)`,
+			`ALTER TABLE transfer DROP COLUMN IF EXISTS refundgascost`,
+			`ALTER TABLE transfer RENAME COLUMN refundgascosttext TO refundgascost`,
+		},
+		ifColumn: map[string]string{
+			`UPDATE transfer SET amountnum = CAST(COALESCE(amount, '0') AS NUMERIC(78,0))`:                   "transfer.amount",
+			`UPDATE transfer SET feenum = CAST(COALESCE(fee, '0') AS NUMERIC(78,0))`:                         "transfer.fee",
+			`UPDATE transfer SET transfergascostnum = CAST(COALESCE(transfergascost, '0') AS NUMERIC(78,0))`: "transfer.transfergascost",
+			`UPDATE transfer SET confirmgascostnum = CAST(COALESCE(confirmgascost, '0') AS NUMERIC(78,0))`:   "transfer.confirmgascost",
+			`UPDATE transfer SET refundgascostnum = CAST(COALESCE(refundgascost, '0') AS NUMERIC(78,0))`:     "transfer.refundgascost",
+			`UPDATE transfer SET amounttext = CAST(amount AS TEXT)`:                                          "transfer.amount",
+			`UPDATE transfer SET feetext = CAST(fee AS TEXT)`:                                                "transfer.fee",
+			`UPDATE transfer SET transfergascosttext = CAST(transfergascost AS TEXT)`:                        "transfer.transfergascost",
+			`UPDATE transfer SET confirmgascosttext = CAST(confirmgascost AS TEXT)`:                          "transfer.confirmgascost",
+			`UPDATE transfer SET refundgascosttext = CAST(refundgascost AS TEXT)`:                            "transfer.refundgascost",
+		},
+		sqliteUp: []string{
+			`CREATE INDEX IF NOT EXISTS transfer_status_timelock_idx ON transfer (status, timelock)`,
+			`CREATE INDEX IF NOT EXISTS transfer_status_update_ts_idx ON transfer (status, updatets)`,
+			`DROP INDEX IF EXISTS transfer_status_idx`,
+		},
+		sqliteDown: []string{
+			`CREATE INDEX IF NOT EXISTS transfer_status_idx ON transfer (status)`,
+			`DROP INDEX IF EXISTS transfer_status_update_ts_idx`,
+			`DROP INDEX IF EXISTS transfer_status_timelock_idx`,
+		},
+	},
+	{
+		// settled transfers moved out of the transfer table, their totals are kept in transfer_rollup.
+		// Restored transfers are held in the transfer table until untilts.
+		version: 3,
+		name:    "transfer_archive",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS transfer_archive (
+    tid TEXT PRIMARY KEY NOT NULL,
+    txhash TEXT NOT NULL DEFAULT '',
+    chainid INT NOT NULL,
+    token TEXT NOT NULL,
+    transfertype INT NOT NULL,
+    timelock TIMESTAMPTZ NOT NULL,
+    hashlock TEXT NOT NULL,
+    status INT NOT NULL,
+    relatedtid TEXT NOT NULL,
+    relatedchainid INT NOT NULL,
+    relatedtoken TEXT NOT NULL,
+    amount NUMERIC(78,0) NOT NULL DEFAULT 0,
+    fee NUMERIC(78,0) NOT NULL DEFAULT 0,
+    transfergascost NUMERIC(78,0) NOT NULL DEFAULT 0,
+    confirmgascost NUMERIC(78,0) NOT NULL DEFAULT 0,
+    refundgascost NUMERIC(78,0) NOT NULL DEFAULT 0,
+    preimage TEXT,
+    senderaddr TEXT NOT NULL,
+    receiveraddr TEXT NOT NULL,
+    txconfirmhash TEXT NOT NULL DEFAULT '',
+    txrefundhash TEXT NOT NULL DEFAULT '',
+    updatets TIMESTAMPTZ NOT NULL,
+    createts TIMESTAMPTZ NOT NULL,
+    preimagestatus INT NOT NULL DEFAULT 0,
+    archivets TIMESTAMPTZ NOT NULL
+)`,
+			`CREATE INDEX IF NOT EXISTS transfer_archive_create_ts_idx ON transfer_archive (createts)`,
+			`CREATE TABLE IF NOT EXISTS transfer_rollup (
+    transfertype INT NOT NULL,
+    chainid INT NOT NULL,
+    relatedchainid INT NOT NULL,
+    token TEXT NOT NULL,
+    status INT NOT NULL,
+    txcount INT NOT NULL,
+    amount NUMERIC(78,0) NOT NULL,
+    fee NUMERIC(78,0) NOT NULL,
+    transfergascost NUMERIC(78,0) NOT NULL,
+    confirmgascost NUMERIC(78,0) NOT NULL,
+    refundgascost NUMERIC(78,0) NOT NULL,
+    PRIMARY KEY (transfertype, chainid, relatedchainid, token, status)
+)`,
+			`CREATE TABLE IF NOT EXISTS transfer_hold (
+    tid TEXT PRIMARY KEY NOT NULL,
+    untilts TIMESTAMPTZ NOT NULL
+)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS transfer_hold`,
+			`DROP TABLE IF EXISTS transfer_rollup`,
+			`DROP TABLE IF EXISTS transfer_archive`,
+		},
+		sqliteUp: []string{
+			`CREATE TABLE IF NOT EXISTS transfer_archive (
+    tid TEXT PRIMARY KEY NOT NULL,
+    txhash TEXT NOT NULL DEFAULT '',
+    chainid INT NOT NULL,
+    token TEXT NOT NULL,
+    transfertype INT NOT NULL,
+    timelock TIMESTAMP NOT NULL,
+    hashlock TEXT NOT NULL,
+    status INT NOT NULL,
+    relatedtid TEXT NOT NULL,
+    relatedchainid INT NOT NULL,
+    relatedtoken TEXT NOT NULL,
+    amount TEXT NOT NULL DEFAULT '0',
+    fee TEXT NOT NULL DEFAULT '0',
+    transfergascost TEXT,
+    confirmgascost TEXT,
+    refundgascost TEXT,
+    preimage TEXT,
+    senderaddr TEXT NOT NULL,
+    receiveraddr TEXT NOT NULL,
+    txconfirmhash TEXT NOT NULL DEFAULT '',
+    txrefundhash TEXT NOT NULL DEFAULT '',
+    updatets TIMESTAMP NOT NULL,
+    createts TIMESTAMP NOT NULL,
+    preimagestatus INT NOT NULL DEFAULT 0,
+    archivets TIMESTAMP NOT NULL
+)`,
+			`CREATE INDEX IF NOT EXISTS transfer_archive_create_ts_idx ON transfer_archive (createts)`,
+			`CREATE TABLE IF NOT EXISTS transfer_rollup (
+    transfertype INT NOT NULL,
+    chainid INT NOT NULL,
+    relatedchainid INT NOT NULL,
+    token TEXT NOT NULL,
+    status INT NOT NULL,
+    txcount INT NOT NULL,
+    amount TEXT NOT NULL,
+    fee TEXT NOT NULL,
+    transfergascost TEXT NOT NULL,
+    confirmgascost TEXT NOT NULL,
+    refundgascost TEXT NOT NULL,
+    PRIMARY KEY (transfertype, chainid, relatedchainid, token, status)
+)`,
+			`CREATE TABLE IF NOT EXISTS transfer_hold (
+    tid TEXT PRIMARY KEY NOT NULL,
+    untilts TIMESTAMP NOT NULL
+)`,
+		},
+	},
+	{
+		// contract logs stored before they are processed
+		version: 4,
+		name:    "event_inbox",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS event_inbox (
+    chainid INT NOT NULL,
+    txhash TEXT NOT NULL,
+    logindex INT NOT NULL,
+    blocknum INT NOT NULL,
+    eventname TEXT NOT NULL,
+    rawlog TEXT NOT NULL,
+    status INT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    lasterror TEXT NOT NULL DEFAULT '',
+    nextts TIMESTAMPTZ NOT NULL,
+    createts TIMESTAMPTZ NOT NULL,
+    updatets TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (chainid, txhash, logindex)
+)`,
+			`CREATE INDEX IF NOT EXISTS event_inbox_status_next_ts_idx ON event_inbox (status, nextts)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS event_inbox`,
+		},
+		sqliteUp: []string{
+			`CREATE TABLE IF NOT EXISTS event_inbox (
+    chainid INT NOT NULL,
+    txhash TEXT NOT NULL,
+    logindex INT NOT NULL,
+    blocknum INT NOT NULL,
+    eventname TEXT NOT NULL,
+    rawlog TEXT NOT NULL,
+    status INT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    lasterror TEXT NOT NULL DEFAULT '',
+    nextts TIMESTAMP NOT NULL,
+    createts TIMESTAMP NOT NULL,
+    updatets TIMESTAMP NOT NULL,
+    PRIMARY KEY (chainid, txhash, logindex)
+)`,
+			`CREATE INDEX IF NOT EXISTS event_inbox_status_next_ts_idx ON event_inbox (status, nextts)`,
+		},
+	},
+	{
+		// the txs sent by the node, with the fee params they were signed with
+		version: 5,
+		name:    "chain_tx",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS chain_tx (
+    chainid INT NOT NULL,
+    txhash TEXT NOT NULL,
+    transferid TEXT NOT NULL,
+    purpose INT NOT NULL,
+    nonce INT NOT NULL,
+    txtype INT NOT NULL,
+    gasprice TEXT NOT NULL,
+    gasfeecap TEXT NOT NULL,
+    gastipcap TEXT NOT NULL,
+    gaslimit INT NOT NULL,
+    createts TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (chainid, txhash)
+)`,
+			`CREATE INDEX IF NOT EXISTS chain_tx_transfer_id_idx ON chain_tx (transferid)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS chain_tx`,
+		},
+		sqliteUp: []string{
+			`CREATE TABLE IF NOT EXISTS chain_tx (
+    chainid INT NOT NULL,
+    txhash TEXT NOT NULL,
+    transferid TEXT NOT NULL,
+    purpose INT NOT NULL,
+    nonce INT NOT NULL,
+    txtype INT NOT NULL,
+    gasprice TEXT NOT NULL,
+    gasfeecap TEXT NOT NULL,
+    gastipcap TEXT NOT NULL,
+    gaslimit INT NOT NULL,
+    createts TIMESTAMP NOT NULL,
+    PRIMARY KEY (chainid, txhash)
+)`,
+			`CREATE INDEX IF NOT EXISTS chain_tx_transfer_id_idx ON chain_tx (transferid)`,
+		},
+	},
+	{
+		// leader lease of active/passive nodes sharing the db, token is the fencing token
+		version: 6,
+		name:    "leader_lease",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS leader_lease (
+    name TEXT PRIMARY KEY NOT NULL,
+    holder TEXT NOT NULL,
+    token INT NOT NULL,
+    expirets TIMESTAMPTZ NOT NULL,
+    updatets TIMESTAMPTZ NOT NULL
+)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS leader_lease`,
+		},
+		sqliteUp: []string{
+			`CREATE TABLE IF NOT EXISTS leader_lease (
+    name TEXT PRIMARY KEY NOT NULL,
+    holder TEXT NOT NULL,
+    token INT NOT NULL,
+    expirets TIMESTAMP NOT NULL,
+    updatets TIMESTAMP NOT NULL
+)`,
+		},
+	},
+	{
+		// what became of the txs sent by the node, existing txs are pending until their receipts are reconciled.
+		// SQLite can't drop columns, the table is copied back on down.
+		version: 7,
+		name:    "chain_tx_receipt",
+		up: []string{
+			`ALTER TABLE chain_tx ADD COLUMN IF NOT EXISTS status INT NOT NULL DEFAULT 1`,
+			`ALTER TABLE chain_tx ADD COLUMN IF NOT EXISTS blocknum INT NOT NULL DEFAULT 0`,
+			`ALTER TABLE chain_tx ADD COLUMN IF NOT EXISTS gasused INT NOT NULL DEFAULT 0`,
+			`ALTER TABLE chain_tx ADD COLUMN IF NOT EXISTS gascost TEXT NOT NULL DEFAULT '0'`,
+			`ALTER TABLE chain_tx ADD COLUMN IF NOT EXISTS txerror TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE chain_tx ADD COLUMN IF NOT EXISTS replaces TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE chain_tx ADD COLUMN IF NOT EXISTS updatets TIMESTAMPTZ NOT NULL DEFAULT '1970-01-01 00:00:00+00'`,
+			`UPDATE chain_tx SET updatets = createts`,
+			`CREATE INDEX IF NOT EXISTS chain_tx_status_create_ts_idx ON chain_tx (status, createts)`,
+			`CREATE INDEX IF NOT EXISTS chain_tx_chain_id_nonce_idx ON chain_tx (chainid, nonce)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS chain_tx_chain_id_nonce_idx`,
+			`DROP INDEX IF EXISTS chain_tx_status_create_ts_idx`,
+			`ALTER TABLE chain_tx DROP COLUMN IF EXISTS updatets`,
+			`ALTER TABLE chain_tx DROP COLUMN IF EXISTS replaces`,
+			`ALTER TABLE chain_tx DROP COLUMN IF EXISTS txerror`,
+			`ALTER TABLE chain_tx DROP COLUMN IF EXISTS gascost`,
+			`ALTER TABLE chain_tx DROP COLUMN IF EXISTS gasused`,
+			`ALTER TABLE chain_tx DROP COLUMN IF EXISTS blocknum`,
+			`ALTER TABLE chain_tx DROP COLUMN IF EXISTS status`,
+		},
+		sqliteUp: []string{
+			`ALTER TABLE chain_tx ADD COLUMN status INT NOT NULL DEFAULT 1`,
+			`ALTER TABLE chain_tx ADD COLUMN blocknum INT NOT NULL DEFAULT 0`,
+			`ALTER TABLE chain_tx ADD COLUMN gasused INT NOT NULL DEFAULT 0`,
+			`ALTER TABLE chain_tx ADD COLUMN gascost TEXT NOT NULL DEFAULT '0'`,
+			`ALTER TABLE chain_tx ADD COLUMN txerror TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE chain_tx ADD COLUMN replaces TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE chain_tx ADD COLUMN updatets TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00'`,
+			`UPDATE chain_tx SET updatets = createts`,
+			`CREATE INDEX IF NOT EXISTS chain_tx_status_create_ts_idx ON chain_tx (status, createts)`,
+			`CREATE INDEX IF NOT EXISTS chain_tx_chain_id_nonce_idx ON chain_tx (chainid, nonce)`,
+		},
+		sqliteDown: []string{
+			`CREATE TABLE IF NOT EXISTS chain_tx_v5 (
+    chainid INT NOT NULL,
+    txhash TEXT NOT NULL,
+    transferid TEXT NOT NULL,
+    purpose INT NOT NULL,
+    nonce INT NOT NULL,
+    txtype INT NOT NULL,
+    gasprice TEXT NOT NULL,
+    gasfeecap TEXT NOT NULL,
+    gastipcap TEXT NOT NULL,
+    gaslimit INT NOT NULL,
+    createts TIMESTAMP NOT NULL,
+    PRIMARY KEY (chainid, txhash)
+)`,
+			`INSERT INTO chain_tx_v5 SELECT chainid, txhash, transferid, purpose, nonce, txtype, gasprice, gasfeecap, gastipcap,
+    gaslimit, createts FROM chain_tx`,
+			`DROP TABLE chain_tx`,
+			`ALTER TABLE chain_tx_v5 RENAME TO chain_tx`,
+			`CREATE INDEX IF NOT EXISTS chain_tx_transfer_id_idx ON chain_tx (transferid)`,
+		},
+	},
+}
+
+// LatestSchemaVersion is the schema version this binary works with.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/celer-network/cBridge-go/contracts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+// max calls in one tryAggregate, to stay under the eth_call gas cap of the endpoints
+const multicallBatchSize = 100
+
+var (
+	cbridgeABI = mustParseABI(contracts.CBridgeABI)
+	erc20ABI   = mustParseABI(contracts.Erc20ABI)
+
+	multicallReads     = newCounter("cbridge/multicall/reads")
+	multicallFallbacks = newCounter("cbridge/multicall/fallbacks")
+)
+
+func mustParseABI(s string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// multicallRead is one call of a batch, decode is called with the return data of a successful call.
+type multicallRead struct {
+	call   contracts.Multicall2Call
+	decode func(data []byte) error
+	// one eth_call instead, if the batch fails
+	fallback func() error
+	desc     string
+	lg       *logger // with the fields of the read
+}
+
+// multicall runs the reads in tryAggregate calls of the chain Multicall contract at the block, nil for the
+// latest. If a batch fails, its reads are run one by one. Failed reads are logged with their logger and skipped.
+func (bc *bridgeConfig) multicall(blockNum *big.Int, lg *logger, reads []*multicallRead) {
+	for start := 0; start < len(reads); start += multicallBatchSize {
+		end := start + multicallBatchSize
+		if end > len(reads) {
+			end = len(reads)
+		}
+		batch := reads[start:end]
+		calls := make([]contracts.Multicall2Call, len(batch))
+		for i, r := range batch {
+			calls[i] = r.call
+		}
+		results, err := bc.multicaller.TryAggregate(&bind.CallOpts{BlockNumber: blockNum}, false, calls)
+		if err == nil && len(results) != len(batch) {
+			err = fmt.Errorf("%d results of %d calls", len(results), len(batch))
+		}
+		if err != nil {
+			lg.with("chain_id", bc.chainId.Uint64()).Warnf("fail to multicall, read one by one, calls:%d, err:%v", len(batch), err)
+			multicallFallbacks.Inc(1)
+			for _, r := range batch {
+				if err = r.fallback(); err != nil {
+					r.lg.Warnf("fail to read %s, err:%v", r.desc, err)
+				}
+			}
+			continue
+		}
+		multicallReads.Inc(int64(len(batch)))
+		for i, r := range batch {
+			if !results[i].Success {
+				r.lg.Warnf("fail to read %s in multicall, call reverted", r.desc)
+				continue
+			}
+			if err = r.decode(results[i].ReturnData); err != nil {
+				r.lg.Warnf("fail to decode %s in multicall, err:%v", r.desc, err)
+			}
+		}
+	}
+}
+
+// sweepTransfers are the on-chain transfers read in batch for the rows of a sweep, by chain and transfer id.
+type sweepTransfers map[uint64]map[Hash]*TransferInfo
+
+// readTransfers reads the on-chain transfers of the rows on chains with a Multicall contract. The transfers
+// on other chains are read one by one as the rows are handled. Failed reads are logged with lg.
+func (s *server) readTransfers(txs []*Transfer, lg *logger) sweepTransfers {
+	tids := make(map[uint64][]Hash)
+	for _, tx := range txs {
+		tids[tx.ChainId] = append(tids[tx.ChainId], tx.TransferId)
+	}
+	remote := make(sweepTransfers)
+	for chainId, ids := range tids {
+		bc, found := s.chainMap[chainId]
+		if found && bc.multicaller != nil {
+			remote[chainId] = bc.getTransfers(lg, ids)
+		}
+	}
+	return remote
+}
+
+// getTransfer returns the transfer read for the sweep, or reads it now if it was not.
+func (st sweepTransfers) getTransfer(bc *bridgeConfig, tid Hash) (*TransferInfo, error) {
+	if transfer, found := st[bc.chainId.Uint64()][tid]; found {
+		return transfer, nil
+	}
+	return bc.getTransfer(tid)
+}
+
+// getTransfers returns the on-chain transfers at the safe block, read in batch with the Multicall contract of
+// the chain. Transfers failing to be read are not returned.
+func (bc *bridgeConfig) getTransfers(lg *logger, tids []Hash) map[Hash]*TransferInfo {
+	transfers := make(map[Hash]*TransferInfo)
+	reads := make([]*multicallRead, 0, len(tids))
+	for _, tid := range tids {
+		tid := tid
+		readLog := lg.with("transfer_id", tid, "chain_id", bc.chainId.Uint64())
+		input, err := cbridgeABI.Pack("transfers", tid)
+		if err != nil {
+			readLog.Errorf("fail to pack transfers call, err:%v", err)
+			return transfers
+		}
+		reads = append(reads, &multicallRead{
+			call: contracts.Multicall2Call{Target: bc.contractChain.GetAddr(), CallData: input},
+			decode: func(data []byte) error {
+				transfer, err := unpackTransfer(data)
+				if err == nil {
+					transfers[tid] = transfer
+				}
+				return err
+			},
+			fallback: func() error {
+				transfer, err := bc.getTransfer(tid)
+				if err == nil {
+					transfers[tid] = transfer
+				}
+				return err
+			},
+			desc: "transfer",
+			lg:   readLog,
+		})
+	}
+	bc.multicall(bc.safeBlockNumber(), lg, reads)
+	return transfers
+}
+
+func unpackTransfer(data []byte) (*TransferInfo, error) {
+	out, err := cbridgeABI.Unpack("transfers", data)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferInfo{
+		Sender:   *abi.ConvertType(out[0], new(Addr)).(*Addr),
+		Receiver: *abi.ConvertType(out[1], new(Addr)).(*Addr),
+		Token:    *abi.ConvertType(out[2], new(Addr)).(*Addr),
+		Amount:   *abi.ConvertType(out[3], new(*big.Int)).(**big.Int),
+		HashLock: *abi.ConvertType(out[4], new([32]byte)).(*[32]byte),
+		TimeLock: *abi.ConvertType(out[5], new(uint64)).(*uint64),
+		Status:   *abi.ConvertType(out[6], new(uint8)).(*uint8),
+	}, nil
+}
+
+// getTokenBalances returns the latest balances of the owner of the tokens in the config, read in batch if the
+// chain has a Multicall contract, otherwise one by one. Balances failing to be read are not returned.
+func (bc *bridgeConfig) getTokenBalances(lg *logger, owner Addr) map[Addr]*big.Int {
+	balances := make(map[Addr]*big.Int)
+	if bc.multicaller == nil {
+		for addr, erc20 := range bc.erc20Map {
+			balance, err := erc20.BalanceOf(nil, owner)
+			if err != nil {
+				lg.with("chain_id", bc.chainId.Uint64(), "token", addr).Warnf("fail to get this token balance, skip it, err:%v", err)
+				continue
+			}
+			balances[addr] = balance
+		}
+		return balances
+	}
+	input, err := erc20ABI.Pack("balanceOf", owner)
+	if err != nil {
+		lg.with("chain_id", bc.chainId.Uint64()).Errorf("fail to pack balanceOf call, err:%v", err)
+		return balances
+	}
+	reads := make([]*multicallRead, 0, len(bc.erc20Map))
+	for addr, erc20 := range bc.erc20Map {
+		addr, erc20 := addr, erc20
+		reads = append(reads, &multicallRead{
+			call: contracts.Multicall2Call{Target: addr, CallData: input},
+			decode: func(data []byte) error {
+				out, err := erc20ABI.Unpack("balanceOf", data)
+				if err != nil {
+					return err
+				}
+				balances[addr] = *abi.ConvertType(out[0], new(*big.Int)).(**big.Int)
+				return nil
+			},
+			fallback: func() error {
+				balance, err := erc20.BalanceOf(nil, owner)
+				if err == nil {
+					balances[addr] = balance
+				}
+				return err
+			},
+			desc: "token balance",
+			lg:   lg.with("chain_id", bc.chainId.Uint64(), "token", addr),
+		})
+	}
+	bc.multicall(nil, lg, reads)
+	return balances
+}
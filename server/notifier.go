@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/gatewayrpc"
+	"github.com/celer-network/goutils/log"
+)
+
+const (
+	defaultNotifyDedupWindow        = time.Hour
+	defaultNotifyRateLimitPerMinute = 20
+	defaultNotifyMaxRetry           = 3
+	defaultSendFailThreshold        = 3
+
+	notifyQueueSize     = 256
+	notifyHttpTimeout   = 10 * time.Second
+	notifyRetryInterval = 2 * time.Second
+
+	chainEndpointCheckTimeout = 10 * time.Second
+)
+
+type Severity string
+
+const (
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Notification is an operational event sent to the configured sinks.
+type Notification struct {
+	Event    cbn.NotifyEvent
+	Key      string // with Event, identifies the notification for deduplication, e.g. transfer id
+	Severity Severity
+	Message  string
+	Fields   map[string]string
+}
+
+// webhookPayload is the body posted to NOTIFY_SINK_WEBHOOK sinks.
+type webhookPayload struct {
+	Node     string            `json:"node"`
+	Event    string            `json:"event"`
+	Key      string            `json:"key"`
+	Severity Severity          `json:"severity"`
+	Message  string            `json:"message"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Ts       int64             `json:"ts"`
+}
+
+type notifySink struct {
+	cfg    *cbn.NotifySink
+	events map[cbn.NotifyEvent]bool
+	// send time of recent notifications, for rate limiting
+	sent []time.Time
+}
+
+type notifier struct {
+	nodeName    string
+	sinks       []*notifySink
+	client      *http.Client
+	dedupWindow time.Duration
+	rateLimit   int
+	maxRetry    int
+	retryDelay  time.Duration
+
+	lock     sync.Mutex
+	lastSent map[string]time.Time // dedup key -> time
+
+	queue chan *Notification
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+func newNotifier(cfg *cbn.NotifyConfig, nodeName string) *notifier {
+	n := &notifier{
+		nodeName:    nodeName,
+		client:      &http.Client{Timeout: notifyHttpTimeout},
+		dedupWindow: defaultNotifyDedupWindow,
+		rateLimit:   defaultNotifyRateLimitPerMinute,
+		maxRetry:    defaultNotifyMaxRetry,
+		retryDelay:  notifyRetryInterval,
+		lastSent:    make(map[string]time.Time),
+		queue:       make(chan *Notification, notifyQueueSize),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if cfg.GetDedupWindow() > 0 {
+		n.dedupWindow = time.Duration(cfg.GetDedupWindow()) * time.Second
+	}
+	if cfg.GetRateLimitPerMinute() > 0 {
+		n.rateLimit = int(cfg.GetRateLimitPerMinute())
+	}
+	if cfg.GetMaxRetry() > 0 {
+		n.maxRetry = int(cfg.GetMaxRetry())
+	}
+	for _, sinkCfg := range cfg.GetSinks() {
+		sink := &notifySink{cfg: sinkCfg}
+		if len(sinkCfg.GetEvents()) > 0 {
+			sink.events = make(map[cbn.NotifyEvent]bool)
+			for _, ev := range sinkCfg.GetEvents() {
+				sink.events[ev] = true
+			}
+		}
+		n.sinks = append(n.sinks, sink)
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues the notification for delivery, it never blocks.
+// Notifications with the same event and key within the dedup window are dropped.
+func (n *notifier) Notify(notification *Notification) {
+	if n == nil || len(n.sinks) == 0 {
+		return
+	}
+	dedupKey := notification.Event.String() + "/" + notification.Key
+	now := time.Now()
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for k, t := range n.lastSent {
+		if now.Sub(t) >= n.dedupWindow {
+			delete(n.lastSent, k)
+		}
+	}
+	if _, found := n.lastSent[dedupKey]; found {
+		return
+	}
+	// a dropped notification is not recorded, so the next one of the key is not deduped
+	lg := notifyLog.with("event", notification.Event.String(), "key", notification.Key)
+	select {
+	case n.queue <- notification:
+		n.lastSent[dedupKey] = now
+		lg.Warnf("queued notification: %s", notification.Message)
+	default:
+		lg.Errorf("notification queue is full, drop notification")
+	}
+}
+
+func (n *notifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.quit)
+	<-n.done
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	for {
+		select {
+		case <-n.quit:
+			return
+		case notification := <-n.queue:
+			for _, sink := range n.sinks {
+				if sink.events != nil && !sink.events[notification.Event] {
+					continue
+				}
+				if !sink.allow(time.Now(), n.rateLimit) {
+					notifyLog.with("event", notification.Event.String(), "key", notification.Key).Warnf(
+						"notify sink %s rate limited, drop notification", sink.cfg.GetType().String())
+					continue
+				}
+				n.deliver(sink, notification)
+			}
+		}
+	}
+}
+
+// allow reports whether the sink can send one more notification in the last minute.
+func (s *notifySink) allow(now time.Time, limit int) bool {
+	recent := s.sent[:0]
+	for _, t := range s.sent {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	s.sent = recent
+	if len(s.sent) >= limit {
+		return false
+	}
+	s.sent = append(s.sent, now)
+	return true
+}
+
+func (n *notifier) deliver(sink *notifySink, notification *Notification) {
+	lg := notifyLog.with("event", notification.Event.String(), "key", notification.Key)
+	body, err := n.payload(sink.cfg, notification)
+	if err != nil {
+		lg.Errorf("fail to build notification payload, sink:%s, err:%v", sink.cfg.GetType().String(), err)
+		return
+	}
+	for attempt := 0; attempt <= n.maxRetry; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-n.quit:
+				return
+			case <-time.After(n.retryDelay * time.Duration(1<<uint(attempt-1))):
+			}
+		}
+		retry, postErr := n.post(sink.cfg.GetUrl(), body)
+		if postErr == nil {
+			return
+		}
+		lg.Warnf("fail to deliver notification, sink:%s, attempt:%d, err:%v", sink.cfg.GetType().String(), attempt+1, postErr)
+		if !retry {
+			return
+		}
+	}
+	lg.Errorf("give up delivering notification, sink:%s", sink.cfg.GetType().String())
+}
+
+// post returns whether the failure is worth a retry.
+func (n *notifier) post(url string, body []byte) (bool, error) {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+func (n *notifier) payload(sinkCfg *cbn.NotifySink, notification *Notification) ([]byte, error) {
+	switch sinkCfg.GetType() {
+	case cbn.NotifySinkType_NOTIFY_SINK_SLACK:
+		return json.Marshal(map[string]string{"text": n.text(notification)})
+	case cbn.NotifySinkType_NOTIFY_SINK_TELEGRAM:
+		return json.Marshal(map[string]string{"chat_id": sinkCfg.GetChatId(), "text": n.text(notification)})
+	default:
+		return json.Marshal(&webhookPayload{
+			Node:     n.nodeName,
+			Event:    notification.Event.String(),
+			Key:      notification.Key,
+			Severity: notification.Severity,
+			Message:  notification.Message,
+			Fields:   notification.Fields,
+			Ts:       time.Now().Unix(),
+		})
+	}
+}
+
+// text formats the notification for chat sinks.
+func (n *notifier) text(notification *Notification) string {
+	lines := []string{fmt.Sprintf("[%s] %s %s", strings.ToUpper(string(notification.Severity)), n.nodeName,
+		strings.TrimPrefix(notification.Event.String(), "NOTIFY_EVENT_"))}
+	lines = append(lines, notification.Message)
+	var keys []string
+	for k := range notification.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %s", k, notification.Fields[k]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *server) notifyTransfer(event cbn.NotifyEvent, severity Severity, msg string, tx *Transfer) {
+	s.notifier.Notify(&Notification{
+		Event:    event,
+		Key:      tx.TransferId.String(),
+		Severity: severity,
+		Message:  msg,
+		Fields: map[string]string{
+			"transferId":     tx.TransferId.String(),
+			"type":           tx.TransferType.String(),
+			"status":         tx.Status.String(),
+			"chainId":        fmt.Sprintf("%d", tx.ChainId),
+			"relatedChainId": fmt.Sprintf("%d", tx.RelatedChainId),
+			"token":          s.getTokenName(tx.ChainId, tx.Token),
+			"amount":         tx.Amount.String(),
+			"timelock":       tx.TimeLock.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+func (s *server) recordSendFail(tx *Transfer, sendErr error) {
+	threshold := s.cfg.GetNotifyConfig().GetSendFailThreshold()
+	if threshold == 0 {
+		threshold = defaultSendFailThreshold
+	}
+	s.sendFailCountLock.Lock()
+	s.sendFailCount[tx.TransferId]++
+	count := s.sendFailCount[tx.TransferId]
+	s.sendFailCountLock.Unlock()
+	if count >= threshold {
+		s.notifyTransfer(cbn.NotifyEvent_NOTIFY_EVENT_SEND_FAILED, SeverityCritical,
+			fmt.Sprintf("transferIn failed %d times, last err: %v", count, sendErr), tx)
+	}
+}
+
+func (s *server) clearSendFail(transferId Hash) {
+	s.sendFailCountLock.Lock()
+	defer s.sendFailCountLock.Unlock()
+	delete(s.sendFailCount, transferId)
+}
+
+// clearPairSendFail drops the send fail count of the transfer pair of tx, called once tx is confirmed or refunded.
+// A transfer in never sent is not retried after its timelock, only the refund of its transfer out ends it.
+func (s *server) clearPairSendFail(tx *Transfer) {
+	s.sendFailCountLock.Lock()
+	defer s.sendFailCountLock.Unlock()
+	delete(s.sendFailCount, tx.TransferId)
+	delete(s.sendFailCount, tx.RelatedTid)
+}
+
+func (s *server) checkTokenBalance(bc *bridgeConfig, token Addr, balance *big.Int) {
+	for _, tokenConfig := range bc.config.GetTokenConfig() {
+		if Hex2Addr(tokenConfig.GetTokenAddress()) != token || tokenConfig.GetMinBalance() == "" {
+			continue
+		}
+		minBalance, ok := new(big.Int).SetString(tokenConfig.GetMinBalance(), 10)
+		if !ok {
+			log.Warnf("invalid min balance config, chain id:%d, token:%s", bc.chainId.Uint64(), tokenConfig.GetTokenName())
+			return
+		}
+		if balance.Cmp(minBalance) < 0 {
+			s.notifier.Notify(&Notification{
+				Event:    cbn.NotifyEvent_NOTIFY_EVENT_LOW_BALANCE,
+				Key:      fmt.Sprintf("%d/%s", bc.chainId.Uint64(), token.String()),
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("%s balance on chain %d is below floor", tokenConfig.GetTokenName(), bc.chainId.Uint64()),
+				Fields: map[string]string{
+					"chainId":    bc.chainId.String(),
+					"token":      tokenConfig.GetTokenName(),
+					"balance":    balance.String(),
+					"minBalance": minBalance.String(),
+				},
+			})
+		}
+		return
+	}
+}
+
+func (s *server) checkGasBalance(bc *bridgeConfig) {
+	if bc.config.GetMinGasBalance() == "" {
+		return
+	}
+	minBalance, ok := new(big.Int).SetString(bc.config.GetMinGasBalance(), 10)
+	if !ok {
+		log.Warnf("invalid min gas balance config, chain id:%d", bc.chainId.Uint64())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), chainEndpointCheckTimeout)
+	defer cancel()
+	balance, err := bc.ec.BalanceAt(ctx, s.accountAddr, nil)
+	if err != nil {
+		log.Warnf("fail to get gas balance, chain id:%d, err:%v", bc.chainId.Uint64(), err)
+		return
+	}
+	if balance.Cmp(minBalance) < 0 {
+		s.notifier.Notify(&Notification{
+			Event:    cbn.NotifyEvent_NOTIFY_EVENT_LOW_BALANCE,
+			Key:      fmt.Sprintf("%d/gas", bc.chainId.Uint64()),
+			Severity: SeverityCritical,
+			Message:  fmt.Sprintf("%s gas balance on chain %d is below floor", bc.config.GetGasTokenName(), bc.chainId.Uint64()),
+			Fields: map[string]string{
+				"chainId":    bc.chainId.String(),
+				"token":      bc.config.GetGasTokenName(),
+				"balance":    balance.String(),
+				"minBalance": minBalance.String(),
+			},
+		})
+	}
+}
+
+func (s *server) checkGatewayPermission(pingErr error) {
+	var gwErr *GatewayError
+	if errors.As(pingErr, &gwErr) && gwErr.Err.GetCode() == gatewayrpc.ErrCode_NODE_NOT_PERMITTED {
+		s.notifier.Notify(&Notification{
+			Event:    cbn.NotifyEvent_NOTIFY_EVENT_GATEWAY_NOT_PERMITTED,
+			Key:      s.cfg.GetGateway(),
+			Severity: SeverityCritical,
+			Message:  fmt.Sprintf("gateway rejected node %s: %s", s.accountAddr.String(), gwErr.Err.GetMsg()),
+			Fields:   map[string]string{"gateway": s.cfg.GetGateway()},
+		})
+	}
+}
+
+// checkChainEndpoints notifies for chains whose rpc endpoint does not respond.
+func (s *server) checkChainEndpoints() {
+	for chainId, bc := range s.chainMap {
+		ctx, cancel := context.WithTimeout(context.Background(), chainEndpointCheckTimeout)
+		_, err := bc.ec.BlockNumber(ctx)
+		cancel()
+		if err != nil {
+			s.notifier.Notify(&Notification{
+				Event:    cbn.NotifyEvent_NOTIFY_EVENT_RPC_DOWN,
+				Key:      fmt.Sprintf("%d", chainId),
+				Severity: SeverityCritical,
+				Message:  fmt.Sprintf("rpc endpoint of chain %d is down: %v", chainId, err),
+				Fields:   map[string]string{"chainId": fmt.Sprintf("%d", chainId)},
+			})
+		}
+	}
+}
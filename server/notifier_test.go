@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+// testSink is a local http sink recording received bodies.
+type testSink struct {
+	*httptest.Server
+	lock   sync.Mutex
+	bodies [][]byte
+	// status codes returned in order, 200 after all consumed
+	statuses []int
+}
+
+func newTestSink(statuses ...int) *testSink {
+	s := &testSink{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.lock.Lock()
+		s.bodies = append(s.bodies, body)
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status = s.statuses[0]
+			s.statuses = s.statuses[1:]
+		}
+		s.lock.Unlock()
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *testSink) received() [][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([][]byte{}, s.bodies...)
+}
+
+// waitReceived waits until the sink gets n requests, and checks no more come in shortly after.
+func (s *testSink) waitReceived(t *testing.T, n int) [][]byte {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.received()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	bodies := s.received()
+	if len(bodies) != n {
+		t.Fatalf("expect %d requests, got %d", n, len(bodies))
+	}
+	return bodies
+}
+
+func newTestNotifier(cfg *cbn.NotifyConfig) *notifier {
+	n := newNotifier(cfg, "test-node")
+	n.retryDelay = 10 * time.Millisecond
+	return n
+}
+
+func TestNotifierPayloads(t *testing.T) {
+	webhook, slack, telegram := newTestSink(), newTestSink(), newTestSink()
+	defer webhook.Close()
+	defer slack.Close()
+	defer telegram.Close()
+	n := newTestNotifier(&cbn.NotifyConfig{
+		Sinks: []*cbn.NotifySink{
+			{Type: cbn.NotifySinkType_NOTIFY_SINK_WEBHOOK, Url: webhook.URL},
+			{Type: cbn.NotifySinkType_NOTIFY_SINK_SLACK, Url: slack.URL},
+			{Type: cbn.NotifySinkType_NOTIFY_SINK_TELEGRAM, Url: telegram.URL, ChatId: "-100"},
+		},
+	})
+	defer n.Close()
+
+	n.Notify(&Notification{
+		Event:    cbn.NotifyEvent_NOTIFY_EVENT_RPC_DOWN,
+		Key:      "5",
+		Severity: SeverityCritical,
+		Message:  "rpc endpoint of chain 5 is down",
+		Fields:   map[string]string{"chainId": "5"},
+	})
+
+	var wp webhookPayload
+	if err := json.Unmarshal(webhook.waitReceived(t, 1)[0], &wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Node != "test-node" || wp.Event != "NOTIFY_EVENT_RPC_DOWN" || wp.Key != "5" ||
+		wp.Severity != SeverityCritical || wp.Fields["chainId"] != "5" {
+		t.Errorf("unexpected webhook payload %+v", wp)
+	}
+
+	var sp map[string]string
+	if err := json.Unmarshal(slack.waitReceived(t, 1)[0], &sp); err != nil {
+		t.Fatal(err)
+	}
+	expText := "[CRITICAL] test-node RPC_DOWN\nrpc endpoint of chain 5 is down\nchainId: 5"
+	if sp["text"] != expText {
+		t.Errorf("unexpected slack text %q", sp["text"])
+	}
+
+	var tp map[string]string
+	if err := json.Unmarshal(telegram.waitReceived(t, 1)[0], &tp); err != nil {
+		t.Fatal(err)
+	}
+	if tp["chat_id"] != "-100" || tp["text"] != expText {
+		t.Errorf("unexpected telegram payload %v", tp)
+	}
+}
+
+func TestNotifierDedupAndEventFilter(t *testing.T) {
+	sink := newTestSink()
+	defer sink.Close()
+	n := newTestNotifier(&cbn.NotifyConfig{
+		Sinks: []*cbn.NotifySink{{
+			Url:    sink.URL,
+			Events: []cbn.NotifyEvent{cbn.NotifyEvent_NOTIFY_EVENT_TRANSFER_REFUNDED},
+		}},
+	})
+	defer n.Close()
+
+	logs := captureLogs(t)
+	refunded := &Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_TRANSFER_REFUNDED, Key: "0x01"}
+	n.Notify(refunded)
+	n.Notify(refunded)
+	n.Notify(&Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_TRANSFER_REFUNDED, Key: "0x02"})
+	n.Notify(&Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_LOW_BALANCE, Key: "0x01"})
+	sink.waitReceived(t, 2)
+	// the deduped notification is not logged
+	if got := strings.Count(logs.String(), "[notify] queued notification"); got != 3 {
+		t.Fatalf("expect 3 queued notifications logged, got %d in %q", got, logs.String())
+	}
+}
+
+func TestNotifierDedupAfterDrop(t *testing.T) {
+	n := newTestNotifier(&cbn.NotifyConfig{Sinks: []*cbn.NotifySink{{Url: "http://127.0.0.1:1"}}})
+	// stop the delivery so the queue fills up
+	n.Close()
+	for i := 0; i < notifyQueueSize; i++ {
+		n.Notify(&Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_LOW_BALANCE, Key: fmt.Sprintf("%d", i)})
+	}
+	refunded := &Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_TRANSFER_REFUNDED, Key: "0x01"}
+	n.Notify(refunded)
+	if _, found := n.lastSent["NOTIFY_EVENT_TRANSFER_REFUNDED/0x01"]; found {
+		t.Fatal("expect dropped notification not recorded")
+	}
+	// the next one is not deduped
+	<-n.queue
+	n.Notify(refunded)
+	if len(n.queue) != notifyQueueSize {
+		t.Fatalf("expect notification queued after the drop, got %d queued", len(n.queue))
+	}
+	if _, found := n.lastSent["NOTIFY_EVENT_TRANSFER_REFUNDED/0x01"]; !found {
+		t.Fatal("expect queued notification recorded")
+	}
+}
+
+func TestSendFailCountCleared(t *testing.T) {
+	s, _ := newPreimageTestServer(t)
+	bc, eventLog := newTestEventBridge(t)
+	timeLock := time.Now().Add(time.Hour)
+	out := newTestTransfer(0x50, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, timeLock)
+	in := newTestTransfer(0x51, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START, timeLock)
+	out.RelatedTid, in.RelatedTid = in.TransferId, out.TransferId
+	for _, tx := range []*Transfer{out, in} {
+		if err := s.db.InsertTransfer(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.recordSendFail(in, errors.New("send failed"))
+	s.recordSendFail(in, errors.New("send failed"))
+	if count := s.sendFailCount[in.TransferId]; count != 2 {
+		t.Fatalf("expect 2 send fails, got %d", count)
+	}
+
+	// the transfer in was never sent, the user refunds the transfer out after its timelock
+	if err := s.handleLogRefund(bc, eventLog(evLogTransferRefunded, out.TransferId)); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sendFailCount) != 0 {
+		t.Fatalf("expect send fail count cleared, got %v", s.sendFailCount)
+	}
+}
+
+func TestNotifierRateLimit(t *testing.T) {
+	sink := newTestSink()
+	defer sink.Close()
+	n := newTestNotifier(&cbn.NotifyConfig{
+		Sinks:              []*cbn.NotifySink{{Url: sink.URL}},
+		RateLimitPerMinute: 2,
+	})
+	defer n.Close()
+
+	for _, key := range []string{"1", "2", "3"} {
+		n.Notify(&Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_RPC_DOWN, Key: key})
+	}
+	sink.waitReceived(t, 2)
+}
+
+func TestNotifierRetry(t *testing.T) {
+	sink := newTestSink(http.StatusInternalServerError, http.StatusTooManyRequests)
+	defer sink.Close()
+	n := newTestNotifier(&cbn.NotifyConfig{Sinks: []*cbn.NotifySink{{Url: sink.URL}}})
+	defer n.Close()
+	n.Notify(&Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_RPC_DOWN, Key: "1"})
+	sink.waitReceived(t, 3)
+
+	// client errors are not retried
+	badSink := newTestSink(http.StatusBadRequest)
+	defer badSink.Close()
+	n2 := newTestNotifier(&cbn.NotifyConfig{Sinks: []*cbn.NotifySink{{Url: badSink.URL}}})
+	defer n2.Close()
+	n2.Notify(&Notification{Event: cbn.NotifyEvent_NOTIFY_EVENT_RPC_DOWN, Key: "1"})
+	badSink.waitReceived(t, 1)
+}
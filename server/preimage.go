@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+var preimageMismatches = newCounter("cbridge/preimage/mismatches")
+
+func verifyPreimage(preimage, hashLock Hash) bool {
+	return getHashLockWithPreImage(preimage) == hashLock
+}
+
+// checkPreimage verifies the preimage against the hashlock of the transfer before it is stored or used.
+// On mismatch the transfer is marked and an alert is sent, source tells where the preimage came from.
+func (s *server) checkPreimage(tx *Transfer, preimage Hash, source string) bool {
+	if verifyPreimage(preimage, tx.HashLock) {
+		return true
+	}
+	preimageMismatches.Inc(1)
+	lg := confirmerLog.transfer(tx)
+	lg.Errorf("preimage does not match hashlock, source:%s, hashLock:%x, preimage:%x", source, tx.HashLock, preimage)
+	dbErr := s.db.MarkPreimageMismatch(tx.TransferId)
+	if dbErr != nil {
+		lg.Errorf("fail to mark preimage mismatch, err:%v", dbErr)
+	}
+	s.notifier.Notify(&Notification{
+		Event:    cbn.NotifyEvent_NOTIFY_EVENT_PREIMAGE_MISMATCH,
+		Key:      tx.TransferId.String(),
+		Severity: SeverityCritical,
+		Message:  fmt.Sprintf("preimage from %s does not match hashlock of transfer %x", source, tx.TransferId),
+		Fields: map[string]string{
+			"transferId": tx.TransferId.String(),
+			"chainId":    fmt.Sprintf("%d", tx.ChainId),
+			"hashLock":   tx.HashLock.String(),
+			"preimage":   preimage.String(),
+			"source":     source,
+		},
+	})
+	return false
+}
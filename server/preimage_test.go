@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/contracts"
+	"github.com/celer-network/cBridge-go/layer1"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+func newPreimageTestServer(t *testing.T) (*server, *testSink) {
+	store, err := NewSqliteDAL(filepath.Join(t.TempDir(), "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	sink := newTestSink()
+	t.Cleanup(sink.Close)
+	s := NewServer("test")
+	s.db = store
+	s.notifier = newTestNotifier(&cbn.NotifyConfig{Sinks: []*cbn.NotifySink{{Url: sink.URL}}})
+	t.Cleanup(s.notifier.Close)
+	return s, sink
+}
+
+// newTestEventBridge returns a bridge of chain 5 able to parse the events returned by eventLog, which have
+// the non indexed args given.
+func newTestEventBridge(t *testing.T) (*bridgeConfig, func(event string, args ...Hash) ethtypes.Log) {
+	cbridge, err := layer1.NewBoundContract(nil, Bytes2Addr([]byte{0x42}), contracts.CBridgeABI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedABI, err := abi.JSON(strings.NewReader(contracts.CBridgeABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventLog := func(event string, args ...Hash) ethtypes.Log {
+		l := ethtypes.Log{
+			Address: cbridge.GetAddr(),
+			Topics:  []Hash{parsedABI.Events[event].ID},
+			TxHash:  Bytes2Hash([]byte{args[0][31], 9}),
+		}
+		for _, arg := range args {
+			l.Data = append(l.Data, arg.Bytes()...)
+		}
+		return l
+	}
+	return &bridgeConfig{chainId: big.NewInt(5), contractChain: cbridge}, eventLog
+}
+
+func TestVerifyPreimage(t *testing.T) {
+	preimage := Bytes2Hash([]byte{0x40, 2})
+	hashLock := getHashLockWithPreImage(preimage)
+	if !verifyPreimage(preimage, hashLock) {
+		t.Error("expect preimage verified")
+	}
+	if verifyPreimage(Bytes2Hash([]byte{0x40, 3}), hashLock) || verifyPreimage(Hash{}, hashLock) || verifyPreimage(preimage, preimage) {
+		t.Error("expect wrong preimage rejected")
+	}
+}
+
+func TestCheckPreimage(t *testing.T) {
+	s, sink := newPreimageTestServer(t)
+	tx := newTestTransfer(0x41, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now().Add(time.Hour))
+	if err := s.db.InsertTransfer(tx); err != nil {
+		t.Fatal(err)
+	}
+	preimage, badPreimage := Bytes2Hash([]byte{0x41, 2}), Bytes2Hash([]byte{0x41, 0xff})
+	mismatches := preimageMismatches.Count()
+
+	if !s.checkPreimage(tx, preimage, "test") {
+		t.Fatal("expect preimage verified")
+	}
+	if got := mustGetTransfer(t, s.db, tx.TransferId).PreimageStatus; got != cbn.PreimageStatus_PREIMAGE_STATUS_NONE {
+		t.Fatalf("expect preimage status none, got %s", got)
+	}
+
+	if s.checkPreimage(tx, badPreimage, "test") {
+		t.Fatal("expect wrong preimage rejected")
+	}
+	if got := mustGetTransfer(t, s.db, tx.TransferId).PreimageStatus; got != cbn.PreimageStatus_PREIMAGE_STATUS_MISMATCH {
+		t.Fatalf("expect preimage status mismatch, got %s", got)
+	}
+	if got := preimageMismatches.Count(); got != mismatches+1 {
+		t.Fatalf("expect 1 mismatch counted, got %d", got-mismatches)
+	}
+	var wp webhookPayload
+	if err := json.Unmarshal(sink.waitReceived(t, 1)[0], &wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Event != "NOTIFY_EVENT_PREIMAGE_MISMATCH" || wp.Severity != SeverityCritical ||
+		wp.Fields["preimage"] != badPreimage.String() || wp.Fields["source"] != "test" {
+		t.Errorf("unexpected notification %+v", wp)
+	}
+
+	// a stored wrong preimage is not confirmed, bc.confirm refuses it before sending the tx
+	tx.Preimage = badPreimage
+	s.tryConfirmTransfer(tx, nil)
+	if got := mustGetTransfer(t, s.db, tx.TransferId).Status; got != cbn.TransferStatus_TRANSFER_STATUS_LOCKED {
+		t.Fatalf("expect transfer still locked, got %s", got)
+	}
+	bc := &bridgeConfig{chainId: big.NewInt(5)}
+	if err := bc.confirm(context.Background(), confirmerLog.transfer(tx), tx.TransferId, badPreimage, tx.HashLock, nil); err == nil {
+		t.Fatal("expect confirm with wrong preimage refused")
+	}
+}
+
+func TestConfirmEventPreimage(t *testing.T) {
+	s, sink := newPreimageTestServer(t)
+	bc, eventLog := newTestEventBridge(t)
+
+	timeLock := time.Now().Add(time.Hour)
+	// the transfer in of the user is confirmed on chain, revealing the preimage of its hashlock
+	newPair := func(id byte, outHashLock func(in *Transfer) Hash) (in, out *Transfer) {
+		in = newTestTransfer(id, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING, timeLock)
+		out = newTestTransfer(id+1, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, timeLock.Add(time.Hour))
+		out.RelatedTid = in.TransferId
+		out.HashLock = outHashLock(in)
+		for _, tx := range []*Transfer{in, out} {
+			if err := s.db.InsertTransfer(tx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return in, out
+	}
+
+	in, out := newPair(0x43, func(in *Transfer) Hash { return in.HashLock })
+	preimage := Bytes2Hash([]byte{0x43, 2})
+	if err := s.handleLogConfirm(bc, eventLog(evLogTransferConfirmed, in.TransferId, preimage)); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGetTransfer(t, s.db, in.TransferId); got.Status != cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED ||
+		got.Preimage != preimage || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED {
+		t.Fatalf("unexpected transfer in %+v", got)
+	}
+	if got := mustGetTransfer(t, s.db, out.TransferId); got.Preimage != preimage || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED {
+		t.Fatalf("expect verified preimage on transfer out, got %x %s", got.Preimage, got.PreimageStatus)
+	}
+	if ids := s.confirmQueue.take(); len(ids) != 1 || ids[0] != out.TransferId {
+		t.Fatalf("expect transfer out queued for confirm, got %x", ids)
+	}
+
+	// the hashlock of the transfer out differs, the revealed preimage cannot confirm it
+	in, out = newPair(0x45, func(in *Transfer) Hash { return getHashLockWithPreImage(Bytes2Hash([]byte{0x46, 2})) })
+	preimage = Bytes2Hash([]byte{0x45, 2})
+	if err := s.handleLogConfirm(bc, eventLog(evLogTransferConfirmed, in.TransferId, preimage)); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGetTransfer(t, s.db, in.TransferId); got.Status != cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED || got.Preimage != preimage {
+		t.Fatalf("unexpected transfer in %+v", got)
+	}
+	if got := mustGetTransfer(t, s.db, out.TransferId); got.Preimage != (Hash{}) || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_MISMATCH {
+		t.Fatalf("expect no preimage on transfer out, got %x %s", got.Preimage, got.PreimageStatus)
+	}
+	if ids := s.confirmQueue.take(); len(ids) != 0 {
+		t.Fatalf("expect nothing queued, got %x", ids)
+	}
+	var wp webhookPayload
+	if err := json.Unmarshal(sink.waitReceived(t, 1)[0], &wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Event != "NOTIFY_EVENT_PREIMAGE_MISMATCH" || wp.Key != out.TransferId.String() || wp.Fields["source"] != "related confirm event" {
+		t.Errorf("unexpected notification %+v", wp)
+	}
+}
@@ -0,0 +1,426 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/contracts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+var errInvalidRebalance = errors.New("invalid rebalance")
+
+const (
+	defaultRebalanceTimeLock = 24 * time.Hour
+	// relay nodes require the src timelock to be more than 16 hours when they process the transferOut
+	minRebalanceTimeLock = 17 * time.Hour
+	// the transferIn of a rebalance must expire this long before its transferOut
+	minRebalanceTimeLockGap = 2 * time.Hour
+
+	defaultRebalanceMaxFeeRate = 100 // ten-thousandth
+)
+
+// Rebalance moves the node's own liquidity from src chain to dst chain through another relay node,
+// as a normal cBridge user:
+//  1. generate a random preimage and store it with the rebalance before sending transferOut on src chain
+//  2. the relay node locks transferIn to us on dst chain, seen by monitorLogTransferIn or processRebalance
+//  3. confirm transferIn on dst chain, which reveals the preimage to the relay node
+//  4. if transferIn does not come before the src timelock, refund transferOut
+func (s *server) Rebalance(srcChainId, dstChainId uint64, tokenName string, amount *big.Int, bridgeAddr Addr, timeLock time.Duration) (*Rebalance, error) {
+	srcBc, found := s.chainMap[srcChainId]
+	if !found {
+		return nil, fmt.Errorf("%w, chain %d not found", errInvalidRebalance, srcChainId)
+	}
+	if _, found = s.chainMap[dstChainId]; !found {
+		return nil, fmt.Errorf("%w, chain %d not found", errInvalidRebalance, dstChainId)
+	}
+	if srcChainId == dstChainId {
+		return nil, fmt.Errorf("%w, src and dst chain are the same", errInvalidRebalance)
+	}
+	srcToken, found := s.chainTokenAddrMap[srcChainId][tokenName]
+	if !found {
+		return nil, fmt.Errorf("%w, token %s not found on chain %d", errInvalidRebalance, tokenName, srcChainId)
+	}
+	dstToken, found := s.chainTokenAddrMap[dstChainId][tokenName]
+	if !found {
+		return nil, fmt.Errorf("%w, token %s not found on chain %d", errInvalidRebalance, tokenName, dstChainId)
+	}
+	if amount.Sign() <= 0 {
+		return nil, fmt.Errorf("%w, invalid amount %s", errInvalidRebalance, amount)
+	}
+	if bridgeAddr == (Addr{}) || bridgeAddr == s.accountAddr {
+		return nil, fmt.Errorf("%w, invalid bridge addr %s, must be another relay node", errInvalidRebalance, bridgeAddr.String())
+	}
+	if timeLock == 0 {
+		timeLock = defaultRebalanceTimeLock
+	}
+	if timeLock < minRebalanceTimeLock {
+		return nil, fmt.Errorf("%w, timelock %s too small, should be at least %s", errInvalidRebalance, timeLock, minRebalanceTimeLock)
+	}
+
+	preimage, err := getRandomPreImage()
+	if err != nil {
+		return nil, fmt.Errorf("fail to generate preimage: %w", err)
+	}
+	hashLock := getHashLockWithPreImage(preimage)
+	r := &Rebalance{
+		TransferId:    getTransferId(s.accountAddr, bridgeAddr, hashLock, srcChainId),
+		SrcChainId:    srcChainId,
+		DstChainId:    dstChainId,
+		SrcToken:      srcToken,
+		DstToken:      dstToken,
+		Amount:        *amount,
+		BridgeAddr:    bridgeAddr,
+		HashLock:      hashLock,
+		Preimage:      preimage,
+		TimeLock:      time.Unix(time.Now().Add(timeLock).Unix(), 0),
+		DstTransferId: getTransferId(bridgeAddr, s.accountAddr, hashLock, dstChainId),
+		Status:        cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING,
+	}
+	// the preimage must be durable before any fund is locked
+	err = s.db.InsertRebalance(r)
+	if err != nil {
+		return nil, fmt.Errorf("fail to save rebalance: %w", err)
+	}
+	err = srcBc.transferOut(r, s.accountAddr, func(success bool) {
+		to := cbn.RebalanceStatus_REBALANCE_STATUS_FAILED
+		if success {
+			to = cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED
+		}
+		dbErr := s.db.SetRebalanceStatusByFrom(r.TransferId, to, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING)
+		if dbErr != nil {
+			rebalanceLog.rebalance(r).Errorf("fail to update rebalance status to %s, err:%v", to, dbErr)
+		}
+	})
+	if err != nil {
+		dbErr := s.db.SetRebalanceStatusByFrom(r.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_FAILED, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING)
+		if dbErr != nil {
+			rebalanceLog.rebalance(r).Errorf("fail to update rebalance status to failed, err:%v", dbErr)
+		}
+		return nil, fmt.Errorf("fail to send transferOut: %w", err)
+	}
+	return r, nil
+}
+
+func (bc *bridgeConfig) transferOut(r *Rebalance, dstAddr Addr, onMined func(success bool)) error {
+	lg := rebalanceLog.rebalance(r)
+	ctx, span := startRebalanceSpan("rebalance transfer out", r)
+	defer span.End()
+	lg.Infof("start rebalance transfer out, dstChainId:%d, bridge:%s, amount:%s", r.DstChainId, r.BridgeAddr.String(), r.Amount.String())
+	// may still be mined later after an error, processRebalance checks the on-chain status
+	handler := logTransactionStateHandler(lg, "rebalance transferOut")
+	logMined := handler.OnMined
+	handler.OnMined = func(receipt *ethtypes.Receipt) {
+		logMined(receipt)
+		onMined(receipt.Status == ethtypes.ReceiptStatusSuccessful)
+	}
+	_, err := bc.transact(ctx, lg, cbn.TxPurpose_TX_PURPOSE_REBALANCE_OUT, r.TransferId, handler,
+		func(ctr bind.ContractTransactor, opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+			cbt, err2 := contracts.NewCBridgeTransactor(bc.contractChain.GetAddr(), ctr)
+			if err2 != nil {
+				return nil, err2
+			}
+			amount := r.Amount
+			return cbt.TransferOut(opts, r.BridgeAddr, r.SrcToken, &amount, r.HashLock, uint64(r.TimeLock.Unix()), r.DstChainId, dstAddr)
+		},
+	)
+	return err
+}
+
+// onRebalanceTransferIn handles a transferIn to us, locked by the relay node of one of our rebalances.
+func (s *server) onRebalanceTransferIn(bc *bridgeConfig, ev *contracts.CBridgeLogNewTransferIn) error {
+	lg := rebalanceLog.with("transfer_id", ev.TransferId, "related_tid", ev.SrcTransferId, "chain_id", bc.chainId.Uint64(), "direction", "in")
+	r, found, dbErr := s.db.GetRebalanceByDstTid(ev.TransferId)
+	if dbErr != nil {
+		return dbErr
+	}
+	if !found || r.DstChainId != bc.chainId.Uint64() || (r.Status != cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING &&
+		r.Status != cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED) {
+		lg.Infof("transfer in to us is not a pending rebalance")
+		return nil
+	}
+	in := &TransferInfo{
+		Sender:   ev.Sender,
+		Receiver: ev.Receiver,
+		Token:    ev.Token,
+		Amount:   ev.Amount,
+		HashLock: ev.Hashlock,
+		TimeLock: ev.Timelock,
+	}
+	if err := s.checkRebalanceTransferIn(r, in); err != nil {
+		rebalanceLog.rebalance(r).Warnf("rebalance transfer in not accepted, err:%v", err)
+		return nil
+	}
+	found, dbErr = s.db.RecordRebalanceTransferIn(r.TransferId, ev.TransferId, ev.Amount)
+	if dbErr != nil {
+		return dbErr
+	}
+	if !found {
+		lg.Infof("transfer in to us is not a pending rebalance")
+		return nil
+	}
+	lg.Infof("rebalance transfer in locked, amount:%s", ev.Amount.String())
+	return nil
+}
+
+// checkRebalanceTransferIn checks the transferIn locked to us pays the rebalance before the preimage is revealed
+// by confirming it: it is sent by the relay node of the rebalance in the dst token, for no less than the amount
+// less the max fee, and it expires safely before transferOut so the relay node can confirm transferOut once it
+// learns the preimage.
+func (s *server) checkRebalanceTransferIn(r *Rebalance, in *TransferInfo) error {
+	if in.HashLock != r.HashLock || in.Receiver != s.accountAddr {
+		return fmt.Errorf("hashlock %x or receiver %s mismatch", in.HashLock, in.Receiver.String())
+	}
+	if in.Sender != r.BridgeAddr {
+		return fmt.Errorf("sender %s is not the bridge addr %s", in.Sender.String(), r.BridgeAddr.String())
+	}
+	if in.Token != r.DstToken {
+		return fmt.Errorf("token %s is not the dst token %s", in.Token.String(), r.DstToken.String())
+	}
+	minAmount := s.minRebalanceDstAmount(r)
+	if in.Amount == nil || in.Amount.Cmp(minAmount) < 0 {
+		return fmt.Errorf("amount %s less than the min amount %s", in.Amount, minAmount)
+	}
+	if maxTimeLock := r.TimeLock.Add(-minRebalanceTimeLockGap); time.Unix(int64(in.TimeLock), 0).After(maxTimeLock) {
+		return fmt.Errorf("timelock %d not %s before the rebalance timelock %d", in.TimeLock, minRebalanceTimeLockGap, r.TimeLock.Unix())
+	}
+	return nil
+}
+
+// minRebalanceDstAmount returns the rebalance amount in the dst token base unit less the max fee.
+func (s *server) minRebalanceDstAmount(r *Rebalance) *big.Int {
+	feeRate := s.cfg.GetRebalanceMaxFeeRate()
+	if feeRate == 0 {
+		feeRate = defaultRebalanceMaxFeeRate
+	}
+	if feeRate > 10000 {
+		feeRate = 10000
+	}
+	amount := convertTokenAmount(&r.Amount, s.getTokenDecimal(r.SrcChainId, r.SrcToken), s.getTokenDecimal(r.DstChainId, r.DstToken))
+	minAmount := new(big.Int).Mul(amount, new(big.Int).SetUint64(10000-feeRate))
+	return minAmount.Div(minAmount, big.NewInt(10000))
+}
+
+// onRebalanceEvent updates the rebalance on the confirm event of its transferIn or the refund event of its transferOut.
+func (s *server) onRebalanceEvent(transferId Hash, to cbn.RebalanceStatus) error {
+	var r *Rebalance
+	var found bool
+	var dbErr error
+	if to == cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRMED {
+		r, found, dbErr = s.db.GetRebalanceByDstTid(transferId)
+	} else {
+		r, found, dbErr = s.db.GetRebalance(transferId)
+	}
+	if dbErr != nil || !found {
+		return dbErr
+	}
+	rebalanceLog.rebalance(r).Infof("rebalance %s", to.String())
+	return s.db.SetRebalanceStatusByFrom(r.TransferId, to, r.Status)
+}
+
+func (s *server) ProcessRebalance() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.processRebalance()
+		}
+	}
+}
+
+func (s *server) processRebalance() {
+	rebalances, dbErr := s.db.GetActiveRebalances()
+	if dbErr != nil {
+		rebalanceLog.Warnf("fail to query active rebalances, err:%s", dbErr)
+		return
+	}
+	for _, r := range rebalances {
+		srcBc, foundSrc := s.chainMap[r.SrcChainId]
+		dstBc, foundDst := s.chainMap[r.DstChainId]
+		if !foundSrc || !foundDst {
+			rebalanceLog.rebalance(r).Warnf("skip rebalance, chain not found, dstChainId:%d", r.DstChainId)
+			continue
+		}
+		switch r.Status {
+		case cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED:
+			s.processRebalanceOut(r, srcBc, dstBc)
+		case cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED:
+			s.processRebalanceConfirm(r, srcBc, dstBc)
+		case cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRM_PENDING:
+			s.processRebalanceConfirmPending(r, dstBc)
+		case cbn.RebalanceStatus_REBALANCE_STATUS_REFUND_PENDING:
+			s.processRebalanceRefundPending(r, srcBc)
+		}
+	}
+}
+
+// processRebalanceOut waits for the transferIn, in case its event is missed, and refunds on expiry.
+func (s *server) processRebalanceOut(r *Rebalance, srcBc, dstBc *bridgeConfig) {
+	remoteIn, err := dstBc.getTransfer(r.DstTransferId)
+	if err != nil {
+		rebalanceLog.rebalance(r).Errorf("fail to get rebalance transfer in, err:%v", err)
+		return
+	}
+	if remoteIn.Status == remoteTransferStatusPending {
+		if err = s.checkRebalanceTransferIn(r, remoteIn); err != nil {
+			rebalanceLog.rebalance(r).Warnf("rebalance transfer in not accepted, err:%v", err)
+		} else {
+			_, dbErr := s.db.RecordRebalanceTransferIn(r.TransferId, r.DstTransferId, remoteIn.Amount)
+			if dbErr != nil {
+				rebalanceLog.rebalance(r).Errorf("fail to record rebalance transfer in, err:%v", dbErr)
+			}
+			return
+		}
+	}
+
+	if r.Status == cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING && time.Since(r.UpdateTs) > maxPendingTimeOutRetryDuration {
+		remoteOut, err := srcBc.getTransfer(r.TransferId)
+		if err != nil {
+			rebalanceLog.rebalance(r).Errorf("fail to get rebalance transfer out, err:%v", err)
+			return
+		}
+		to := cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED
+		if remoteOut.Status == remoteTransferStatusUndefined {
+			rebalanceLog.rebalance(r).Warnf("rebalance transfer out not found on chain, set it failed")
+			to = cbn.RebalanceStatus_REBALANCE_STATUS_FAILED
+		}
+		dbErr := s.db.SetRebalanceStatusByFrom(r.TransferId, to, r.Status)
+		if dbErr != nil {
+			rebalanceLog.rebalance(r).Errorf("fail to update rebalance status to %s, err:%v", to, dbErr)
+		}
+		return
+	}
+
+	if time.Now().After(r.TimeLock.Add(refundSafeMargin)) {
+		s.refundRebalance(r, srcBc)
+	}
+}
+
+// processRebalanceConfirm reveals the preimage by confirming transferIn on dst chain.
+func (s *server) processRebalanceConfirm(r *Rebalance, srcBc, dstBc *bridgeConfig) {
+	remoteIn, err := dstBc.getTransfer(r.DstTransferId)
+	if err != nil {
+		rebalanceLog.rebalance(r).Errorf("fail to get rebalance transfer in, err:%v", err)
+		return
+	}
+	if remoteIn.Status == remoteTransferStatusPending {
+		// checked again as the transferIn is only safe to confirm if it pays the rebalance
+		if err = s.checkRebalanceTransferIn(r, remoteIn); err != nil {
+			rebalanceLog.rebalance(r).Warnf("rebalance transfer in not accepted, err:%v", err)
+			remoteIn.Status = remoteTransferStatusUndefined
+		}
+	}
+	if remoteIn.Status != remoteTransferStatusPending || time.Now().Add(timeLockSafeMargin).After(time.Unix(int64(remoteIn.TimeLock), 0)) {
+		// transferIn is refunded, about to expire or not accepted, the preimage is not revealed so transferOut can be refunded
+		if time.Now().After(r.TimeLock.Add(refundSafeMargin)) {
+			s.refundRebalance(r, srcBc)
+		}
+		return
+	}
+	lg := rebalanceLog.rebalance(r)
+	dbErr := s.db.SetRebalanceStatusByFrom(r.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRM_PENDING, cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED)
+	if dbErr != nil {
+		lg.Errorf("fail to update rebalance status to confirm pending, err:%v", dbErr)
+		return
+	}
+	// the confirm is of the transfer in on dst chain
+	lg = lg.with("transfer_id", r.DstTransferId, "related_tid", r.TransferId, "chain_id", r.DstChainId, "direction", "in",
+		"status", cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRM_PENDING)
+	ctx, span := startRebalanceSpan("rebalance confirm", r)
+	defer span.End()
+	err = dstBc.confirm(ctx, lg, r.DstTransferId, r.Preimage, r.HashLock, nil)
+	if err != nil {
+		lg.Errorf("fail to confirm rebalance transfer in, err:%v", err)
+	}
+}
+
+func (s *server) processRebalanceConfirmPending(r *Rebalance, dstBc *bridgeConfig) {
+	remoteIn, err := dstBc.getTransfer(r.DstTransferId)
+	if err != nil {
+		rebalanceLog.rebalance(r).Errorf("fail to get rebalance transfer in, err:%v", err)
+		return
+	}
+	switch {
+	case remoteIn.Status == remoteTransferStatusConfirmed:
+		s.setRebalanceStatus(r, cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRMED)
+	case remoteIn.Status == remoteTransferStatusPending && time.Since(r.UpdateTs) > maxPendingTimeOutRetryDuration:
+		s.setRebalanceStatus(r, cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED)
+	}
+}
+
+func (s *server) processRebalanceRefundPending(r *Rebalance, srcBc *bridgeConfig) {
+	remoteOut, err := srcBc.getTransfer(r.TransferId)
+	if err != nil {
+		rebalanceLog.rebalance(r).Errorf("fail to get rebalance transfer out, err:%v", err)
+		return
+	}
+	switch {
+	case remoteOut.Status == remoteTransferStatusRefunded:
+		s.setRebalanceStatus(r, cbn.RebalanceStatus_REBALANCE_STATUS_REFUNDED)
+	case remoteOut.Status == remoteTransferStatusPending && time.Since(r.UpdateTs) > maxPendingTimeOutRetryDuration:
+		s.setRebalanceStatus(r, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED)
+	}
+}
+
+func (s *server) refundRebalance(r *Rebalance, srcBc *bridgeConfig) {
+	remoteOut, err := srcBc.getTransfer(r.TransferId)
+	if err != nil {
+		rebalanceLog.rebalance(r).Errorf("fail to get rebalance transfer out, err:%v", err)
+		return
+	}
+	switch remoteOut.Status {
+	case remoteTransferStatusPending:
+	case remoteTransferStatusRefunded:
+		s.setRebalanceStatus(r, cbn.RebalanceStatus_REBALANCE_STATUS_REFUNDED)
+		return
+	case remoteTransferStatusConfirmed:
+		s.setRebalanceStatus(r, cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRMED)
+		return
+	default:
+		rebalanceLog.rebalance(r).Warnf("rebalance transfer out status is invalid, remote status:%d", remoteOut.Status)
+		return
+	}
+	dbErr := s.db.SetRebalanceStatusByFrom(r.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_REFUND_PENDING, r.Status)
+	if dbErr != nil {
+		rebalanceLog.rebalance(r).Errorf("fail to update rebalance status to refund pending, err:%v", dbErr)
+		return
+	}
+	lg := rebalanceLog.rebalance(r).with("status", cbn.RebalanceStatus_REBALANCE_STATUS_REFUND_PENDING)
+	ctx, span := startRebalanceSpan("rebalance refund", r)
+	defer span.End()
+	err = srcBc.refund(ctx, lg, r.TransferId, r.HashLock)
+	if err != nil {
+		lg.Errorf("fail to refund rebalance transfer out, err:%v", err)
+	}
+}
+
+func (s *server) setRebalanceStatus(r *Rebalance, to cbn.RebalanceStatus) {
+	dbErr := s.db.SetRebalanceStatusByFrom(r.TransferId, to, r.Status)
+	if dbErr != nil {
+		rebalanceLog.rebalance(r).Errorf("fail to update rebalance status to %s, err:%v", to, dbErr)
+	}
+}
+
+func (r *Rebalance) toProto() *cbn.Rebalance {
+	return &cbn.Rebalance{
+		TransferId:    r.TransferId.Bytes(),
+		SrcChainId:    r.SrcChainId,
+		DstChainId:    r.DstChainId,
+		SrcToken:      r.SrcToken.Bytes(),
+		DstToken:      r.DstToken.Bytes(),
+		Amount:        r.Amount.String(),
+		DstAmount:     r.DstAmount.String(),
+		BridgeAddr:    r.BridgeAddr.Bytes(),
+		HashLock:      r.HashLock.Bytes(),
+		TimeLock:      uint64(r.TimeLock.Unix()),
+		DstTransferId: r.DstTransferId.Bytes(),
+		Status:        r.Status,
+		CreateTs:      uint64(r.CreateTs.Unix()),
+		UpdateTs:      uint64(r.UpdateTs.Unix()),
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/contracts"
+)
+
+func TestE2ERebalanceTransferInMismatch(t *testing.T) {
+	env := newE2EEnv(t, e2eOptions{decimalsA: 18, decimalsB: 6, nodeLiquidityB: 1000000})
+	env.start()
+	cA, cB := env.chains[e2eChainA], env.chains[e2eChainB]
+	// the user is the relay node of the rebalances, the owner another one
+	relay, node := env.addr(env.user), env.addr(env.node)
+
+	otherToken, tx, other, err := contracts.DeployErc20(env.auth(cB, env.owner), cB.ec, "Other", "OTH", cB.tokens(1000000), 6)
+	env.mustSucceed(cB, tx, err)
+	tx, err = other.Transfer(env.auth(cB, env.owner), relay, cB.tokens(1000))
+	env.mustSucceed(cB, tx, err)
+	tx, err = other.Approve(env.auth(cB, env.user), cB.bridgeAddr, MaxUint256)
+	env.mustSucceed(cB, tx, err)
+	tx, err = cB.token.Approve(env.auth(cB, env.owner), cB.bridgeAddr, MaxUint256)
+	env.mustSucceed(cB, tx, err)
+
+	rebalance := func() *Rebalance {
+		r, err := env.s.Rebalance(e2eChainA, e2eChainB, e2eTokenName, cA.tokens(100), relay, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.waitRebalanceStatus(r.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED)
+		return r
+	}
+	lockIn := func(key *ecdsa.PrivateKey, r *Rebalance, token Addr, amount *big.Int, timeLock time.Time) Hash {
+		tx, err := cB.bridge.TransferIn(env.auth(cB, key), node, token, amount, r.HashLock, uint64(timeLock.Unix()), e2eChainA, r.TransferId)
+		env.mustSucceed(cB, tx, err)
+		return getTransferId(env.addr(key), node, r.HashLock, e2eChainB)
+	}
+	// 100 tokens of 18 decimals on chain A are 100 tokens of 6 decimals on chain B, the relay node may charge 1%
+	received := sub(cB.tokens(100), e2eFee)
+	type mismatch struct {
+		desc  string
+		r     *Rebalance
+		inTid Hash
+	}
+	var mismatches []*mismatch
+	add := func(desc string, key *ecdsa.PrivateKey, token Addr, amount *big.Int, timeLockBefore time.Duration) {
+		r := rebalance()
+		mismatches = append(mismatches, &mismatch{desc, r, lockIn(key, r, token, amount, r.TimeLock.Add(-timeLockBefore))})
+	}
+	add("token", env.user, otherToken, received, 8*time.Hour)
+	add("sender", env.owner, cB.tokenAddr, received, 8*time.Hour)
+	add("amount", env.user, cB.tokenAddr, cB.tokens(98), 8*time.Hour)
+	add("timelock", env.user, cB.tokenAddr, received, time.Hour)
+
+	valid := rebalance()
+	validIn := lockIn(env.user, valid, cB.tokenAddr, received, valid.TimeLock.Add(-8*time.Hour))
+	env.waitRebalanceStatus(valid.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED)
+	env.s.processRebalance()
+	env.waitFor("rebalance transfer in confirmed on chain", func() bool {
+		return env.remoteStatus(e2eChainB, validIn) == remoteTransferStatusConfirmed
+	})
+
+	checkNotConfirmed := func(status cbn.RebalanceStatus) {
+		t.Helper()
+		time.Sleep(2 * time.Second)
+		for _, m := range mismatches {
+			if remote := env.remoteStatus(e2eChainB, m.inTid); remote != remoteTransferStatusPending {
+				t.Fatalf("expect transfer in of %s mismatch pending on chain, got %d", m.desc, remote)
+			}
+			r, _, err := env.s.db.GetRebalance(m.r.TransferId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Status != status {
+				t.Fatalf("expect rebalance of %s mismatch %s, got %s", m.desc, status, r.Status)
+			}
+		}
+	}
+	checkNotConfirmed(cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED)
+
+	// a transferIn recorded before it was checked is checked again before the confirm
+	for _, m := range mismatches {
+		_, err = env.dal().Exec("UPDATE rebalance SET status = $1 WHERE tid = $2", cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED, m.r.TransferId.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.s.processRebalance()
+	checkNotConfirmed(cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED)
+}
+
+func (env *e2eEnv) waitRebalanceStatus(tid Hash, status cbn.RebalanceStatus) {
+	env.t.Helper()
+	env.waitFor(fmt.Sprintf("rebalance %x %s", tid, status), func() bool {
+		r, found, err := env.s.db.GetRebalance(tid)
+		return err == nil && found && r.Status == status
+	})
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+const (
+	defaultArchiveInterval  = time.Hour
+	defaultArchiveBatchSize = 1000
+	defaultRestoreHoldDays  = 7
+)
+
+var (
+	errArchiveDisabled = errors.New("archiving is disabled, retention_days not set")
+
+	archivedTransfers = newCounter("cbridge/retention/archived_transfers")
+	restoredTransfers = newCounter("cbridge/retention/restored_transfers")
+)
+
+type retentionPolicy struct {
+	retention   time.Duration // 0 means disabled
+	interval    time.Duration
+	batchSize   uint64
+	restoreHold time.Duration
+}
+
+func newRetentionPolicy(cfg *cbn.RetentionConfig) *retentionPolicy {
+	p := &retentionPolicy{
+		retention:   time.Duration(cfg.GetRetentionDays()) * 24 * time.Hour,
+		interval:    defaultArchiveInterval,
+		batchSize:   defaultArchiveBatchSize,
+		restoreHold: defaultRestoreHoldDays * 24 * time.Hour,
+	}
+	if cfg.GetInterval() > 0 {
+		p.interval = time.Duration(cfg.GetInterval()) * time.Second
+	}
+	if cfg.GetBatchSize() > 0 {
+		p.batchSize = cfg.GetBatchSize()
+	}
+	if cfg.GetRestoreHoldDays() > 0 {
+		p.restoreHold = time.Duration(cfg.GetRestoreHoldDays()) * 24 * time.Hour
+	}
+	return p
+}
+
+func (s *server) ProcessArchiveTransfers() {
+	if s.retention.retention == 0 {
+		return
+	}
+	ticker := time.NewTicker(s.retention.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			if _, err := s.archiveTransfers(); err != nil {
+				retentionLog.Errorf("fail to archive transfers, err:%v", err)
+			}
+		}
+	}
+}
+
+// archiveTransfers archives the settled transfers older than the retention in batches.
+func (s *server) archiveTransfers() (uint64, error) {
+	if s.retention.retention == 0 {
+		return 0, errArchiveDisabled
+	}
+	before := time.Now().Add(-s.retention.retention)
+	var total uint64
+	for {
+		archived, err := s.db.ArchiveTransfers(before, s.retention.batchSize)
+		total += archived
+		archivedTransfers.Inc(int64(archived))
+		if err != nil {
+			return total, err
+		}
+		if archived < s.retention.batchSize {
+			break
+		}
+	}
+	if total > 0 {
+		retentionLog.Infof("archived %d transfers created before %s", total, before.UTC().Format(time.RFC3339))
+	}
+	return total, nil
+}
+
+// restoreTransfers moves the archived transfers created in [from, to) back for audits.
+func (s *server) restoreTransfers(from, to time.Time) (uint64, error) {
+	restored, err := s.db.RestoreTransfers(from, to, time.Now().Add(s.retention.restoreHold))
+	restoredTransfers.Inc(int64(restored))
+	if err != nil {
+		return restored, err
+	}
+	retentionLog.Infof("restored %d archived transfers created in [%s, %s)", restored,
+		from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	return restored, nil
+}
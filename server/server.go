@@ -6,7 +6,6 @@ import (
 	"math"
 	"math/big"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,14 +23,12 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/julienschmidt/httprouter"
 	solsha3 "github.com/miguelmota/go-solidity-sha3"
 )
 
 const (
-	dbDriver   = "postgres"
-	dbFmt      = "postgresql://cbridge@%s/cbridge?sslmode=disable"
-	dbPoolSize = 20
 	// on-chain event names
 	evLogTransferOut       = "LogNewTransferOut"
 	evLogTransferIn        = "LogNewTransferIn"
@@ -44,15 +41,23 @@ const (
 	remoteTransferStatusRefunded  = 3
 
 	transactorWaitTimeout = 5 * time.Minute
+
+	// scans of the whole transfer table, a safety net of the work queues fed by the monitor callbacks
+	sendTransferScanInterval    = 2 * time.Minute
+	confirmTransferScanInterval = 2 * time.Minute
+	refundTransferScanInterval  = 5 * time.Minute
 )
 
+// dialChain connects to the chain endpoint, tests replace it to connect to simulated chains.
+var dialChain = dialTracedChain
+
 type server struct {
 	version      string
 	cfg          *cbn.CBridgeConfig // config from local json file
 	chainMap     map[uint64]*bridgeConfig
 	chainMapLock sync.Mutex
 	accountAddr  Addr
-	db           *DAL
+	db           TransferStore
 	gateway      GatewayAPI
 	signer       *eth.CelerSigner // sign req msg
 
@@ -66,6 +71,21 @@ type server struct {
 	chainGasTokenMap     map[uint64]*chainGasTokenInfo
 
 	gatewayChainInfoMapLock sync.Mutex
+
+	notifier  *notifier
+	watchdog  *timelockWatchdog
+	retention *retentionPolicy
+	inbox     *eventInbox
+	lease     *leaderLease // nil if ha is disabled
+	// last result of the readiness checks served at /readyz
+	readinessCache readinessCache
+	// transferIn id -> number of failed sends
+	sendFailCount     map[Hash]uint64
+	sendFailCountLock sync.Mutex
+	// transfers to process as soon as possible, fed by the monitor callbacks
+	sendQueue    *workQueue
+	confirmQueue *workQueue
+	refundQueue  *workQueue
 	// signal for goroutines to exit
 	quit chan bool
 }
@@ -79,11 +99,22 @@ type bridgeConfig struct {
 	config *cbn.ChainConfig
 
 	chainId  *big.Int
+	rc       *rpc.Client // for the calls ethclient lacks
 	ec       *ethclient.Client
 	trans    *eth.Transactor
 	watch    *watcher.WatchService
 	mon      *monitor.Service
+	sub      *logSubscriber // subscription mode, instead of watch and mon
 	erc20Map map[Addr]*contracts.Erc20
+	db       TransferStore // records the sent txs
+	lease    *leaderLease  // fences off the txs of a deposed leader, nil if ha is disabled
+
+	confirmSched *confirmScheduler
+	multicaller  *contracts.Multicall2Caller // nil if multicall_address is not set
+	// tokens whose allowance to the bridge was low at Init, approved once the node leads
+	unapprovedTokens []Addr
+	// records the blocks the watches have scanned, for the readiness checks
+	watchClient *watchClient
 
 	// on-chain contracts
 	contractChain layer1.Contract
@@ -98,13 +129,17 @@ func NewServer(version string) *server {
 		chainTokenAddrMap:    make(map[uint64]map[string]Addr),
 		chainTokenDecimalMap: make(map[uint64]map[Addr]uint64),
 		chainGasTokenMap:     make(map[uint64]*chainGasTokenInfo),
+		sendFailCount:        make(map[Hash]uint64),
+		sendQueue:            newWorkQueue(),
+		confirmQueue:         newWorkQueue(),
+		refundQueue:          newWorkQueue(),
 		quit:                 make(chan bool),
 	}
 }
 
-func (s *server) InitGatewayClient(gatewayUrl string) error {
+func (s *server) InitGatewayClient(config *cbn.CBridgeConfig) error {
 	var err error
-	s.gateway, err = NewGatewayAPI(gatewayUrl)
+	s.gateway, err = NewGatewayAPI(config.GetGateway(), config.GetGatewayInsecure(), config.GetGatewayConfig())
 	if err != nil {
 		log.Fatalln("fail to connect to gateway server:", err)
 		return err
@@ -115,14 +150,21 @@ func (s *server) InitGatewayClient(gatewayUrl string) error {
 func (s *server) Init(config *cbn.CBridgeConfig, ks, pwdDir string) error {
 	s.cfg = config
 	var err error
+	s.notifier = newNotifier(config.GetNotifyConfig(), config.GetRelayNodeName())
+	s.watchdog = newTimelockWatchdog(config.GetWatchdogConfig(), config.GetNotifyConfig().GetTimelockWarnBefore())
+	s.retention = newRetentionPolicy(config.GetRetentionConfig())
+	s.inbox = newEventInbox(config.GetEventInboxConfig())
 
 	// init db
 	log.Infoln("Initializing DB...")
-	s.db, err = NewDAL(dbDriver, fmt.Sprintf(dbFmt, config.GetDb()), dbPoolSize)
+	s.db, err = NewTransferStore(config)
 	if err != nil {
 		return err
 	}
 	log.Infoln("Successfully initialize DB")
+	if config.GetHaConfig().GetEnabled() {
+		s.lease = newLeaderLease(config.GetHaConfig(), s.db)
+	}
 
 	log.Infoln("Loading keystore...")
 	tcfg, err := GetTransactorConfig(ks, pwdDir)
@@ -146,11 +188,16 @@ func (s *server) Init(config *cbn.CBridgeConfig, ks, pwdDir string) error {
 		bgc := &bridgeConfig{
 			config:   chainConfig,
 			erc20Map: map[Addr]*contracts.Erc20{},
+			db:       s.db,
+			lease:    s.lease,
+
+			confirmSched: newConfirmScheduler(chainConfig.GetConfirmScheduleConfig()),
 		}
-		bgc.ec, err = ethclient.Dial(chainConfig.GetEndpoint())
+		bgc.rc, err = dialChain(chainConfig.GetEndpoint())
 		if err != nil {
 			return err
 		}
+		bgc.ec = ethclient.NewClient(bgc.rc)
 		bgc.chainId, err = bgc.ec.ChainID(context.Background())
 		if err != nil {
 			return err
@@ -165,6 +212,12 @@ func (s *server) Init(config *cbn.CBridgeConfig, ks, pwdDir string) error {
 		if err != nil {
 			return err
 		}
+		if chainConfig.GetMulticallAddress() != "" {
+			bgc.multicaller, err = contracts.NewMulticall2Caller(Hex2Addr(chainConfig.GetMulticallAddress()), bgc.ec)
+			if err != nil {
+				return err
+			}
+		}
 		bgc.contractChain, err = layer1.NewBoundContract(bgc.ec, Hex2Addr(chainConfig.ContractAddress), contracts.CBridgeABI)
 		if err != nil {
 			return err
@@ -205,40 +258,14 @@ func (s *server) Init(config *cbn.CBridgeConfig, ks, pwdDir string) error {
 				return err
 			}
 
-			ksfAc, err := os.Open(ks)
+			// only read here, a standby must not send txs
+			lowAllowance, err := bgc.lowAllowance(s.accountAddr, Hex2Addr(tokenConfig.GetTokenAddress()))
 			if err != nil {
 				return err
 			}
-			authAccount, err := bind.NewTransactorWithChainID(ksfAc, tcfg.Passphrase, bgc.chainId)
-			if err != nil {
-				return err
-			}
-
-			curAllowance, err := bgc.erc20Map[Hex2Addr(tokenConfig.GetTokenAddress())].Allowance(&bind.CallOpts{}, s.accountAddr, bgc.contractChain.GetAddr())
-			if err != nil {
-				return err
-			}
-
-			if curAllowance.Cmp(new(big.Int).Div(MaxUint256, big.NewInt(2))) < 0 {
-				log.Infof("Approving token %s on chain %d...", tokenConfig.GetTokenName(), chainConfig.GetChainId())
-				approveTx, approveErr := bgc.erc20Map[Hex2Addr(tokenConfig.GetTokenAddress())].Approve(authAccount, bgc.contractChain.GetAddr(), MaxUint256)
-				if approveErr != nil {
-					log.Errorf("please try again, can not approve token %s on chain %d, err:%v", tokenConfig.GetTokenName(), chainConfig.GetChainId(), approveErr)
-					return approveErr
-				}
-
-				approveReceipt, approveReceiptErr := eth.WaitMinedWithTxHash(context.Background(),
-					bgc.ec,
-					approveTx.Hash().String(),
-					eth.WithTimeout(2*time.Minute),
-					eth.WithBlockDelay(2))
-
-				if approveReceiptErr != nil {
-					log.Errorf("please try again, can not approve token %s on chain %d, err:%v", tokenConfig.GetTokenName(), chainConfig.GetChainId(), approveReceiptErr)
-					return approveReceiptErr
-				}
-				log.Infof("success to approve token:%s on chain:%d, receiptTxHash:%x, blockNum:%s, gasUsed:%d",
-					tokenConfig.GetTokenName(), chainConfig.GetChainId(), approveReceipt.TxHash, approveReceipt.BlockNumber.String(), approveReceipt.GasUsed)
+			if lowAllowance {
+				log.Infof("token %s on chain %d is not approved to the bridge yet, approve it once leading", tokenConfig.GetTokenName(), chainConfig.GetChainId())
+				bgc.unapprovedTokens = append(bgc.unapprovedTokens, Hex2Addr(tokenConfig.GetTokenAddress()))
 			}
 		}
 
@@ -246,21 +273,46 @@ func (s *server) Init(config *cbn.CBridgeConfig, ks, pwdDir string) error {
 		log.Infof("Successfully initialize chain %d", chainConfig.GetChainId())
 	}
 
+	if s.lease != nil {
+		log.Infof("ha enabled, start as standby, holder:%s", s.lease.holder)
+		return nil
+	}
+	return s.startLeading()
+}
+
+// startLeading registers the node in the gateway and starts monitoring, right in Init if ha is disabled,
+// otherwise once the node acquires the leader lease.
+func (s *server) startLeading() error {
 	smallDelay := func() {
 		time.Sleep(200 * time.Millisecond)
 	}
 
+	for _, bc := range s.chainMap {
+		if err := s.approveTokens(bc); err != nil {
+			return err
+		}
+	}
+
 	// refresh ping first
-	log.Infof("Registering in gateway: %s", config.GetGateway())
-	err = s.PingAndRefreshFee()
+	log.Infof("Registering in gateway: %s", s.cfg.GetGateway())
+	err := s.PingAndRefreshFee()
 	if err != nil {
 		return err
 	}
-	log.Infof("Successfully registered in gateway: %s", config.GetGateway())
+	log.Infof("Successfully registered in gateway: %s", s.cfg.GetGateway())
 
 	// init monitoring
 	for _, bgc := range s.chainMap {
-		bgc.watch = watcher.NewWatchService(bgc.ec, s.db, bgc.config.WatchConfig.GetPollingInterval(), bgc.config.WatchConfig.GetMaxBlockDelta())
+		if bgc.config.GetWatchConfig().GetWsEndpoint() != "" {
+			err = s.startLogSubscriber(bgc)
+			if err != nil {
+				log.Errorf("can not start subscription mode on chain %d, err:%v", bgc.chainId.Uint64(), err)
+				return err
+			}
+			continue
+		}
+		bgc.watchClient = newWatchClient(bgc.ec)
+		bgc.watch = watcher.NewWatchService(bgc.watchClient, s.db, bgc.config.WatchConfig.GetPollingInterval(), bgc.config.WatchConfig.GetMaxBlockDelta())
 		if bgc.watch == nil {
 			fmt.Println("Cannot setup watch service")
 			return fmt.Errorf("NewWatchService failed: %w", err)
@@ -295,6 +347,21 @@ func (s *server) Init(config *cbn.CBridgeConfig, ks, pwdDir string) error {
 	return nil
 }
 
+func (s *server) startLogSubscriber(bc *bridgeConfig) error {
+	sub, err := newLogSubscriber(bc, s.db, func(name string, eLog ethtypes.Log) bool {
+		return s.storeInboxEvent(bc, name, eLog)
+	})
+	if err != nil {
+		return err
+	}
+	if err = sub.initCursor(); err != nil {
+		return err
+	}
+	bc.sub = sub
+	go sub.run(s.quit)
+	return nil
+}
+
 func (s *server) monitorLogTransferOut(bc *bridgeConfig) (monitor.CallbackID, error) {
 	cfg := &monitor.Config{
 		ChainId:      bc.chainId.Uint64(),
@@ -304,126 +371,117 @@ func (s *server) monitorLogTransferOut(bc *bridgeConfig) (monitor.CallbackID, er
 		ForwardDelay: bc.config.GetWatchConfig().GetForwardBlockDelay(),
 	}
 	return bc.mon.Monitor(cfg, func(id monitor.CallbackID, eLog ethtypes.Log) bool {
-		ev := &contracts.CBridgeLogNewTransferOut{}
-		err := bc.contractChain.ParseEvent(evLogTransferOut, eLog, ev)
-		if err != nil {
-			log.Errorf("monitorLogTransferOut: cannot parse event, chainId:%d, txHash:%x, err:%v", bc.chainId.Uint64(), eLog.TxHash, err)
-			return false
-		}
-		if ev.Receiver != s.accountAddr {
-			log.Infof("this transfer out receiver is not current relay node and skip it")
-			return false
-		}
-		log.Infof("get monitorLogTransferOut, chain id:%d, block number:%d, transfer id:%x, eLog txHash:%x", bc.chainId.Uint64(), eLog.BlockNumber, ev.TransferId, eLog.TxHash)
+		return s.storeInboxEvent(bc, evLogTransferOut, eLog)
+	})
+}
 
-		_, found := s.chainMap[ev.DstChainId]
-		if found {
-			tsNow := time.Now()
-			tokenName, foundTokenName := s.chainTokenNameMap[bc.chainId.Uint64()][ev.Token]
-			if !foundTokenName {
-				log.Warnf("fail to get this token name, transferId:%x, token:%s", ev.TransferId, ev.Token.String())
-				return false
-			}
+func (s *server) handleLogTransferOut(bc *bridgeConfig, eLog ethtypes.Log) (err error) {
+	ev := &contracts.CBridgeLogNewTransferOut{}
+	err = bc.contractChain.ParseEvent(evLogTransferOut, eLog, ev)
+	if err != nil {
+		return newPoisonEventErr("cannot parse event, err:%v", err)
+	}
+	transferInId := getTransferId(ev.Receiver, ev.DstAddress, ev.Hashlock, ev.DstChainId)
+	lg := monitorLog.with("transfer_id", ev.TransferId, "related_tid", transferInId, "chain_id", bc.chainId.Uint64(), "direction", "out",
+		"tx_hash", eLog.TxHash)
+	if ev.Receiver != s.accountAddr {
+		lg.Debugf("transfer out receiver is not this relay node, skip it")
+		return nil
+	}
+	lg.Infof("get transfer out event, block number:%d", eLog.BlockNumber)
+	ctx, span := startTraceSpan("transfer out event", ev.TransferId, eventSpanAttrs(bc, eLog)...)
+	defer func() {
+		endSpan(span, err)
+	}()
+	db := s.db.WithContext(ctx)
+
+	_, found := s.chainMap[ev.DstChainId]
+	if !found {
+		return newPoisonEventErr("fail to get transfer out dst chain, dst chainId:%d", ev.DstChainId)
+	}
+	tsNow := time.Now()
+	tokenName, foundTokenName := s.chainTokenNameMap[bc.chainId.Uint64()][ev.Token]
+	if !foundTokenName {
+		return newPoisonEventErr("fail to get this token name, transferId:%x, token:%s", ev.TransferId, ev.Token.String())
+	}
 
-			dstChainTokenMap, foundDisChainTokenMap := s.chainTokenAddrMap[ev.DstChainId]
-			if !foundDisChainTokenMap {
-				log.Warnf("fail to get this dst chain, transferId:%x, dst chainId:%d", ev.TransferId, ev.DstChainId)
-				return false
-			}
-			dstToken, foundDstToken := dstChainTokenMap[tokenName]
-			if !foundDstToken {
-				log.Warnf("fail to get this dst token, transferId:%x, tokenName:%s", ev.TransferId, tokenName)
-				return false
-			}
+	dstChainTokenMap, foundDisChainTokenMap := s.chainTokenAddrMap[ev.DstChainId]
+	if !foundDisChainTokenMap {
+		return newPoisonEventErr("fail to get this dst chain, transferId:%x, dst chainId:%d", ev.TransferId, ev.DstChainId)
+	}
+	dstToken, foundDstToken := dstChainTokenMap[tokenName]
+	if !foundDstToken {
+		return newPoisonEventErr("fail to get this dst token, transferId:%x, tokenName:%s", ev.TransferId, tokenName)
+	}
 
-			srcTokenDecimal, foundSrcTokenDecimal := s.chainTokenDecimalMap[bc.chainId.Uint64()][ev.Token]
-			if !foundSrcTokenDecimal {
-				log.Warnf("fail to get this src token decimal, transferId:%x, token:%s", ev.TransferId, ev.Token.String())
-				return false
-			}
+	srcTokenDecimal, foundSrcTokenDecimal := s.chainTokenDecimalMap[bc.chainId.Uint64()][ev.Token]
+	if !foundSrcTokenDecimal {
+		return newPoisonEventErr("fail to get this src token decimal, transferId:%x, token:%s", ev.TransferId, ev.Token.String())
+	}
 
-			dstTokenDecimal, foundDstTokenDecimal := s.chainTokenDecimalMap[ev.DstChainId][dstToken]
-			if !foundDstTokenDecimal {
-				log.Warnf("fail to get this dst token decimal, transferId:%x, token:%s", ev.TransferId, dstToken.String())
-				return false
-			}
+	dstTokenDecimal, foundDstTokenDecimal := s.chainTokenDecimalMap[ev.DstChainId][dstToken]
+	if !foundDstTokenDecimal {
+		return newPoisonEventErr("fail to get this dst token decimal, transferId:%x, token:%s", ev.TransferId, dstToken.String())
+	}
 
-			dstAmount := ev.Amount
-			if srcTokenDecimal > dstTokenDecimal {
-				p := uint64(1)
-				for i := uint64(0); i < (srcTokenDecimal - dstTokenDecimal); i++ {
-					p = p * 10
-				}
-				dstAmount = new(big.Int).Div(ev.Amount, new(big.Int).SetUint64(p))
-			} else if srcTokenDecimal < dstTokenDecimal {
-				p := uint64(1)
-				for i := uint64(0); i < (dstTokenDecimal - srcTokenDecimal); i++ {
-					p = p * 10
-				}
-				dstAmount = new(big.Int).Mul(ev.Amount, new(big.Int).SetUint64(p))
-			}
-			log.Infof("transferOutId:%x, srcAmount:%s, srcTokenDecimal:%d, dstTokenDecimal:%d, dstAmt:%s", ev.TransferId, ev.Amount.String(), srcTokenDecimal, dstTokenDecimal, dstAmount.String())
-			// save transfer out
-			log.Infof("save transfer out, transferOutId:%x", ev.TransferId)
-			dbErr := s.db.InsertTransfer(&Transfer{
-				TransferId:     ev.TransferId,
-				TxHash:         eLog.TxHash,
-				ChainId:        bc.chainId.Uint64(),
-				Token:          ev.Token,
-				TransferType:   cbn.TransferType_TRANSFER_TYPE_OUT,
-				TimeLock:       time.Unix(int64(ev.Timelock), 0),
-				HashLock:       ev.Hashlock,
-				Status:         cbn.TransferStatus_TRANSFER_STATUS_LOCKED,
-				RelatedTid:     getTransferId(ev.Receiver, ev.DstAddress, ev.Hashlock, ev.DstChainId),
-				RelatedChainId: ev.DstChainId,
-				RelatedToken:   dstToken,
-				Amount:         *ev.Amount,
-				Sender:         ev.Sender,
-				Receiver:       ev.Receiver,
-				UpdateTs:       tsNow,
-				CreateTs:       tsNow,
-			})
-			if dbErr != nil {
-				log.Errorf("fail to insert transfer out, should try again, ev:%v, err:%v", ev, dbErr)
-				return true
-			}
+	dstAmount := convertTokenAmount(ev.Amount, srcTokenDecimal, dstTokenDecimal)
+	lg.Infof("transfer out amount:%s, srcTokenDecimal:%d, dstTokenDecimal:%d, dstAmount:%s", ev.Amount, srcTokenDecimal, dstTokenDecimal, dstAmount)
+	// save transfer out
+	lg.with("status", cbn.TransferStatus_TRANSFER_STATUS_LOCKED).Infof("save transfer out")
+	dbErr := db.InsertTransfer(&Transfer{
+		TransferId:     ev.TransferId,
+		TxHash:         eLog.TxHash,
+		ChainId:        bc.chainId.Uint64(),
+		Token:          ev.Token,
+		TransferType:   cbn.TransferType_TRANSFER_TYPE_OUT,
+		TimeLock:       time.Unix(int64(ev.Timelock), 0),
+		HashLock:       ev.Hashlock,
+		Status:         cbn.TransferStatus_TRANSFER_STATUS_LOCKED,
+		RelatedTid:     transferInId,
+		RelatedChainId: ev.DstChainId,
+		RelatedToken:   dstToken,
+		Amount:         *ev.Amount,
+		Sender:         ev.Sender,
+		Receiver:       ev.Receiver,
+		UpdateTs:       tsNow,
+		CreateTs:       tsNow,
+	})
+	if dbErr != nil {
+		return fmt.Errorf("fail to insert transfer out, err:%w", dbErr)
+	}
 
-			timeout := int64(ev.Timelock) - tsNow.Unix()
-			if timeout < 3600*16 { // src timeout should be larger than 16 hours
-				log.Errorf("src transfer out timeout too small: %d sec", timeout)
-				return false
-			}
-			// dst timeout should be 2 hour smaller than src
-			chain2TimeLock := tsNow.Add(time.Duration(timeout-(8*3600)) * time.Second)
-			// save transfer in
-			transferInId := getTransferId(ev.Receiver, ev.DstAddress, ev.Hashlock, ev.DstChainId)
-			log.Infof("save transfer in, transferInId:%x", transferInId)
-			dbErr = s.db.InsertTransfer(&Transfer{
-				TransferId:     transferInId,
-				ChainId:        ev.DstChainId,
-				Token:          dstToken,
-				TransferType:   cbn.TransferType_TRANSFER_TYPE_IN,
-				TimeLock:       chain2TimeLock,
-				HashLock:       ev.Hashlock,
-				Status:         cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START,
-				RelatedTid:     ev.TransferId,
-				RelatedChainId: bc.chainId.Uint64(),
-				RelatedToken:   ev.Token,
-				Amount:         *dstAmount,
-				Sender:         ev.Receiver,
-				Receiver:       ev.DstAddress,
-				UpdateTs:       tsNow,
-				CreateTs:       tsNow,
-			})
-			if dbErr != nil {
-				log.Errorf("fail to insert transfer out, ev:%v, err:%v", ev, dbErr)
-				return true
-			}
-		} else {
-			log.Warnf("fail to get transfer out dst chain, transfer out:%v", ev)
-		}
-		return false
+	timeout := int64(ev.Timelock) - tsNow.Unix()
+	if timeout < 3600*16 { // src timeout should be larger than 16 hours
+		lg.Errorf("src transfer out timeout too small: %d sec", timeout)
+		return nil
+	}
+	// dst timeout should be 2 hour smaller than src
+	chain2TimeLock := tsNow.Add(time.Duration(timeout-(8*3600)) * time.Second)
+	// save transfer in
+	monitorLog.with("transfer_id", transferInId, "related_tid", ev.TransferId, "chain_id", ev.DstChainId, "direction", "in",
+		"status", cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START).Infof("save transfer in")
+	dbErr = db.InsertTransfer(&Transfer{
+		TransferId:     transferInId,
+		ChainId:        ev.DstChainId,
+		Token:          dstToken,
+		TransferType:   cbn.TransferType_TRANSFER_TYPE_IN,
+		TimeLock:       chain2TimeLock,
+		HashLock:       ev.Hashlock,
+		Status:         cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START,
+		RelatedTid:     ev.TransferId,
+		RelatedChainId: bc.chainId.Uint64(),
+		RelatedToken:   ev.Token,
+		Amount:         *dstAmount,
+		Sender:         ev.Receiver,
+		Receiver:       ev.DstAddress,
+		UpdateTs:       tsNow,
+		CreateTs:       tsNow,
 	})
+	if dbErr != nil {
+		return fmt.Errorf("fail to insert transfer in, err:%w", dbErr)
+	}
+	s.sendQueue.add(transferInId)
+	return nil
 }
 
 func (s *server) monitorLogTransferIn(bc *bridgeConfig) (monitor.CallbackID, error) {
@@ -435,26 +493,43 @@ func (s *server) monitorLogTransferIn(bc *bridgeConfig) (monitor.CallbackID, err
 		ForwardDelay: bc.config.GetWatchConfig().GetForwardBlockDelay(),
 	}
 	return bc.mon.Monitor(cfg, func(id monitor.CallbackID, eLog ethtypes.Log) bool {
-		ev := &contracts.CBridgeLogNewTransferIn{}
-		err := bc.contractChain.ParseEvent(evLogTransferIn, eLog, ev)
-		if err != nil {
-			log.Errorf("monitorLogTransferIn: cannot parse event, chainId:%d, txHash:%x, err:%v", bc.chainId.Uint64(), eLog.TxHash, err)
-			return false
-		}
+		return s.storeInboxEvent(bc, evLogTransferIn, eLog)
+	})
+}
 
-		if ev.Sender != s.accountAddr {
-			log.Infof("this transfer in sender is not current relay node")
-			return false
-		}
-		log.Infof("get monitorLogTransferIn, chain id:%d, block number:%d, transfer id:%x, eLog txHash:%x", bc.chainId.Uint64(), eLog.BlockNumber, ev.TransferId, eLog.TxHash)
-		dbErr := s.db.RecordTransferIn(ev.TransferId, eLog.TxHash)
+func (s *server) handleLogTransferIn(bc *bridgeConfig, eLog ethtypes.Log) (err error) {
+	ev := &contracts.CBridgeLogNewTransferIn{}
+	err = bc.contractChain.ParseEvent(evLogTransferIn, eLog, ev)
+	if err != nil {
+		return newPoisonEventErr("cannot parse event, err:%v", err)
+	}
+
+	if ev.Receiver == s.accountAddr {
+		dbErr := s.onRebalanceTransferIn(bc, ev)
 		if dbErr != nil {
-			log.Errorf("fail to send this transfer in to locked, transferId:%x, err:%v", ev.TransferId, dbErr)
-			return true
+			return fmt.Errorf("fail to record rebalance transfer in, err:%w", dbErr)
 		}
+		return nil
+	}
+	lg := monitorLog.with("transfer_id", ev.TransferId, "related_tid", ev.SrcTransferId, "chain_id", bc.chainId.Uint64(), "direction", "in",
+		"tx_hash", eLog.TxHash)
+	if ev.Sender != s.accountAddr {
+		lg.Debugf("transfer in sender is not this relay node, skip it")
+		return nil
+	}
+	lg.Infof("get transfer in event, block number:%d", eLog.BlockNumber)
+	ctx, span := startTraceSpan("transfer in event", ev.SrcTransferId, eventSpanAttrs(bc, eLog)...)
+	defer func() {
+		endSpan(span, err)
+	}()
+	dbErr := s.db.WithContext(ctx).RecordTransferIn(ev.TransferId, eLog.TxHash)
+	if dbErr != nil {
+		return fmt.Errorf("fail to send this transfer in to locked, err:%w", dbErr)
+	}
+	// refund it once expired unless the receiver confirms it
+	s.refundQueue.addAt(ev.TransferId, time.Unix(int64(ev.Timelock), 0))
 
-		return false
-	})
+	return nil
 }
 
 func (s *server) monitorLogConfirm(bc *bridgeConfig) (monitor.CallbackID, error) {
@@ -466,28 +541,63 @@ func (s *server) monitorLogConfirm(bc *bridgeConfig) (monitor.CallbackID, error)
 		ForwardDelay: bc.config.GetWatchConfig().GetForwardBlockDelay(),
 	}
 	return bc.mon.Monitor(cfg, func(id monitor.CallbackID, eLog ethtypes.Log) bool {
-		ev := &contracts.CBridgeLogTransferConfirmed{}
-		err := bc.contractChain.ParseEvent(evLogTransferConfirmed, eLog, ev)
-		if err != nil {
-			log.Errorf("monitorLogTransferConfirm: cannot parse event, chainId:%d, txHash:%x, err:%v", bc.chainId.Uint64(), eLog.TxHash, err)
-			return false
-		}
-		log.Infof("get monitorLogConfirm, chain id:%d, block number:%d, transfer id:%x, eLog txHash:%x, preimage:%x", bc.chainId.Uint64(), eLog.BlockNumber, ev.TransferId, eLog.TxHash, ev.Preimage)
-		dbErr := s.db.ConfirmTransfer(ev.TransferId, ev.Preimage, eLog.TxHash)
-		if dbErr != nil {
-			log.Errorf("fail to update transfer status to confirmed, ev:%v, err:%v", ev, dbErr)
-			return true
-		}
-
-		dbErr = s.db.SetRelatedTxPreimage(ev.Preimage, ev.TransferId)
-		if dbErr != nil {
-			log.Errorf("fail to update transfer status to set preimage, ev:%v, err:%v", ev, dbErr)
-			return true
-		}
-		return false
+		return s.storeInboxEvent(bc, evLogTransferConfirmed, eLog)
 	})
 }
 
+func (s *server) handleLogConfirm(bc *bridgeConfig, eLog ethtypes.Log) (err error) {
+	ev := &contracts.CBridgeLogTransferConfirmed{}
+	err = bc.contractChain.ParseEvent(evLogTransferConfirmed, eLog, ev)
+	if err != nil {
+		return newPoisonEventErr("cannot parse event, err:%v", err)
+	}
+	tx, found, dbErr := s.db.GetTransferByTid(ev.TransferId)
+	if dbErr != nil {
+		return fmt.Errorf("fail to get confirmed transfer, err:%w", dbErr)
+	}
+	lg := monitorLog.with("transfer_id", ev.TransferId, "chain_id", bc.chainId.Uint64(), "tx_hash", eLog.TxHash)
+	outTid := ev.TransferId
+	if found {
+		lg = monitorLog.transfer(tx).with("tx_hash", eLog.TxHash)
+		outTid = traceTid(tx)
+	}
+	ctx, span := startTraceSpan("confirm event", outTid, eventSpanAttrs(bc, eLog)...)
+	defer func() {
+		endSpan(span, err)
+	}()
+	db := s.db.WithContext(ctx)
+	lg.Infof("get confirm event, block number:%d, preimage:%x", eLog.BlockNumber, ev.Preimage)
+	if found {
+		s.checkPreimage(tx, ev.Preimage, "confirm event")
+	}
+	dbErr = s.onRebalanceEvent(ev.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRMED)
+	if dbErr != nil {
+		return fmt.Errorf("fail to update rebalance to confirmed, err:%w", dbErr)
+	}
+	// the transfer is confirmed on chain anyway, the preimage is only stored if it matches
+	dbErr = db.ConfirmTransfer(ev.TransferId, ev.Preimage, eLog.TxHash)
+	if dbErr != nil {
+		return fmt.Errorf("fail to update transfer status to confirmed, err:%w", dbErr)
+	}
+	if found {
+		s.clearPairSendFail(tx)
+	}
+
+	relatedTx, found, dbErr := db.GetTransferByRelatedTid(ev.TransferId)
+	if dbErr != nil {
+		return fmt.Errorf("fail to get related transfer, err:%w", dbErr)
+	}
+	if !found || !s.checkPreimage(relatedTx, ev.Preimage, "related confirm event") {
+		return nil
+	}
+	dbErr = db.SetRelatedTxPreimage(ev.Preimage, ev.TransferId)
+	if dbErr != nil {
+		return fmt.Errorf("fail to update transfer status to set preimage, err:%w", dbErr)
+	}
+	s.confirmQueue.add(relatedTx.TransferId)
+	return nil
+}
+
 // Monitor on-chain user transfer events.
 func (s *server) monitorLogRefund(bc *bridgeConfig) (monitor.CallbackID, error) {
 	cfg := &monitor.Config{
@@ -498,24 +608,52 @@ func (s *server) monitorLogRefund(bc *bridgeConfig) (monitor.CallbackID, error)
 		ForwardDelay: bc.config.GetWatchConfig().GetForwardBlockDelay(),
 	}
 	return bc.mon.Monitor(cfg, func(id monitor.CallbackID, eLog ethtypes.Log) bool {
-		ev := &contracts.CBridgeLogTransferRefunded{}
-		err := bc.contractChain.ParseEvent(evLogTransferRefunded, eLog, ev)
-		if err != nil {
-			log.Errorf("monitorLogTransferRefund: cannot parse event, chainId:%d, txHash:%x, err:%v", bc.chainId.Uint64(), eLog.TxHash, err)
-			return false
-		}
-		log.Infof("get monitorLogRefund, chain id:%d, block number:%d, transfer id:%x, eLog txHash:%x", bc.chainId.Uint64(), eLog.BlockNumber, ev.TransferId, eLog.TxHash)
-		dbErr := s.db.RefundTransfer(ev.TransferId, eLog.TxHash)
-		if dbErr != nil {
-			log.Errorf("this transfer is refunded by fail to update the status in db, tx:%v, err:%v", ev, dbErr)
-			return true
-		}
-		return false
+		return s.storeInboxEvent(bc, evLogTransferRefunded, eLog)
 	})
 }
 
+func (s *server) handleLogRefund(bc *bridgeConfig, eLog ethtypes.Log) (err error) {
+	ev := &contracts.CBridgeLogTransferRefunded{}
+	err = bc.contractChain.ParseEvent(evLogTransferRefunded, eLog, ev)
+	if err != nil {
+		return newPoisonEventErr("cannot parse event, err:%v", err)
+	}
+	lg := monitorLog.with("transfer_id", ev.TransferId, "chain_id", bc.chainId.Uint64(), "tx_hash", eLog.TxHash)
+	lg.Infof("get refund event, block number:%d", eLog.BlockNumber)
+	// the refunded transfer in is in the trace of its transfer out
+	outTid := ev.TransferId
+	if tx, found, dbErr := s.db.GetTransferByTid(ev.TransferId); dbErr == nil && found {
+		outTid = traceTid(tx)
+	}
+	ctx, span := startTraceSpan("refund event", outTid, eventSpanAttrs(bc, eLog)...)
+	defer func() {
+		endSpan(span, err)
+	}()
+	db := s.db.WithContext(ctx)
+	dbErr := db.RefundTransfer(ev.TransferId, eLog.TxHash)
+	if dbErr != nil {
+		return fmt.Errorf("fail to update transfer status to refunded, err:%w", dbErr)
+	}
+	dbErr = s.onRebalanceEvent(ev.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_REFUNDED)
+	if dbErr != nil {
+		return fmt.Errorf("fail to update rebalance to refunded, err:%w", dbErr)
+	}
+	tx, found, dbErr := db.GetTransferByTid(ev.TransferId)
+	if dbErr != nil {
+		lg.Warnf("fail to get refunded transfer, err:%v", dbErr)
+	} else if found {
+		s.clearPairSendFail(tx)
+		s.notifyTransfer(cbn.NotifyEvent_NOTIFY_EVENT_TRANSFER_REFUNDED, SeverityWarning, "transfer refunded", tx)
+	}
+	return nil
+}
+
 func (s *server) Close() {
 	close(s.quit)
+	s.sendQueue.close()
+	s.confirmQueue.close()
+	s.refundQueue.close()
+	s.notifier.Close()
 	for _, bgc := range s.chainMap {
 		if bgc.mon != nil {
 			// Close monitor before watch otherwise monitor recreates the watchers.
@@ -529,10 +667,10 @@ func (s *server) Close() {
 	}
 }
 
-func (bc *bridgeConfig) transferIn(dstAddr, token Addr, amount *big.Int, hashLock, transferId, srcTransferId Hash, timeLock, srcChainId uint64) error {
-	log.Infof("start transfer in, transferId:%x, chainId:%d, srcTransferId:%x, hashLock:%x", transferId, bc.chainId.Uint64(), srcTransferId, hashLock)
-	_, err := bc.trans.Transact(
-		logTransactionStateHandler(fmt.Sprintf("receipt transferIn, transferId: %x, chainId: %s", transferId, bc.chainId)),
+func (bc *bridgeConfig) transferIn(ctx context.Context,
+	lg *logger, dstAddr, token Addr, amount *big.Int, hashLock, transferId, srcTransferId Hash, timeLock, srcChainId uint64) error {
+	lg.Infof("start transfer in, hashLock:%x", hashLock)
+	_, err := bc.transact(ctx, lg, cbn.TxPurpose_TX_PURPOSE_TRANSFER_IN, transferId, logTransactionStateHandler(lg, "transferIn"),
 		func(ctr bind.ContractTransactor, opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
 			cbt, err2 := contracts.NewCBridgeTransactor(bc.contractChain.GetAddr(), ctr)
 			if err2 != nil {
@@ -544,25 +682,87 @@ func (bc *bridgeConfig) transferIn(dstAddr, token Addr, amount *big.Int, hashLoc
 	return err
 }
 
-func (bc *bridgeConfig) confirm(transferId, srcTransferId, preImage, hashLock Hash) error {
-	log.Infof("start confirm, transferId:%x, chainId:%d, srcTransferId:%x, hashLock:%x", transferId, bc.chainId.Uint64(), srcTransferId, hashLock)
-	_, err := bc.trans.Transact(
-		logTransactionStateHandler(fmt.Sprintf("receipt confirm, transferId: %x, chainId: %s", transferId, bc.chainId)),
+// lowAllowance returns whether the allowance of the token from addr to the bridge is below half of the max.
+func (bc *bridgeConfig) lowAllowance(addr, token Addr) (bool, error) {
+	allowance, err := bc.erc20Map[token].Allowance(&bind.CallOpts{}, addr, bc.contractChain.GetAddr())
+	if err != nil {
+		return false, err
+	}
+	return allowance.Cmp(new(big.Int).Div(MaxUint256, big.NewInt(2))) < 0, nil
+}
+
+// approveTokens approves the unapproved tokens of the chain to the bridge and waits for the txs to be mined.
+// The txs are sent by transact, so only by the leader, and recorded in the chain_tx table.
+func (s *server) approveTokens(bc *bridgeConfig) error {
+	for len(bc.unapprovedTokens) > 0 {
+		token := bc.unapprovedTokens[0]
+		lg := senderLog.with("chain_id", bc.chainId.Uint64(), "token", s.getTokenName(bc.chainId.Uint64(), token))
+		// the previous leader may have approved it
+		lowAllowance, err := bc.lowAllowance(s.accountAddr, token)
+		if err != nil {
+			return err
+		}
+		if lowAllowance {
+			lg.Infof("approving token to the bridge")
+			tx, err := bc.transact(context.Background(), lg, cbn.TxPurpose_TX_PURPOSE_APPROVE, Hash{}, logTransactionStateHandler(lg, "approve"),
+				func(ctr bind.ContractTransactor, opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+					erc20, err2 := contracts.NewErc20Transactor(token, ctr)
+					if err2 != nil {
+						return nil, err2
+					}
+					return erc20.Approve(opts, bc.contractChain.GetAddr(), MaxUint256)
+				},
+			)
+			if err != nil {
+				lg.Errorf("please try again, can not approve token, err:%v", err)
+				return err
+			}
+			receipt, err := eth.WaitMinedWithTxHash(context.Background(), bc.ec, tx.Hash().String(),
+				eth.WithTimeout(2*time.Minute), eth.WithBlockDelay(2))
+			if err != nil {
+				lg.with("tx_hash", tx.Hash()).Errorf("please try again, can not approve token, err:%v", err)
+				return err
+			}
+			lg.with("tx_hash", receipt.TxHash).Infof("success to approve token, blockNum:%s, gasUsed:%d", receipt.BlockNumber.String(), receipt.GasUsed)
+		}
+		bc.unapprovedTokens = bc.unapprovedTokens[1:]
+	}
+	return nil
+}
+
+// confirm sends the confirm tx, onMined is called with the sent tx and its receipt if not nil.
+func (bc *bridgeConfig) confirm(ctx context.Context, lg *logger, transferId, preImage, hashLock Hash, onMined func(sent *ethtypes.Transaction, receipt *ethtypes.Receipt)) error {
+	lg.Infof("start confirm, hashLock:%x", hashLock)
+	if !verifyPreimage(preImage, hashLock) {
+		// the contract would revert, do not burn gas on it
+		return fmt.Errorf("preimage %x does not match hashlock %x", preImage, hashLock)
+	}
+	handler := logTransactionStateHandler(lg, "confirm")
+	// set before the transactor waits for the receipt
+	var sent *ethtypes.Transaction
+	if onMined != nil {
+		logMined := handler.OnMined
+		handler.OnMined = func(receipt *ethtypes.Receipt) {
+			logMined(receipt)
+			onMined(sent, receipt)
+		}
+	}
+	_, err := bc.transact(ctx, lg, cbn.TxPurpose_TX_PURPOSE_CONFIRM, transferId, handler,
 		func(ctr bind.ContractTransactor, opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
 			cbt, err2 := contracts.NewCBridgeTransactor(bc.contractChain.GetAddr(), ctr)
 			if err2 != nil {
 				return nil, err2
 			}
-			return cbt.Confirm(opts, transferId, preImage)
+			sent, err2 = cbt.Confirm(opts, transferId, preImage)
+			return sent, err2
 		},
 	)
 	return err
 }
 
-func (bc *bridgeConfig) refund(transferId, srcTransferId, hashLock Hash) error {
-	log.Infof("start refund, transferId:%x, chainId:%d, srcTransferId:%x, hashLock:%x", transferId, bc.chainId.Uint64(), srcTransferId, hashLock)
-	_, err := bc.trans.Transact(
-		logTransactionStateHandler(fmt.Sprintf("receipt refund, transferId: %x", transferId)),
+func (bc *bridgeConfig) refund(ctx context.Context, lg *logger, transferId, hashLock Hash) error {
+	lg.Infof("start refund, hashLock:%x", hashLock)
+	_, err := bc.transact(ctx, lg, cbn.TxPurpose_TX_PURPOSE_REFUND, transferId, logTransactionStateHandler(lg, "refund"),
 		func(ctr bind.ContractTransactor, opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
 			cbt, err2 := contracts.NewCBridgeTransactor(bc.contractChain.GetAddr(), ctr)
 			if err2 != nil {
@@ -574,17 +774,17 @@ func (bc *bridgeConfig) refund(transferId, srcTransferId, hashLock Hash) error {
 	return err
 }
 
-func logTransactionStateHandler(desc string) *eth.TransactionStateHandler {
+func logTransactionStateHandler(lg *logger, desc string) *eth.TransactionStateHandler {
 	return &eth.TransactionStateHandler{
 		OnMined: func(receipt *ethtypes.Receipt) {
 			if receipt.Status == ethtypes.ReceiptStatusSuccessful {
-				log.Infof("%s transaction %x succeeded", desc, receipt.TxHash)
+				lg.with("tx_hash", receipt.TxHash).Infof("%s tx succeeded, block number:%s, gas used:%d", desc, receipt.BlockNumber, receipt.GasUsed)
 			} else {
-				log.Errorf("%s transaction %x failed", desc, receipt.TxHash)
+				lg.with("tx_hash", receipt.TxHash).Errorf("%s tx failed, block number:%s", desc, receipt.BlockNumber)
 			}
 		},
 		OnError: func(tx *ethtypes.Transaction, err error) {
-			log.Errorf("%s transaction %x err: %s", desc, tx.Hash(), err)
+			lg.with("tx_hash", tx.Hash()).Errorf("%s tx err:%v", desc, err)
 		},
 	}
 }
@@ -595,8 +795,7 @@ func (bc *bridgeConfig) getTransfer(transferId Hash) (*TransferInfo, error) {
 		return nil, err
 	}
 
-	safeBlkNum := bc.mon.GetCurrentBlockNumber().Uint64() - bc.config.GetWatchConfig().GetBlockDelay()
-	transfer, err := cbcall.Transfers(&bind.CallOpts{BlockNumber: new(big.Int).SetUint64(safeBlkNum)}, transferId)
+	transfer, err := cbcall.Transfers(&bind.CallOpts{BlockNumber: bc.safeBlockNumber()}, transferId)
 	if err != nil {
 		return nil, err
 	}
@@ -611,6 +810,34 @@ func (bc *bridgeConfig) getTransfer(transferId Hash) (*TransferInfo, error) {
 	}, nil
 }
 
+// safeBlockNumber returns the latest block with block_delay confirmations, where the on-chain transfers are read,
+// or nil for the latest block if the monitoring has not seen block_delay blocks yet.
+func (bc *bridgeConfig) safeBlockNumber() *big.Int {
+	current, blockDelay := bc.currentBlockNumber(), bc.config.GetWatchConfig().GetBlockDelay()
+	if current == 0 || current < blockDelay {
+		return nil
+	}
+	return new(big.Int).SetUint64(current - blockDelay)
+}
+
+// currentBlockNumber returns the latest block number seen by the event monitoring of the chain.
+func (bc *bridgeConfig) currentBlockNumber() uint64 {
+	if bc.sub != nil {
+		return bc.sub.getHead()
+	}
+	if bc.mon != nil {
+		return bc.mon.GetCurrentBlockNumber().Uint64()
+	}
+	return 0
+}
+
+func (bc *bridgeConfig) eventSource() cbn.EventSource {
+	if bc.sub != nil {
+		return bc.sub.getSource()
+	}
+	return cbn.EventSource_EVENT_SOURCE_POLLING
+}
+
 type TransferInfo struct {
 	Sender   common.Address
 	Receiver common.Address
@@ -660,19 +887,24 @@ func (s *server) PingCron() {
 	defer ticker.Stop()
 	for {
 		select {
+		case <-s.quit:
+			return
 		case <-ticker.C:
 			pingErr := s.PingAndRefreshFee()
 			if pingErr != nil {
-				log.Warnf("fail to get ping info, err:%v", pingErr)
+				gatewayLog.Warnf("fail to get ping info, err:%v", pingErr)
+				s.checkGatewayPermission(pingErr)
 			}
+			s.checkChainEndpoints()
 		}
 	}
 }
 
 func (s *server) PingAndRefreshFee() error {
+	// legacy signature for gateways not checking the ping auth
 	sigMsg, err := s.signer.SignEthMessage(s.accountAddr.Bytes())
 	if err != nil {
-		log.Errorf("fail to sig for ping, err:%v", err)
+		gatewayLog.Errorf("fail to sig for ping, err:%v", err)
 		return err
 	}
 	req := &gatewayrpc.PingRequest{
@@ -686,30 +918,58 @@ func (s *server) PingAndRefreshFee() error {
 		chainInfo := &gatewayrpc.ChainInfo{
 			ChainId:         k,
 			TokenAndBalance: map[string]string{},
-			FeePer_10000:    v.config.FeeRate,
+			FeePer_10000:    s.getFeeRate(k),
 		}
-		for addr, erc20 := range v.erc20Map {
-			balance, balanceErr := erc20.BalanceOf(nil, s.accountAddr)
-			if balanceErr != nil {
-				log.Warnf("fail to get this token balance, skip it, chain id:%d, token addr:%s, err:%s", k, addr, balanceErr.Error())
-				continue
-			}
+		for addr, balance := range v.getTokenBalances(gatewayLog, s.accountAddr) {
 			chainInfo.TokenAndBalance[addr.String()] = balance.String()
+			s.checkTokenBalance(v, addr, balance)
 		}
+		s.checkGasBalance(v)
 		req.ChainInfo = append(req.ChainInfo, chainInfo)
 	}
+	if err = gatewayrpc.SignPing(req, time.Now(), s.signer.SignEthMessage); err != nil {
+		gatewayLog.Errorf("fail to sign ping auth, err:%v", err)
+		return err
+	}
 	resp, pingErr := s.gateway.PingGateway(req)
 	if pingErr != nil {
 		return pingErr
 	}
 	if beErr := resp.GetErr(); beErr != nil {
-		return fmt.Errorf("gateway err:%v", beErr)
+		return &GatewayError{Err: beErr}
 	}
 
 	s.setGatewayChainInfo(resp.GetChainInfo())
 	return nil
 }
 
+func (s *server) getFeeRate(chainId uint64) uint64 {
+	s.chainMapLock.Lock()
+	defer s.chainMapLock.Unlock()
+	bc, found := s.chainMap[chainId]
+	if !found {
+		return 0
+	}
+	return bc.config.GetFeeRate()
+}
+
+func (s *server) setFeeRate(chainId, feeRate uint64) bool {
+	s.chainMapLock.Lock()
+	defer s.chainMapLock.Unlock()
+	bc, found := s.chainMap[chainId]
+	if !found {
+		return false
+	}
+	bc.config.FeeRate = feeRate
+	return true
+}
+
+func (s *server) getGatewayChainInfo(chainId uint64) *gatewayrpc.GatewayChainInfo {
+	s.gatewayChainInfoMapLock.Lock()
+	defer s.gatewayChainInfoMapLock.Unlock()
+	return s.gatewayChainInfoMap[chainId]
+}
+
 func (s *server) setGatewayChainInfo(gatewayChainInfoMap map[uint64]*gatewayrpc.GatewayChainInfo) {
 	s.gatewayChainInfoMapLock.Lock()
 	defer s.gatewayChainInfoMapLock.Unlock()
@@ -717,10 +977,16 @@ func (s *server) setGatewayChainInfo(gatewayChainInfoMap map[uint64]*gatewayrpc.
 }
 
 func (s *server) ProcessSendTransfer() {
-	ticker := time.NewTicker(10 * time.Second)
+	// pick up the work left before the start
+	s.processTrySendTransferIn()
+	ticker := time.NewTicker(sendTransferScanInterval)
 	defer ticker.Stop()
 	for {
 		select {
+		case <-s.quit:
+			return
+		case <-s.sendQueue.wake:
+			s.processSendQueue()
 		case <-ticker.C:
 			s.processTrySendTransferIn()
 		}
@@ -728,10 +994,15 @@ func (s *server) ProcessSendTransfer() {
 }
 
 func (s *server) ProcessConfirmTransfer() {
-	ticker := time.NewTicker(10 * time.Second)
+	s.processTryConfirmTransfer()
+	ticker := time.NewTicker(confirmTransferScanInterval)
 	defer ticker.Stop()
 	for {
 		select {
+		case <-s.quit:
+			return
+		case <-s.confirmQueue.wake:
+			s.processConfirmQueue()
 		case <-ticker.C:
 			s.processTryConfirmTransfer()
 		}
@@ -739,110 +1010,178 @@ func (s *server) ProcessConfirmTransfer() {
 }
 
 func (s *server) ProcessRefundTransferIn() {
-	ticker := time.NewTicker(1 * time.Minute)
+	s.processTryRefundTransferIn()
+	ticker := time.NewTicker(refundTransferScanInterval)
 	defer ticker.Stop()
 	for {
 		select {
+		case <-s.quit:
+			return
+		case <-s.refundQueue.wake:
+			s.processRefundQueue()
 		case <-ticker.C:
 			s.processTryRefundTransferIn()
 		}
 	}
 }
 
+// takeQueuedTransfers returns the transfers of the ids taken from the queue, as they are in the db now.
+// lg is the logger of the job processing the queue.
+func (s *server) takeQueuedTransfers(q *workQueue, lg *logger) []*Transfer {
+	var txs []*Transfer
+	for _, tid := range q.take() {
+		tx, found, dbErr := s.db.GetTransferByTid(tid)
+		if dbErr != nil {
+			// left to the next scan
+			lg.with("transfer_id", tid).Errorf("fail to get queued transfer, err:%v", dbErr)
+			continue
+		}
+		if found {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
 func (s *server) ProcessRecoverTimeoutPendingTransfer() {
 	ticker := time.NewTicker(2 * time.Minute)
 	defer ticker.Stop()
 	for {
 		select {
+		case <-s.quit:
+			return
 		case <-ticker.C:
 			s.processRecoverTimeoutPendingTransferIn()
 			s.processRecoverTimeoutPendingConfirm()
 			s.processRecoverTimeoutPendingRefund()
+			s.reconcileChainTxs()
 		}
 	}
 }
 
+// processTrySendTransferIn scans all started transfers in, a safety net of the send queue.
 func (s *server) processTrySendTransferIn() {
+	// new transfers need the fee from the gateway, pause them while it is down.
+	if s.gateway.Status().GetState() == cbn.GatewayState_GATEWAY_STATE_DOWN {
+		senderLog.Warnf("gateway is down, skip sending transfer in")
+		return
+	}
 	startedTransferIn, dbErr := s.db.GetAllStartTransferIn()
 	if dbErr != nil {
-		log.Warnf("fail to query started transferIn, err:%s", dbErr)
+		senderLog.Warnf("fail to query started transferIn, err:%s", dbErr)
 		return
 	}
+	remote := s.readTransfers(startedTransferIn, senderLog)
 	for _, tx := range startedTransferIn {
-		bc, foundBc := s.chainMap[tx.ChainId]
-		if foundBc {
-			tsNow := time.Now()
-			if tx.TimeLock.Add(time.Duration(-6) * time.Minute).Before(tsNow) {
-				log.Warnf("this transfer out is already timeout, transferId:%x, timeLock: %s", tx.TransferId, tx.TimeLock.String())
-				continue
-			}
+		s.trySendTransferIn(tx, remote)
+	}
+}
 
-			remoteTransferIn, err := bc.getTransfer(tx.TransferId)
-			if err != nil {
-				log.Errorf("fail to get transfer in, txId:%x, err:%v", tx.TransferId, err)
-				continue
-			}
-			if remoteTransferIn.Status != 0 {
-				log.Warnf("this transfer in already exist, we try to set the status to locked, transfer:%+v", remoteTransferIn)
-				if remoteTransferIn.Status == remoteTransferStatusPending {
-					// for some chain, we may miss transfer in event, then we should set it.
-					log.Warnf("find exist pending transfer, tid:%x, try to set the status", tx.TransferId)
-					tryResetPendingTransferInStatusErr := s.db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED,
-						cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START)
-					if tryResetPendingTransferInStatusErr != nil {
-						log.Errorf("fail to set exist transferIn, tid:%x, err:%v", tx.TransferId, tryResetPendingTransferInStatusErr)
-					}
-				}
-				continue
-			}
+// processSendQueue sends the transfers in added to the send queue. While the gateway is down they are left in
+// the queue, for the next wake up or scan.
+func (s *server) processSendQueue() {
+	if s.gateway.Status().GetState() == cbn.GatewayState_GATEWAY_STATE_DOWN {
+		senderLog.Warnf("gateway is down, skip sending queued transfers in")
+		return
+	}
+	txs := s.takeQueuedTransfers(s.sendQueue, senderLog)
+	var sendable []*Transfer
+	for _, tx := range txs {
+		// same conditions as GetAllStartTransferIn
+		if tx.TransferType == cbn.TransferType_TRANSFER_TYPE_IN && tx.Status == cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START &&
+			tx.TimeLock.After(time.Now().Add(time.Hour)) {
+			sendable = append(sendable, tx)
+		}
+	}
+	remote := s.readTransfers(sendable, senderLog)
+	for _, tx := range sendable {
+		s.trySendTransferIn(tx, remote)
+	}
+}
 
-			// if the old fee is recorded, may caused by try send transfer in failed
-			// then we add it back first.
-			originAmt := new(big.Int).Add(&tx.Amount, &tx.Fee)
+func (s *server) trySendTransferIn(tx *Transfer, remote sweepTransfers) {
+	bc, foundBc := s.chainMap[tx.ChainId]
+	if !foundBc {
+		return
+	}
+	lg := senderLog.transfer(tx)
+	ctx, span := startTransferSpan("send transfer in", tx)
+	defer span.End()
+	db := s.db.WithContext(ctx)
+	tsNow := time.Now()
+	if tx.TimeLock.Add(time.Duration(-6) * time.Minute).Before(tsNow) {
+		lg.Warnf("this transfer in is already timeout, timeLock:%s", tx.TimeLock.String())
+		return
+	}
 
-			finalFee, getFeeErr := s.gateway.GetFee(tx.RelatedTid)
-			if getFeeErr != nil {
-				log.Errorf("can not get the fee for this transfer, transferOutId:%x, err:%v", tx.RelatedTid, getFeeErr)
-				continue
+	remoteTransferIn, err := remote.getTransfer(bc, tx.TransferId)
+	if err != nil {
+		lg.Errorf("fail to get transfer in, err:%v", err)
+		return
+	}
+	if remoteTransferIn.Status != 0 {
+		lg.Warnf("this transfer in already exist, remote status:%d", remoteTransferIn.Status)
+		if remoteTransferIn.Status == remoteTransferStatusPending {
+			// for some chain, we may miss transfer in event, then we should set it.
+			lg.Warnf("find exist pending transfer, try to set the status to locked")
+			tryResetPendingTransferInStatusErr := db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED,
+				cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START)
+			if tryResetPendingTransferInStatusErr != nil {
+				lg.Errorf("fail to set exist transferIn, err:%v", tryResetPendingTransferInStatusErr)
 			}
+		}
+		return
+	}
 
-			log.Infof("tx:%s, final fee:%s", tx.RelatedTid.String(), finalFee.String())
+	// if the old fee is recorded, may caused by try send transfer in failed
+	// then we add it back first.
+	originAmt := new(big.Int).Add(&tx.Amount, &tx.Fee)
 
-			if finalFee.Cmp(originAmt) > 0 {
-				log.Errorf("this fee is bigger than amount, transferOutId:%x, fee:%s, origin amount:%s, err:%v", tx.RelatedTid, finalFee.String(), originAmt.String(), getFeeErr)
-				continue
-			}
+	finalFee, getFeeErr := s.gateway.GetFee(ctx, tx.RelatedTid)
+	if getFeeErr != nil {
+		lg.Errorf("can not get the fee for this transfer, err:%v", getFeeErr)
+		return
+	}
 
-			newAmount := new(big.Int).Sub(originAmt, finalFee)
+	lg.Infof("final fee:%s", finalFee.String())
 
-			setTransferInAmountAndFeeErr := s.db.SetTransferInAmountAndFee(tx.TransferId, newAmount, finalFee)
-			if setTransferInAmountAndFeeErr != nil {
-				log.Errorf("fail to set transferIn fee and amount, ev:%v, err:%v", tx, setTransferInAmountAndFeeErr)
-				continue
-			}
+	if finalFee.Cmp(originAmt) > 0 {
+		lg.Errorf("this fee is bigger than amount, fee:%s, origin amount:%s", finalFee.String(), originAmt.String())
+		return
+	}
 
-			setDbTransferToPendingErr := s.db.SetPendingTransferIn(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING,
-				cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START)
-			if setDbTransferToPendingErr != nil {
-				log.Errorf("fail to set transferIn to pending, ev:%v, err:%v", tx, setDbTransferToPendingErr)
-				continue
-			}
+	newAmount := new(big.Int).Sub(originAmt, finalFee)
 
-			sendTransferInErr := bc.transferIn(tx.Receiver, tx.Token, newAmount, tx.HashLock, tx.TransferId, tx.RelatedTid, uint64(tx.TimeLock.Unix()), tx.RelatedChainId)
-			if sendTransferInErr != nil {
-				log.Errorf("fail to transferIn, ev:%+v, err:%v", tx, sendTransferInErr)
-				// if fail, let try again one time
-				time.Sleep(6 * time.Second)
-				sendTransferInAgainErr := bc.transferIn(tx.Receiver, tx.Token, newAmount, tx.HashLock, tx.TransferId, tx.RelatedTid, uint64(tx.TimeLock.Unix()), tx.RelatedChainId)
-				if sendTransferInAgainErr != nil {
-					log.Errorf("fail to transferIn again, ev:%+v, err:%v", tx, sendTransferInAgainErr)
-				} else {
-					log.Infof("retry send transferIn success, transferIn id: %x", tx.TransferId)
-				}
-				continue
-			}
+	setTransferInAmountAndFeeErr := db.SetTransferInAmountAndFee(tx.TransferId, newAmount, finalFee)
+	if setTransferInAmountAndFeeErr != nil {
+		lg.Errorf("fail to set transferIn fee and amount, err:%v", setTransferInAmountAndFeeErr)
+		return
+	}
+
+	setDbTransferToPendingErr := db.SetPendingTransferIn(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING,
+		cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START)
+	if setDbTransferToPendingErr != nil {
+		lg.Errorf("fail to set transferIn to pending, err:%v", setDbTransferToPendingErr)
+		return
+	}
+	lg = lg.with("status", cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING)
+
+	sendTransferInErr := bc.transferIn(ctx, lg, tx.Receiver, tx.Token, newAmount, tx.HashLock, tx.TransferId, tx.RelatedTid, uint64(tx.TimeLock.Unix()), tx.RelatedChainId)
+	if sendTransferInErr != nil {
+		lg.Errorf("fail to transferIn, amount:%s, err:%v", newAmount, sendTransferInErr)
+		// if fail, let try again one time
+		time.Sleep(6 * time.Second)
+		sendTransferInAgainErr := bc.transferIn(ctx, lg, tx.Receiver, tx.Token, newAmount, tx.HashLock, tx.TransferId, tx.RelatedTid, uint64(tx.TimeLock.Unix()), tx.RelatedChainId)
+		if sendTransferInAgainErr != nil {
+			lg.Errorf("fail to transferIn again, err:%v", sendTransferInAgainErr)
+			s.recordSendFail(tx, sendTransferInAgainErr)
+		} else {
+			lg.Infof("retry send transferIn success")
+			s.clearSendFail(tx.TransferId)
 		}
+		return
 	}
+	s.clearSendFail(tx.TransferId)
 }
 
 // Once we get the confirm monitor event, we will save the preimage to both transferOut and transferIn.
@@ -851,48 +1190,90 @@ func (s *server) processTrySendTransferIn() {
 func (s *server) processTryConfirmTransfer() {
 	lockedTransfer, dbErr := s.db.GetAllConfirmableLockedTransfer()
 	if dbErr != nil {
-		log.Errorf("fail to query confirmable transfers, err:%s", dbErr)
+		confirmerLog.Errorf("fail to query confirmable transfers, err:%s", dbErr)
 		return
 	}
+	remote := s.readTransfers(lockedTransfer, confirmerLog)
 	for _, tx := range lockedTransfer {
-		dstBcg, foundBcg := s.chainMap[tx.ChainId]
-		if foundBcg {
-			remoteTransfer, err := dstBcg.getTransfer(tx.TransferId)
-			if err != nil {
-				log.Errorf("fail to get transfer, txId:%x, err:%v", tx.TransferId, err)
-				continue
+		s.tryConfirmTransfer(tx, remote)
+	}
+}
+
+// processConfirmQueue confirms the transfers added to the confirm queue.
+func (s *server) processConfirmQueue() {
+	var confirmable []*Transfer
+	for _, tx := range s.takeQueuedTransfers(s.confirmQueue, confirmerLog) {
+		// same conditions as GetAllConfirmableLockedTransfer
+		if tx.Status == cbn.TransferStatus_TRANSFER_STATUS_LOCKED && tx.Preimage != (Hash{}) &&
+			tx.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_MISMATCH {
+			confirmable = append(confirmable, tx)
+		}
+	}
+	remote := s.readTransfers(confirmable, confirmerLog)
+	for _, tx := range confirmable {
+		s.tryConfirmTransfer(tx, remote)
+	}
+}
+
+func (s *server) tryConfirmTransfer(tx *Transfer, remote sweepTransfers) {
+	if !s.checkPreimage(tx, tx.Preimage, "stored preimage") {
+		return
+	}
+	lg := confirmerLog.transfer(tx)
+	ctx, span := startTransferSpan("confirm", tx)
+	defer span.End()
+	db := s.db.WithContext(ctx)
+	dstBcg, foundBcg := s.chainMap[tx.ChainId]
+	if foundBcg {
+		remoteTransfer, err := remote.getTransfer(dstBcg, tx.TransferId)
+		if err != nil {
+			lg.Errorf("fail to get transfer, err:%v", err)
+			return
+		}
+		lg.Debugf("get remote confirmable transfer, remote status:%d", remoteTransfer.Status)
+		if remoteTransfer.Status == remoteTransferStatusPending {
+			deferConfirm, deferral := dstBcg.confirmSched.schedule(dstBcg, tx)
+			if deferConfirm {
+				return
 			}
-			log.Infof("get remote confirmable transfer:%v", remoteTransfer)
-			if remoteTransfer.Status == remoteTransferStatusPending {
-				dbErr = s.db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
-				if dbErr != nil {
-					log.Errorf("update refund to confirm pending failed, tx:%v, err:%v", tx, dbErr)
+			var onMined func(sent *ethtypes.Transaction, receipt *ethtypes.Receipt)
+			if deferral != nil {
+				onMined = func(sent *ethtypes.Transaction, receipt *ethtypes.Receipt) {
+					dstBcg.confirmSched.onConfirmMined(tx.TransferId, deferral, sent, receipt)
 				}
+			}
+			dbErr := db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+			if dbErr != nil {
+				lg.Errorf("fail to update the confirm pending status in db, err:%v", dbErr)
+			}
 
-				log.Infof("try confirm this tx, txId:%x, txType:%s", tx.TransferId, tx.TransferType.String())
-				err = dstBcg.confirm(tx.TransferId, tx.RelatedTid, tx.Preimage, tx.HashLock)
-				if err != nil {
-					log.Errorf("fail to confirm related transfer, ev:%v, err:%v", tx, err)
-					continue
-				}
-			} else if remoteTransfer.Status == remoteTransferStatusRefunded {
-				dbErr = s.db.UpdateTransferStatus(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED)
-				if dbErr != nil {
-					log.Errorf("this transfer is refunded by fail to update the status in db, tx:%v, err:%v", tx, dbErr)
-					continue
-				}
-			} else if remoteTransfer.Status == remoteTransferStatusConfirmed {
-				dbErr = s.db.ConfirmTransfer(tx.TransferId, tx.Preimage, Hash{})
-				if dbErr != nil {
-					log.Errorf("fail to update transfer status to confirmed, tx:%v, err:%v", tx, dbErr)
-					continue
-				}
-			} else {
-				log.Warnf("this transfer in status is invalid, transfer:%v", remoteTransfer)
+			lg = lg.with("status", cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING)
+			err = dstBcg.confirm(ctx, lg, tx.TransferId, tx.Preimage, tx.HashLock, onMined)
+			if err != nil {
+				lg.Errorf("fail to confirm transfer, err:%v", err)
+				return
+			}
+		} else if remoteTransfer.Status == remoteTransferStatusRefunded {
+			dstBcg.confirmSched.end(tx.TransferId)
+			dbErr := db.UpdateTransferStatus(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED)
+			if dbErr != nil {
+				lg.Errorf("this transfer is refunded but fail to update the status in db, err:%v", dbErr)
+				return
+			}
+			s.clearPairSendFail(tx)
+		} else if remoteTransfer.Status == remoteTransferStatusConfirmed {
+			dstBcg.confirmSched.end(tx.TransferId)
+			dbErr := db.ConfirmTransfer(tx.TransferId, tx.Preimage, Hash{})
+			if dbErr != nil {
+				lg.Errorf("fail to update transfer status to confirmed, err:%v", dbErr)
+				return
 			}
+			s.clearPairSendFail(tx)
 		} else {
-			log.Warnf("skip to confirm this tx, because we can not find this chain:%d ,tx:%v", tx.TransferId, tx)
+			lg.Warnf("this transfer status is invalid, remote status:%d", remoteTransfer.Status)
 		}
+	} else {
+		lg.Warnf("skip to confirm this transfer, chain not found")
 	}
 }
 
@@ -900,142 +1281,173 @@ func (s *server) processTryRefundTransferIn() {
 	// do refund
 	refundableTransferIn, dbErr := s.db.GetAllRefundAbleTransferIn()
 	if dbErr != nil {
-		log.Warnf("fail to query refund able transfers, err:%s", dbErr)
+		refunderLog.Warnf("fail to query refund able transfers, err:%s", dbErr)
 		return
 	}
+	remote := s.readTransfers(refundableTransferIn, refunderLog)
 	for _, tx := range refundableTransferIn {
-		bc, foundBc := s.chainMap[tx.ChainId]
-		if foundBc {
-			remoteTransferIn, err := bc.getTransfer(tx.TransferId)
-			if err != nil {
-				log.Errorf("fail to get transfer in, txid:%s, err:%v", tx.TransferId.String(), err)
-				continue
-			}
-			log.Infof("get remote refundable transfer in:%v", remoteTransferIn)
-			if remoteTransferIn.Status == remoteTransferStatusPending {
-				dbErr = s.db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
-				if dbErr != nil {
-					log.Errorf("fail to update the refund pending status in db, tx:%v, err:%v", tx, dbErr)
-				}
-				// before do refund, we should check the related transfer, if it is already confirmed, then we should confirm this transfer instead of refund it.
-				relatedTransfer, exist, getTransferByRelatedTidErr := s.db.GetTransferByTid(tx.RelatedTid)
-				if getTransferByRelatedTidErr != nil {
-					log.Errorf("fail to get related transfer, RelatedTid:%s, err:%v", tx.RelatedTid.String(), err)
-					continue
-				}
-				if !exist {
-					log.Errorf("fail to get related transfer, not exist, transferId:%s, RelatedTid:%s", tx.TransferId.String(), tx.RelatedTid.String())
-					continue
-				}
+		s.tryRefundTransferIn(tx, remote)
+	}
+}
 
-				if relatedTransfer.Status == cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED {
-					err = bc.confirm(tx.TransferId, relatedTransfer.TransferId, relatedTransfer.Preimage, tx.HashLock)
-					if err != nil {
-						log.Errorf("fail to confirm this tx: %v", tx)
-						continue
-					}
-				} else {
-					// for some chain, we may miss confirm info.
-					// here, we should check remote transfer out to make sure it is not confirmed.
-					// may be useless, as we can not directly get the preimage
-					transferOutBc, foundTransferOutBc := s.chainMap[relatedTransfer.ChainId]
-					if !foundTransferOutBc {
-						log.Errorf("fail to find transfer out bc, transferOutId:%x", relatedTransfer.TransferId)
-						continue
-					} else {
-						remoteTransferOut, getRemoteTransferOutErr := transferOutBc.getTransfer(relatedTransfer.TransferId)
-						if getRemoteTransferOutErr != nil {
-							log.Errorf("fail to get remoteTransferOut, transferOutId:%x, err:%v", relatedTransfer.TransferId, err)
-							continue
-						}
-						if remoteTransferOut.Status == remoteTransferStatusConfirmed {
-							log.Errorf("this remote transfer out is already confirmed, we should not refund it, transferOutId:%x", relatedTransfer.TransferId)
-							continue
-						}
-					}
+// processRefundQueue refunds the transfers in added to the refund queue.
+func (s *server) processRefundQueue() {
+	var refundable []*Transfer
+	for _, tx := range s.takeQueuedTransfers(s.refundQueue, refunderLog) {
+		// same conditions as GetAllRefundAbleTransferIn
+		if tx.TransferType == cbn.TransferType_TRANSFER_TYPE_IN && tx.Status == cbn.TransferStatus_TRANSFER_STATUS_LOCKED &&
+			tx.TimeLock.Before(time.Now()) {
+			refundable = append(refundable, tx)
+		}
+	}
+	remote := s.readTransfers(refundable, refunderLog)
+	for _, tx := range refundable {
+		s.tryRefundTransferIn(tx, remote)
+	}
+}
 
-					err = bc.refund(tx.TransferId, tx.RelatedTid, tx.HashLock)
-					if err != nil {
-						log.Errorf("fail to refund this tx: %v, err:%v", tx, err)
-						continue
-					}
+func (s *server) tryRefundTransferIn(tx *Transfer, remote sweepTransfers) {
+	bc, foundBc := s.chainMap[tx.ChainId]
+	if !foundBc {
+		return
+	}
+	lg := refunderLog.transfer(tx)
+	ctx, span := startTransferSpan("refund", tx)
+	defer span.End()
+	db := s.db.WithContext(ctx)
+	remoteTransferIn, err := remote.getTransfer(bc, tx.TransferId)
+	if err != nil {
+		lg.Errorf("fail to get transfer in, err:%v", err)
+		return
+	}
+	lg.Debugf("get remote refundable transfer in, remote status:%d", remoteTransferIn.Status)
+	if remoteTransferIn.Status == remoteTransferStatusPending {
+		dbErr := db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING, cbn.TransferStatus_TRANSFER_STATUS_LOCKED)
+		if dbErr != nil {
+			lg.Errorf("fail to update the refund pending status in db, err:%v", dbErr)
+		}
+		lg = lg.with("status", cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING)
+		// before do refund, we should check the related transfer, if it is already confirmed, then we should confirm this transfer instead of refund it.
+		relatedTransfer, exist, getTransferByRelatedTidErr := db.GetTransferByTid(tx.RelatedTid)
+		if getTransferByRelatedTidErr != nil {
+			lg.Errorf("fail to get related transfer, err:%v", getTransferByRelatedTidErr)
+			return
+		}
+		if !exist {
+			lg.Errorf("fail to get related transfer, not exist")
+			return
+		}
+
+		if relatedTransfer.Status == cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED {
+			if !s.checkPreimage(tx, relatedTransfer.Preimage, "related transfer preimage") {
+				// do not refund either, the related transfer is confirmed on chain
+				return
+			}
+			err = bc.confirm(ctx, lg, tx.TransferId, relatedTransfer.Preimage, tx.HashLock, nil)
+			if err != nil {
+				lg.Errorf("fail to confirm instead of refund, err:%v", err)
+				return
+			}
+		} else {
+			// for some chain, we may miss confirm info.
+			// here, we should check remote transfer out to make sure it is not confirmed.
+			// may be useless, as we can not directly get the preimage
+			transferOutBc, foundTransferOutBc := s.chainMap[relatedTransfer.ChainId]
+			if !foundTransferOutBc {
+				lg.Errorf("fail to find the chain of the related transfer out, chainId:%d", relatedTransfer.ChainId)
+				return
+			} else {
+				remoteTransferOut, getRemoteTransferOutErr := transferOutBc.getTransfer(relatedTransfer.TransferId)
+				if getRemoteTransferOutErr != nil {
+					lg.Errorf("fail to get remote transfer out, err:%v", getRemoteTransferOutErr)
+					return
 				}
-			} else if remoteTransferIn.Status == remoteTransferStatusRefunded {
-				dbErr = s.db.UpdateTransferStatus(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED)
-				if dbErr != nil {
-					log.Errorf("this transfer is refunded by fail to update the status in db, tx:%v, err:%v", tx, dbErr)
+				if remoteTransferOut.Status == remoteTransferStatusConfirmed {
+					lg.Errorf("the remote transfer out is already confirmed, we should not refund it")
+					return
 				}
-			} else {
-				log.Warnf("this transfer in status is invalid, transfer:%v", remoteTransferIn)
 			}
 
+			err = bc.refund(ctx, lg, tx.TransferId, tx.HashLock)
+			if err != nil {
+				lg.Errorf("fail to refund, err:%v", err)
+				return
+			}
 		}
+	} else if remoteTransferIn.Status == remoteTransferStatusRefunded {
+		dbErr := db.UpdateTransferStatus(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED)
+		if dbErr != nil {
+			lg.Errorf("this transfer is refunded but fail to update the status in db, err:%v", dbErr)
+		} else {
+			s.clearPairSendFail(tx)
+		}
+	} else {
+		lg.Warnf("this transfer in status is invalid, remote status:%d", remoteTransferIn.Status)
 	}
 }
 
 func (s *server) processRecoverTimeoutPendingTransferIn() {
 	transfers, dbErr := s.db.GetRecoverTimeoutPendingTransferIn()
 	if dbErr != nil {
-		log.Warnf("fail to get timeout pending transfer in, err:%s", dbErr)
+		senderLog.Warnf("fail to get timeout pending transfer in, err:%s", dbErr)
 		return
 	}
 	for _, tx := range transfers {
 		dbErr = s.db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START,
 			cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING)
 		if dbErr != nil {
-			log.Warnf("fail to recover timeout pending transfer in, err:%s", dbErr)
+			senderLog.transfer(tx).Warnf("fail to recover timeout pending transfer in, err:%s", dbErr)
+			continue
 		}
+		senderLog.transfer(tx).Infof("recover timeout pending transfer in")
+		s.sendQueue.add(tx.TransferId)
 	}
 }
 
 func (s *server) processRecoverTimeoutPendingConfirm() {
 	transfers, dbErr := s.db.GetRecoverTimeoutPendingConfirm()
 	if dbErr != nil {
-		log.Warnf("fail to get timeout pending confirm, err:%s", dbErr)
+		confirmerLog.Warnf("fail to get timeout pending confirm, err:%s", dbErr)
 		return
 	}
 	for _, tx := range transfers {
 		dbErr = s.db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED,
 			cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING)
 		if dbErr != nil {
-			log.Warnf("fail to recover timeout pending confirm transfer in, err:%s", dbErr)
+			confirmerLog.transfer(tx).Warnf("fail to recover timeout pending confirm, err:%s", dbErr)
+			continue
 		}
+		confirmerLog.transfer(tx).Infof("recover timeout pending confirm")
+		s.confirmQueue.add(tx.TransferId)
 	}
 }
 
 func (s *server) processRecoverTimeoutPendingRefund() {
 	transfers, dbErr := s.db.GetRecoverTimeoutPendingRefund()
 	if dbErr != nil {
-		log.Warnf("fail to recover timeout pending refund refund, err:%s", dbErr)
+		refunderLog.Warnf("fail to get timeout pending refund, err:%s", dbErr)
 		return
 	}
 	for _, tx := range transfers {
 		dbErr = s.db.SetTransferStatusByFrom(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED,
 			cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING)
 		if dbErr != nil {
-			log.Warnf("fail to recover timeout pending refund transfer in, err:%s", dbErr)
+			refunderLog.transfer(tx).Warnf("fail to recover timeout pending refund, err:%s", dbErr)
+			continue
 		}
+		refunderLog.transfer(tx).Infof("recover timeout pending refund")
+		s.refundQueue.add(tx.TransferId)
 	}
 }
 
 func (s *server) GetTotalSummary(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	log.Infof("GetTotalSummary")
-	perChain2ChainSummary := make(map[string]*Chain2ChainBreakDownDetail)
-
-	transfers, dbErr := s.db.GetAllTransfers()
+	httpLog.Infof("GetTotalSummary")
+	perChain2ChainSummary, dbErr := s.getTotalSummary()
 	if dbErr != nil {
 		return
 	}
 
-	for _, tx := range transfers {
-		if tx.TransferType == cbn.TransferType_TRANSFER_TYPE_OUT {
-			s.recordTransferOutSummary(tx, perChain2ChainSummary)
-		} else if tx.TransferType == cbn.TransferType_TRANSFER_TYPE_IN {
-			s.recordTransferInSummary(tx, perChain2ChainSummary)
-		}
-	}
-
-	log.Infof("finished")
+	httpLog.Infof("finished")
 
 	content := []string{"------------------------------------------------"}
 	for _, v := range perChain2ChainSummary {
@@ -1057,17 +1469,16 @@ func (s *server) GetTotalSummary(w http.ResponseWriter, r *http.Request, ps http
 	content = append(content, fmt.Sprintf(""))
 
 	resp := strings.Join(content, "\n")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	_, err := w.Write([]byte(resp))
 	if err != nil {
-		log.Errorf("write response err: %v", err)
+		httpLog.Errorf("write response err: %v", err)
 		http.Error(w, "write response failed", http.StatusExpectationFailed)
 		return
 	}
 }
 
 func (s *server) GetTransfer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	log.Infof("GetTransfer")
+	httpLog.Infof("GetTransfer")
 	limitString := ps.ByName("limit")
 	limit, err := strconv.ParseUint(limitString, 10, 64)
 	if err != nil {
@@ -1098,16 +1509,31 @@ func (s *server) GetTransfer(w http.ResponseWriter, r *http.Request, ps httprout
 			tx.Sender.String(), srcChain, dstChain, volume, createTs, txType, txStatus))
 	}
 	resp := strings.Join(content, "\n")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	_, err = w.Write([]byte(resp))
 	if err != nil {
-		log.Errorf("write response err: %v", err)
+		httpLog.Errorf("write response err: %v", err)
 		http.Error(w, "write response failed", http.StatusExpectationFailed)
 		return
 	}
 }
 
-func (s *server) recordTransferInSummary(transferIn *Transfer, perChain2ChainSummary map[string]*Chain2ChainBreakDownDetail) {
+func (s *server) getTotalSummary() (map[string]*Chain2ChainBreakDownDetail, error) {
+	perChain2ChainSummary := make(map[string]*Chain2ChainBreakDownDetail)
+	summaries, dbErr := s.db.GetTransferSummary()
+	if dbErr != nil {
+		return nil, dbErr
+	}
+	for _, ts := range summaries {
+		if ts.TransferType == cbn.TransferType_TRANSFER_TYPE_OUT {
+			s.recordTransferOutSummary(ts, perChain2ChainSummary)
+		} else if ts.TransferType == cbn.TransferType_TRANSFER_TYPE_IN {
+			s.recordTransferInSummary(ts, perChain2ChainSummary)
+		}
+	}
+	return perChain2ChainSummary, nil
+}
+
+func (s *server) recordTransferInSummary(transferIn *TransferSummary, perChain2ChainSummary map[string]*Chain2ChainBreakDownDetail) {
 	if transferIn.Status == cbn.TransferStatus_TRANSFER_STATUS_LOCKED ||
 		transferIn.Status == cbn.TransferStatus_TRANSFER_STATUS_REFUNDED ||
 		transferIn.Status == cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED {
@@ -1137,7 +1563,7 @@ func (s *server) recordTransferInSummary(transferIn *Transfer, perChain2ChainSum
 		case cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED:
 			chain2ChainSummary.GasCost = new(big.Int).Add(chain2ChainSummary.GasCost, &transferIn.TransferGasCost)
 			chain2ChainSummary.GasCost = new(big.Int).Add(chain2ChainSummary.GasCost, &transferIn.ConfirmGasCost)
-			chain2ChainSummary.TotalSuccessTransferInNumber++
+			chain2ChainSummary.TotalSuccessTransferInNumber += transferIn.Count
 			chain2ChainSummaryFee, foundChain2ChainSummaryFee := chain2ChainSummary.FeeReceived[transferIn.Token]
 			if !foundChain2ChainSummaryFee {
 				chain2ChainSummaryFee = &TokenFeeSummary{
@@ -1155,7 +1581,7 @@ func (s *server) recordTransferInSummary(transferIn *Transfer, perChain2ChainSum
 	}
 }
 
-func (s *server) recordTransferOutSummary(transferOut *Transfer, perChain2ChainSummary map[string]*Chain2ChainBreakDownDetail) {
+func (s *server) recordTransferOutSummary(transferOut *TransferSummary, perChain2ChainSummary map[string]*Chain2ChainBreakDownDetail) {
 	key := generateChain2ChainKey(transferOut.ChainId, transferOut.RelatedChainId)
 	chain2ChainSummary, foundChain2ChainSummary := perChain2ChainSummary[key]
 	if !foundChain2ChainSummary {
@@ -1169,7 +1595,7 @@ func (s *server) recordTransferOutSummary(transferOut *Transfer, perChain2ChainS
 		}
 		perChain2ChainSummary[key] = chain2ChainSummary
 	}
-	chain2ChainSummary.TotalTransferOutNumber++
+	chain2ChainSummary.TotalTransferOutNumber += transferOut.Count
 }
 
 func (s *server) getTokenName(chainId uint64, tokenAddr Addr) string {
@@ -1188,6 +1614,18 @@ func (s *server) getTokenDecimal(chainId uint64, tokenAddr Addr) uint64 {
 	return decimal
 }
 
+// convertTokenAmount converts the amount in the base unit of a token of srcDecimal to that of dstDecimal.
+func convertTokenAmount(amount *big.Int, srcDecimal, dstDecimal uint64) *big.Int {
+	if srcDecimal > dstDecimal {
+		p := new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(srcDecimal-dstDecimal), nil)
+		return new(big.Int).Div(amount, p)
+	} else if srcDecimal < dstDecimal {
+		p := new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(dstDecimal-srcDecimal), nil)
+		return new(big.Int).Mul(amount, p)
+	}
+	return amount
+}
+
 func (s *server) getGasTokenInfo(chainId uint64) *chainGasTokenInfo {
 	info, found := s.chainGasTokenMap[chainId]
 	if !found {
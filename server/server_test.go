@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+func TestSafeBlockNumber(t *testing.T) {
+	bc := &bridgeConfig{
+		config: &cbn.ChainConfig{WatchConfig: &cbn.WatchConfig{BlockDelay: 5}},
+		sub:    &logSubscriber{},
+	}
+	// the latest block until the monitoring has seen block_delay blocks
+	for _, head := range []uint64{0, 3} {
+		bc.sub.head = head
+		if safe := bc.safeBlockNumber(); safe != nil {
+			t.Errorf("head %d: expect the latest block, got %s", head, safe)
+		}
+	}
+	for head, expected := range map[uint64]uint64{5: 0, 105: 100} {
+		bc.sub.head = head
+		if safe := bc.safeBlockNumber(); safe == nil || safe.Uint64() != expected {
+			t.Errorf("head %d: expect block %d, got %v", head, expected, safe)
+		}
+	}
+}
@@ -0,0 +1,584 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celer-network/cBridge-go/contracts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const simGasLimit = 30000000
+
+// simChain is an in-memory chain with its own chain id, which the CBridge contract reads with CHAINID.
+// Every transaction is mined in a new block right away, and the node connects to it with an ethclient
+// through an in-process JSON-RPC server, so no network is needed.
+type simChain struct {
+	mu       sync.Mutex
+	chainId  *big.Int
+	config   *params.ChainConfig
+	db       ethdb.Database
+	chain    *core.BlockChain
+	signer   ethtypes.Signer
+	offset   time.Duration // added to the wall clock for the block time
+	dropLogs map[common.Hash]bool
+	rc       *rpc.Client
+	ec       *ethclient.Client
+	srv      *rpc.Server
+	// clients with subscriptions, see dialWs
+	wsClients []*rpc.Client
+	wsDown    bool
+	// eth_calls to multicallAddr run the tryAggregate of a Multicall contract, see setMulticall
+	multicallAddr Addr
+	multicallDown bool
+	multicalls    int
+}
+
+func newSimChain(t *testing.T, chainId uint64, alloc ...Addr) *simChain {
+	config := *params.AllEthashProtocolChanges
+	config.ChainID = new(big.Int).SetUint64(chainId)
+	genesisAlloc := core.GenesisAlloc{}
+	for _, addr := range alloc {
+		genesisAlloc[addr] = core.GenesisAccount{Balance: new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))}
+	}
+	db := rawdb.NewMemoryDatabase()
+	genesis := &core.Genesis{
+		Config:    &config,
+		GasLimit:  simGasLimit,
+		Alloc:     genesisAlloc,
+		Timestamp: uint64(time.Now().Unix()),
+	}
+	genesis.MustCommit(db)
+	chain, err := core.NewBlockChain(db, nil, &config, ethash.NewFullFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &simChain{
+		chainId:  config.ChainID,
+		config:   &config,
+		db:       db,
+		chain:    chain,
+		signer:   ethtypes.LatestSignerForChainID(config.ChainID),
+		dropLogs: make(map[common.Hash]bool),
+	}
+	srv := rpc.NewServer()
+	if err = srv.RegisterName("eth", &simChainAPI{c: c}); err != nil {
+		t.Fatal(err)
+	}
+	c.srv = srv
+	c.rc = rpc.DialInProc(srv)
+	c.ec = ethclient.NewClient(c.rc)
+	t.Cleanup(func() {
+		c.ec.Close()
+		srv.Stop()
+		chain.Stop()
+	})
+	return c
+}
+
+// advance moves the block time forward by d and mines an empty block.
+func (c *simChain) advance(d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += d
+	return c.mine(nil)
+}
+
+// now is the wall clock of the chain.
+func (c *simChain) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+// dropEvent makes the chain return no logs of the event from now on, like a flaky RPC endpoint.
+func (c *simChain) dropEvent(contractAbi abi.ABI, event string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropLogs[contractAbi.Events[event].ID] = true
+}
+
+// dialWs returns a new client to the chain, like one connected to a websocket endpoint.
+func (c *simChain) dialWs() (*rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.wsDown {
+		return nil, errors.New("websocket endpoint down")
+	}
+	rc := rpc.DialInProc(c.srv)
+	c.wsClients = append(c.wsClients, rc)
+	return rc, nil
+}
+
+// setWsDown disconnects the clients returned by dialWs and fails new dials until set up again.
+func (c *simChain) setWsDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wsDown = down
+	if down {
+		for _, rc := range c.wsClients {
+			rc.Close()
+		}
+		c.wsClients = nil
+	}
+}
+
+// mine mines a block with the tx, if not nil. c.mu must be held.
+func (c *simChain) mine(tx *ethtypes.Transaction) (err error) {
+	parent := c.chain.CurrentBlock()
+	blockTime := uint64(time.Now().Add(c.offset).Unix())
+	if blockTime <= parent.Time() {
+		blockTime = parent.Time() + 1
+	}
+	defer func() {
+		// the block generator panics on invalid transactions
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	blocks, _ := core.GenerateChain(c.config, parent, ethash.NewFaker(), c.db, 1, func(i int, b *core.BlockGen) {
+		// the generated block time is parent time + 10s
+		b.OffsetTime(int64(blockTime) - int64(parent.Time()) - 10)
+		if tx != nil {
+			b.AddTxWithChain(c.chain, tx)
+		}
+	})
+	_, err = c.chain.InsertChain(blocks)
+	return err
+}
+
+func (c *simChain) blockAt(blockNr rpc.BlockNumberOrHash) *ethtypes.Block {
+	if hash, ok := blockNr.Hash(); ok {
+		return c.chain.GetBlockByHash(hash)
+	}
+	if num, ok := blockNr.Number(); ok && num >= 0 {
+		return c.chain.GetBlockByNumber(uint64(num))
+	}
+	return c.chain.CurrentBlock()
+}
+
+func (c *simChain) stateAt(blockNr rpc.BlockNumberOrHash) (*ethtypes.Block, *state.StateDB, error) {
+	block := c.blockAt(blockNr)
+	if block == nil {
+		return nil, nil, errors.New("block not found")
+	}
+	statedb, err := c.chain.StateAt(block.Root())
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, statedb, nil
+}
+
+func (c *simChain) call(args *simCallArgs, blockNr rpc.BlockNumberOrHash, gas uint64) (*core.ExecutionResult, error) {
+	block, st, err := c.stateAt(blockNr)
+	if err != nil {
+		return nil, err
+	}
+	var from Addr
+	if args.From != nil {
+		from = *args.From
+	}
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+	zero := new(big.Int)
+	msg := ethtypes.NewMessage(from, args.To, 0, value, gas, zero, zero, zero, args.data(), nil, true)
+	evm := vm.NewEVM(core.NewEVMBlockContext(block.Header(), c.chain, nil), core.NewEVMTxContext(msg), st, c.config,
+		vm.Config{NoBaseFee: true})
+	return core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+}
+
+func (c *simChain) logs(crit filters.FilterCriteria) []*ethtypes.Log {
+	head := c.chain.CurrentBlock().NumberU64()
+	from, to := uint64(0), head
+	if crit.FromBlock != nil && crit.FromBlock.Sign() >= 0 {
+		from = crit.FromBlock.Uint64()
+	}
+	if crit.ToBlock != nil && crit.ToBlock.Sign() >= 0 && crit.ToBlock.Uint64() < head {
+		to = crit.ToBlock.Uint64()
+	}
+	logs := []*ethtypes.Log{}
+	for num := from; num <= to; num++ {
+		block := c.chain.GetBlockByNumber(num)
+		if block == nil {
+			break
+		}
+		for _, receipt := range rawdb.ReadReceipts(c.db, block.Hash(), num, c.config) {
+			for _, l := range receipt.Logs {
+				if matchLog(l, crit) && !c.dropLogs[l.Topics[0]] {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+	return logs
+}
+
+func matchLog(l *ethtypes.Log, crit filters.FilterCriteria) bool {
+	if len(crit.Addresses) > 0 {
+		found := false
+		for _, addr := range crit.Addresses {
+			found = found || addr == l.Address
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(crit.Topics) > len(l.Topics) {
+		return false
+	}
+	for i, topics := range crit.Topics {
+		found := len(topics) == 0
+		for _, topic := range topics {
+			found = found || topic == l.Topics[i]
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(l.Topics) > 0
+}
+
+type simCallArgs struct {
+	From     *Addr           `json:"from"`
+	To       *Addr           `json:"to"`
+	Gas      *hexutil.Uint64 `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Data     *hexutil.Bytes  `json:"data"`
+	Input    *hexutil.Bytes  `json:"input"`
+}
+
+func (args *simCallArgs) data() []byte {
+	if args.Input != nil {
+		return *args.Input
+	}
+	if args.Data != nil {
+		return *args.Data
+	}
+	return nil
+}
+
+func resultError(res *core.ExecutionResult) error {
+	if reason, err := abi.UnpackRevert(res.Revert()); err == nil {
+		return fmt.Errorf("execution reverted: %s", reason)
+	}
+	return res.Err
+}
+
+// setMulticall serves a Multicall contract at addr, or fails the calls to it if down.
+func (c *simChain) setMulticall(addr Addr, down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.multicallAddr = addr
+	c.multicallDown = down
+}
+
+func (c *simChain) getMulticalls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.multicalls
+}
+
+// tryAggregate runs the calls like Multicall2.tryAggregate(false, calls), there is no code deployed for it.
+func (c *simChain) tryAggregate(args *simCallArgs, blockNr rpc.BlockNumberOrHash, gas uint64) (hexutil.Bytes, error) {
+	if c.multicallDown {
+		return nil, errors.New("execution reverted")
+	}
+	c.multicalls++
+	method := simMulticallABI.Methods["tryAggregate"]
+	input := args.data()
+	if len(input) < 4 {
+		return nil, errors.New("no method id")
+	}
+	in, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil, err
+	}
+	var results []contracts.Multicall2Result
+	for _, call := range *abi.ConvertType(in[1], new([]contracts.Multicall2Call)).(*[]contracts.Multicall2Call) {
+		data := hexutil.Bytes(call.CallData)
+		target := call.Target
+		res, err := c.call(&simCallArgs{From: args.From, To: &target, Data: &data}, blockNr, gas)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, contracts.Multicall2Result{Success: !res.Failed(), ReturnData: res.Return()})
+	}
+	return method.Outputs.Pack(results)
+}
+
+var simMulticallABI = mustParseABI(contracts.Multicall2ABI)
+
+// simChainAPI serves the subset of the eth JSON-RPC namespace used by the relay node and the tests.
+type simChainAPI struct {
+	c *simChain
+}
+
+func (api *simChainAPI) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(api.c.chainId)
+}
+
+func (api *simChainAPI) BlockNumber() hexutil.Uint64 {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	return hexutil.Uint64(api.c.chain.CurrentBlock().NumberU64())
+}
+
+func (api *simChainAPI) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) (*ethtypes.Header, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	block := api.c.blockAt(rpc.BlockNumberOrHashWithNumber(number))
+	if block == nil {
+		return nil, nil
+	}
+	return block.Header(), nil
+}
+
+func (api *simChainAPI) GasPrice() *hexutil.Big {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	price := new(big.Int).Add(api.c.chain.CurrentBlock().BaseFee(), big.NewInt(params.GWei))
+	return (*hexutil.Big)(price)
+}
+
+func (api *simChainAPI) MaxPriorityFeePerGas() *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(params.GWei))
+}
+
+// FeeHistory serves eth_feeHistory, the reward percentiles are of the effective tips of the txs in each block.
+func (api *simChainAPI) FeeHistory(blocks hexutil.Uint64, last rpc.BlockNumber, percentiles []float64) (*feeHistory, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	head := api.c.blockAt(rpc.BlockNumberOrHashWithNumber(last))
+	if head == nil {
+		return nil, errors.New("block not found")
+	}
+	n := uint64(blocks)
+	if n > head.NumberU64()+1 {
+		n = head.NumberU64() + 1
+	}
+	oldest := head.NumberU64() + 1 - n
+	res := &feeHistory{OldestBlock: (*hexutil.Big)(new(big.Int).SetUint64(oldest))}
+	for num := oldest; num <= head.NumberU64(); num++ {
+		block := api.c.chain.GetBlockByNumber(num)
+		var tips []*big.Int
+		for _, tx := range block.Transactions() {
+			tip, err := tx.EffectiveGasTip(block.BaseFee())
+			if err != nil {
+				return nil, err
+			}
+			tips = append(tips, tip)
+		}
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		reward := make([]*hexutil.Big, len(percentiles))
+		for i, p := range percentiles {
+			reward[i] = (*hexutil.Big)(new(big.Int))
+			if len(tips) > 0 {
+				reward[i] = (*hexutil.Big)(tips[int(p/100*float64(len(tips)-1))])
+			}
+		}
+		res.Reward = append(res.Reward, reward)
+		res.BaseFee = append(res.BaseFee, (*hexutil.Big)(block.BaseFee()))
+	}
+	res.BaseFee = append(res.BaseFee, (*hexutil.Big)(misc.CalcBaseFee(api.c.config, head.Header())))
+	return res, nil
+}
+
+func (api *simChainAPI) GetTransactionCount(addr Addr, blockNr rpc.BlockNumberOrHash) (hexutil.Uint64, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	_, st, err := api.c.stateAt(blockNr)
+	if err != nil {
+		return 0, err
+	}
+	return hexutil.Uint64(st.GetNonce(addr)), nil
+}
+
+func (api *simChainAPI) GetBalance(addr Addr, blockNr rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	_, st, err := api.c.stateAt(blockNr)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(st.GetBalance(addr)), nil
+}
+
+func (api *simChainAPI) GetCode(addr Addr, blockNr rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	_, st, err := api.c.stateAt(blockNr)
+	if err != nil {
+		return nil, err
+	}
+	return st.GetCode(addr), nil
+}
+
+func (api *simChainAPI) Call(args simCallArgs, blockNr rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	gas := uint64(simGasLimit)
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	}
+	if args.To != nil && api.c.multicallAddr != (Addr{}) && *args.To == api.c.multicallAddr {
+		return api.c.tryAggregate(&args, blockNr, gas)
+	}
+	res, err := api.c.call(&args, blockNr, gas)
+	if err != nil {
+		return nil, err
+	}
+	if res.Failed() {
+		return nil, resultError(res)
+	}
+	return res.Return(), nil
+}
+
+func (api *simChainAPI) EstimateGas(args simCallArgs) (hexutil.Uint64, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	lo, hi := params.TxGas-1, uint64(simGasLimit)
+	res, err := api.c.call(&args, latest, hi)
+	if err != nil {
+		return 0, err
+	}
+	if res.Failed() {
+		return 0, resultError(res)
+	}
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		res, err = api.c.call(&args, latest, mid)
+		if err != nil || res.Failed() {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hexutil.Uint64(hi), nil
+}
+
+func (api *simChainAPI) SendRawTransaction(input hexutil.Bytes) (Hash, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	tx := new(ethtypes.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return Hash{}, err
+	}
+	from, err := ethtypes.Sender(api.c.signer, tx)
+	if err != nil {
+		return Hash{}, err
+	}
+	_, st, err := api.c.stateAt(rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber))
+	if err != nil {
+		return Hash{}, err
+	}
+	if nonce := st.GetNonce(from); tx.Nonce() < nonce {
+		return Hash{}, core.ErrNonceTooLow
+	} else if tx.Nonce() > nonce {
+		return Hash{}, core.ErrNonceTooHigh
+	}
+	if err = api.c.mine(tx); err != nil {
+		return Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+func (api *simChainAPI) GetTransactionByHash(hash Hash) (*ethtypes.Transaction, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	tx, _, _, _ := rawdb.ReadTransaction(api.c.db, hash)
+	return tx, nil
+}
+
+func (api *simChainAPI) GetTransactionReceipt(hash Hash) (*ethtypes.Receipt, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	receipt, _, _, _ := rawdb.ReadReceipt(api.c.db, hash, api.c.config)
+	return receipt, nil
+}
+
+// NewHeads serves eth_subscribe("newHeads").
+func (api *simChainAPI) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	heads := make(chan core.ChainHeadEvent, 16)
+	headSub := api.c.chain.SubscribeChainHeadEvent(heads)
+	go func() {
+		defer headSub.Unsubscribe()
+		for {
+			select {
+			case ev := <-heads:
+				_ = notifier.Notify(sub.ID, ev.Block.Header())
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// Logs serves eth_subscribe("logs").
+func (api *simChainAPI) Logs(ctx context.Context, crit filters.FilterCriteria) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	logs := make(chan []*ethtypes.Log, 16)
+	logSub := api.c.chain.SubscribeLogsEvent(logs)
+	go func() {
+		defer logSub.Unsubscribe()
+		for {
+			select {
+			case ls := <-logs:
+				api.c.mu.Lock()
+				for _, l := range ls {
+					if matchLog(l, crit) && !api.c.dropLogs[l.Topics[0]] {
+						_ = notifier.Notify(sub.ID, l)
+					}
+				}
+				api.c.mu.Unlock()
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (api *simChainAPI) GetLogs(crit filters.FilterCriteria) ([]*ethtypes.Log, error) {
+	api.c.mu.Lock()
+	defer api.c.mu.Unlock()
+	return api.c.logs(crit), nil
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/eth/watcher"
+)
+
+const (
+	dbDriverPostgres = "postgres"
+	dbDriverSqlite   = "sqlite3"
+	// legacy connection string used when only the db host is configured
+	dbFmt             = "postgresql://cbridge@%s/cbridge?sslmode=disable"
+	defaultDbUser     = "cbridge"
+	defaultDbName     = "cbridge"
+	defaultDbPoolSize = 20
+	dbPasswordEnv     = "CBRIDGE_DB_PASSWORD"
+)
+
+// TransferStore is the storage used by the server, implemented by DAL over CockroachDB/PostgreSQL
+// and by SqliteDAL over an embedded SQLite file.
+type TransferStore interface {
+	watcher.WatchDAL
+	Close()
+	// Ping checks the db is reachable.
+	Ping() error
+	// WithContext returns the store tracing its queries in the span of ctx, e.g. of a step of a transfer.
+	WithContext(ctx context.Context) TransferStore
+
+	// The "transfer" table.
+	InsertTransfer(tx *Transfer) error
+	SetRelatedTxPreimage(preimage, relatedTid Hash) error
+	ConfirmTransfer(tid, preimage, txConfirmHash Hash) error
+	MarkPreimageMismatch(tid Hash) error
+	RefundTransfer(tid, txRefundHash Hash) error
+	RecordTransferIn(tid, txHash Hash) error
+	UpdateTransferStatus(tid Hash, to cbn.TransferStatus) error
+	SetTransferInAmountAndFee(tid Hash, amount, fee *big.Int) error
+	SetPendingTransferIn(tid Hash, to, from cbn.TransferStatus) error
+	SetTransferStatusByFrom(tid Hash, to, from cbn.TransferStatus) error
+	GetTransferByTid(tid Hash) (*Transfer, bool, error)
+	GetTransferByRelatedTid(relatedTid Hash) (*Transfer, bool, error)
+	GetAllTransfers() ([]*Transfer, error)
+	GetAllTransfersWithLimit(limit uint64) ([]*Transfer, error)
+	GetTransfersWithFilter(limit uint64, status cbn.TransferStatus, chainId uint64) ([]*Transfer, error)
+	GetAllStartTransferIn() ([]*Transfer, error)
+	GetAllConfirmableLockedTransfer() ([]*Transfer, error)
+	GetRecoverTimeoutPendingTransferIn() ([]*Transfer, error)
+	GetRecoverTimeoutPendingConfirm() ([]*Transfer, error)
+	GetRecoverTimeoutPendingRefund() ([]*Transfer, error)
+	GetAllRefundAbleTransferIn() ([]*Transfer, error)
+	GetOpenTransferOut() ([]*TransferPair, error)
+	GetTransferSummary() ([]*TransferSummary, error)
+	ArchiveTransfers(before time.Time, limit uint64) (uint64, error)
+	RestoreTransfers(from, to, holdUntil time.Time) (uint64, error)
+
+	// The "rebalance" table.
+	InsertRebalance(r *Rebalance) error
+	SetRebalanceStatusByFrom(tid Hash, to, from cbn.RebalanceStatus) error
+	RecordRebalanceTransferIn(tid, dstTid Hash, dstAmount *big.Int) (bool, error)
+	GetRebalance(tid Hash) (*Rebalance, bool, error)
+	GetRebalanceByDstTid(dstTid Hash) (*Rebalance, bool, error)
+	GetRebalancesWithLimit(limit uint64) ([]*Rebalance, error)
+	GetActiveRebalances() ([]*Rebalance, error)
+
+	// The "event_inbox" table.
+	InsertInboxEvent(e *InboxEvent) (bool, error)
+	GetDueInboxEvents(limit uint64) ([]*InboxEvent, error)
+	GetInboxEvents(limit uint64, status cbn.InboxEventStatus, chainId uint64) ([]*InboxEvent, error)
+	UpdateInboxEvent(e *InboxEvent) error
+	RequeueInboxEvents(chainId uint64, txHash string) (uint64, error)
+	DeleteProcessedInboxEvents(before time.Time) (uint64, error)
+
+	// The "chain_tx" table.
+	InsertChainTx(t *ChainTx) error
+	GetPendingChainTxByNonce(chainId, nonce uint64) (*ChainTx, bool, error)
+	SetChainTxMined(t *ChainTx) error
+	SetChainTxFailed(chainId uint64, txHash Hash, txErr string) error
+	GetChainTxsByTransfer(transferId Hash) ([]*ChainTx, error)
+	GetChainTxs(limit uint64, status cbn.ChainTxStatus, chainId uint64) ([]*ChainTx, error)
+	GetPendingChainTxs(before time.Time, limit uint64) ([]*ChainTx, error)
+
+	// The "leader_lease" table.
+	AcquireLeaderLease(name, holder string, ttl time.Duration) (uint64, bool, error)
+	CheckLeaderLease(name, holder string, token uint64) (bool, error)
+	ReleaseLeaderLease(name, holder string) error
+	GetLeaderLease(name string) (*LeaderLease, bool, error)
+}
+
+var (
+	_ TransferStore = (*DAL)(nil)
+	_ TransferStore = (*SqliteDAL)(nil)
+)
+
+// NewTransferStore opens the store configured by db_config, or the legacy db host if db_config is not set.
+// Pending schema migrations are applied unless db_config.manual_migrate is set.
+func NewTransferStore(config *cbn.CBridgeConfig) (TransferStore, error) {
+	d, err := OpenDAL(config)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.prepareSchema(!config.GetDbConfig().GetManualMigrate()); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if d.driver == dbDriverSqlite {
+		return &SqliteDAL{d}, nil
+	}
+	return d, nil
+}
+
+// OpenDAL opens the db configured without touching the schema.
+func OpenDAL(config *cbn.CBridgeConfig) (*DAL, error) {
+	dbCfg := config.GetDbConfig()
+	if dbCfg == nil {
+		return NewDAL(dbDriverPostgres, fmt.Sprintf(dbFmt, config.GetDb()), defaultDbPoolSize)
+	}
+	switch dbCfg.GetDriver() {
+	case "", dbDriverPostgres:
+		poolSize := defaultDbPoolSize
+		if dbCfg.GetPoolSize() > 0 {
+			poolSize = int(dbCfg.GetPoolSize())
+		}
+		return NewDAL(dbDriverPostgres, postgresDsn(dbCfg), poolSize)
+	case dbDriverSqlite:
+		if dbCfg.GetDsn() == "" {
+			return nil, fmt.Errorf("dsn (db file path) is required for %s", dbDriverSqlite)
+		}
+		return NewDAL(dbDriverSqlite, dbCfg.GetDsn(), 0)
+	default:
+		return nil, fmt.Errorf("unsupported db driver %s", dbCfg.GetDriver())
+	}
+}
+
+// postgresDsn builds the connection string from the config fields, unless the full dsn is given.
+func postgresDsn(cfg *cbn.DbConfig) string {
+	password := cfg.GetPassword()
+	if env := os.Getenv(dbPasswordEnv); env != "" {
+		password = env
+	}
+	if cfg.GetDsn() != "" {
+		if password == "" {
+			return cfg.GetDsn()
+		}
+		// the password from env takes precedence over the one in dsn
+		u, err := url.Parse(cfg.GetDsn())
+		if err != nil || u.User == nil {
+			return cfg.GetDsn()
+		}
+		u.User = url.UserPassword(u.User.Username(), password)
+		return u.String()
+	}
+
+	user := cfg.GetUser()
+	if user == "" {
+		user = defaultDbUser
+	}
+	database := cfg.GetDatabase()
+	if database == "" {
+		database = defaultDbName
+	}
+	sslMode := cfg.GetSslMode()
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	u := &url.URL{
+		Scheme: "postgresql",
+		Host:   cfg.GetHost(),
+		Path:   "/" + database,
+	}
+	if password != "" {
+		u.User = url.UserPassword(user, password)
+	} else {
+		u.User = url.User(user)
+	}
+	params := url.Values{}
+	params.Set("sslmode", sslMode)
+	if cfg.GetSslRootCert() != "" {
+		params.Set("sslrootcert", cfg.GetSslRootCert())
+	}
+	if cfg.GetSslCert() != "" {
+		params.Set("sslcert", cfg.GetSslCert())
+	}
+	if cfg.GetSslKey() != "" {
+		params.Set("sslkey", cfg.GetSslKey())
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
@@ -0,0 +1,839 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// env of a CockroachDB/PostgreSQL dsn, the conformance suite also runs against it if set.
+// The db is migrated to the latest schema and all rows of the cbridge tables are deleted.
+const testPostgresDsnEnv = "CBRIDGE_TEST_POSTGRES_DSN"
+
+func TestSqliteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cbridge-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewSqliteDAL(filepath.Join(dir, "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testTransferStore(t, store, store.DAL)
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(testPostgresDsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testPostgresDsnEnv)
+	}
+	store, err := NewDAL(dbDriverPostgres, dsn, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.prepareSchema(true); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"monitor", "transfer", "rebalance", "transfer_archive", "transfer_rollup", "transfer_hold", "event_inbox",
+		"chain_tx", "leader_lease"} {
+		if _, err = store.Exec("DELETE FROM " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testTransferStore(t, store, store)
+}
+
+// testTransferStore is the conformance suite every TransferStore implementation must pass.
+// d is the DAL under the store, used to set up rows not reachable through the interface.
+func testTransferStore(t *testing.T, store TransferStore, d *DAL) {
+	t.Run("Monitor", func(t *testing.T) { testStoreMonitor(t, store) })
+	t.Run("Transfer", func(t *testing.T) { testStoreTransfer(t, store) })
+	t.Run("Preimage", func(t *testing.T) { testStorePreimage(t, store) })
+	t.Run("Queries", func(t *testing.T) { testStoreQueries(t, store, d) })
+	t.Run("Summary", func(t *testing.T) { testStoreSummary(t, store, d) })
+	t.Run("Archive", func(t *testing.T) { testStoreArchive(t, store, d) })
+	t.Run("Rebalance", func(t *testing.T) { testStoreRebalance(t, store) })
+	t.Run("Inbox", func(t *testing.T) { testStoreInbox(t, store) })
+	t.Run("ChainTx", func(t *testing.T) { testStoreChainTx(t, store) })
+	t.Run("LeaderLease", func(t *testing.T) { testStoreLeaderLease(t, store) })
+}
+
+func testStoreMonitor(t *testing.T, store TransferStore) {
+	if _, _, found, err := store.GetMonitorBlock("ev"); err != nil || found {
+		t.Fatalf("expect not found, found:%t err:%v", found, err)
+	}
+	if err := store.InsertMonitor("ev", 10, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateMonitorBlock("ev", 11, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertMonitorBlock("ev", 12, 3, false); err != nil {
+		t.Fatal(err)
+	}
+	blockNum, blockIdx, found, err := store.GetMonitorBlock("ev")
+	if err != nil || !found || blockNum != 12 || blockIdx != 3 {
+		t.Fatalf("unexpected monitor block %d %d, found:%t err:%v", blockNum, blockIdx, found, err)
+	}
+}
+
+var testTokenAddr = Hex2Addr("0x1af3f329e8be154074d8769d1ffa4ee058b1dbc3")
+
+func newTestTransfer(id byte, transferType cbn.TransferType, status cbn.TransferStatus, timeLock time.Time) *Transfer {
+	tx := &Transfer{
+		TransferId:     Bytes2Hash([]byte{id}),
+		TxHash:         Bytes2Hash([]byte{id, 1}),
+		ChainId:        5,
+		Token:          testTokenAddr,
+		TransferType:   transferType,
+		TimeLock:       timeLock,
+		HashLock:       getHashLockWithPreImage(Bytes2Hash([]byte{id, 2})),
+		Status:         status,
+		RelatedTid:     Bytes2Hash([]byte{id, 3}),
+		RelatedChainId: 4,
+		RelatedToken:   testTokenAddr,
+		Sender:         Bytes2Addr([]byte{id, 4}),
+		Receiver:       Bytes2Addr([]byte{id, 5}),
+	}
+	tx.Amount.SetString("123456789012345678901234567890", 10)
+	tx.Fee.SetInt64(1000)
+	return tx
+}
+
+func mustGetTransfer(t *testing.T, store TransferStore, tid Hash) *Transfer {
+	t.Helper()
+	tx, found, err := store.GetTransferByTid(tid)
+	if err != nil || !found {
+		t.Fatalf("fail to get transfer %x, found:%t err:%v", tid, found, err)
+	}
+	return tx
+}
+
+func transferIds(txs []*Transfer) []Hash {
+	var ids []Hash
+	for _, tx := range txs {
+		ids = append(ids, tx.TransferId)
+	}
+	return ids
+}
+
+func expectTransferIds(t *testing.T, name string, txs []*Transfer, err error, expected ...*Transfer) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	ids := transferIds(txs)
+	if len(ids) != len(expected) {
+		t.Fatalf("%s: expect %d transfers, got %x", name, len(expected), ids)
+	}
+	for i, tx := range expected {
+		if ids[i] != tx.TransferId {
+			t.Fatalf("%s: expect transfer %x at %d, got %x", name, tx.TransferId, i, ids)
+		}
+	}
+}
+
+func testStoreTransfer(t *testing.T, store TransferStore) {
+	timeLock := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	in := newTestTransfer(0x10, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START, timeLock)
+	if err := store.InsertTransfer(in); err != nil {
+		t.Fatal(err)
+	}
+	// duplicated inserts are ignored
+	dup := *in
+	dup.ChainId = 100
+	if err := store.InsertTransfer(&dup); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mustGetTransfer(t, store, in.TransferId)
+	if got.TxHash != in.TxHash || got.ChainId != in.ChainId || got.Token != in.Token || got.TransferType != in.TransferType ||
+		!got.TimeLock.Equal(timeLock) || got.HashLock != in.HashLock || got.Status != in.Status || got.RelatedTid != in.RelatedTid ||
+		got.RelatedChainId != in.RelatedChainId || got.Amount.Cmp(&in.Amount) != 0 || got.Fee.Cmp(&in.Fee) != 0 ||
+		got.Sender != in.Sender || got.Receiver != in.Receiver || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_NONE {
+		t.Fatalf("transfer not stored as inserted, expect %+v, got %+v", in, got)
+	}
+	if time.Since(got.CreateTs) > time.Minute || time.Since(got.UpdateTs) > time.Minute {
+		t.Fatalf("unexpected timestamps, createTs:%s updateTs:%s", got.CreateTs, got.UpdateTs)
+	}
+	if _, found, err := store.GetTransferByTid(Bytes2Hash([]byte{0xff})); err != nil || found {
+		t.Fatalf("expect not found, found:%t err:%v", found, err)
+	}
+	related, found, err := store.GetTransferByRelatedTid(in.RelatedTid)
+	if err != nil || !found || related.TransferId != in.TransferId {
+		t.Fatalf("fail to get by related tid, found:%t err:%v", found, err)
+	}
+
+	newAmount, newFee := big.NewInt(500), big.NewInt(7)
+	if err = store.SetTransferInAmountAndFee(in.TransferId, newAmount, newFee); err != nil {
+		t.Fatal(err)
+	}
+	// wrong from status does nothing
+	if err = store.SetPendingTransferIn(in.TransferId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING, cbn.TransferStatus_TRANSFER_STATUS_LOCKED); err != nil {
+		t.Fatal(err)
+	}
+	if got = mustGetTransfer(t, store, in.TransferId); got.Status != cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START ||
+		got.Amount.Cmp(newAmount) != 0 || got.Fee.Cmp(newFee) != 0 {
+		t.Fatalf("unexpected transfer %+v", got)
+	}
+	if err = store.SetPendingTransferIn(in.TransferId, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START); err != nil {
+		t.Fatal(err)
+	}
+	txHash := Bytes2Hash([]byte{0x10, 0x99})
+	if err = store.RecordTransferIn(in.TransferId, txHash); err != nil {
+		t.Fatal(err)
+	}
+	if got = mustGetTransfer(t, store, in.TransferId); got.Status != cbn.TransferStatus_TRANSFER_STATUS_LOCKED || got.TxHash != txHash {
+		t.Fatalf("unexpected transfer after transfer in %+v", got)
+	}
+
+	if err = store.SetTransferStatusByFrom(in.TransferId, cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING, cbn.TransferStatus_TRANSFER_STATUS_LOCKED); err != nil {
+		t.Fatal(err)
+	}
+	refundHash := Bytes2Hash([]byte{0x10, 0x98})
+	if err = store.RefundTransfer(in.TransferId, refundHash); err != nil {
+		t.Fatal(err)
+	}
+	if got = mustGetTransfer(t, store, in.TransferId); got.Status != cbn.TransferStatus_TRANSFER_STATUS_REFUNDED || got.TxRefundHash != refundHash {
+		t.Fatalf("unexpected transfer after refund %+v", got)
+	}
+	if err = store.UpdateTransferStatus(in.TransferId, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED); err != nil {
+		t.Fatal(err)
+	}
+	if got = mustGetTransfer(t, store, in.TransferId); got.Status != cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED {
+		t.Fatalf("unexpected status %s", got.Status)
+	}
+}
+
+func testStorePreimage(t *testing.T, store TransferStore) {
+	timeLock := time.Now().Add(2 * time.Hour)
+	out := newTestTransfer(0x20, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, timeLock)
+	in := newTestTransfer(0x21, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, timeLock)
+	in.HashLock = out.HashLock
+	in.RelatedTid = out.TransferId
+	for _, tx := range []*Transfer{out, in} {
+		if err := store.InsertTransfer(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	preimage, badPreimage := Bytes2Hash([]byte{0x20, 2}), Bytes2Hash([]byte{0x20, 0xff})
+
+	// a preimage not matching the hashlock is never stored
+	if err := store.SetRelatedTxPreimage(badPreimage, out.TransferId); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkPreimageMismatch(in.TransferId); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGetTransfer(t, store, in.TransferId); got.Preimage != (Hash{}) || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_MISMATCH {
+		t.Fatalf("unexpected preimage %x status %s", got.Preimage, got.PreimageStatus)
+	}
+	if err := store.SetRelatedTxPreimage(preimage, out.TransferId); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGetTransfer(t, store, in.TransferId); got.Preimage != preimage || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED {
+		t.Fatalf("unexpected preimage %x status %s", got.Preimage, got.PreimageStatus)
+	}
+	// a verified preimage is kept
+	if err := store.MarkPreimageMismatch(in.TransferId); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGetTransfer(t, store, in.TransferId); got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED {
+		t.Fatalf("unexpected preimage status %s", got.PreimageStatus)
+	}
+
+	confirmHash := Bytes2Hash([]byte{0x20, 0x99})
+	if err := store.ConfirmTransfer(out.TransferId, badPreimage, confirmHash); err != nil {
+		t.Fatal(err)
+	}
+	got := mustGetTransfer(t, store, out.TransferId)
+	if got.Status != cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED || got.TxConfirmHash != confirmHash ||
+		got.Preimage != (Hash{}) || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_NONE {
+		t.Fatalf("unexpected transfer after confirm with bad preimage %+v", got)
+	}
+	if err := store.ConfirmTransfer(out.TransferId, preimage, confirmHash); err != nil {
+		t.Fatal(err)
+	}
+	if got = mustGetTransfer(t, store, out.TransferId); got.Preimage != preimage || got.PreimageStatus != cbn.PreimageStatus_PREIMAGE_STATUS_VERIFIED {
+		t.Fatalf("unexpected transfer after confirm %+v", got)
+	}
+}
+
+func testStoreQueries(t *testing.T, store TransferStore, d *DAL) {
+	// start from a clean transfer table, the queries below scan all rows
+	if _, err := d.Exec("DELETE FROM transfer"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	newTx := func(id byte, transferType cbn.TransferType, status cbn.TransferStatus, timeLock time.Time) *Transfer {
+		tx := newTestTransfer(id, transferType, status, timeLock)
+		if err := store.InsertTransfer(tx); err != nil {
+			t.Fatal(err)
+		}
+		// keep createts distinct for ordering
+		time.Sleep(10 * time.Millisecond)
+		return tx
+	}
+	inStart := newTx(0x30, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START, now.Add(2*time.Hour))
+	newTx(0x31, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_START, now.Add(30*time.Minute))
+	inExpired := newTx(0x32, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(-time.Minute))
+	outLate := newTx(0x33, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(5*time.Hour))
+	outEarly := newTx(0x34, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_CONFIRM_PENDING, now.Add(3*time.Hour))
+	inPending := newTx(0x35, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_TRANSFER_IN_PENDING, now.Add(2*time.Hour))
+	refundPending := newTx(0x36, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING, now.Add(-time.Hour))
+	chain9 := newTestTransfer(0x37, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, now)
+	chain9.ChainId = 9
+	if err := store.InsertTransfer(chain9); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, err := store.GetAllTransfers()
+	if err != nil || len(txs) != 8 {
+		t.Fatalf("expect 8 transfers, got %d, err:%v", len(txs), err)
+	}
+	txs, err = store.GetAllTransfersWithLimit(2)
+	expectTransferIds(t, "GetAllTransfersWithLimit", txs, err, chain9, refundPending)
+	txs, err = store.GetTransfersWithFilter(10, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, 0)
+	expectTransferIds(t, "GetTransfersWithFilter status", txs, err, outLate, inExpired)
+	txs, err = store.GetTransfersWithFilter(10, 0, 9)
+	expectTransferIds(t, "GetTransfersWithFilter chain", txs, err, chain9)
+	txs, err = store.GetAllStartTransferIn()
+	expectTransferIds(t, "GetAllStartTransferIn", txs, err, inStart)
+	txs, err = store.GetAllRefundAbleTransferIn()
+	expectTransferIds(t, "GetAllRefundAbleTransferIn", txs, err, inExpired)
+	if _, err = d.Exec("UPDATE transfer SET relatedtid = $1 WHERE tid = $2", inPending.TransferId.String(), outEarly.TransferId.String()); err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := store.GetOpenTransferOut()
+	if err != nil || len(pairs) != 2 {
+		t.Fatalf("GetOpenTransferOut: expect 2 transfers, got %d, err:%v", len(pairs), err)
+	}
+	if pairs[0].Out.TransferId != outEarly.TransferId || pairs[1].Out.TransferId != outLate.TransferId {
+		t.Fatalf("GetOpenTransferOut: expect %x %x, got %x %x", outEarly.TransferId, outLate.TransferId, pairs[0].Out.TransferId, pairs[1].Out.TransferId)
+	}
+	if in := pairs[0].In; in == nil || in.TransferId != inPending.TransferId || in.Status != inPending.Status || !in.TimeLock.Equal(inPending.TimeLock) {
+		t.Fatalf("GetOpenTransferOut: expect transfer in %x joined, got %+v", inPending.TransferId, in)
+	}
+	if pairs[1].In != nil {
+		t.Fatalf("GetOpenTransferOut: expect no transfer in, got %+v", pairs[1].In)
+	}
+
+	// nothing is pending long enough yet
+	for name, f := range map[string]func() ([]*Transfer, error){
+		"GetRecoverTimeoutPendingTransferIn": store.GetRecoverTimeoutPendingTransferIn,
+		"GetRecoverTimeoutPendingConfirm":    store.GetRecoverTimeoutPendingConfirm,
+		"GetRecoverTimeoutPendingRefund":     store.GetRecoverTimeoutPendingRefund,
+	} {
+		txs, err = f()
+		expectTransferIds(t, name, txs, err)
+	}
+	if _, err = d.Exec("UPDATE transfer SET updatets = $1", dbNow().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	txs, err = store.GetRecoverTimeoutPendingTransferIn()
+	expectTransferIds(t, "GetRecoverTimeoutPendingTransferIn", txs, err, inPending)
+	txs, err = store.GetRecoverTimeoutPendingConfirm()
+	expectTransferIds(t, "GetRecoverTimeoutPendingConfirm", txs, err, outEarly)
+	txs, err = store.GetRecoverTimeoutPendingRefund()
+	expectTransferIds(t, "GetRecoverTimeoutPendingRefund", txs, err, refundPending)
+
+	// confirmable transfers are ordered by source timelock, the one of a transferIn is the timelock of its transferOut
+	inOfLate := newTestTransfer(0x38, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(time.Hour))
+	inOfLate.RelatedTid = outLate.TransferId
+	if err = store.InsertTransfer(inOfLate); err != nil {
+		t.Fatal(err)
+	}
+	outFirst := newTestTransfer(0x39, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(4*time.Hour))
+	if err = store.InsertTransfer(outFirst); err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range []*Transfer{inOfLate, outFirst, outLate} {
+		if err = store.ConfirmTransfer(tx.TransferId, Bytes2Hash([]byte{tx.TransferId[31], 2}), Hash{}); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.UpdateTransferStatus(tx.TransferId, cbn.TransferStatus_TRANSFER_STATUS_LOCKED); err != nil {
+			t.Fatal(err)
+		}
+	}
+	txs, err = store.GetAllConfirmableLockedTransfer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 3 || txs[0].TransferId != outFirst.TransferId {
+		t.Fatalf("GetAllConfirmableLockedTransfer: unexpected order %x", transferIds(txs))
+	}
+	// a verified preimage is never marked mismatched by the store, set it directly
+	if _, err = d.Exec("UPDATE transfer SET preimagestatus = $1 WHERE tid = $2", cbn.PreimageStatus_PREIMAGE_STATUS_MISMATCH, outFirst.TransferId.String()); err != nil {
+		t.Fatal(err)
+	}
+	txs, err = store.GetAllConfirmableLockedTransfer()
+	if err != nil || len(txs) != 2 {
+		t.Fatalf("GetAllConfirmableLockedTransfer: expect mismatched excluded, got %x, err:%v", transferIds(txs), err)
+	}
+}
+
+func testStoreRebalance(t *testing.T, store TransferStore) {
+	timeLock := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	preimage := Bytes2Hash([]byte{0x40, 2})
+	r := &Rebalance{
+		TransferId: Bytes2Hash([]byte{0x40}),
+		SrcChainId: 5,
+		DstChainId: 4,
+		SrcToken:   testTokenAddr,
+		DstToken:   testTokenAddr,
+		BridgeAddr: Bytes2Addr([]byte{0x40, 6}),
+		HashLock:   getHashLockWithPreImage(preimage),
+		Preimage:   preimage,
+		TimeLock:   timeLock,
+		Status:     cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING,
+	}
+	r.Amount.SetInt64(1e18)
+	if err := store.InsertRebalance(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertRebalance(r); err == nil {
+		t.Fatal("expect duplicated rebalance rejected")
+	}
+	got, found, err := store.GetRebalance(r.TransferId)
+	if err != nil || !found || got.Amount.Cmp(&r.Amount) != 0 || got.HashLock != r.HashLock || got.Preimage != preimage ||
+		!got.TimeLock.Equal(timeLock) || got.BridgeAddr != r.BridgeAddr || got.Status != r.Status {
+		t.Fatalf("rebalance not stored as inserted, got %+v, found:%t err:%v", got, found, err)
+	}
+
+	if err = store.SetRebalanceStatusByFrom(r.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_LOCKED, cbn.RebalanceStatus_REBALANCE_STATUS_OUT_PENDING); err != nil {
+		t.Fatal(err)
+	}
+	dstTid := Bytes2Hash([]byte{0x41})
+	if ok, err := store.RecordRebalanceTransferIn(Bytes2Hash([]byte{0xff}), dstTid, big.NewInt(1)); err != nil || ok {
+		t.Fatalf("expect no such rebalance, ok:%t err:%v", ok, err)
+	}
+	if ok, err := store.RecordRebalanceTransferIn(r.TransferId, dstTid, big.NewInt(9e17)); err != nil || !ok {
+		t.Fatalf("fail to record transfer in, ok:%t err:%v", ok, err)
+	}
+	got, found, err = store.GetRebalanceByDstTid(dstTid)
+	if err != nil || !found || got.TransferId != r.TransferId || got.DstAmount.Cmp(big.NewInt(9e17)) != 0 ||
+		got.Status != cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED {
+		t.Fatalf("unexpected rebalance %+v, found:%t err:%v", got, found, err)
+	}
+
+	rs, err := store.GetActiveRebalances()
+	if err != nil || len(rs) != 1 {
+		t.Fatalf("expect 1 active rebalance, got %d, err:%v", len(rs), err)
+	}
+	if err = store.SetRebalanceStatusByFrom(r.TransferId, cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRMED, cbn.RebalanceStatus_REBALANCE_STATUS_IN_LOCKED); err != nil {
+		t.Fatal(err)
+	}
+	if rs, err = store.GetActiveRebalances(); err != nil || len(rs) != 0 {
+		t.Fatalf("expect no active rebalance, got %d, err:%v", len(rs), err)
+	}
+	if rs, err = store.GetRebalancesWithLimit(10); err != nil || len(rs) != 1 || rs[0].Status != cbn.RebalanceStatus_REBALANCE_STATUS_CONFIRMED {
+		t.Fatalf("unexpected rebalances %+v, err:%v", rs, err)
+	}
+}
+
+func testStoreSummary(t *testing.T, store TransferStore, d *DAL) {
+	if _, err := d.Exec("DELETE FROM transfer"); err != nil {
+		t.Fatal(err)
+	}
+	timeLock := time.Now().Add(time.Hour)
+	// sums must be exact beyond float precision
+	amount, _ := new(big.Int).SetString("1000000000000000000000000000001", 10)
+	for i, status := range []cbn.TransferStatus{
+		cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED,
+		cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED,
+		cbn.TransferStatus_TRANSFER_STATUS_LOCKED,
+	} {
+		tx := newTestTransfer(byte(0x50+i), cbn.TransferType_TRANSFER_TYPE_IN, status, timeLock)
+		tx.Amount.Set(amount)
+		tx.TransferGasCost.SetInt64(int64(i + 1))
+		if err := store.InsertTransfer(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := newTestTransfer(0x53, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, timeLock)
+	if err := store.InsertTransfer(out); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := store.GetTransferSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("expect 3 summaries, got %d", len(summaries))
+	}
+	var confirmed *TransferSummary
+	for _, ts := range summaries {
+		if ts.TransferType == cbn.TransferType_TRANSFER_TYPE_IN && ts.Status == cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED {
+			confirmed = ts
+		}
+	}
+	if confirmed == nil {
+		t.Fatal("confirmed transfer in summary not found")
+	}
+	expAmount := new(big.Int).Mul(amount, big.NewInt(2))
+	if confirmed.Count != 2 || confirmed.Amount.Cmp(expAmount) != 0 || confirmed.Fee.Cmp(big.NewInt(2000)) != 0 ||
+		confirmed.TransferGasCost.Cmp(big.NewInt(3)) != 0 || confirmed.ChainId != 5 || confirmed.RelatedChainId != 4 ||
+		confirmed.Token != testTokenAddr {
+		t.Fatalf("unexpected summary %+v", confirmed)
+	}
+}
+
+// summaryString formats the summaries to compare them regardless of order.
+func summaryString(t *testing.T, store TransferStore) map[string]string {
+	t.Helper()
+	summaries, err := store.GetTransferSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]string)
+	for _, ts := range summaries {
+		key := fmt.Sprintf("%s %d->%d %s %s", ts.TransferType, ts.ChainId, ts.RelatedChainId, ts.Token.String(), ts.Status)
+		res[key] = fmt.Sprintf("count:%d amount:%s fee:%s gas:%s/%s/%s", ts.Count, ts.Amount.String(), ts.Fee.String(),
+			ts.TransferGasCost.String(), ts.ConfirmGasCost.String(), ts.RefundGasCost.String())
+	}
+	return res
+}
+
+func testStoreArchive(t *testing.T, store TransferStore, d *DAL) {
+	if _, err := d.Exec("DELETE FROM transfer"); err != nil {
+		t.Fatal(err)
+	}
+	old := dbNow().Add(-30 * 24 * time.Hour)
+	newPair := func(id byte, outStatus, inStatus cbn.TransferStatus, inOld bool) (*Transfer, *Transfer) {
+		out := newTestTransfer(id, cbn.TransferType_TRANSFER_TYPE_OUT, outStatus, old)
+		in := newTestTransfer(id+1, cbn.TransferType_TRANSFER_TYPE_IN, inStatus, old)
+		out.RelatedTid = in.TransferId
+		in.RelatedTid = out.TransferId
+		in.RefundGasCost.SetInt64(int64(id))
+		for _, tx := range []*Transfer{out, in} {
+			if err := store.InsertTransfer(tx); err != nil {
+				t.Fatal(err)
+			}
+			if tx == in && !inOld {
+				continue
+			}
+			if _, err := d.Exec("UPDATE transfer SET createts = $1 WHERE tid = $2", old, tx.TransferId.String()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return out, in
+	}
+	settledOut, settledIn := newPair(0x60, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, true)
+	openOut, _ := newPair(0x62, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, true)
+	recentOut, _ := newPair(0x64, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED, false)
+	// a transferOut without transferIn
+	single := newTestTransfer(0x66, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED, old)
+	if err := store.InsertTransfer(single); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec("UPDATE transfer SET createts = $1 WHERE tid = $2", old, single.TransferId.String()); err != nil {
+		t.Fatal(err)
+	}
+	summary := summaryString(t, store)
+
+	before := dbNow().Add(-24 * time.Hour)
+	// in batches of 2
+	archived, err := store.ArchiveTransfers(before, 2)
+	if err != nil || archived != 2 {
+		t.Fatalf("expect 2 archived, got %d, err:%v", archived, err)
+	}
+	if archived, err = store.ArchiveTransfers(before, 2); err != nil || archived != 1 {
+		t.Fatalf("expect 1 archived, got %d, err:%v", archived, err)
+	}
+	if archived, err = store.ArchiveTransfers(before, 2); err != nil || archived != 0 {
+		t.Fatalf("expect nothing to archive, got %d, err:%v", archived, err)
+	}
+	for _, tx := range []*Transfer{settledOut, settledIn, single} {
+		if _, found, err := store.GetTransferByTid(tx.TransferId); err != nil || found {
+			t.Fatalf("expect %x archived, found:%t err:%v", tx.TransferId, found, err)
+		}
+	}
+	for _, tx := range []*Transfer{openOut, recentOut} {
+		mustGetTransfer(t, store, tx.TransferId)
+	}
+	if got := summaryString(t, store); !reflect.DeepEqual(got, summary) {
+		t.Fatalf("summary changed after archive, before:%v, after:%v", summary, got)
+	}
+
+	// the related transfer in is restored too
+	restored, err := store.RestoreTransfers(old.Add(-time.Second), old.Add(time.Second), dbNow().Add(time.Hour))
+	if err != nil || restored != 3 {
+		t.Fatalf("expect 3 restored, got %d, err:%v", restored, err)
+	}
+	got := mustGetTransfer(t, store, settledIn.TransferId)
+	if got.Status != settledIn.Status || got.RefundGasCost.Cmp(&settledIn.RefundGasCost) != 0 || !got.CreateTs.Equal(old) {
+		t.Fatalf("transfer not restored as archived %+v", got)
+	}
+	if got := summaryString(t, store); !reflect.DeepEqual(got, summary) {
+		t.Fatalf("summary changed after restore, before:%v, after:%v", summary, got)
+	}
+	// restored transfers are held
+	if archived, err = store.ArchiveTransfers(before, 10); err != nil || archived != 0 {
+		t.Fatalf("expect held transfers not archived, got %d, err:%v", archived, err)
+	}
+}
+
+func newTestInboxEvent(chainId uint64, b byte, index uint) *InboxEvent {
+	return &InboxEvent{
+		ChainId:   chainId,
+		EventName: evLogTransferOut,
+		Log: ethtypes.Log{
+			Address:     testTokenAddr,
+			Topics:      []Hash{Bytes2Hash([]byte{b, 1})},
+			Data:        []byte{b},
+			BlockNumber: uint64(b),
+			TxHash:      Bytes2Hash([]byte{b}),
+			BlockHash:   Bytes2Hash([]byte{b, 2}),
+			Index:       index,
+		},
+	}
+}
+
+func testStoreInbox(t *testing.T, store TransferStore) {
+	e := newTestInboxEvent(1, 0x60, 3)
+	for i, expected := range []bool{true, false} {
+		if stored, err := store.InsertInboxEvent(e); err != nil || stored != expected {
+			t.Fatalf("insert %d: expect stored %t, got %t, err:%v", i, expected, stored, err)
+		}
+	}
+	other := newTestInboxEvent(2, 0x61, 0)
+	if _, err := store.InsertInboxEvent(other); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := store.GetDueInboxEvents(10)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("expect 2 due events, got %d, err:%v", len(due), err)
+	}
+	got := due[0]
+	if got.ChainId != 1 || got.EventName != evLogTransferOut || got.Status != cbn.InboxEventStatus_INBOX_EVENT_STATUS_PENDING ||
+		!reflect.DeepEqual(got.Log, e.Log) {
+		t.Fatalf("event not stored as inserted, got %+v", got)
+	}
+
+	got.Attempts = 1
+	got.LastError = "rpc error"
+	got.NextTs = time.Now().Add(time.Hour)
+	if err = store.UpdateInboxEvent(got); err != nil {
+		t.Fatal(err)
+	}
+	if due, err = store.GetDueInboxEvents(10); err != nil || len(due) != 1 || due[0].ChainId != 2 {
+		t.Fatalf("expect only the event of chain 2 due, got %+v, err:%v", due, err)
+	}
+
+	got.Status = cbn.InboxEventStatus_INBOX_EVENT_STATUS_QUARANTINED
+	if err = store.UpdateInboxEvent(got); err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.GetInboxEvents(10, cbn.InboxEventStatus_INBOX_EVENT_STATUS_QUARANTINED, 0)
+	if err != nil || len(events) != 1 || events[0].Attempts != 1 || events[0].LastError != "rpc error" {
+		t.Fatalf("unexpected quarantined events %+v, err:%v", events, err)
+	}
+	if events, err = store.GetInboxEvents(10, 0, 2); err != nil || len(events) != 1 || events[0].ChainId != 2 {
+		t.Fatalf("unexpected events of chain 2 %+v, err:%v", events, err)
+	}
+
+	if requeued, err := store.RequeueInboxEvents(1, Bytes2Hash([]byte{0xff}).String()); err != nil || requeued != 0 {
+		t.Fatalf("expect nothing requeued for other tx, got %d, err:%v", requeued, err)
+	}
+	if requeued, err := store.RequeueInboxEvents(1, e.Log.TxHash.String()); err != nil || requeued != 1 {
+		t.Fatalf("expect 1 requeued, got %d, err:%v", requeued, err)
+	}
+	if due, err = store.GetDueInboxEvents(10); err != nil || len(due) != 2 || due[0].Attempts != 0 {
+		t.Fatalf("expect 2 due events after requeue, got %+v, err:%v", due, err)
+	}
+
+	for _, ev := range due {
+		ev.Status = cbn.InboxEventStatus_INBOX_EVENT_STATUS_PROCESSED
+		if err = store.UpdateInboxEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if deleted, err := store.DeleteProcessedInboxEvents(time.Now().Add(-time.Hour)); err != nil || deleted != 0 {
+		t.Fatalf("expect recent events kept, deleted %d, err:%v", deleted, err)
+	}
+	if deleted, err := store.DeleteProcessedInboxEvents(time.Now().Add(time.Second)); err != nil || deleted != 2 {
+		t.Fatalf("expect 2 deleted, got %d, err:%v", deleted, err)
+	}
+}
+
+func testStoreChainTx(t *testing.T, store TransferStore) {
+	tid := Bytes2Hash([]byte{0x70})
+	legacy := &ChainTx{
+		ChainId:    5,
+		TxHash:     Bytes2Hash([]byte{0x70, 1}),
+		TransferId: tid,
+		Purpose:    cbn.TxPurpose_TX_PURPOSE_TRANSFER_IN,
+		Nonce:      7,
+		TxType:     cbn.TxType_TX_TYPE_LEGACY,
+		GasLimit:   200000,
+		Status:     cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING,
+	}
+	legacy.GasPrice.SetString("12000000000", 10)
+	dynamic := &ChainTx{
+		ChainId:    5,
+		TxHash:     Bytes2Hash([]byte{0x70, 2}),
+		TransferId: tid,
+		Purpose:    cbn.TxPurpose_TX_PURPOSE_REFUND,
+		Nonce:      8,
+		TxType:     cbn.TxType_TX_TYPE_DYNAMIC_FEE,
+		GasLimit:   100000,
+		Status:     cbn.ChainTxStatus_CHAIN_TX_STATUS_PENDING,
+	}
+	dynamic.GasFeeCap.SetString("30000000000", 10)
+	dynamic.GasTipCap.SetString("1500000000", 10)
+	for _, tx := range []*ChainTx{legacy, dynamic} {
+		if err := store.InsertChainTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.InsertChainTx(legacy); err == nil {
+		t.Fatal("expect error inserting the same tx twice")
+	}
+
+	txs, err := store.GetChainTxsByTransfer(tid)
+	if err != nil || len(txs) != 2 {
+		t.Fatalf("expect 2 txs, got %d, err:%v", len(txs), err)
+	}
+	for i, expected := range []*ChainTx{legacy, dynamic} {
+		got := *txs[i]
+		got.CreateTs, got.UpdateTs = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(&got, expected) {
+			t.Fatalf("tx %d not stored as inserted, got %+v, expect %+v", i, &got, expected)
+		}
+	}
+	if txs, err = store.GetChainTxsByTransfer(Bytes2Hash([]byte{0x71})); err != nil || len(txs) != 0 {
+		t.Fatalf("expect no tx of other transfer, got %d, err:%v", len(txs), err)
+	}
+
+	// a tx of the nonce of the pending legacy tx replaces it
+	pending, found, err := store.GetPendingChainTxByNonce(5, 7)
+	if err != nil || !found || pending.TxHash != legacy.TxHash {
+		t.Fatalf("expect pending legacy tx, found %t, err:%v", found, err)
+	}
+	replacement := *legacy
+	replacement.TxHash = Bytes2Hash([]byte{0x70, 3})
+	replacement.Replaces = legacy.TxHash
+	if err = store.InsertChainTx(&replacement); err != nil {
+		t.Fatal(err)
+	}
+	mined := replacement
+	mined.Status = cbn.ChainTxStatus_CHAIN_TX_STATUS_SUCCEEDED
+	mined.BlockNum = 100
+	mined.GasUsed = 50000
+	mined.GasCost.SetString("600000000000000", 10)
+	if err = store.SetChainTxMined(&mined); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetChainTxFailed(5, dynamic.TxHash, "timeout"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetChainTxFailed(5, mined.TxHash, "timeout"); err == nil {
+		t.Fatal("expect error failing a mined tx")
+	}
+	if _, found, err = store.GetPendingChainTxByNonce(5, 7); err != nil || found {
+		t.Fatalf("expect no pending tx of the nonce, found %t, err:%v", found, err)
+	}
+	if txs, err = store.GetChainTxsByTransfer(tid); err != nil || len(txs) != 3 {
+		t.Fatalf("expect 3 txs, got %d, err:%v", len(txs), err)
+	}
+	byHash := make(map[Hash]*ChainTx)
+	for _, tx := range txs {
+		byHash[tx.TxHash] = tx
+	}
+	if got := byHash[legacy.TxHash]; got.Status != cbn.ChainTxStatus_CHAIN_TX_STATUS_REPLACED {
+		t.Fatalf("expect replaced tx, got %+v", got)
+	}
+	if got := byHash[mined.TxHash]; got.Status != mined.Status || got.BlockNum != 100 || got.GasUsed != 50000 ||
+		got.GasCost.Cmp(&mined.GasCost) != 0 || got.Replaces != legacy.TxHash {
+		t.Fatalf("unexpected mined tx %+v", got)
+	}
+	if got := byHash[dynamic.TxHash]; got.Status != cbn.ChainTxStatus_CHAIN_TX_STATUS_FAILED || got.Error != "timeout" {
+		t.Fatalf("unexpected failed tx %+v", got)
+	}
+
+	if txs, err = store.GetChainTxs(10, cbn.ChainTxStatus_CHAIN_TX_STATUS_FAILED, 5); err != nil || len(txs) != 1 || txs[0].TxHash != dynamic.TxHash {
+		t.Fatalf("expect the failed tx, got %d, err:%v", len(txs), err)
+	}
+	if txs, err = store.GetChainTxs(10, 0, 6); err != nil || len(txs) != 0 {
+		t.Fatalf("expect no tx of other chain, got %d, err:%v", len(txs), err)
+	}
+	if txs, err = store.GetChainTxs(2, 0, 0); err != nil || len(txs) != 2 {
+		t.Fatalf("expect 2 latest txs, got %d, err:%v", len(txs), err)
+	}
+	pendingTx := replacement
+	pendingTx.TxHash = Bytes2Hash([]byte{0x70, 4})
+	pendingTx.Nonce = 9
+	pendingTx.Replaces = Hash{}
+	if err = store.InsertChainTx(&pendingTx); err != nil {
+		t.Fatal(err)
+	}
+	if txs, err = store.GetPendingChainTxs(time.Now().Add(-time.Minute), 10); err != nil || len(txs) != 0 {
+		t.Fatalf("expect no tx pending for long, got %d, err:%v", len(txs), err)
+	}
+	if txs, err = store.GetPendingChainTxs(time.Now().Add(time.Second), 10); err != nil || len(txs) != 1 || txs[0].TxHash != pendingTx.TxHash {
+		t.Fatalf("expect the pending tx, got %d, err:%v", len(txs), err)
+	}
+}
+
+func testStoreLeaderLease(t *testing.T, store TransferStore) {
+	if _, found, err := store.GetLeaderLease("relay"); err != nil || found {
+		t.Fatalf("expect no lease, found %t, err:%v", found, err)
+	}
+	token, acquired, err := store.AcquireLeaderLease("relay", "a", time.Minute)
+	if err != nil || !acquired || token != 1 {
+		t.Fatalf("expect a to acquire the lease with token 1, got %d %t, err:%v", token, acquired, err)
+	}
+	if _, acquired, err = store.AcquireLeaderLease("relay", "b", time.Minute); err != nil || acquired {
+		t.Fatalf("expect b not to acquire the held lease, got %t, err:%v", acquired, err)
+	}
+	if token, acquired, err = store.AcquireLeaderLease("relay", "a", time.Minute); err != nil || !acquired || token != 1 {
+		t.Fatalf("expect a to renew the lease with token 1, got %d %t, err:%v", token, acquired, err)
+	}
+	if held, err := store.CheckLeaderLease("relay", "a", 1); err != nil || !held {
+		t.Fatalf("expect a to hold the lease, got %t, err:%v", held, err)
+	}
+
+	if err = store.ReleaseLeaderLease("relay", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := store.CheckLeaderLease("relay", "a", 1); !held {
+		t.Fatal("expect the lease not released by b")
+	}
+	if err = store.ReleaseLeaderLease("relay", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := store.CheckLeaderLease("relay", "a", 1); err != nil || held {
+		t.Fatalf("expect the released lease not held, got %t, err:%v", held, err)
+	}
+	if token, acquired, err = store.AcquireLeaderLease("relay", "b", time.Minute); err != nil || !acquired || token != 2 {
+		t.Fatalf("expect b to acquire the released lease with token 2, got %d %t, err:%v", token, acquired, err)
+	}
+	if held, _ := store.CheckLeaderLease("relay", "a", 1); held {
+		t.Fatal("expect a fenced off by its stale token")
+	}
+	lease, found, err := store.GetLeaderLease("relay")
+	if err != nil || !found || lease.Holder != "b" || lease.Token != 2 || !lease.ExpireTs.After(time.Now()) {
+		t.Fatalf("unexpected lease %+v, found %t, err:%v", lease, found, err)
+	}
+
+	// expired without renewal
+	if _, acquired, err = store.AcquireLeaderLease("other", "a", -time.Second); err != nil || !acquired {
+		t.Fatalf("expect a to acquire other lease, got %t, err:%v", acquired, err)
+	}
+	if token, acquired, err = store.AcquireLeaderLease("other", "b", time.Minute); err != nil || !acquired || token != 2 {
+		t.Fatalf("expect b to take over the expired lease with token 2, got %d %t, err:%v", token, acquired, err)
+	}
+}
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/cBridge-go/contracts"
+	"github.com/celer-network/goutils/eth/monitor"
+	"github.com/celer-network/goutils/log"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	defaultWsReconnectInterval = 30 * time.Second
+	subscriptionRpcTimeout     = 10 * time.Second
+	// cursor in the monitor table, next to the ones of the polling watches
+	subscriptionCursorEvent = "Subscription"
+)
+
+var (
+	subscriptionReconnects = newCounter("cbridge/subscription/reconnects")
+	subscriptionPolls      = newCounter("cbridge/subscription/fallback_polls")
+)
+
+// logSubscriber is the subscription mode of a chain. It gets new heads and the contract logs pushed over
+// ws_endpoint, and stores each log once it is block_delay blocks deep. The cursor is the last block whose
+// logs are all stored, logs after it are fetched with eth_getLogs on every (re)connect to fill the gap.
+// While ws_endpoint is down, logs are polled from the chain endpoint every polling_interval instead.
+type logSubscriber struct {
+	chainId           uint64
+	wsEndpoint        string
+	ec                *ethclient.Client // chain endpoint, for polling
+	db                TransferStore
+	blockDelay        uint64
+	maxBlockDelta     uint64 // 0 means unlimited
+	pollingInterval   time.Duration
+	reconnectInterval time.Duration
+	query             ethereum.FilterQuery
+	eventNames        map[Hash]string // event id -> event name
+	cursorName        string
+	// store returns true if the log is not stored and should be retried
+	store func(name string, eLog ethtypes.Log) bool
+
+	lock   sync.Mutex
+	head   uint64
+	cursor uint64
+	source cbn.EventSource
+}
+
+func newLogSubscriber(bc *bridgeConfig, db TransferStore, store func(string, ethtypes.Log) bool) (*logSubscriber, error) {
+	watchCfg := bc.config.GetWatchConfig()
+	parsedABI, err := abi.JSON(strings.NewReader(contracts.CBridgeABI))
+	if err != nil {
+		return nil, err
+	}
+	ls := &logSubscriber{
+		chainId:           bc.chainId.Uint64(),
+		wsEndpoint:        watchCfg.GetWsEndpoint(),
+		ec:                bc.ec,
+		db:                db,
+		blockDelay:        watchCfg.GetBlockDelay(),
+		maxBlockDelta:     watchCfg.GetMaxBlockDelta(),
+		pollingInterval:   time.Duration(watchCfg.GetPollingInterval()) * time.Second,
+		reconnectInterval: defaultWsReconnectInterval,
+		eventNames:        make(map[Hash]string),
+		cursorName:        monitor.NewEventStr(bc.chainId.Uint64(), bc.contractChain.GetAddr(), subscriptionCursorEvent),
+		store:             store,
+		source:            cbn.EventSource_EVENT_SOURCE_FALLBACK_POLLING,
+	}
+	if ls.pollingInterval == 0 {
+		return nil, fmt.Errorf("chain %d polling_interval not set", ls.chainId)
+	}
+	if watchCfg.GetWsReconnectInterval() > 0 {
+		ls.reconnectInterval = time.Duration(watchCfg.GetWsReconnectInterval()) * time.Second
+	}
+	var topics []Hash
+	for _, name := range []string{evLogTransferOut, evLogTransferIn, evLogTransferConfirmed, evLogTransferRefunded} {
+		id := parsedABI.Events[name].ID
+		ls.eventNames[id] = name
+		topics = append(topics, id)
+	}
+	ls.query = ethereum.FilterQuery{
+		Addresses: []Addr{bc.contractChain.GetAddr()},
+		Topics:    [][]Hash{topics},
+	}
+	return ls, nil
+}
+
+// initCursor loads the stored cursor. The first time, it resumes from the polling watches of the chain if the
+// node ran in polling mode before, or starts from the current block like them.
+func (ls *logSubscriber) initCursor() error {
+	ctx, cancel := context.WithTimeout(context.Background(), subscriptionRpcTimeout)
+	defer cancel()
+	head, err := ls.ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	ls.setHead(head.Number.Uint64())
+	blockNum, _, found, err := ls.db.GetMonitorBlock(ls.cursorName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		blockNum, err = ls.pollingCursor()
+		if err != nil {
+			return err
+		}
+		if err = ls.db.InsertMonitor(ls.cursorName, blockNum, 0, false); err != nil {
+			return err
+		}
+	}
+	ls.lock.Lock()
+	ls.cursor = blockNum
+	ls.lock.Unlock()
+	log.Infof("chain %d subscription mode, logs are stored after block %d", ls.chainId, blockNum)
+	return nil
+}
+
+func (ls *logSubscriber) pollingCursor() (uint64, error) {
+	var cursor uint64
+	found := false
+	for _, name := range ls.eventNames {
+		blockNum, _, watchFound, err := ls.db.GetMonitorBlock(monitor.NewEventStr(ls.chainId, ls.query.Addresses[0], name))
+		if err != nil {
+			return 0, err
+		}
+		// logs of the watch block after the acked one may not be handled yet
+		if watchFound && blockNum > 0 && (!found || blockNum-1 < cursor) {
+			cursor, found = blockNum-1, true
+		}
+	}
+	if !found && ls.getHead() > 0 {
+		cursor = ls.getHead() - 1
+	}
+	return cursor, nil
+}
+
+// run keeps the subscription up and polls while it is down, until quit is closed.
+func (ls *logSubscriber) run(quit chan bool) {
+	for {
+		err := ls.subscribe(quit)
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		log.Warnf("chain %d subscription down, poll logs until reconnect in %s, err:%v", ls.chainId, ls.reconnectInterval, err)
+		ls.setSource(cbn.EventSource_EVENT_SOURCE_FALLBACK_POLLING)
+		reconnect := time.NewTimer(ls.reconnectInterval)
+		ticker := time.NewTicker(ls.pollingInterval)
+		polling := true
+		for polling {
+			if pollErr := ls.poll(); pollErr != nil {
+				log.Errorf("fail to poll logs, chainId:%d, err:%v", ls.chainId, pollErr)
+			}
+			select {
+			case <-quit:
+				ticker.Stop()
+				reconnect.Stop()
+				return
+			case <-reconnect.C:
+				polling = false
+			case <-ticker.C:
+			}
+		}
+		ticker.Stop()
+		subscriptionReconnects.Inc(1)
+	}
+}
+
+// subscribe returns when the subscription fails or quit is closed.
+func (ls *logSubscriber) subscribe(quit chan bool) error {
+	rc, err := dialChain(ls.wsEndpoint)
+	if err != nil {
+		return err
+	}
+	ec := ethclient.NewClient(rc)
+	defer ec.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), subscriptionRpcTimeout)
+	defer cancel()
+	heads := make(chan *ethtypes.Header, 16)
+	headSub, err := ec.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return fmt.Errorf("fail to subscribe new heads: %w", err)
+	}
+	defer headSub.Unsubscribe()
+	logs := make(chan ethtypes.Log, 256)
+	logSub, err := ec.SubscribeFilterLogs(ctx, ls.query, logs)
+	if err != nil {
+		return fmt.Errorf("fail to subscribe logs: %w", err)
+	}
+	defer logSub.Unsubscribe()
+
+	// fill the gap since the cursor, up to the head as the subscriptions may miss the blocks mined before them
+	head, err := ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	ls.setHead(head.Number.Uint64())
+	pending, err := ls.fetchLogs(ec, ls.getCursor()+1, head.Number.Uint64())
+	if err != nil {
+		return err
+	}
+	if pending, err = ls.flush(pending); err != nil {
+		return err
+	}
+	ls.setSource(cbn.EventSource_EVENT_SOURCE_SUBSCRIPTION)
+	log.Infof("chain %d subscribed to new heads and logs at %s, head:%d", ls.chainId, ls.wsEndpoint, head.Number.Uint64())
+
+	for {
+		select {
+		case <-quit:
+			return nil
+		case err = <-headSub.Err():
+			return subscriptionClosedErr("new heads", err)
+		case err = <-logSub.Err():
+			return subscriptionClosedErr("logs", err)
+		case h := <-heads:
+			ls.setHead(h.Number.Uint64())
+		case l := <-logs:
+			if l.Removed {
+				pending = ls.removeLog(pending, l)
+				continue
+			}
+			pending = append(pending, l)
+		}
+		if pending, err = ls.flush(pending); err != nil {
+			return err
+		}
+	}
+}
+
+// subscriptionClosedErr describes the error of a subscription, which is nil when the connection is closed.
+func subscriptionClosedErr(name string, err error) error {
+	if err == nil {
+		return fmt.Errorf("%s subscription closed", name)
+	}
+	return fmt.Errorf("%s subscription failed: %w", name, err)
+}
+
+// poll stores the logs up to the safe block from the chain endpoint.
+func (ls *logSubscriber) poll() error {
+	subscriptionPolls.Inc(1)
+	ctx, cancel := context.WithTimeout(context.Background(), subscriptionRpcTimeout)
+	defer cancel()
+	head, err := ls.ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	ls.setHead(head.Number.Uint64())
+	safe, ok := ls.safeBlock()
+	if !ok {
+		return nil
+	}
+	logs, err := ls.fetchLogs(ls.ec, ls.getCursor()+1, safe)
+	if err != nil {
+		return err
+	}
+	_, err = ls.flush(logs)
+	return err
+}
+
+// fetchLogs gets the contract logs in [from, to], at most max_block_delta blocks per query.
+func (ls *logSubscriber) fetchLogs(ec *ethclient.Client, from, to uint64) ([]ethtypes.Log, error) {
+	var logs []ethtypes.Log
+	for from <= to {
+		end := to
+		if ls.maxBlockDelta > 0 && end-from >= ls.maxBlockDelta {
+			end = from + ls.maxBlockDelta - 1
+		}
+		q := ls.query
+		q.FromBlock = new(big.Int).SetUint64(from)
+		q.ToBlock = new(big.Int).SetUint64(end)
+		ctx, cancel := context.WithTimeout(context.Background(), subscriptionRpcTimeout)
+		got, err := ec.FilterLogs(ctx, q)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("fail to get logs [%d-%d]: %w", from, end, err)
+		}
+		logs = append(logs, got...)
+		from = end + 1
+	}
+	return logs, nil
+}
+
+// flush stores the pending logs not after the safe block in order, advances the cursor to the safe block and
+// returns the logs left pending.
+func (ls *logSubscriber) flush(pending []ethtypes.Log) ([]ethtypes.Log, error) {
+	safe, ok := ls.safeBlock()
+	if !ok {
+		return pending, nil
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].BlockNumber != pending[j].BlockNumber {
+			return pending[i].BlockNumber < pending[j].BlockNumber
+		}
+		return pending[i].Index < pending[j].Index
+	})
+	n := 0
+	for ; n < len(pending) && pending[n].BlockNumber <= safe; n++ {
+		l := pending[n]
+		name, found := ls.eventNames[l.Topics[0]]
+		if !found {
+			continue
+		}
+		if ls.store(name, l) {
+			return pending[n:], fmt.Errorf("fail to store %s log of tx %x", name, l.TxHash)
+		}
+	}
+	if safe > ls.getCursor() {
+		if err := ls.db.UpdateMonitorBlock(ls.cursorName, safe, 0); err != nil {
+			return pending[n:], err
+		}
+		ls.lock.Lock()
+		ls.cursor = safe
+		ls.lock.Unlock()
+	}
+	return pending[n:], nil
+}
+
+// removeLog drops the pending log undone by a chain reorg. A stored one is only reported, block_delay should
+// be large enough for the chain to not happen.
+func (ls *logSubscriber) removeLog(pending []ethtypes.Log, removed ethtypes.Log) []ethtypes.Log {
+	for i, l := range pending {
+		if l.TxHash == removed.TxHash && l.Index == removed.Index && l.BlockHash == removed.BlockHash {
+			return append(pending[:i], pending[i+1:]...)
+		}
+	}
+	monitorLog.with("chain_id", ls.chainId, "tx_hash", removed.TxHash).Warnf(
+		"log removed by reorg after stored, block:%d, consider a larger blockDelay", removed.BlockNumber)
+	return pending
+}
+
+// safeBlock returns the last block at least block_delay deep.
+func (ls *logSubscriber) safeBlock() (uint64, bool) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	if ls.head < ls.blockDelay {
+		return 0, false
+	}
+	return ls.head - ls.blockDelay, true
+}
+
+func (ls *logSubscriber) setHead(head uint64) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	if head > ls.head {
+		ls.head = head
+	}
+}
+
+func (ls *logSubscriber) getHead() uint64 {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	return ls.head
+}
+
+func (ls *logSubscriber) getCursor() uint64 {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	return ls.cursor
+}
+
+func (ls *logSubscriber) setSource(source cbn.EventSource) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	ls.source = source
+}
+
+func (ls *logSubscriber) getSource() cbn.EventSource {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	return ls.source
+}
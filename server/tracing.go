@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/log"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultOtlpEndpoint       = "localhost:4317"
+	defaultTracingServiceName = "cbridge-node"
+)
+
+// tracer of the node, a no-op until SetupTracing sets the global tracer provider
+var tracer = otel.Tracer("github.com/celer-network/cBridge-go/server")
+
+// SetupTracing exports the spans of the node to the OTLP collector of tracing_config, it should be called once
+// before the node starts. The returned func flushes the spans not exported yet.
+func SetupTracing(cfg *cbn.TracingConfig, version string) (func(), error) {
+	if !cfg.GetEnabled() {
+		return func() {}, nil
+	}
+	endpoint := cfg.GetOtlpEndpoint()
+	if endpoint == "" {
+		endpoint = defaultOtlpEndpoint
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if cfg.GetInsecure() {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create otlp exporter, err:%w", err)
+	}
+	ratio := cfg.GetSampleRatio()
+	if ratio <= 0 {
+		ratio = 1
+	}
+	serviceName := cfg.GetServiceName()
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// by trace id, so all the steps of a transfer are either sampled or not
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithIDGenerator(newTransferIDGenerator()),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName), semconv.ServiceVersionKey.String(version))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	log.Infof("tracing enabled, otlp endpoint:%s, sample ratio:%g", endpoint, ratio)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Errorf("fail to flush spans, err:%v", err)
+		}
+	}, nil
+}
+
+type transferTraceKey struct{}
+
+// transferIDGenerator gives the root spans of the steps of a transfer the trace id derived from its transfer
+// out id, so the steps are in one trace though they run in different jobs, even across restarts.
+type transferIDGenerator struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func newTransferIDGenerator() *transferIDGenerator {
+	return &transferIDGenerator{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (g *transferIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if tid, ok := ctx.Value(transferTraceKey{}).(Hash); ok {
+		return transferTraceID(tid), g.NewSpanID(ctx, trace.TraceID{})
+	}
+	var traceID trace.TraceID
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.rand.Read(traceID[:])
+	var spanID trace.SpanID
+	g.rand.Read(spanID[:])
+	return traceID, spanID
+}
+
+func (g *transferIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	var spanID trace.SpanID
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.rand.Read(spanID[:])
+	return spanID
+}
+
+func transferTraceID(outTid Hash) trace.TraceID {
+	var traceID trace.TraceID
+	copy(traceID[:], crypto.Keccak256(outTid.Bytes()))
+	return traceID
+}
+
+// traceTid returns the id the trace of the transfer is derived from, the id of its transfer out.
+func traceTid(tx *Transfer) Hash {
+	if tx.TransferType == cbn.TransferType_TRANSFER_TYPE_IN {
+		return tx.RelatedTid
+	}
+	return tx.TransferId
+}
+
+// startTransferSpan starts the root span of a step of the transfer, in the trace of the transfer.
+func startTransferSpan(name string, tx *Transfer) (context.Context, trace.Span) {
+	return startTraceSpan(name, traceTid(tx),
+		attribute.String("transfer.id", tx.TransferId.Hex()),
+		attribute.String("transfer.related_id", tx.RelatedTid.Hex()),
+		attribute.Int64("transfer.chain_id", int64(tx.ChainId)),
+		attribute.String("transfer.direction", transferDirection(tx.TransferType)),
+		attribute.String("transfer.status", tx.Status.String()))
+}
+
+// startRebalanceSpan starts the root span of a step of the rebalance, in the trace of its transfer out.
+func startRebalanceSpan(name string, r *Rebalance) (context.Context, trace.Span) {
+	return startTraceSpan(name, r.TransferId,
+		attribute.String("transfer.id", r.TransferId.Hex()),
+		attribute.String("transfer.related_id", r.DstTransferId.Hex()),
+		attribute.Int64("transfer.chain_id", int64(r.SrcChainId)),
+		attribute.String("rebalance.status", r.Status.String()))
+}
+
+// startTraceSpan starts a root span in the trace of the transfer out id.
+func startTraceSpan(name string, outTid Hash, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := context.WithValue(context.Background(), transferTraceKey{}, outTid)
+	return tracer.Start(ctx, name, trace.WithNewRoot(), trace.WithAttributes(attrs...))
+}
+
+// eventSpanAttrs are the attributes of the span handling the contract event.
+func eventSpanAttrs(bc *bridgeConfig, eLog ethtypes.Log) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("event.chain_id", int64(bc.chainId.Uint64())),
+		attribute.String("event.tx_hash", eLog.TxHash.Hex()),
+		attribute.Int64("event.block_number", int64(eLog.BlockNumber)),
+		attribute.Int64("event.log_index", int64(eLog.Index)),
+	}
+}
+
+// endSpan ends the span with the error status if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// dialTracedChain connects to the chain endpoint, the json-rpc calls over http made in a span are traced.
+func dialTracedChain(endpoint string) (*rpc.Client, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return rpc.Dial(endpoint)
+	}
+	return rpc.DialHTTPWithClient(endpoint, &http.Client{Transport: &rpcTracingTransport{base: http.DefaultTransport}})
+}
+
+// rpcTracingTransport traces the json-rpc requests sent with the context of a span, e.g. the txs sent by a
+// step of a transfer. Others, such as the polling of the monitors, are not traced.
+type rpcTracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *rpcTracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(req.Context()).IsValid() || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	method := jsonRpcMethod(body)
+	ctx, span := tracer.Start(req.Context(), method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.RPCSystemKey.String("jsonrpc"), semconv.RPCMethodKey.String(method), semconv.NetPeerNameKey.String(req.URL.Host)))
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err == nil {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	endSpan(span, err)
+	return resp, err
+}
+
+// jsonRpcMethod returns the method of the json-rpc request, "batch" for a batch request.
+func jsonRpcMethod(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return "batch"
+	}
+	var msg struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(trimmed, &msg); err != nil || msg.Method == "" {
+		return "unknown"
+	}
+	return msg.Method
+}
+
+// dbSpanName is the span name of a db query, its operation and first table.
+func dbSpanName(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "db"
+	}
+	op := strings.ToUpper(fields[0])
+	for i, f := range fields[:len(fields)-1] {
+		switch strings.ToUpper(f) {
+		case "FROM", "INTO", "UPDATE":
+			return "db " + op + " " + strings.Trim(fields[i+1], "(,")
+		}
+	}
+	return "db " + op
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+func TestTransferTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr),
+		sdktrace.WithIDGenerator(newTransferIDGenerator())))
+
+	out := newTestTransfer(1, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now())
+	in := newTestTransfer(2, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, time.Now())
+	in.RelatedTid = out.TransferId
+	_, outSpan := startTransferSpan("confirm", out)
+	ctx, inSpan := startTransferSpan("send transfer in", in)
+	traceID := transferTraceID(out.TransferId)
+	if outSpan.SpanContext().TraceID() != traceID || inSpan.SpanContext().TraceID() != traceID {
+		t.Fatal("expect the steps of both transfers in the trace of the transfer out")
+	}
+	if outSpan.SpanContext().SpanID() == inSpan.SpanContext().SpanID() {
+		t.Fatal("expect different span ids of the steps")
+	}
+
+	dir, err := ioutil.TempDir("", "cbridge-tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewSqliteDAL(filepath.Join(dir, "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, _, err = store.GetTransferByTid(in.TransferId); err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Ended()) != 0 {
+		t.Fatalf("expect queries without span context not traced, got %d spans", len(sr.Ended()))
+	}
+	if _, _, err = store.WithContext(ctx).GetTransferByTid(in.TransferId); err != nil {
+		t.Fatal(err)
+	}
+
+	rpcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer rpcServer.Close()
+	rc, err := dialTracedChain(rpcServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	ec := ethclient.NewClient(rc)
+	if _, err = ec.BlockNumber(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ec.BlockNumber(ctx); err != nil {
+		t.Fatal(err)
+	}
+	inSpan.End()
+	outSpan.End()
+
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range sr.Ended() {
+		names[span.Name()] = span
+	}
+	if len(names) != 4 {
+		t.Fatalf("expect 4 spans, got %v", names)
+	}
+	for _, name := range []string{"db SELECT transfer", "eth_blockNumber"} {
+		span, found := names[name]
+		if !found {
+			t.Fatalf("expect span %s, got %v", name, names)
+		}
+		if span.Parent().SpanID() != inSpan.SpanContext().SpanID() || span.SpanContext().TraceID() != traceID {
+			t.Fatalf("expect span %s a child of the step span", name)
+		}
+	}
+	var statement string
+	for _, kv := range names["db SELECT transfer"].Attributes() {
+		if kv.Key == semconv.DBStatementKey {
+			statement = kv.Value.AsString()
+		}
+	}
+	if statement == "" {
+		t.Fatal("expect db statement attribute")
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/eth"
+	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultPriorityFeePercentile = 50
+	defaultFeeHistoryBlocks      = 10
+	maxFeeHistoryBlocks          = 1024
+
+	feeHistoryTimeout = 10 * time.Second
+)
+
+var cappedTxFees = newCounter("cbridge/tx/capped_fees")
+
+// txFees are the fee params of the txs sent in one bridgeConfig.transact.
+type txFees struct {
+	chainId uint64
+	dynamic bool
+	feeCap  *big.Int // dynamic fee tx only
+	tipCap  *big.Int // dynamic fee tx only
+	// force_gas_gwei, nil means no cap
+	maxFee *big.Int
+	capped bool
+}
+
+// feeHistory is the result of eth_feeHistory, not supported by the ethclient of our go-ethereum version.
+type feeHistory struct {
+	OldestBlock *hexutil.Big     `json:"oldestBlock"`
+	Reward      [][]*hexutil.Big `json:"reward"`
+	BaseFee     []*hexutil.Big   `json:"baseFeePerGas"`
+}
+
+func gweiToWei(gwei uint64) *big.Int {
+	return new(big.Int).Mul(new(big.Int).SetUint64(gwei), big.NewInt(1e9))
+}
+
+// suggestFees returns the fees of the next txs of the chain. A dynamic fee tx pays the median over the last
+// blocks of the configured percentile of the priority fees, plus add_gas_gwei, and its max fee allows the
+// base fee to double before the tx is mined. force_gas_gwei caps the max fee. Legacy txs are sent if the
+// fee history is not available.
+func (bc *bridgeConfig) suggestFees(ctx context.Context) *txFees {
+	tcfg := bc.config.GetTransactorConfig()
+	fees := &txFees{chainId: bc.chainId.Uint64()}
+	if bc.config.GetForceGasGwei() > 0 {
+		fees.maxFee = gweiToWei(bc.config.GetForceGasGwei())
+	}
+	if tcfg.GetTxType() != cbn.TxType_TX_TYPE_DYNAMIC_FEE {
+		return fees
+	}
+
+	percentile := tcfg.GetPriorityFeePercentile()
+	if percentile <= 0 || percentile > 100 {
+		percentile = defaultPriorityFeePercentile
+	}
+	blocks := tcfg.GetFeeHistoryBlocks()
+	if blocks == 0 {
+		blocks = defaultFeeHistoryBlocks
+	} else if blocks > maxFeeHistoryBlocks {
+		blocks = maxFeeHistoryBlocks
+	}
+	ctx, cancel := context.WithTimeout(ctx, feeHistoryTimeout)
+	defer cancel()
+	var history feeHistory
+	err := bc.rc.CallContext(ctx, &history, "eth_feeHistory", hexutil.Uint64(blocks), "latest", []float64{percentile})
+	if err != nil {
+		log.Warnf("fail to get fee history of chain %d, send legacy txs, err:%v", fees.chainId, err)
+		return fees
+	}
+	// the last base fee is of the next block
+	if len(history.BaseFee) == 0 || history.BaseFee[len(history.BaseFee)-1] == nil ||
+		history.BaseFee[len(history.BaseFee)-1].ToInt().Sign() == 0 {
+		log.Warnf("chain %d has no base fee, send legacy txs", fees.chainId)
+		return fees
+	}
+	baseFee := history.BaseFee[len(history.BaseFee)-1].ToInt()
+
+	var rewards []*big.Int
+	for _, r := range history.Reward {
+		if len(r) > 0 && r[0] != nil {
+			rewards = append(rewards, r[0].ToInt())
+		}
+	}
+	tip := new(big.Int)
+	if len(rewards) > 0 {
+		sort.Slice(rewards, func(i, j int) bool { return rewards[i].Cmp(rewards[j]) < 0 })
+		tip.Set(rewards[len(rewards)/2])
+	}
+	tip.Add(tip, gweiToWei(tcfg.GetAddGasGwei()))
+	feeCap := new(big.Int).Mul(baseFee, big.NewInt(2))
+	feeCap.Add(feeCap, tip)
+
+	if fees.maxFee != nil && feeCap.Cmp(fees.maxFee) > 0 {
+		log.Warnf("max fee per gas %s of chain %d larger than cap %s, use the cap", feeCap, fees.chainId, fees.maxFee)
+		cappedTxFees.Inc(1)
+		fees.capped = true
+		feeCap.Set(fees.maxFee)
+		if tip.Cmp(feeCap) > 0 {
+			tip.Set(feeCap)
+		}
+	}
+	fees.dynamic = true
+	fees.feeCap = feeCap
+	fees.tipCap = tip
+	return fees
+}
+
+// gasPrice returns the max price per gas of a tx sent with f: the fee cap of a dynamic fee tx, else the legacy
+// gas price suggested by the endpoint, capped by force_gas_gwei.
+func (f *txFees) gasPrice(suggested *big.Int) *big.Int {
+	if f.dynamic {
+		return new(big.Int).Set(f.feeCap)
+	}
+	if f.maxFee != nil && suggested.Cmp(f.maxFee) > 0 {
+		return new(big.Int).Set(f.maxFee)
+	}
+	return new(big.Int).Set(suggested)
+}
+
+// apply sets the fees of the tx to sign. opts.GasPrice is the legacy gas price set by the transactor,
+// unset for a dynamic fee tx.
+func (f *txFees) apply(opts *bind.TransactOpts) {
+	if f.dynamic {
+		opts.GasPrice = nil
+		opts.GasFeeCap = f.feeCap
+		opts.GasTipCap = f.tipCap
+		return
+	}
+	if f.maxFee != nil && opts.GasPrice != nil && opts.GasPrice.Cmp(f.maxFee) > 0 {
+		if !f.capped {
+			log.Warnf("gas price %s of chain %d larger than cap %s, use the cap", opts.GasPrice, f.chainId, f.maxFee)
+			cappedTxFees.Inc(1)
+			f.capped = true
+		}
+		opts.GasPrice = new(big.Int).Set(f.maxFee)
+	}
+}
+
+// transact sends the tx of method with the fees of the chain config, and records it in the db.
+// transferId is the transfer or rebalance the tx is sent for. With ha enabled, only the leader sends.
+// The sending is traced as a child span of ctx.
+func (bc *bridgeConfig) transact(ctx context.Context,
+	lg *logger, purpose cbn.TxPurpose, transferId Hash, handler *eth.TransactionStateHandler, method eth.TxMethod) (tx *ethtypes.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, "transact", trace.WithAttributes(
+		attribute.String("tx.purpose", purpose.String()), attribute.Int64("tx.chain_id", int64(bc.chainId.Uint64()))))
+	defer func() {
+		if tx != nil {
+			span.SetAttributes(attribute.String("tx.hash", tx.Hash().Hex()), attribute.Int64("tx.nonce", int64(tx.Nonce())))
+		}
+		endSpan(span, err)
+	}()
+	if err = bc.lease.check(); err != nil {
+		return nil, err
+	}
+	fees := bc.suggestFees(ctx)
+	var chainTx *ChainTx
+	tx, err = bc.trans.Transact(bc.chainTxHandler(lg, &chainTx, handler), func(ctr bind.ContractTransactor, opts *bind.TransactOpts) (*ethtypes.Transaction, error) {
+		fees.apply(opts)
+		opts.Context = ctx
+		sent, err2 := method(ctr, opts)
+		if err2 == nil && !opts.NoSend {
+			// recorded before the transactor waits for the receipt, so the handler finds the tx in the db
+			chainTx = bc.recordChainTx(ctx, lg, purpose, transferId, sent)
+		}
+		return sent, err2
+	})
+	if err != nil {
+		return nil, err
+	}
+	lg.with("tx_hash", chainTx.TxHash).Infof("sent %s tx, nonce:%d, type:%s, gasPrice:%s, maxFee:%s, maxPriorityFee:%s", purpose,
+		chainTx.Nonce, chainTx.TxType, chainTx.GasPrice.String(), chainTx.GasFeeCap.String(), chainTx.GasTipCap.String())
+	return tx, nil
+}
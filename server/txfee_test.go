@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestSuggestFeesWithoutFeeHistory(t *testing.T) {
+	// the endpoint serves no eth_feeHistory
+	rc := rpc.DialInProc(rpc.NewServer())
+	defer rc.Close()
+	bc := &bridgeConfig{
+		chainId: big.NewInt(5),
+		rc:      rc,
+		config: &cbn.ChainConfig{
+			ChainId:          5,
+			ForceGasGwei:     2,
+			TransactorConfig: &cbn.TransactorConfig{TxType: cbn.TxType_TX_TYPE_DYNAMIC_FEE},
+		},
+	}
+	fees := bc.suggestFees(context.Background())
+	if fees.dynamic {
+		t.Fatalf("expect legacy fees, got %+v", fees)
+	}
+
+	capped := cappedTxFees.Count()
+	opts := &bind.TransactOpts{GasPrice: gweiToWei(5)}
+	fees.apply(opts)
+	if opts.GasPrice.Cmp(gweiToWei(2)) != 0 || opts.GasFeeCap != nil || opts.GasTipCap != nil {
+		t.Fatalf("expect legacy gas price capped to 2 gwei, got %+v", opts)
+	}
+	if cappedTxFees.Count() != capped+1 {
+		t.Fatal("expect capped fee counted")
+	}
+	opts = &bind.TransactOpts{GasPrice: gweiToWei(1)}
+	fees.apply(opts)
+	if opts.GasPrice.Cmp(gweiToWei(1)) != 0 {
+		t.Fatalf("expect gas price below the cap kept, got %s", opts.GasPrice)
+	}
+}
+
+func TestTxFeesGasPrice(t *testing.T) {
+	dynamic := &txFees{dynamic: true, feeCap: gweiToWei(30), tipCap: gweiToWei(2)}
+	if got := dynamic.gasPrice(gweiToWei(10)); got.Cmp(gweiToWei(30)) != 0 {
+		t.Errorf("expect the fee cap of a dynamic fee tx, got %s", got)
+	}
+	legacy := &txFees{maxFee: gweiToWei(20)}
+	if got := legacy.gasPrice(gweiToWei(10)); got.Cmp(gweiToWei(10)) != 0 {
+		t.Errorf("expect the suggested gas price, got %s", got)
+	}
+	if got := legacy.gasPrice(gweiToWei(25)); got.Cmp(gweiToWei(20)) != 0 {
+		t.Errorf("expect the gas price capped, got %s", got)
+	}
+}
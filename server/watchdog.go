@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+	"github.com/celer-network/goutils/log"
+)
+
+const defaultWatchdogScanInterval = time.Minute
+
+var defaultWatchdogThresholds = []time.Duration{6 * time.Hour, 2 * time.Hour, 30 * time.Minute}
+
+var (
+	watchdogOpenTransfers      = newGauge("cbridge/watchdog/open_transfers")
+	watchdogEscalatedTransfers = newGauge("cbridge/watchdog/escalated_transfers")
+	watchdogMaxLevel           = newGauge("cbridge/watchdog/max_level")
+	watchdogMinSrcTimeLeft     = newGauge("cbridge/watchdog/min_src_timelock_left_seconds")
+	watchdogMinDstTimeLeft     = newGauge("cbridge/watchdog/min_dst_timelock_left_seconds")
+	watchdogEscalations        = newCounter("cbridge/watchdog/escalations")
+)
+
+// timelockWatchdog watches open transfer pairs, i.e. transferOuts not yet confirmed and
+// their transferIns. If the source timelock expires before we confirm the transferOut,
+// the user can refund it and the funds we sent on the destination chain are lost. A pair
+// escalates on the closer of both timelocks while the user can still confirm the transferIn.
+type timelockWatchdog struct {
+	// descending, level i means the time left is below thresholds[i-1]
+	thresholds []time.Duration
+	interval   time.Duration
+	// transferOut id -> last escalated level, only accessed by the watchdog goroutine
+	levels map[Hash]int
+}
+
+// newTimelockWatchdog returns the watchdog of cfg. warnBefore is the deprecated notify_config.timelock_warn_before
+// in seconds, the first threshold if cfg has none.
+func newTimelockWatchdog(cfg *cbn.WatchdogConfig, warnBefore uint64) *timelockWatchdog {
+	w := &timelockWatchdog{
+		thresholds: defaultWatchdogThresholds,
+		interval:   defaultWatchdogScanInterval,
+		levels:     make(map[Hash]int),
+	}
+	if len(cfg.GetThresholds()) > 0 {
+		if warnBefore > 0 {
+			log.Warnln("notify_config.timelock_warn_before is deprecated and ignored, watchdog_config.thresholds is set")
+		}
+		w.thresholds = nil
+		for _, t := range cfg.GetThresholds() {
+			w.thresholds = append(w.thresholds, time.Duration(t)*time.Second)
+		}
+		sort.Slice(w.thresholds, func(i, j int) bool { return w.thresholds[i] > w.thresholds[j] })
+	} else if warnBefore > 0 {
+		log.Warnln("notify_config.timelock_warn_before is deprecated, use watchdog_config.thresholds")
+		first := time.Duration(warnBefore) * time.Second
+		w.thresholds = []time.Duration{first}
+		for _, t := range defaultWatchdogThresholds {
+			if t < first {
+				w.thresholds = append(w.thresholds, t)
+			}
+		}
+	}
+	if cfg.GetScanInterval() > 0 {
+		w.interval = time.Duration(cfg.GetScanInterval()) * time.Second
+	}
+	return w
+}
+
+func (w *timelockWatchdog) level(timeLeft time.Duration) int {
+	level := 0
+	for i, t := range w.thresholds {
+		if timeLeft < t {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+func (s *server) ProcessTimelockWatchdog() {
+	ticker := time.NewTicker(s.watchdog.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.processTimelockWatchdog()
+		}
+	}
+}
+
+func (s *server) processTimelockWatchdog() {
+	w := s.watchdog
+	pairs, dbErr := s.db.GetOpenTransferOut()
+	if dbErr != nil {
+		confirmerLog.Warnf("fail to query open transfer outs, err:%s", dbErr)
+		return
+	}
+	now := time.Now()
+	escalated, maxLevel := 0, 0
+	minSrcLeft, minDstLeft := time.Duration(math.MaxInt64), time.Duration(math.MaxInt64)
+	levels := make(map[Hash]int)
+	for _, pair := range pairs {
+		out, in := pair.Out, pair.In
+		srcLeft := out.TimeLock.Sub(now)
+		if srcLeft < minSrcLeft {
+			minSrcLeft = srcLeft
+		}
+		level := w.level(srcLeft)
+		if in != nil {
+			dstLeft := in.TimeLock.Sub(now)
+			if dstLeft < minDstLeft {
+				minDstLeft = dstLeft
+			}
+			// a transfer in the user has not confirmed yet escalates on its own timelock too
+			if transferInOpen(in) {
+				if dstLevel := w.level(dstLeft); dstLevel > level {
+					level = dstLevel
+				}
+			}
+		}
+		levels[out.TransferId] = level
+		if level == 0 {
+			continue
+		}
+		escalated++
+		if level > maxLevel {
+			maxLevel = level
+		}
+		if level > w.levels[out.TransferId] {
+			s.escalateTimelock(out, in, level, srcLeft)
+		}
+	}
+	w.levels = levels
+
+	watchdogOpenTransfers.Update(int64(len(pairs)))
+	watchdogEscalatedTransfers.Update(int64(escalated))
+	watchdogMaxLevel.Update(int64(maxLevel))
+	if minSrcLeft == time.Duration(math.MaxInt64) {
+		minSrcLeft = 0
+	}
+	if minDstLeft == time.Duration(math.MaxInt64) {
+		minDstLeft = 0
+	}
+	watchdogMinSrcTimeLeft.Update(int64(minSrcLeft.Seconds()))
+	watchdogMinDstTimeLeft.Update(int64(minDstLeft.Seconds()))
+}
+
+// transferInOpen returns whether the transfer in can still be confirmed by the user.
+func transferInOpen(in *Transfer) bool {
+	switch in.Status {
+	case cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED,
+		cbn.TransferStatus_TRANSFER_STATUS_REFUND_PENDING:
+		return false
+	}
+	return true
+}
+
+// escalateTimelock is called once each time a transfer pair reaches a higher level.
+func (s *server) escalateTimelock(out, in *Transfer, level int, srcLeft time.Duration) {
+	w := s.watchdog
+	watchdogEscalations.Inc(1)
+	severity := SeverityWarning
+	if level == len(w.thresholds) {
+		severity = SeverityCritical
+	}
+	msg := fmt.Sprintf("transfer out not confirmed, source timelock expires in %s (level %d/%d)",
+		srcLeft.Round(time.Second), level, len(w.thresholds))
+	fields := map[string]string{
+		"transferOutId": out.TransferId.String(),
+		"status":        out.Status.String(),
+		"srcChainId":    fmt.Sprintf("%d", out.ChainId),
+		"srcTimelock":   out.TimeLock.UTC().Format(time.RFC3339),
+		"dstChainId":    fmt.Sprintf("%d", out.RelatedChainId),
+		"token":         s.getTokenName(out.ChainId, out.Token),
+		"amount":        out.Amount.String(),
+		"level":         fmt.Sprintf("%d", level),
+	}
+	if in != nil {
+		dstLeft := in.TimeLock.Sub(time.Now())
+		fields["transferInId"] = in.TransferId.String()
+		fields["transferInStatus"] = in.Status.String()
+		fields["dstTimelock"] = in.TimeLock.UTC().Format(time.RFC3339)
+		msg += fmt.Sprintf(", transfer in %s, destination timelock expires in %s", in.Status.String(), dstLeft.Round(time.Second))
+		if in.Status == cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED {
+			// the preimage is revealed, only our confirm on the source chain is missing
+			severity = SeverityCritical
+		}
+	}
+	if severity == SeverityCritical {
+		confirmerLog.transfer(out).Errorf("timelock watchdog: %s", msg)
+	} else {
+		confirmerLog.transfer(out).Warnf("timelock watchdog: %s", msg)
+	}
+	s.notifier.Notify(&Notification{
+		Event:    cbn.NotifyEvent_NOTIFY_EVENT_TIMELOCK_APPROACHING,
+		Key:      fmt.Sprintf("%s/%d", out.TransferId.String(), level),
+		Severity: severity,
+		Message:  msg,
+		Fields:   fields,
+	})
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	cbn "github.com/celer-network/cBridge-go/cbridgenode"
+)
+
+func TestWatchdogThresholds(t *testing.T) {
+	tests := []struct {
+		desc       string
+		cfg        *cbn.WatchdogConfig
+		warnBefore uint64
+		expected   []time.Duration
+	}{
+		{"default", nil, 0, defaultWatchdogThresholds},
+		{"sorted", &cbn.WatchdogConfig{Thresholds: []uint64{600, 7200, 1800}}, 0, []time.Duration{2 * time.Hour, 30 * time.Minute, 10 * time.Minute}},
+		{"alias", nil, 3 * 3600, []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute}},
+		{"alias above defaults", nil, 12 * 3600, []time.Duration{12 * time.Hour, 6 * time.Hour, 2 * time.Hour, 30 * time.Minute}},
+		{"alias below defaults", nil, 600, []time.Duration{10 * time.Minute}},
+		{"alias ignored", &cbn.WatchdogConfig{Thresholds: []uint64{3600}}, 7200, []time.Duration{time.Hour}},
+	}
+	for _, test := range tests {
+		if w := newTimelockWatchdog(test.cfg, test.warnBefore); !reflect.DeepEqual(w.thresholds, test.expected) {
+			t.Errorf("%s: expect thresholds %v, got %v", test.desc, test.expected, w.thresholds)
+		}
+	}
+
+	w := newTimelockWatchdog(&cbn.WatchdogConfig{ScanInterval: 30}, 0)
+	if w.interval != 30*time.Second {
+		t.Errorf("expect scan interval 30s, got %s", w.interval)
+	}
+	for timeLeft, expected := range map[time.Duration]int{
+		7 * time.Hour:    0,
+		6 * time.Hour:    0,
+		5 * time.Hour:    1,
+		2 * time.Hour:    1,
+		time.Hour:        2,
+		10 * time.Minute: 3,
+		-time.Minute:     3,
+	} {
+		if level := w.level(timeLeft); level != expected {
+			t.Errorf("time left %s: expect level %d, got %d", timeLeft, expected, level)
+		}
+	}
+}
+
+func TestWatchdogEscalation(t *testing.T) {
+	store, err := NewSqliteDAL(filepath.Join(t.TempDir(), "cbridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	sink := newTestSink()
+	defer sink.Close()
+	s := NewServer("test")
+	s.db = store
+	s.watchdog = newTimelockWatchdog(nil, 0)
+	s.notifier = newTestNotifier(&cbn.NotifyConfig{Sinks: []*cbn.NotifySink{{Url: sink.URL}}})
+	defer s.notifier.Close()
+
+	now := time.Now()
+	in := newTestTransfer(0x91, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED, now.Add(30*time.Minute))
+	out := newTestTransfer(0x90, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(time.Hour))
+	out.RelatedTid = in.TransferId
+	safe := newTestTransfer(0x92, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(10*time.Hour))
+	for _, tx := range []*Transfer{in, out, safe} {
+		if err = store.InsertTransfer(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	escalations := watchdogEscalations.Count()
+
+	s.processTimelockWatchdog()
+	var wp webhookPayload
+	if err = json.Unmarshal(sink.waitReceived(t, 1)[0], &wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Event != "NOTIFY_EVENT_TIMELOCK_APPROACHING" || wp.Key != out.TransferId.String()+"/2" ||
+		wp.Fields["transferInId"] != in.TransferId.String() || wp.Fields["level"] != "2" {
+		t.Errorf("unexpected escalation %+v", wp)
+	}
+	// the transfer in is confirmed, only our confirm is missing
+	if wp.Severity != SeverityCritical {
+		t.Errorf("expect critical escalation, got %s", wp.Severity)
+	}
+	for _, m := range []struct {
+		name          string
+		got, expected int64
+	}{
+		{"open transfers", watchdogOpenTransfers.Value(), 2},
+		{"escalated transfers", watchdogEscalatedTransfers.Value(), 1},
+		{"max level", watchdogMaxLevel.Value(), 2},
+		{"escalations", watchdogEscalations.Count(), escalations + 1},
+	} {
+		if m.got != m.expected {
+			t.Errorf("expect %s %d, got %d", m.name, m.expected, m.got)
+		}
+	}
+	if left := watchdogMinSrcTimeLeft.Value(); left <= 3500 || left > 3600 {
+		t.Errorf("expect min src timelock left about 3600s, got %d", left)
+	}
+	if left := watchdogMinDstTimeLeft.Value(); left <= 1700 || left > 1800 {
+		t.Errorf("expect min dst timelock left about 1800s, got %d", left)
+	}
+
+	// the same level is not escalated again
+	s.processTimelockWatchdog()
+	sink.waitReceived(t, 1)
+	if got := watchdogEscalations.Count(); got != escalations+1 {
+		t.Errorf("expect no new escalation, got %d", got-escalations)
+	}
+
+	// the next level is escalated
+	if _, err = store.Exec("UPDATE transfer SET timelock = $1 WHERE tid = $2", now.Add(10*time.Minute), out.TransferId.String()); err != nil {
+		t.Fatal(err)
+	}
+	s.processTimelockWatchdog()
+	if err = json.Unmarshal(sink.waitReceived(t, 2)[1], &wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Key != out.TransferId.String()+"/3" || watchdogMaxLevel.Value() != 3 {
+		t.Errorf("expect level 3 escalation, got %+v", wp)
+	}
+
+	// confirmed transfers are no longer watched
+	if _, err = store.Exec("UPDATE transfer SET status = $1", cbn.TransferStatus_TRANSFER_STATUS_CONFIRMED); err != nil {
+		t.Fatal(err)
+	}
+	s.processTimelockWatchdog()
+	if watchdogOpenTransfers.Value() != 0 || watchdogEscalatedTransfers.Value() != 0 || len(s.watchdog.levels) != 0 {
+		t.Errorf("expect nothing watched, got %d open %d escalated", watchdogOpenTransfers.Value(), watchdogEscalatedTransfers.Value())
+	}
+}
+
+func TestWatchdogDestinationTimelock(t *testing.T) {
+	s, sink := newPreimageTestServer(t)
+	s.watchdog = newTimelockWatchdog(nil, 0)
+
+	now := time.Now()
+	// the source timelock is far, the transfer in is not confirmed and close to its own timelock
+	in := newTestTransfer(0x95, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(20*time.Minute))
+	out := newTestTransfer(0x94, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(10*time.Hour))
+	out.RelatedTid = in.TransferId
+	// the transfer in is refunded, its timelock does not matter anymore
+	refundedIn := newTestTransfer(0x97, cbn.TransferType_TRANSFER_TYPE_IN, cbn.TransferStatus_TRANSFER_STATUS_REFUNDED, now.Add(-time.Minute))
+	refundedOut := newTestTransfer(0x96, cbn.TransferType_TRANSFER_TYPE_OUT, cbn.TransferStatus_TRANSFER_STATUS_LOCKED, now.Add(10*time.Hour))
+	refundedOut.RelatedTid = refundedIn.TransferId
+	for _, tx := range []*Transfer{in, out, refundedIn, refundedOut} {
+		if err := s.db.InsertTransfer(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.processTimelockWatchdog()
+	var wp webhookPayload
+	if err := json.Unmarshal(sink.waitReceived(t, 1)[0], &wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Key != out.TransferId.String()+"/3" || wp.Fields["transferInId"] != in.TransferId.String() {
+		t.Errorf("expect level 3 escalation on the destination timelock, got %+v", wp)
+	}
+	if s.watchdog.levels[out.TransferId] != 3 || s.watchdog.levels[refundedOut.TransferId] != 0 {
+		t.Errorf("unexpected levels %v", s.watchdog.levels)
+	}
+}
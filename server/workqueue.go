@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// workQueue collects the ids of transfers whose state changed and wakes the processor owning them, so
+// transfers are handled as soon as their events arrive instead of at the next scan of the whole table.
+// An id added several times before the processor takes it is processed once.
+type workQueue struct {
+	lock   sync.Mutex
+	ids    map[Hash]bool
+	timers map[Hash]*time.Timer
+	closed bool
+	// has a value when ids are waiting
+	wake chan struct{}
+}
+
+func newWorkQueue() *workQueue {
+	return &workQueue{
+		ids:    make(map[Hash]bool),
+		timers: make(map[Hash]*time.Timer),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (q *workQueue) add(ids ...Hash) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	for _, id := range ids {
+		q.ids[id] = true
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// addAt adds the id at time t, e.g. once a timelock expires. A later addAt of the same id replaces it.
+func (q *workQueue) addAt(id Hash, t time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	if timer, found := q.timers[id]; found {
+		timer.Stop()
+	}
+	q.timers[id] = time.AfterFunc(time.Until(t), func() {
+		q.lock.Lock()
+		delete(q.timers, id)
+		q.lock.Unlock()
+		q.add(id)
+	})
+}
+
+// take returns and removes all waiting ids.
+func (q *workQueue) take() []Hash {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ids := make([]Hash, 0, len(q.ids))
+	for id := range q.ids {
+		ids = append(ids, id)
+	}
+	q.ids = make(map[Hash]bool)
+	return ids
+}
+
+// close stops the pending addAt timers and drops later adds.
+func (q *workQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	for _, timer := range q.timers {
+		timer.Stop()
+	}
+	q.timers = nil
+}
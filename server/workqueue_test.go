@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func waitWake(t *testing.T, q *workQueue) {
+	t.Helper()
+	select {
+	case <-q.wake:
+	case <-time.After(time.Second):
+		t.Fatal("queue not woken")
+	}
+}
+
+func TestWorkQueue(t *testing.T) {
+	q := newWorkQueue()
+	id1, id2 := Hash{1}, Hash{2}
+	q.add(id1)
+	q.add(id1, id2)
+	waitWake(t, q)
+	if ids := q.take(); len(ids) != 2 {
+		t.Fatalf("expect 2 distinct ids, got %v", ids)
+	}
+	if ids := q.take(); len(ids) != 0 {
+		t.Fatalf("expect empty queue, got %v", ids)
+	}
+
+	q.addAt(id1, time.Now().Add(time.Hour))
+	q.addAt(id1, time.Now().Add(50*time.Millisecond))
+	waitWake(t, q)
+	if ids := q.take(); len(ids) != 1 || ids[0] != id1 {
+		t.Fatalf("expect id added at time, got %v", ids)
+	}
+
+	q.addAt(id2, time.Now().Add(50*time.Millisecond))
+	q.close()
+	q.add(id1)
+	time.Sleep(100 * time.Millisecond)
+	if ids := q.take(); len(ids) != 0 {
+		t.Fatalf("expect no ids after close, got %v", ids)
+	}
+}